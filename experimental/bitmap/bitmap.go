@@ -0,0 +1,222 @@
+package bitmap
+
+/*
+
+Compressed bitmaps over a fixed universe [0,N).
+
+Sets are stored in one of three forms, whichever is smallest:
+
+  - a sorted list of members (few members)
+  - a sorted list of non-members (almost everything is a member)
+  - a dense bit vector
+
+Tile variant to sample sets are mostly one of the first two (rare
+variants, or the reference variant), so most bitmaps only take a
+few bytes.
+
+Operations work on the dense form and re-compress the result, except
+for the common case of intersecting two member lists, which is done
+directly.
+
+The fields are exported so bitmaps can be gob encoded.
+
+*/
+
+type Bitmap struct {
+  N int
+
+  // If set, Sparse (or Dense) holds the positions *not* in the set.
+  //
+  Invert bool
+  Sparse []uint32
+  Dense []uint64
+}
+
+func New( n int ) *Bitmap {
+  return &Bitmap{ N:n }
+}
+
+// Create a bitmap from a sorted list of positions.
+//
+func FromSorted( n int, pos []int ) *Bitmap {
+  b := &Bitmap{ N:n, Sparse:make( []uint32, len(pos) ) }
+  for i:=0; i<len(pos); i++ { b.Sparse[i] = uint32(pos[i]) }
+  b.compress()
+  return b
+}
+
+// Bitmap with every position in [0,n) set.
+//
+func Full( n int ) *Bitmap {
+  return &Bitmap{ N:n, Invert:true }
+}
+
+func n_word( n int ) int { return (n+63)/64 }
+
+func (b *Bitmap) words() []uint64 {
+  w := make( []uint64, n_word( b.N ) )
+
+  if b.Dense!=nil {
+    copy( w, b.Dense )
+  } else {
+    for i:=0; i<len(b.Sparse); i++ {
+      w[ b.Sparse[i]/64 ] |= uint64(1) << (b.Sparse[i]%64)
+    }
+  }
+
+  if b.Invert {
+    for i:=0; i<len(w); i++ { w[i] = ^w[i] }
+    trim( w, b.N )
+  }
+
+  return w
+}
+
+// Clear bits past n in the last word.
+//
+func trim( w []uint64, n int ) {
+  if (n%64)!=0 && len(w)>0 {
+    w[len(w)-1] &= (uint64(1) << uint(n%64)) - 1
+  }
+}
+
+func popcount( x uint64 ) int {
+  c := 0
+  for ; x!=0; c++ { x &= x-1 }
+  return c
+}
+
+func from_words( n int, w []uint64 ) *Bitmap {
+  b := &Bitmap{ N:n, Dense:w }
+  b.compress()
+  return b
+}
+
+// Pick the smallest representation.  A list entry takes 4 bytes,
+// the dense form n/8 bytes.
+//
+func (b *Bitmap) compress() {
+  c := b.Count()
+  lim := b.N/32
+
+  if (c > lim) && ((b.N-c) > lim) {
+    if b.Dense==nil || b.Invert {
+      b.Dense = b.words()
+      b.Invert = false
+    }
+    b.Sparse = nil
+    return
+  }
+
+  invert := c > lim
+  if (b.Dense==nil) && (b.Invert==invert) { return }
+
+  w := b.words()
+  sparse := []uint32{}
+  for i:=0; i<len(w); i++ {
+    x := w[i]
+    if invert { x = ^x }
+    for j:=0; (j<64) && ((i*64+j)<b.N); j++ {
+      if (x & (uint64(1)<<uint(j)))!=0 { sparse = append( sparse, uint32(i*64+j) ) }
+    }
+  }
+
+  b.Invert = invert
+  b.Sparse = sparse
+  b.Dense = nil
+}
+
+func (b *Bitmap) Contains( i int ) bool {
+  if (i<0) || (i>=b.N) { return false }
+
+  found := false
+  if b.Dense!=nil {
+    found = (b.Dense[i/64] & (uint64(1)<<uint(i%64))) != 0
+  } else {
+    lo,hi := 0,len(b.Sparse)
+    for lo<hi {
+      m := (lo+hi)/2
+      if int(b.Sparse[m]) < i { lo = m+1 } else { hi = m }
+    }
+    found = (lo<len(b.Sparse)) && (int(b.Sparse[lo])==i)
+  }
+
+  return found != b.Invert
+}
+
+// Number of positions set.
+//
+func (b *Bitmap) Count() int {
+  c := len(b.Sparse)
+  if b.Dense!=nil {
+    c = 0
+    for i:=0; i<len(b.Dense); i++ { c += popcount( b.Dense[i] ) }
+  }
+  if b.Invert { return b.N - c }
+  return c
+}
+
+// Positions set, in increasing order.
+//
+func (b *Bitmap) Slice() []int {
+  if (b.Dense==nil) && !b.Invert {
+    r := make( []int, len(b.Sparse) )
+    for i:=0; i<len(b.Sparse); i++ { r[i] = int(b.Sparse[i]) }
+    return r
+  }
+
+  w := b.words()
+  r := []int{}
+  for i:=0; i<len(w); i++ {
+    for x:=w[i]; x!=0; x &= x-1 {
+      j := 0
+      for (x & (uint64(1)<<uint(j)))==0 { j++ }
+      r = append( r, i*64+j )
+    }
+  }
+  return r
+}
+
+func (b *Bitmap) And( o *Bitmap ) *Bitmap {
+
+  if (b.Dense==nil) && (o.Dense==nil) && !b.Invert && !o.Invert {
+    r := &Bitmap{ N:b.N, Sparse:[]uint32{} }
+    i,j := 0,0
+    for (i<len(b.Sparse)) && (j<len(o.Sparse)) {
+      if b.Sparse[i]==o.Sparse[j] {
+        r.Sparse = append( r.Sparse, b.Sparse[i] )
+        i++ ; j++
+      } else if b.Sparse[i] < o.Sparse[j] { i++
+      } else { j++ }
+    }
+    return r
+  }
+
+  x,y := b.words(),o.words()
+  for i:=0; i<len(x) && i<len(y); i++ { x[i] &= y[i] }
+  for i:=len(y); i<len(x); i++ { x[i] = 0 }
+  return from_words( b.N, x )
+}
+
+func (b *Bitmap) Or( o *Bitmap ) *Bitmap {
+  x,y := b.words(),o.words()
+  for i:=0; i<len(x) && i<len(y); i++ { x[i] |= y[i] }
+  return from_words( b.N, x )
+}
+
+func (b *Bitmap) AndNot( o *Bitmap ) *Bitmap {
+  x,y := b.words(),o.words()
+  for i:=0; i<len(x) && i<len(y); i++ { x[i] &^= y[i] }
+  return from_words( b.N, x )
+}
+
+func (b *Bitmap) Not() *Bitmap {
+  r := &Bitmap{ N:b.N, Invert:!b.Invert, Sparse:b.Sparse, Dense:b.Dense }
+  return r
+}
+
+// True if every position set in o is set in b.
+//
+func (b *Bitmap) Superset( o *Bitmap ) bool {
+  return o.AndNot( b ).Count() == 0
+}
@@ -0,0 +1,62 @@
+package bitmap
+
+import "testing"
+import "bytes"
+import "encoding/gob"
+
+func check( t *testing.T, name string, b *Bitmap, want []int ) {
+  got := b.Slice()
+  if len(got)!=len(want) { t.Errorf("%s: got %v, want %v", name, got, want) ; return }
+  for i:=0; i<len(got); i++ {
+    if got[i]!=want[i] { t.Errorf("%s: got %v, want %v", name, got, want) ; return }
+  }
+  if b.Count()!=len(want) { t.Errorf("%s: count %d != %d", name, b.Count(), len(want)) }
+}
+
+func TestBitmap( t *testing.T ) {
+  n := 200
+
+  a := FromSorted( n, []int{ 1, 5, 64, 130 } )
+  b := FromSorted( n, []int{ 5, 64, 199 } )
+
+  if (a.Dense!=nil) || a.Invert { t.Errorf("expected member list") }
+
+  check( t, "and", a.And(b), []int{ 5, 64 } )
+  check( t, "or", a.Or(b), []int{ 1, 5, 64, 130, 199 } )
+  check( t, "andnot", a.AndNot(b), []int{ 1, 130 } )
+
+  if !a.Contains(130) || a.Contains(131) || a.Contains(-1) || a.Contains(n) { t.Errorf("contains") }
+
+  na := a.Not()
+  if na.Count()!=n-4 { t.Errorf("not count %d", na.Count()) }
+  if na.Contains(5) || !na.Contains(6) { t.Errorf("not contains") }
+
+  // All but a few set gives an inverted list.
+  //
+  full := Full( n )
+  f := full.AndNot( a )
+  if !f.Invert || f.Dense!=nil { t.Errorf("expected inverted list") }
+  check( t, "full and", f.And( FromSorted( n, []int{ 0, 1, 2 } ) ), []int{ 0, 2 } )
+
+  // About half set gives a dense vector.
+  //
+  half := []int{}
+  for i:=0; i<n; i+=2 { half = append( half, i ) }
+  h := FromSorted( n, half )
+  if h.Dense==nil { t.Errorf("expected dense") }
+  check( t, "dense", h.And(a), []int{ 64, 130 } )
+
+  if !h.Superset( FromSorted( n, []int{ 0, 64 } ) ) { t.Errorf("superset") }
+  if h.Superset( a ) { t.Errorf("not superset") }
+}
+
+func TestGob( t *testing.T ) {
+  var buf bytes.Buffer
+
+  a := FromSorted( 100, []int{ 3, 17, 99 } )
+  if e := gob.NewEncoder( &buf ).Encode( a ) ; e!=nil { t.Fatalf("%v", e) }
+
+  b := Bitmap{}
+  if e := gob.NewDecoder( &buf ).Decode( &b ) ; e!=nil { t.Fatalf("%v", e) }
+  check( t, "gob", &b, []int{ 3, 17, 99 } )
+}
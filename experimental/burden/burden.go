@@ -0,0 +1,255 @@
+package burden
+
+import "fmt"
+import "math"
+import "math/rand"
+
+import "../cgf"
+
+/*
+
+Rare tile variant burden tests of CGF samples.
+
+Tile positions are grouped (by gene, region or path, the caller
+decides).  At each position of a group, every non-reference tile
+variant (tile variant id other than 0) with a frequency among the
+called alleles of all the samples, cases and controls together, of at
+most MaxFrequency qualifies.  A sample carrying a qualifying tile
+variant on either allele anywhere in the group is a carrier.
+
+Carriers and non-carriers among the cases and controls make up a 2x2
+table, tested with Fisher's exact test (two sided).  Samples not
+called at any position of the group are left out.  With Permutation
+set, the case and control labels of the tested samples are shuffled
+that many times and the permutation p-value is the fraction of
+shuffles with a Fisher p-value at most the observed one, counting the
+observed labelling ((k+1)/(n+1)).
+
+Spanning tiles count at the step they start at.
+
+*/
+
+var DefaultMaxFrequency float64 = 0.01
+
+type Group struct {
+  Name string
+
+  // Tile positions, as (path, step), without repeats.
+  //
+  Position [][2]int
+}
+
+type Option struct {
+
+  // Tile variants with a frequency above this don't qualify.
+  //
+  MaxFrequency float64
+
+  // Number of label permutations, 0 for none.
+  //
+  Permutation int
+  Seed int64
+}
+
+type Result struct {
+  Name string
+  QualifyingVariant int
+
+  CaseCarrier int
+  CaseCount int
+  ControlCarrier int
+  ControlCount int
+
+  // Odds ratio of being a carrier in cases vs. controls, with 0.5
+  // added to every cell if any of them is 0.
+  //
+  OddsRatio float64
+  PValue float64
+
+  Permutation int `json:",omitempty"`
+  PermutationPValue float64 `json:",omitempty"`
+}
+
+// Tile variants starting at path, step, one per allele.
+//
+func start_variant( cg *cgf.CGF, tile_map []cgf.TileMapEntry, path, step int ) ( []int, bool ) {
+  abv,ok := cg.ABV[ fmt.Sprintf("%x", path) ]
+  if !ok || (step<0) || (step>=len(abv)) { return nil, false }
+
+  code := cg.CharMap[ abv[step:step+1] ]
+  if code == -2 {
+    var e error
+    code,e = cg.LookupABVTileMapVariant( path, step )
+    if e!=nil { return nil, false }
+  }
+  if (code<0) || (code>=len(tile_map)) { return nil, false }
+
+  tme := tile_map[code]
+  v := make( []int, len(tme.Variant) )
+  for a:=0; a<len(tme.Variant); a++ {
+    if (len(tme.Variant[a])==0) || (tme.Variant[a][0]<0) { return nil, false }
+    v[a] = tme.Variant[a][0]
+  }
+  return v, len(v)>0
+}
+
+// Carrier status of each sample for the positions given.  called is
+// false for samples not called at any of them.  Returns the number
+// of qualifying tile variants as well.
+//
+func Carrier( cgs []*cgf.CGF, tile_map []cgf.TileMapEntry, pos [][2]int, max_freq float64 ) ( carrier, called []bool, n_variant int ) {
+  n := len(cgs)
+  carrier = make( []bool, n )
+  called = make( []bool, n )
+  gt := make( [][]int, n )
+
+  for p:=0; p<len(pos); p++ {
+    count := make( map[int]int )
+    n_allele := 0
+
+    for s:=0; s<n; s++ {
+      v,ok := start_variant( cgs[s], tile_map, pos[p][0], pos[p][1] )
+      gt[s] = nil
+      if !ok { continue }
+      gt[s] = v
+      called[s] = true
+      n_allele += len(v)
+      for a:=0; a<len(v); a++ { count[v[a]]++ }
+    }
+    if n_allele==0 { continue }
+
+    qualify := make( map[int]bool )
+    for v,c := range count {
+      if (v==0) || (float64(c)/float64(n_allele) > max_freq) { continue }
+      qualify[v] = true
+      n_variant++
+    }
+    if len(qualify)==0 { continue }
+
+    for s:=0; s<n; s++ {
+      for a:=0; a<len(gt[s]); a++ {
+        if qualify[gt[s][a]] { carrier[s] = true }
+      }
+    }
+  }
+
+  return carrier, called, n_variant
+}
+
+func log_choose( n, k int ) float64 {
+  a,_ := math.Lgamma( float64(n+1) )
+  b,_ := math.Lgamma( float64(k+1) )
+  c,_ := math.Lgamma( float64(n-k+1) )
+  return a-b-c
+}
+
+// Two sided Fisher's exact test of the 2x2 table
+//
+//   a b
+//   c d
+//
+// summing the probabilities of the tables with the same margins that
+// are no more likely than the one given.
+//
+func FisherExact( a, b, c, d int ) float64 {
+  row0,col0,n := a+b, a+c, a+b+c+d
+  if n==0 { return 1 }
+
+  lo := 0
+  if col0-(c+d) > lo { lo = col0-(c+d) }
+  hi := row0
+  if col0 < hi { hi = col0 }
+
+  lp := func( x int ) float64 {
+    return log_choose( row0, x ) + log_choose( n-row0, col0-x ) - log_choose( n, col0 )
+  }
+
+  p_obs := lp( a )
+  p := 0.0
+  for x:=lo; x<=hi; x++ {
+    px := lp( x )
+    if px <= p_obs + 1e-7 { p += math.Exp( px ) }
+  }
+  if p>1 { p = 1 }
+  return p
+}
+
+func odds_ratio( a, b, c, d int ) float64 {
+  fa,fb,fc,fd := float64(a), float64(b), float64(c), float64(d)
+  if (a==0) || (b==0) || (c==0) || (d==0) {
+    fa,fb,fc,fd = fa+0.5, fb+0.5, fc+0.5, fd+0.5
+  }
+  return (fa*fd) / (fb*fc)
+}
+
+// Test carriers among the first n_case samples (the cases) against
+// the rest (the controls).  rng is only used for permutations.
+//
+func CarrierTest( name string, carrier, called []bool, n_case int, permutation int, rng *rand.Rand ) Result {
+  res := Result{ Name:name }
+
+  tested := []bool{}
+  n_tested_case := 0
+  for s:=0; s<len(carrier); s++ {
+    if !called[s] { continue }
+    tested = append( tested, carrier[s] )
+    if s<n_case {
+      n_tested_case++
+      if carrier[s] { res.CaseCarrier++ }
+    } else if carrier[s] {
+      res.ControlCarrier++
+    }
+  }
+  res.CaseCount = n_tested_case
+  res.ControlCount = len(tested) - n_tested_case
+
+  a,b := res.CaseCarrier, res.CaseCount-res.CaseCarrier
+  c,d := res.ControlCarrier, res.ControlCount-res.ControlCarrier
+  res.OddsRatio = odds_ratio( a, b, c, d )
+  res.PValue = FisherExact( a, b, c, d )
+
+  if (permutation<=0) || (rng==nil) { return res }
+
+  n_carrier := a+c
+  k := 0
+  for i:=0; i<permutation; i++ {
+    rng.Shuffle( len(tested), func( x, y int ) { tested[x],tested[y] = tested[y],tested[x] } )
+    pa := 0
+    for s:=0; s<n_tested_case; s++ {
+      if tested[s] { pa++ }
+    }
+    pp := FisherExact( pa, n_tested_case-pa, n_carrier-pa, res.ControlCount-(n_carrier-pa) )
+    if pp <= res.PValue*(1+1e-7) { k++ }
+  }
+  res.Permutation = permutation
+  res.PermutationPValue = float64(k+1) / float64(permutation+1)
+
+  return res
+}
+
+// Run the burden test of each group, cases against controls, using
+// tile_map to decode the ABVs.  progress and cancelled are optional.
+//
+func Burden( cases, controls []*cgf.CGF, tile_map []cgf.TileMapEntry, groups []Group, opt Option, progress func(done, total int), cancelled func() bool ) ( []Result, error ) {
+  if (len(cases)==0) || (len(controls)==0) { return nil, fmt.Errorf("no cases or no controls") }
+  if opt.MaxFrequency<=0 { opt.MaxFrequency = DefaultMaxFrequency }
+
+  cgs := make( []*cgf.CGF, 0, len(cases)+len(controls) )
+  cgs = append( cgs, cases... )
+  cgs = append( cgs, controls... )
+
+  var rng *rand.Rand
+  if opt.Permutation>0 { rng = rand.New( rand.NewSource( opt.Seed ) ) }
+
+  res := make( []Result, len(groups) )
+  for g:=0; g<len(groups); g++ {
+    if (cancelled!=nil) && cancelled() { return nil, fmt.Errorf("cancelled") }
+    if progress!=nil { progress( g, len(groups) ) }
+
+    carrier,called,n_variant := Carrier( cgs, tile_map, groups[g].Position, opt.MaxFrequency )
+    res[g] = CarrierTest( groups[g].Name, carrier, called, len(cases), opt.Permutation, rng )
+    res[g].QualifyingVariant = n_variant
+  }
+
+  return res, nil
+}
@@ -0,0 +1,64 @@
+package burden
+
+import "testing"
+import "math"
+import "math/rand"
+
+func TestFisherExact( t *testing.T ) {
+
+  // Lady tasting tea, and tables checked against R's fisher.test.
+  //
+  tab := []struct {
+    a,b,c,d int
+    p float64
+  }{
+    { 3, 1, 1, 3, 0.4857142857 },
+    { 1, 9, 11, 3, 0.002759456 },
+    { 10, 0, 0, 10, 1.082509e-05 },
+    { 0, 5, 0, 5, 1 },
+    { 2, 3, 2, 3, 1 },
+  }
+
+  for i:=0; i<len(tab); i++ {
+    x := tab[i]
+    p := FisherExact( x.a, x.b, x.c, x.d )
+    if math.Abs( p-x.p ) > 1e-6*math.Max( x.p, 1e-3 ) {
+      t.Errorf("%v: got %g, expected %g", x, p, x.p)
+    }
+    if q := FisherExact( x.c, x.d, x.a, x.b ) ; math.Abs( p-q ) > 1e-12 {
+      t.Errorf("%v: not symmetric (%g, %g)", x, p, q)
+    }
+  }
+}
+
+func TestCarrierTest( t *testing.T ) {
+
+  // Eight cases, six of them carriers, eight controls with one, and
+  // an uncalled control.
+  //
+  carrier := []bool{ true, true, true, false, true, true, true, false,
+                     false, false, true, false, false, false, false, false, true }
+  called := make( []bool, len(carrier) )
+  for i:=0; i<len(called); i++ { called[i] = true }
+  called[16] = false
+
+  res := CarrierTest( "g", carrier, called, 8, 0, nil )
+  if (res.CaseCarrier!=6) || (res.CaseCount!=8) || (res.ControlCarrier!=1) || (res.ControlCount!=8) {
+    t.Fatalf("bad counts %+v", res)
+  }
+  if math.Abs( res.PValue - FisherExact( 6, 2, 1, 7 ) ) > 1e-12 { t.Errorf("p-value %g", res.PValue) }
+  if math.Abs( res.OddsRatio - 21 ) > 1e-9 { t.Errorf("odds ratio %g", res.OddsRatio) }
+  if res.Permutation!=0 { t.Errorf("permutations without asking") }
+
+  // The permutation p-value of a carrier count test follows the
+  // exact one.
+  //
+  res = CarrierTest( "g", carrier, called, 8, 4000, rand.New( rand.NewSource(1) ) )
+  if res.Permutation!=4000 { t.Fatalf("%d permutations", res.Permutation) }
+  if math.Abs( res.PermutationPValue - res.PValue ) > 0.01 {
+    t.Errorf("permutation p-value %g, exact %g", res.PermutationPValue, res.PValue)
+  }
+
+  res2 := CarrierTest( "g", carrier, called, 8, 4000, rand.New( rand.NewSource(1) ) )
+  if res2.PermutationPValue != res.PermutationPValue { t.Errorf("not reproducible with the same seed") }
+}
@@ -0,0 +1,109 @@
+package cgf
+
+import "fmt"
+import "strings"
+
+// Tiles overlap their neighbours by a tag of this many bases.
+//
+var TagLength int = 24
+
+type SequenceTile struct {
+  Step int
+  Variant int
+  Length int
+  NoCall bool
+}
+
+// The tiles on each allele covering steps [beg,end) of path.  end<0
+// runs to the end of the path.  The first tile may start before beg
+// if beg is inside a spanning tile.  Positions with no call are
+// returned as single step tiles with NoCall set.
+//
+func ( cg *CGF ) AlleleTiles( tile_map []TileMapEntry, path, beg, end int ) ( [][]SequenceTile, error ) {
+  abv,ok := cg.ABV[ fmt.Sprintf("%x", path) ]
+  if !ok { return nil, fmt.Errorf("Could not find path %x", path) }
+
+  if (end<0) || (end>len(abv)) { end = len(abv) }
+  if (beg<0) || (beg>=end) { return nil, fmt.Errorf("invalid step range [%x,%x)", beg, end) }
+
+  n_allele := 2
+  if len(tile_map)>0 { n_allele = len(tile_map[0].Variant) }
+
+  res := make( [][]SequenceTile, n_allele )
+
+  for allele:=0; allele<n_allele; allele++ {
+    for step:=beg; step<end; {
+      _,s,code,e := cg.LookupABVStartTileMapVariant( path, step )
+
+      found := false
+      if (e==nil) && (code>=0) && (code<len(tile_map)) && (allele<len(tile_map[code].Variant)) {
+        tme := tile_map[code]
+        x := 0
+        for v_ind:=0; v_ind<len(tme.Variant[allele]); v_ind++ {
+          l := tme.VariantLength[allele][v_ind]
+          if ((s+x) <= step) && (step < (s+x+l)) {
+            v := tme.Variant[allele][v_ind]
+            res[allele] = append( res[allele], SequenceTile{ Step:s+x, Variant:v, Length:l, NoCall:v<0 } )
+            step = s+x+l
+            found = true
+            break
+          }
+          x += l
+        }
+      }
+
+      if !found {
+        res[allele] = append( res[allele], SequenceTile{ Step:step, Variant:-1, Length:1, NoCall:true } )
+        step++
+      }
+    }
+  }
+
+  return res, nil
+}
+
+// Join consecutive tile sequences, dropping the tag each tile shares
+// with the one before it.  Tiles with nocall set are masked with 'N'
+// (seq should hold a sequence of the right length, e.g. the
+// reference tile's).  Where a called tile borders a masked one, the
+// shared tag is taken from the called tile.
+//
+func StitchTileSequence( seq []string, nocall []bool ) string {
+  out := []byte{}
+
+  for i:=0; i<len(seq); i++ {
+    s := seq[i]
+    if nocall[i] { s = strings.Repeat( "N", len(s) ) }
+
+    if i==0 {
+      out = append( out, s... )
+      continue
+    }
+
+    if nocall[i-1] && !nocall[i] {
+      n := len(out)-TagLength
+      if n<0 { n = 0 }
+      out = append( out[:n], s... )
+      continue
+    }
+
+    if len(s)>TagLength { out = append( out, s[TagLength:]... ) }
+  }
+
+  return string(out)
+}
+
+// A FASTA record with the sequence wrapped at width bases.
+//
+func FastaRecord( header, seq string, width int ) string {
+  if width<=0 { width = len(seq) }
+  if width<=0 { width = 1 }
+
+  lines := []string{ ">" + header }
+  for i:=0; i<len(seq); i+=width {
+    e := i+width
+    if e>len(seq) { e = len(seq) }
+    lines = append( lines, seq[i:e] )
+  }
+  return strings.Join( lines, "\n" ) + "\n"
+}
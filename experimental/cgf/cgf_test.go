@@ -4,6 +4,7 @@ import "fmt"
 import "os"
 import "testing"
 import "io/ioutil"
+import "strings"
 
 var test_cgf []byte = []byte(`{"#!cgf":"a",
       "CGFVersion" : "0.4",
@@ -494,3 +495,79 @@ func TestLoad( t *testing.T ) {
 
 }
 
+
+func TestAlleleTiles( t *testing.T ) {
+
+  f,err := ioutil.TempFile( "", "" )
+  if err != nil { t.Error( err ) }
+
+  f.Write( test_cgf )
+  f.Close()
+
+  cg,ee := Load( f.Name() )
+  if ee != nil { t.Fatal(ee) }
+  os.Remove( f.Name() )
+
+  // "3" : "..BCDEK***", K is [[6,7+2,8],[17+4]] (the encoded tile
+  // map in test_cgf has 17 as a single step, use the full entry)
+  //
+  tm := append( []TileMapEntry{}, cg.TileMap... )
+  tm[10] = TileMapEntry{ Type:"het", Ploidy:2, Variant:[][]int{ {6,7,8}, {17} }, VariantLength:[][]int{ {1,2,1}, {4} } }
+
+  at,e := cg.AlleleTiles( tm, 3, 4, -1 )
+  if e!=nil { t.Fatal(e) }
+
+  expect := [][]SequenceTile{
+    []SequenceTile{ {4,0,1,false}, {5,1,1,false}, {6,6,1,false}, {7,7,2,false}, {9,8,1,false} },
+    []SequenceTile{ {4,1,1,false}, {5,0,1,false}, {6,17,4,false} },
+  }
+  if fmt.Sprintf("%v", at) != fmt.Sprintf("%v", expect) {
+    t.Error( fmt.Errorf("AlleleTiles: got %v, expected %v", at, expect) )
+  }
+
+  // Starting inside spanning tiles.
+  //
+  at,e = cg.AlleleTiles( tm, 3, 8, 10 )
+  if e!=nil { t.Fatal(e) }
+  if (len(at[0])!=2) || (at[0][0].Step!=7) || (len(at[1])!=1) || (at[1][0].Step!=6) {
+    t.Error( fmt.Errorf("AlleleTiles (spanning): got %v", at) )
+  }
+
+  // "0" : "----------...", no-calls.
+  //
+  at,e = cg.AlleleTiles( tm, 0, 9, 11 )
+  if e!=nil { t.Fatal(e) }
+  if !at[0][0].NoCall || at[0][1].NoCall {
+    t.Error( fmt.Errorf("AlleleTiles (no-call): got %v", at) )
+  }
+
+  if _,e = cg.AlleleTiles( tm, 3, 5, 5 ) ; e==nil {
+    t.Error( fmt.Errorf("AlleleTiles: expected error for empty range") )
+  }
+}
+
+func TestStitchTileSequence( t *testing.T ) {
+  tag := strings.Repeat( "t", TagLength )
+  tag2 := strings.Repeat( "g", TagLength )
+
+  a := "aaaa" + tag
+  b := tag + "cc" + tag2
+  c := tag2 + "gggg"
+
+  s := StitchTileSequence( []string{ a, b, c }, []bool{ false, false, false } )
+  if s != "aaaa" + tag + "cc" + tag2 + "gggg" {
+    t.Error( fmt.Errorf("StitchTileSequence: got %s", s) )
+  }
+
+  // The masked tile keeps the tags of its called neighbours.
+  //
+  s = StitchTileSequence( []string{ a, b, c }, []bool{ false, true, false } )
+  if s != "aaaa" + tag + "NN" + tag2 + "gggg" {
+    t.Error( fmt.Errorf("StitchTileSequence (masked): got %s", s) )
+  }
+
+  r := FastaRecord( "x", "acgtacg", 3 )
+  if r != ">x\nacg\ntac\ng\n" {
+    t.Error( fmt.Errorf("FastaRecord: got %q", r) )
+  }
+}
@@ -0,0 +1,184 @@
+package main
+
+import "os"
+import "fmt"
+import "bufio"
+import "strconv"
+import "strings"
+
+import "../cgf"
+import "../tile_dbh"
+
+import "github.com/codegangsta/cli"
+
+import "../lightlog"
+
+/*
+
+Write a sample's sequence over a tile path and step range as FASTA,
+one record per allele.
+
+  cgf2fasta -i hu011C57.cgf -d tiledb.sqlite3 -P 247 -S 0+3
+
+Tiles are stitched on their shared tags and no-call tiles are masked
+with 'N' over the length of the reference tile.  Tile sequences
+come from the tile sqlite database.  This is the same as lantern's
+'sample-sequence' request.
+
+*/
+
+var VERSION_STR string = "0.1, AGPLv3.0"
+var g_verboseFlag bool
+
+func _main( c *cli.Context ) {
+  g_verboseFlag = c.Bool("Verbose")
+  if g_verboseFlag { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
+
+  if len( c.String("input-cgf")) == 0 {
+    lightlog.Error( "Provide input CGF file" )
+    cli.ShowAppHelp( c )
+    os.Exit(1)
+  }
+
+  cg,e := cgf.Load( c.String("input-cgf") )
+  if e!=nil { lightlog.Error( "%s: %v", c.String("input-cgf"), e ) ; os.Exit(1) }
+
+  dbh,e := tile_dbh.OpenSqlite3( c.String("tile-db") )
+  if e!=nil { lightlog.Error( "%s: %v", c.String("tile-db"), e ) ; os.Exit(1) }
+  defer dbh.Close()
+
+  path_64,e := strconv.ParseInt( c.String("hex-path"), 16, 64 )
+  if e!=nil { lightlog.Error( "invalid path: %v", e ) ; os.Exit(1) }
+  path := int(path_64)
+
+  // Step range, "beg", "beg+n", "beg-end" or "beg-" (end exclusive).
+  //
+  beg,end := 0,-1
+  if step_str := c.String("hex-step") ; len(step_str)>0 {
+    var e0,e1 error
+    var a,b int64
+    if strings.Contains( step_str, "+" ) {
+      f := strings.SplitN( step_str, "+", 2 )
+      a,e0 = strconv.ParseInt( f[0], 16, 64 )
+      b,e1 = strconv.ParseInt( f[1], 16, 64 )
+      b += a
+    } else if strings.Contains( step_str, "-" ) {
+      f := strings.SplitN( step_str, "-", 2 )
+      a,e0 = strconv.ParseInt( f[0], 16, 64 )
+      b = -1
+      if len(f[1])>0 { b,e1 = strconv.ParseInt( f[1], 16, 64 ) }
+    } else {
+      a,e0 = strconv.ParseInt( step_str, 16, 64 )
+      b = a+1
+    }
+    if (e0!=nil) || (e1!=nil) { lightlog.Error( "invalid step range '%s'", step_str ) ; os.Exit(1) }
+    beg,end = int(a),int(b)
+  }
+
+  allele_tiles,e := cg.AlleleTiles( cg.TileMap, path, beg, end )
+  if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+
+  ofp := os.Stdout
+  if (len(c.String("output"))>0) && (c.String("output")!="-") {
+    ofp,e = os.Create( c.String("output") )
+    if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+    defer ofp.Close()
+  }
+  bw := bufio.NewWriter( ofp )
+  defer bw.Flush()
+
+  name := c.String("name")
+  if len(name)==0 { name = c.String("input-cgf") }
+
+  for a:=0; a<len(allele_tiles); a++ {
+    tiles := allele_tiles[a]
+    if len(tiles)==0 { continue }
+
+    seq := make( []string, len(tiles) )
+    nocall := make( []bool, len(tiles) )
+
+    for i:=0; i<len(tiles); i++ {
+      variant := tiles[i].Variant
+      if tiles[i].NoCall { variant = 0 }
+
+      tileid := fmt.Sprintf("%03x.%02x.%04x.%04x", path, 0, tiles[i].Step, variant)
+      s,e := dbh.GetSeqString( tileid )
+      if e!=nil { lightlog.Error( "%s: %v", tileid, e ) ; os.Exit(1) }
+
+      seq[i] = s
+      nocall[i] = tiles[i].NoCall
+    }
+
+    last := tiles[len(tiles)-1]
+    header := fmt.Sprintf("%s allele=%d path=%03x step=%04x-%04x", name, a, path, tiles[0].Step, last.Step+last.Length)
+    bw.WriteString( cgf.FastaRecord( header, cgf.StitchTileSequence( seq, nocall ), c.Int("width") ) )
+  }
+
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "cgf2fasta"
+  app.Usage = "Write the sequence of a CGF sample over a tile range as FASTA"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+
+    cli.StringFlag{
+      Name: "input-cgf, i",
+      Usage: "Input CGF",
+    },
+
+    cli.StringFlag{
+      Name: "tile-db, d",
+      Value: "./tiledb.sqlite3",
+      Usage: "Tile sequence sqlite database",
+    },
+
+    cli.StringFlag{
+      Name: "hex-path, P",
+      Value: "0",
+      Usage: "Path (in hexadecimal)",
+    },
+
+    cli.StringFlag{
+      Name: "hex-step, S",
+      Usage: "Step range (in hexadecimal, e.g. '10+20' or '10-'), whole path if empty",
+    },
+
+    cli.StringFlag{
+      Name: "name, n",
+      Usage: "Sample name for the FASTA headers (default the CGF file name)",
+    },
+
+    cli.IntFlag{
+      Name: "width, w",
+      Value: 60,
+      Usage: "FASTA line width",
+    },
+
+    cli.StringFlag{
+      Name: "output, o",
+      Value: "-",
+      Usage: "Output file",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag",
+    },
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
+    },
+
+  }
+
+  app.Run(os.Args)
+
+}
@@ -0,0 +1,293 @@
+package main
+
+import "os"
+import "fmt"
+import "sort"
+import "bufio"
+import "strings"
+import "strconv"
+import "path/filepath"
+
+import "../cgf"
+import "../burden"
+
+import "github.com/codegangsta/cli"
+
+import "../lightlog"
+
+/*
+
+Rare tile variant burden test of CGF files, outside of lantern.
+
+  cgfburden -case case.manifest -control control.manifest -g genes.tsv -n 1000
+
+The case and control manifests list one CGF file per line (blank
+lines and lines starting with '#' are skipped, relative names are
+relative to the manifest), as lantern's '--cgf-manifest'.
+
+The groups file has a group name and its tile positions on each line,
+tab separated:
+
+  BRCA1	247.00.0000-0151
+  REGION_A	2c5.00.0010,2c5.00.0013-0020
+
+Tile positions are hex 'path.version.step' with an optional '-end'
+step (end non-inclusive), the form lantern's 'gene-tiles' request
+lists them in.  Without a groups file every path is a group.
+
+Writes a tab separated table with one row per group.  The same test
+is available from lantern as the 'burden-test' request.
+
+*/
+
+var VERSION_STR string = "0.1, AGPLv3.0"
+var g_verboseFlag bool
+
+func read_manifest( fn string ) ( []string, error ) {
+  fp,e := os.Open( fn )
+  if e!=nil { return nil, e }
+  defer fp.Close()
+
+  dir := filepath.Dir( fn )
+  res := []string{}
+
+  scanner := bufio.NewScanner( fp )
+  for scanner.Scan() {
+    line := strings.TrimSpace( scanner.Text() )
+    if (len(line)==0) || strings.HasPrefix( line, "#" ) { continue }
+    if !filepath.IsAbs( line ) { line = filepath.Join( dir, line ) }
+    res = append( res, line )
+  }
+  return res, scanner.Err()
+}
+
+// Parse "path.version.step" or "path.version.beg-end" (hex, end
+// non-inclusive) into (path, step) positions.
+//
+func parse_position( s string ) ( [][2]int, error ) {
+  parts := strings.Split( s, "." )
+  if len(parts)!=3 { return nil, fmt.Errorf("invalid tile position %s", s) }
+
+  path,e := strconv.ParseInt( parts[0], 16, 64 )
+  if e!=nil { return nil, fmt.Errorf("invalid tile position %s: %v", s, e) }
+
+  step_range := strings.SplitN( parts[2], "-", 2 )
+  beg,e := strconv.ParseInt( step_range[0], 16, 64 )
+  if e!=nil { return nil, fmt.Errorf("invalid tile position %s: %v", s, e) }
+  end := beg+1
+  if len(step_range)==2 {
+    end,e = strconv.ParseInt( step_range[1], 16, 64 )
+    if e!=nil { return nil, fmt.Errorf("invalid tile position %s: %v", s, e) }
+    if end<=beg { return nil, fmt.Errorf("invalid tile position %s: empty range", s) }
+  }
+
+  res := [][2]int{}
+  for step:=beg; step<end; step++ { res = append( res, [2]int{ int(path), int(step) } ) }
+  return res, nil
+}
+
+func read_groups( fn string ) ( []burden.Group, error ) {
+  fp,e := os.Open( fn )
+  if e!=nil { return nil, e }
+  defer fp.Close()
+
+  res := []burden.Group{}
+
+  scanner := bufio.NewScanner( fp )
+  for line_no:=1; scanner.Scan(); line_no++ {
+    line := strings.TrimSpace( scanner.Text() )
+    if (len(line)==0) || strings.HasPrefix( line, "#" ) { continue }
+
+    fields := strings.Split( line, "\t" )
+    if len(fields)!=2 { return nil, fmt.Errorf("%s:%d: expected name and tile positions", fn, line_no) }
+
+    g := burden.Group{ Name:fields[0] }
+    seen := make( map[[2]int]bool )
+    for _,s := range strings.Split( fields[1], "," ) {
+      pos,e := parse_position( strings.TrimSpace(s) )
+      if e!=nil { return nil, fmt.Errorf("%s:%d: %v", fn, line_no, e) }
+      for i:=0; i<len(pos); i++ {
+        if seen[pos[i]] { continue }
+        seen[pos[i]] = true
+        g.Position = append( g.Position, pos[i] )
+      }
+    }
+    res = append( res, g )
+  }
+  return res, scanner.Err()
+}
+
+// One group per path, over the longest ABV of the samples.
+//
+func path_groups( cgs []*cgf.CGF ) []burden.Group {
+  path_len := make( map[int]int )
+  for s:=0; s<len(cgs); s++ {
+    for path_str,abv := range cgs[s].ABV {
+      p,e := strconv.ParseInt( path_str, 16, 64 )
+      if e!=nil { continue }
+      if len(abv) > path_len[int(p)] { path_len[int(p)] = len(abv) }
+    }
+  }
+
+  paths := []int{}
+  for p := range path_len { paths = append( paths, p ) }
+  sort.Ints( paths )
+
+  res := make( []burden.Group, len(paths) )
+  for i:=0; i<len(paths); i++ {
+    res[i].Name = fmt.Sprintf("%03x", paths[i])
+    for step:=0; step<path_len[paths[i]]; step++ {
+      res[i].Position = append( res[i].Position, [2]int{ paths[i], step } )
+    }
+  }
+  return res
+}
+
+func load_cgfs( fns []string, ref *cgf.CGF ) ( []*cgf.CGF, error ) {
+  cgs := make( []*cgf.CGF, len(fns) )
+  for i:=0; i<len(fns); i++ {
+    cg,e := cgf.Load( fns[i] )
+    if e!=nil { return nil, fmt.Errorf("%s: %v", fns[i], e) }
+    if (ref!=nil) && (cg.EncodedTileMapMd5Sum != ref.EncodedTileMapMd5Sum) {
+      return nil, fmt.Errorf("%s: tile map doesn't match the other samples", fns[i])
+    }
+    if ref==nil { ref = cg }
+    cgs[i] = cg
+    lightlog.Debug( "loaded %s", fns[i] )
+  }
+  return cgs, nil
+}
+
+func _main( c *cli.Context ) {
+  g_verboseFlag = c.Bool("Verbose")
+  if g_verboseFlag { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
+
+  if (len(c.String("case"))==0) || (len(c.String("control"))==0) {
+    lightlog.Error( "Provide case and control manifests" )
+    cli.ShowAppHelp( c )
+    os.Exit(1)
+  }
+
+  case_fns,e := read_manifest( c.String("case") )
+  if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+  control_fns,e := read_manifest( c.String("control") )
+  if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+
+  cases,e := load_cgfs( case_fns, nil )
+  if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+  if len(cases)==0 { lightlog.Error( "no cases in %s", c.String("case") ) ; os.Exit(1) }
+  controls,e := load_cgfs( control_fns, cases[0] )
+  if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+  if len(controls)==0 { lightlog.Error( "no controls in %s", c.String("control") ) ; os.Exit(1) }
+
+  var groups []burden.Group
+  if len(c.String("groups"))>0 {
+    groups,e = read_groups( c.String("groups") )
+    if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+  } else {
+    groups = path_groups( append( append( []*cgf.CGF{}, cases... ), controls... ) )
+  }
+  lightlog.Debug( "%d groups", len(groups) )
+
+  opt := burden.Option{
+    MaxFrequency : c.Float64("max-frequency"),
+    Permutation : c.Int("permutation"),
+    Seed : int64( c.Int("seed") ),
+  }
+
+  progress := func( done, total int ) {
+    if done%64==0 { lightlog.Debug( "group %d/%d", done, total ) }
+  }
+
+  res,e := burden.Burden( cases, controls, cases[0].TileMap, groups, opt, progress, nil )
+  if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+
+  ofp := os.Stdout
+  if (len(c.String("output"))>0) && (c.String("output")!="-") {
+    ofp,e = os.Create( c.String("output") )
+    if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+    defer ofp.Close()
+  }
+  bw := bufio.NewWriter( ofp )
+  defer bw.Flush()
+
+  fmt.Fprintf( bw, "group\tqualifying-variants\tcase-carriers\tcases\tcontrol-carriers\tcontrols\todds-ratio\tp-value" )
+  if opt.Permutation>0 { fmt.Fprintf( bw, "\tpermutation-p-value" ) }
+  fmt.Fprintf( bw, "\n" )
+
+  for i:=0; i<len(res); i++ {
+    r := res[i]
+    fmt.Fprintf( bw, "%s\t%d\t%d\t%d\t%d\t%d\t%g\t%g", r.Name, r.QualifyingVariant,
+      r.CaseCarrier, r.CaseCount, r.ControlCarrier, r.ControlCount, r.OddsRatio, r.PValue )
+    if opt.Permutation>0 { fmt.Fprintf( bw, "\t%g", r.PermutationPValue ) }
+    fmt.Fprintf( bw, "\n" )
+  }
+
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "cgfburden"
+  app.Usage = "Rare tile variant burden test of case and control CGF files"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+
+    cli.StringFlag{
+      Name: "case, a",
+      Usage: "Manifest of case CGF files",
+    },
+
+    cli.StringFlag{
+      Name: "control, b",
+      Usage: "Manifest of control CGF files",
+    },
+
+    cli.StringFlag{
+      Name: "groups, g",
+      Usage: "Groups file (name and tile positions per line), one group per path if empty",
+    },
+
+    cli.Float64Flag{
+      Name: "max-frequency, m",
+      Value: 0.01,
+      Usage: "Only count non-reference tile variants with frequency up to this",
+    },
+
+    cli.IntFlag{
+      Name: "permutation, n",
+      Value: 0,
+      Usage: "Number of case/control label permutations for the permutation p-value",
+    },
+
+    cli.IntFlag{
+      Name: "seed",
+      Value: 1,
+      Usage: "Random seed for the permutations",
+    },
+
+    cli.StringFlag{
+      Name: "output, o",
+      Value: "-",
+      Usage: "Output file",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag",
+    },
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
+    },
+
+  }
+
+  app.Run(os.Args)
+
+}
@@ -10,6 +10,8 @@ import "strconv"
 
 import "github.com/codegangsta/cli"
 
+import "../lightlog"
+
 var VERSION_STR string = "0.1, AGPLv3.0"
 
 var g_verboseFlag bool
@@ -27,9 +29,11 @@ func Md5sumToStr( m5 []byte ) string {
 
 func _main( c *cli.Context ) {
   g_verboseFlag = c.Bool("Verbose")
+  if g_verboseFlag { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
 
   if len( c.String("input-cgf")) == 0 {
-    fmt.Fprintf( os.Stderr, "Provide input CGF file\n" )
+    lightlog.Error( "Provide input CGF file" )
     cli.ShowAppHelp( c )
     os.Exit(1)
   }
@@ -37,7 +41,7 @@ func _main( c *cli.Context ) {
   //cg,err := cgf.LoadLean( c.String("input-cgf") )
   cg,err := cgf.Load( c.String("input-cgf") )
   if err!=nil {
-    fmt.Fprintf( os.Stderr, "%v\n", err )
+    lightlog.Error( "%v", err )
     cli.ShowAppHelp( c )
     os.Exit(1)
   }
@@ -46,7 +50,7 @@ func _main( c *cli.Context ) {
   str_tm_md5 := Md5sumToStr( tm_md5[:] )
 
   if str_tm_md5 != cg.EncodedTileMapMd5Sum {
-    fmt.Fprintf( os.Stderr, "md5sums don't match! %s != %s\n", str_tm_md5, cg.EncodedTileMapMd5Sum )
+    lightlog.Error( "md5sums don't match! %s != %s", str_tm_md5, cg.EncodedTileMapMd5Sum )
   }
   //fmt.Printf("%s %s\n", Md5sumToStr(tm_md5[:]), cg.TileMapStringMd5Sum )
 
@@ -56,17 +60,17 @@ func _main( c *cli.Context ) {
     path,_ := strconv.ParseInt( path_str, 16, 64 )
 
     if (path<0) {
-      fmt.Fprintf( os.Stderr, "path<0! (%d from %s)\n", path, path_str)
+      lightlog.Error( "path<0! (%d from %s)", path, path_str)
       continue
     }
 
     if path>=int64(len(cg.StepPerPath)) {
-      fmt.Fprintf( os.Stderr, "path (%d) exceeds StepPerPath length (%d)!\n", path, len(cg.StepPerPath))
+      lightlog.Error( "path (%d) exceeds StepPerPath length (%d)!", path, len(cg.StepPerPath))
       continue
     }
 
     if len(abv) != cg.StepPerPath[path] {
-      fmt.Fprintf( os.Stderr, "StepPerPath[%d] != len(abv) (%d)!\n", cg.StepPerPath[path], len(abv))
+      lightlog.Error( "StepPerPath[%d] != len(abv) (%d)!", cg.StepPerPath[path], len(abv))
       continue
     }
 
@@ -82,18 +86,18 @@ func _main( c *cli.Context ) {
 
         lookup,found := cg.OverflowMap[ overflow_key ]
         if !found {
-          fmt.Fprintf( os.Stderr, "Could not find %s in overflow table!  [%x,%x,%x) %s(!%s!)%s\n",
+          lightlog.Error( "Could not find %s in overflow table!  [%x,%x,%x) %s(!%s!)%s",
             overflow_key, s, p, e, abv[s:p], abv[p:p+1], abv[p+1:e] )
         }
 
         if (lookup < 0) || (lookup >= len(cg.TileMap)) {
-          fmt.Fprintf( os.Stderr, "lookup out of range lookup %d not in [%d,%d) for overflow key %s\n", lookup, 0, len(cg.TileMap), overflow_key )
+          lightlog.Error( "lookup out of range lookup %d not in [%d,%d) for overflow key %s", lookup, 0, len(cg.TileMap), overflow_key )
         }
 
       }
 
       if ch=='-' {
-        fmt.Fprintf( os.Stderr, "Found unmapped position! %s: %s(!%s!)%s\n", overflow_key, abv[s:p], abv[p:p+1], abv[p+1:e] )
+        lightlog.Error( "Found unmapped position! %s: %s(!%s!)%s", overflow_key, abv[s:p], abv[p:p+1], abv[p+1:e] )
 
       }
 
@@ -110,7 +114,7 @@ func _main( c *cli.Context ) {
     _ = s_step
 
     if _,ok := cg.ABV[s_path] ; !ok {
-      fmt.Fprintf( os.Stderr, "could not find path %s in ABV!\n", s_path )
+      lightlog.Error( "could not find path %s in ABV!", s_path )
       continue
     }
 
@@ -121,7 +125,7 @@ func _main( c *cli.Context ) {
       e := int(istep+10)
       if e>len(cg.ABV[s_path]) { e = len(cg.ABV[s_path]) }
 
-      fmt.Fprintf( os.Stderr, "Overflow entry %s does not map to overflow character! [%x,%x,%x) %s(!%s!)%s\n",
+      lightlog.Error( "Overflow entry %s does not map to overflow character! [%x,%x,%x) %s(!%s!)%s",
       overflow_key, s, istep, e, cg.ABV[s_path][s:istep], cg.ABV[s_path][istep:istep+1], cg.ABV[s_path][istep:e] )
       continue
     }
@@ -152,6 +156,10 @@ func main() {
       Name: "Verbose, V",
       Usage: "Verbose flag",
     },
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
+    },
   }
 
   app.Run(os.Args)
@@ -0,0 +1,211 @@
+package main
+
+import "os"
+import "fmt"
+import "bufio"
+import "strings"
+import "strconv"
+
+import "../cgf"
+import "../pca"
+
+import "github.com/codegangsta/cli"
+
+import "../lightlog"
+
+/*
+
+Principal component analysis of CGF files, outside of lantern.
+
+  cgfpca -p 247+3 -k 4 sample0.cgf sample1.cgf ...
+
+Writes a tab separated table with one row per CGF and one column per
+component, preceded by comment lines holding the variance explained
+by each component and the number of tile variants used.  The same
+analysis is available from lantern as the 'population-pca' request.
+
+*/
+
+var VERSION_STR string = "0.1, AGPLv3.0"
+var g_verboseFlag bool
+
+func parseIntOption( istr string, base int ) ([][2]int64, error) {
+  r := make( [][2]int64, 0, 8 )
+  commaval := strings.Split( istr, "," )
+  for i:=0; i<len(commaval); i++ {
+
+    if strings.Contains( commaval[i], "-" ) {
+
+      dashval := strings.Split( commaval[i], "-" )
+      if len(dashval) > 2 { return nil, fmt.Errorf("invalid option %s", commaval[i]) }
+
+      a,ee := strconv.ParseInt( dashval[0], base, 64 )
+      if ee!=nil { return nil, fmt.Errorf("invalid option %s: %v", dashval[0], ee ) }
+
+      if len(dashval[1])==0 {
+        r = append( r, [2]int64{a,-1} )
+        continue
+      }
+
+      b,ee := strconv.ParseInt( dashval[1], base, 64)
+      if ee!=nil { return nil, fmt.Errorf("invalid option %s: %v", dashval[1], ee ) }
+      r = append( r, [2]int64{a,b} )
+
+    } else if strings.Contains( commaval[i], "+" ) {
+
+      plusval := strings.Split( commaval[i], "+" )
+      if len(plusval) > 2 { return nil, fmt.Errorf("invalid option %s", commaval[i]) }
+
+      a,ee := strconv.ParseInt( plusval[0], base, 64 )
+      if ee!=nil { return nil, fmt.Errorf("invalid option %s: %v", plusval[0], ee ) }
+
+      if len(plusval[1])==0 {
+        r = append( r, [2]int64{a,-1} )
+        continue
+      }
+
+      b,ee := strconv.ParseInt( plusval[1], base, 64)
+      if ee!=nil { return nil, fmt.Errorf("invalid option %s: %v", plusval[1], ee ) }
+      if b<0 { return nil, fmt.Errorf("invalid option %s: %d < 0", plusval[1], b ) }
+      r = append( r, [2]int64{a,a+b} )
+
+    } else {
+      a,ee := strconv.ParseInt( commaval[i], base, 64 )
+      if ee!=nil { return nil, fmt.Errorf("invalid option %s: %v", commaval[i], ee ) }
+
+      r = append( r, [2]int64{a,a+1} )
+    }
+
+  }
+
+  return r,nil
+}
+
+func _main( c *cli.Context ) {
+  g_verboseFlag = c.Bool("Verbose")
+  if g_verboseFlag { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
+
+  fns := []string( c.Args() )
+  if len(fns) < 2 {
+    lightlog.Error( "Provide at least two CGF files" )
+    cli.ShowAppHelp( c )
+    os.Exit(1)
+  }
+
+  opt := pca.DosageOption{ MinFrequency:c.Float64("min-frequency") }
+  if len(c.String("path"))>0 {
+    r,e := parseIntOption( c.String("path"), 16 )
+    if e!=nil { lightlog.Error( "invalid path: %v", e ) ; os.Exit(1) }
+    opt.PathRange = r
+  }
+
+  cgs := make( []*cgf.CGF, len(fns) )
+  for i:=0; i<len(fns); i++ {
+    cg,e := cgf.Load( fns[i] )
+    if e!=nil { lightlog.Error( "%s: %v", fns[i], e ) ; os.Exit(1) }
+    if (i>0) && (cg.EncodedTileMapMd5Sum != cgs[0].EncodedTileMapMd5Sum) {
+      lightlog.Error( "%s: tile map doesn't match %s", fns[i], fns[0] )
+      os.Exit(1)
+    }
+    cgs[i] = cg
+    lightlog.Debug( "loaded %s", fns[i] )
+  }
+
+  progress := func( done, total int ) {
+    if done%64==0 { lightlog.Debug( "path %d/%d", done, total ) }
+  }
+
+  g,e := pca.DosageGram( cgs, cgs[0].TileMap, opt, progress, nil )
+  if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+  lightlog.Debug( "%d tile variants", g.Feature )
+
+  res,e := g.PCA( c.Int("component"), c.Int("max-iter"), 0, nil )
+  if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+
+  ofp := os.Stdout
+  if (len(c.String("output"))>0) && (c.String("output")!="-") {
+    ofp,e = os.Create( c.String("output") )
+    if e!=nil { lightlog.Error( "%v", e ) ; os.Exit(1) }
+    defer ofp.Close()
+  }
+  bw := bufio.NewWriter( ofp )
+  defer bw.Flush()
+
+  k := len(res.Eigenvalue)
+
+  fmt.Fprintf( bw, "# tile-variants %d\n", res.Feature )
+  fmt.Fprintf( bw, "# iterations %d\n", res.Iteration )
+  fmt.Fprintf( bw, "# eigenvalue" )
+  for j:=0; j<k; j++ { fmt.Fprintf( bw, "\t%g", res.Eigenvalue[j] ) }
+  fmt.Fprintf( bw, "\n# variance-explained" )
+  for j:=0; j<k; j++ { fmt.Fprintf( bw, "\t%g", res.VarianceExplained[j] ) }
+  fmt.Fprintf( bw, "\n" )
+
+  fmt.Fprintf( bw, "sample" )
+  for j:=0; j<k; j++ { fmt.Fprintf( bw, "\tPC%d", j+1 ) }
+  fmt.Fprintf( bw, "\n" )
+
+  for i:=0; i<len(fns); i++ {
+    fmt.Fprintf( bw, "%s", fns[i] )
+    for j:=0; j<k; j++ { fmt.Fprintf( bw, "\t%g", res.Coordinate[i][j] ) }
+    fmt.Fprintf( bw, "\n" )
+  }
+
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "cgfpca"
+  app.Usage = "Principal component analysis of tile variant dosages in CGF files"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+
+    cli.StringFlag{
+      Name: "path, p",
+      Usage: "Path range(s) (in hexadecimal, e.g. '247+3,2c5'), all paths if empty",
+    },
+
+    cli.IntFlag{
+      Name: "component, k",
+      Value: 10,
+      Usage: "Number of principal components",
+    },
+
+    cli.Float64Flag{
+      Name: "min-frequency, m",
+      Value: 0.01,
+      Usage: "Drop tile variants with minor frequency below this",
+    },
+
+    cli.IntFlag{
+      Name: "max-iter",
+      Value: 500,
+      Usage: "Maximum solver iterations",
+    },
+
+    cli.StringFlag{
+      Name: "output, o",
+      Value: "-",
+      Usage: "Output file",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag",
+    },
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
+    },
+
+  }
+
+  app.Run(os.Args)
+
+}
@@ -10,6 +10,8 @@ import "../cgf"
 
 import "github.com/codegangsta/cli"
 
+import "../lightlog"
+
 
 var VERSION_STR string = "0.1, AGPLv3.0"
 var g_verboseFlag bool
@@ -90,13 +92,13 @@ func parseIntOption( istr string, base int ) ([][]int64, error) {
 func _tile_peek( c *cli.Context ) {
 
   if len(c.String("cgf-file"))==0 {
-    fmt.Fprintf( os.Stderr, "provide cgf-file\n" )
+    lightlog.Error( "provide cgf-file" )
     cli.ShowAppHelp( c )
     os.Exit(1)
   }
 
   cg,ee := cgf.Load( c.String("cgf-file") ) ; _ = cg
-  if ee!=nil { fmt.Fprintf( os.Stderr, "%s: %v\n", c.String("cgf-file"), ee) ; os.Exit(1) }
+  if ee!=nil { lightlog.Error( "%s: %v", c.String("cgf-file"), ee) ; os.Exit(1) }
 
   pathraw := c.String("path") ; _ = pathraw
   stepraw := c.String("step") ; _ = stepraw
@@ -122,11 +124,11 @@ func _tile_peek( c *cli.Context ) {
   }
 
   path_ranges,ee := parseIntOption( path_opt, pathbase )
-  if ee!=nil { fmt.Fprintf( os.Stderr, "%v",ee ) ; os.Exit(1) }
+  if ee!=nil { lightlog.Error( "%v",ee ) ; os.Exit(1) }
   _ = path_ranges
 
   step_ranges,ee := parseIntOption( step_opt, stepbase )
-  if ee!=nil { fmt.Fprintf( os.Stderr, "%v",ee ) ; os.Exit(1) }
+  if ee!=nil { lightlog.Error( "%v",ee ) ; os.Exit(1) }
   _ = step_ranges
 
   // allele, value
@@ -138,7 +140,7 @@ func _tile_peek( c *cli.Context ) {
 
     abv,abv_ok := cg.ABV[ p_s ]
     if !abv_ok {
-      fmt.Fprintf( os.Stderr, "%s invalid index into ABV object\n", p_s )
+      lightlog.Error( "%s invalid index into ABV object", p_s )
       os.Exit(1)
     }
 
@@ -152,7 +154,7 @@ func _tile_peek( c *cli.Context ) {
       if s_e>=0 {
 
         if (s_s<0) || (s_e>len(abv)) || (s_s>=len(abv)) {
-          fmt.Fprintf( os.Stderr, "step ranges must be in [%d,%d) (value range [%d,%d))\n", 0, len(abv), s_s, s_e)
+          lightlog.Error( "step ranges must be in [%d,%d) (value range [%d,%d))", 0, len(abv), s_s, s_e)
           os.Exit(1)
         }
 
@@ -162,7 +164,7 @@ func _tile_peek( c *cli.Context ) {
       } else {
 
         if (s_s<0) || (s_s>=len(abv)) {
-          fmt.Fprintf( os.Stderr, "step ranges must be in [%d,%d) (value range [%d,%d))\n", 0, len(abv), s_s, s_e)
+          lightlog.Error( "step ranges must be in [%d,%d) (value range [%d,%d))", 0, len(abv), s_s, s_e)
           os.Exit(1)
         }
 
@@ -175,7 +177,7 @@ func _tile_peek( c *cli.Context ) {
         if abv[ai] == '*' { continue; }
         _path,_step,_tmv,e := cg.LookupABVStartTileMapVariant( int(path_ranges[pind][0]), int(ai) )
         if e!=nil {
-          fmt.Fprintf( os.Stderr, "lookup fail for %d,%d (%x,%x), got %v\n",
+          lightlog.Error( "lookup fail for %d,%d (%x,%x), got %v",
             int(path_ranges[pind][0]), int(ai),
             int(path_ranges[pind][0]), int(ai),
             e)
@@ -232,13 +234,13 @@ func _tile_peek( c *cli.Context ) {
 func _abv_peek( c *cli.Context ) {
 
   if len(c.String("cgf-file"))==0 {
-    fmt.Fprintf( os.Stderr, "provide cgf-file\n" )
+    lightlog.Error( "provide cgf-file" )
     cli.ShowAppHelp( c )
     os.Exit(1)
   }
 
   cg,ee := cgf.Load( c.String("cgf-file") ) ; _ = cg
-  if ee!=nil { fmt.Fprintf( os.Stderr, "%s: %v\n", c.String("cgf-file"), ee) ; os.Exit(1) }
+  if ee!=nil { lightlog.Error( "%s: %v", c.String("cgf-file"), ee) ; os.Exit(1) }
 
   pathraw := c.String("path") ; _ = pathraw
   stepraw := c.String("step") ; _ = stepraw
@@ -264,12 +266,12 @@ func _abv_peek( c *cli.Context ) {
   }
 
   path_ranges,ee := parseIntOption( path_opt, pathbase )
-  if ee!=nil { fmt.Fprintf( os.Stderr, "%v",ee ) ; os.Exit(1) }
+  if ee!=nil { lightlog.Error( "%v",ee ) ; os.Exit(1) }
   _ = path_ranges
 
   //step_ranges,ee := parseIntOption(stepraw, 10 )
   step_ranges,ee := parseIntOption( step_opt, stepbase )
-  if ee!=nil { fmt.Fprintf( os.Stderr, "%v",ee ) ; os.Exit(1) }
+  if ee!=nil { lightlog.Error( "%v",ee ) ; os.Exit(1) }
   _ = step_ranges
 
 
@@ -280,7 +282,7 @@ func _abv_peek( c *cli.Context ) {
 
     abv,abv_ok := cg.ABV[ p_s ]
     if !abv_ok {
-      fmt.Fprintf( os.Stderr, "%s invalid index into ABV object\n", p_s )
+      lightlog.Error( "%s invalid index into ABV object", p_s )
       os.Exit(1)
     }
 
@@ -292,7 +294,7 @@ func _abv_peek( c *cli.Context ) {
       if s_e>=0 {
 
         if (s_s<0) || (s_e>len(abv)) || (s_s>=len(abv)) {
-          fmt.Fprintf( os.Stderr, "step ranges must be in [%d,%d) (value range [%d,%d))\n", 0, len(abv), s_s, s_e)
+          lightlog.Error( "step ranges must be in [%d,%d) (value range [%d,%d))", 0, len(abv), s_s, s_e)
           os.Exit(1)
         }
 
@@ -300,7 +302,7 @@ func _abv_peek( c *cli.Context ) {
       } else {
 
         if (s_s<0) || (s_s>=len(abv)) {
-          fmt.Fprintf( os.Stderr, "step ranges must be in [%d,%d) (value range [%d,%d))\n", 0, len(abv), s_s, s_e)
+          lightlog.Error( "step ranges must be in [%d,%d) (value range [%d,%d))", 0, len(abv), s_s, s_e)
           os.Exit(1)
         }
 
@@ -315,6 +317,10 @@ func _abv_peek( c *cli.Context ) {
 }
 
 func _main( c *cli.Context ) {
+  g_verboseFlag = c.Bool("Verbose")
+
+  if g_verboseFlag { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
 
   action := c.String("action") ; _ = action
 
@@ -324,7 +330,7 @@ func _main( c *cli.Context ) {
     _tile_peek( c )
   } else if action == "length" {
     cg,ee := cgf.Load( c.String("cgf-file") ) ; _ = cg
-    if ee!=nil { fmt.Fprintf( os.Stderr, "%s: %v\n", c.String("cgf-file"), ee) ; os.Exit(1) }
+    if ee!=nil { lightlog.Error( "%s: %v", c.String("cgf-file"), ee) ; os.Exit(1) }
 
     path := c.String("path")
     pathbase := 10
@@ -332,9 +338,9 @@ func _main( c *cli.Context ) {
 
     xl,e := strconv.ParseInt( path, pathbase, 64)
     x := int(xl)
-    if e!=nil { fmt.Fprintf(os.Stderr, "invalid path: %v\n", e) ; os.Exit(1) }
+    if e!=nil { lightlog.Error( "invalid path: %v", e) ; os.Exit(1) }
     if (x<0) || (x>len(cg.StepPerPath)) {
-      fmt.Fprintf(os.Stderr, "invalid path: %d needs to be in the range [%d,%d)\n", x, 0, len(cg.StepPerPath))
+      lightlog.Error( "invalid path: %d needs to be in the range [%d,%d)", x, 0, len(cg.StepPerPath))
       os.Exit(1)
     }
 
@@ -396,6 +402,10 @@ func main() {
       Name: "Verbose, V",
       Usage: "Verbose flag",
     },
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
+    },
 
   }
 
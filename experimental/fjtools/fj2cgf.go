@@ -19,6 +19,8 @@ import "../cgf"
 
 import "github.com/codegangsta/cli"
 
+import "../lightlog"
+
 import "runtime/pprof"
 
 var VERSION_STR string = "0.4, AGPLv3.0"
@@ -244,11 +246,11 @@ func hasGap( fjHeader *sloppyjson.SloppyJSON ) bool {
       vv := strings.Split( nod.S, " " )
       v := strings.Split( vv[2], "-" )
       hg19_s,err = strconv.Atoi(v[0])
-      if err!=nil { fmt.Fprintf( os.Stderr, "hg19_s conversion %v\n", err ) }
+      if err!=nil { lightlog.Error( "hg19_s conversion %v", err ) }
 
       v = strings.Split( vv[3], "+" )
       hg19_e,err = strconv.Atoi(v[0])
-      if err!=nil { fmt.Fprintf( os.Stderr, "hg19_e conversion %v\n", err ) }
+      if err!=nil { lightlog.Error( "hg19_e conversion %v", err ) }
     }
   }
 
@@ -257,10 +259,10 @@ func hasGap( fjHeader *sloppyjson.SloppyJSON ) bool {
       if strings.HasPrefix( fjHeader.O["notes"].L[i].S, "gapOnTag ") {
         v := strings.Split( fjHeader.O["notes"].L[i].S, " " )
         s,ee := strconv.Atoi(v[3])
-        if ee !=nil { fmt.Fprintf( os.Stderr, "s conversion %v\n", ee ) }
+        if ee !=nil { lightlog.Error( "s conversion %v", ee ) }
 
         e,ee := strconv.Atoi(v[4])
-        if ee!=nil { fmt.Fprintf( os.Stderr, "e conversion %v\n", ee ) }
+        if ee!=nil { lightlog.Error( "e conversion %v", ee ) }
 
         if (e < hg19_s) || (s > hg19_e) { continue }
 
@@ -434,7 +436,7 @@ func UpdateABVPloidy1( cg *cgf.CGF, tileLibFn string, fastjFn string ) error {
     if pos,ok := tleCache[ fjBaseId ].Md5sumPosMap[md5s] ; ok {
       variantPos = pos
     } else {
-      fmt.Fprintf( os.Stderr, "WARNING: %d %x %s not found in tleCache!\n", fjBaseId, fjBaseId, md5s )
+      lightlog.Warn( "%d %x %s not found in tleCache!", fjBaseId, fjBaseId, md5s )
     }
 
     phaseVariant[0] = append( phaseVariant[0], variantPos )
@@ -636,7 +638,7 @@ func UpdateABVPloidy2( cg *cgf.CGF, tileLibFn string, fastjFn string ) error {
     if pos,ok := tleCache[ fjBaseId ].Md5sumPosMap[md5s] ; ok {
       variantPos = pos
     } else {
-      fmt.Fprintf( os.Stderr, "WARNING: %d %x %s not found in tleCache!\n", fjBaseId, fjBaseId, md5s )
+      lightlog.Warn( "%d %x %s not found in tleCache!", fjBaseId, fjBaseId, md5s )
     }
 
 
@@ -757,7 +759,7 @@ func _main( c *cli.Context ) {
   if gProfileFlag {
     prof_f,err := os.Create( gProfileFile )
     if err != nil {
-      fmt.Fprintf( os.Stderr, "Could not open profile file %s: %v\n", gProfileFile, err )
+      lightlog.Error( "Could not open profile file %s: %v", gProfileFile, err )
       os.Exit(2)
     }
 
@@ -766,19 +768,21 @@ func _main( c *cli.Context ) {
   }
 
   g_verboseFlag   = c.Bool("Verbose")
+  if g_verboseFlag { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
   gProfileFlag    = c.Bool("pprof")
   gMemProfileFlag = c.Bool("mprof")
 
   gPloidy = c.Int("ploidy")
 
   if len( c.String("input-fastj")) == 0 {
-    fmt.Fprintf( os.Stderr, "Provide input FastJ file\n" )
+    lightlog.Error( "Provide input FastJ file" )
     cli.ShowAppHelp( c )
     os.Exit(1)
   }
 
   if len( c.String("tile-library")) == 0 {
-    fmt.Fprintf( os.Stderr, "Provide tile library\n" )
+    lightlog.Error( "Provide tile library" )
     cli.ShowAppHelp( c )
     os.Exit(1)
   }
@@ -788,7 +792,7 @@ func _main( c *cli.Context ) {
     var err error
     gCGF,err = cgf.Load( c.String("cgf-file") )
     if err!=nil {
-      fmt.Fprintf( os.Stderr, "%v\n", err )
+      lightlog.Error( "%v", err )
       os.Exit(1)
     }
   } else {
@@ -806,7 +810,7 @@ func _main( c *cli.Context ) {
   fastj_fns := strings.Split( c.String("input-fastj"), "," )
 
   if len(tile_lib_fns) != len(fastj_fns) {
-    fmt.Fprintf( os.Stderr, "tile library list length (%d) does not match fastj input list length (%d)\n",
+    lightlog.Error( "tile library list length (%d) does not match fastj input list length (%d)",
       len(tile_lib_fns), len(fastj_fns) )
     os.Exit(1)
   }
@@ -814,19 +818,19 @@ func _main( c *cli.Context ) {
   for i:=0; i<len(tile_lib_fns); i++ {
 
     if g_verboseFlag {
-      fmt.Fprintf( os.Stderr, ">>> %s %s\n", tile_lib_fns[i], fastj_fns[i])
+      lightlog.Debug( "%s %s", tile_lib_fns[i], fastj_fns[i])
     }
 
     if gPloidy == 1 {
       e := UpdateABVPloidy1( gCGF, tile_lib_fns[i], fastj_fns[i] )
       if e!=nil {
-        fmt.Fprintf( os.Stderr, "ERROR: processing %s %s: %v\n", tile_lib_fns[i], fastj_fns[i], e)
+        lightlog.Error( "processing %s %s: %v", tile_lib_fns[i], fastj_fns[i], e)
         os.Exit(1)
       }
     } else if gPloidy == 2 {
       e := UpdateABVPloidy2( gCGF, tile_lib_fns[i], fastj_fns[i] )
       if e!=nil {
-        fmt.Fprintf( os.Stderr, "ERROR: processing %s %s: %v\n", tile_lib_fns[i], fastj_fns[i], e)
+        lightlog.Error( "processing %s %s: %v", tile_lib_fns[i], fastj_fns[i], e)
         os.Exit(1)
       }
     }
@@ -840,7 +844,7 @@ func _main( c *cli.Context ) {
     var err error
     ofp,err = os.Create( c.String("output-cgf") )
     if err!=nil {
-      fmt.Fprintf( os.Stderr, "%v", err )
+      lightlog.Error( "%v", err )
       os.Exit(1)
     }
     defer ofp.Close()
@@ -899,6 +903,10 @@ func main() {
       Name: "Verbose, V",
       Usage: "Verbose flag",
     },
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
+    },
 
     cli.BoolFlag{
       Name: "pprof",
@@ -4,12 +4,12 @@ import "fmt"
 import "os"
 import "crypto/md5"
 
-import "log"
-
 import "github.com/abeconnelly/autoio"
 import "github.com/abeconnelly/sloppyjson"
 import "github.com/codegangsta/cli"
 
+import "../lightlog"
+
 
 var VERSION_STR string = "0.2.0, AGPLv3.0"
 
@@ -55,7 +55,7 @@ func tile_check( sj *sloppyjson.SloppyJSON, seq []byte ) error {
     }
   } else if len( sj.O["startTag"].S ) != g_tagLength {
 
-    fmt.Printf(">>>>> %v %v %v %v --> %v\n", sj.O["startTile"].Y, sj.O["startTag"].S, sj.O["startSeq"].S, "???", sj.O["startTile"].Y == "true"  )
+    lightlog.Debug("%v %v %v %v --> %v", sj.O["startTile"].Y, sj.O["startTag"].S, sj.O["startSeq"].S, "???", sj.O["startTile"].Y == "true"  )
 
     return fmt.Errorf( "ERROR: len(startTag) != %d (len(startTag)=%d (startTile %s))", g_tagLength, len(sj.O["startTag"].S), sj.O["startTile"].Y )
   }
@@ -92,10 +92,12 @@ func tile_check( sj *sloppyjson.SloppyJSON, seq []byte ) error {
 
 func _main( c *cli.Context ) {
   g_verboseFlag = c.Bool("Verbose")
+  if g_verboseFlag { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
   g_tagLength = c.Int("tag-length")
 
   if len( c.String("input-fastj")) == 0 {
-    fmt.Fprintf( os.Stderr, "Provide input FastJ file\n" )
+    lightlog.Error( "Provide input FastJ file" )
     cli.ShowAppHelp( c )
     os.Exit(1)
   }
@@ -106,7 +108,7 @@ func _main( c *cli.Context ) {
 
   scanner,err := autoio.OpenReadScanner( c.String("input-fastj") )
   if err != nil {
-    fmt.Fprintf( os.Stderr, "%v", err )
+    lightlog.Error( "%v", err )
     os.Exit(1)
   }
   defer scanner.Close()
@@ -127,14 +129,14 @@ func _main( c *cli.Context ) {
 
       if !first_pass {
         e = tile_check( sj, seq )
-        if e != nil { log.Fatal( fmt.Sprintf("%v (line_no %d)", e, line_no) ) }
+        if e != nil { lightlog.Fatal("%v (line_no %d)", e, line_no) }
       }
       first_pass=false
 
       seq = seq[0:0]
 
       sj,e = sloppyjson.Loads( l[1:] ) ; _ = sj
-      if e!=nil { log.Fatal(e) }
+      if e!=nil { lightlog.Fatal("%v", e) }
 
       continue
     }
@@ -144,7 +146,7 @@ func _main( c *cli.Context ) {
 
   if !first_pass {
     e = tile_check( sj, seq )
-    if e != nil { log.Fatal(e) }
+    if e != nil { lightlog.Fatal("%v", e) }
   }
 
   fmt.Printf("ok\n");
@@ -176,6 +178,10 @@ func main() {
       Name: "Verbose, V",
       Usage: "Verbose flag",
     },
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
+    },
   }
 
   app.Run(os.Args)
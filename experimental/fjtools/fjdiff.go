@@ -13,6 +13,8 @@ import "../bioenv"
 
 import "github.com/codegangsta/cli"
 
+import "../lightlog"
+
 
 var VERSION_STR string = "0.1, AGPLv3.0"
 var g_verboseFlag bool
@@ -66,16 +68,18 @@ func cmp_fastj( a *tile.TileSet, b *tile.TileSet ) {
 
 func _main( c *cli.Context ) {
   g_verboseFlag = c.Bool("Verbose")
+  if g_verboseFlag { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
   tagLength := c.Int("tag-length")
 
   if len( c.String("a-input-fastj")) == 0 {
-    fmt.Fprintf( os.Stderr, "Provide input A FastJ file\n" )
+    lightlog.Error( "Provide input A FastJ file" )
     cli.ShowAppHelp( c )
     os.Exit(1)
   }
 
   if len( c.String("b-input-fastj")) == 0 {
-    fmt.Fprintf( os.Stderr, "Provide input B FastJ file\n" )
+    lightlog.Error( "Provide input B FastJ file" )
     cli.ShowAppHelp( c )
     os.Exit(1)
   }
@@ -86,14 +90,14 @@ func _main( c *cli.Context ) {
 
   a_scanner,err := bioenv.OpenScanner( c.String("a-input-fastj") )
   if err != nil {
-    fmt.Fprintf( os.Stderr, "%v", err )
+    lightlog.Error( "%v", err )
     os.Exit(1)
   }
   defer a_scanner.Close()
 
   b_scanner,err := bioenv.OpenScanner( c.String("b-input-fastj") )
   if err != nil {
-    fmt.Fprintf( os.Stderr, "%v", err )
+    lightlog.Error( "%v", err )
     os.Exit(1)
   }
   defer b_scanner.Close()
@@ -103,13 +107,13 @@ func _main( c *cli.Context ) {
 
   err = aTileSet.FastjScanner( a_scanner.Scanner )
   if err != nil {
-    fmt.Fprintf( os.Stderr, "%v\n", err )
+    lightlog.Error( "%v", err )
     os.Exit(1)
   }
 
   err = bTileSet.FastjScanner( b_scanner.Scanner )
   if err != nil {
-    fmt.Fprintf( os.Stderr, "%v\n", err )
+    lightlog.Error( "%v", err )
     os.Exit(1)
   }
 
@@ -150,6 +154,10 @@ func main() {
       Name: "Verbose, V",
       Usage: "Verbose flag",
     },
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
+    },
 
   }
 
@@ -22,8 +22,6 @@ package main
 import "fmt"
 import "os"
 
-import "log"
-
 import "strings"
 import "strconv"
 
@@ -31,6 +29,8 @@ import "github.com/abeconnelly/autoio"
 import "github.com/abeconnelly/sloppyjson"
 import "github.com/codegangsta/cli"
 
+import "../lightlog"
+
 
 var VERSION_STR string = "0.1.0, AGPLv3.0"
 
@@ -158,6 +158,8 @@ func pass_filter(tileid string, seed_tile_len int) bool {
 
 func _main( c *cli.Context ) {
   g_verboseFlag = c.Bool("Verbose")
+  if g_verboseFlag { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
 
   beg_str := c.String("start")
   end_str := c.String("end")
@@ -171,14 +173,14 @@ func _main( c *cli.Context ) {
   }
 
   if len( c.String("input-fastj")) == 0 {
-    fmt.Fprintf( os.Stderr, "Provide input FastJ file\n" )
+    lightlog.Error( "Provide input FastJ file" )
     cli.ShowAppHelp( c )
     os.Exit(1)
   }
 
   scanner,err := autoio.OpenReadScannerSimple( c.String("input-fastj") )
   if err != nil {
-    fmt.Fprintf( os.Stderr, "%v", err )
+    lightlog.Error( "%v", err )
     os.Exit(1)
   }
   defer scanner.Close()
@@ -202,7 +204,7 @@ func _main( c *cli.Context ) {
     if l[0]=='>' {
 
       sj,e := sloppyjson.Loads(l[1:])
-      if e!=nil { log.Fatal(e) }
+      if e!=nil { lightlog.Fatal("%v", e) }
 
       tileid := sj.O["tileID"].S
       seed_tile_len := int(sj.O["seedTileLength"].P)
@@ -277,6 +279,10 @@ func main() {
       Name: "Verbose, V",
       Usage: "Verbose flag",
     },
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
+    },
   }
 
   app.Run(os.Args)
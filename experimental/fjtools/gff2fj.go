@@ -207,6 +207,8 @@ import _ "compress/gzip"
 
 import "github.com/codegangsta/cli"
 
+import "../lightlog"
+
 import "math/rand"
 
 import "crypto/md5"
@@ -660,7 +662,7 @@ func fill_no_call( s []byte, ds int ) []byte {
 func append_with_ref_and_nocall( s, ref []byte, gapEndPos, spos, epos int) []byte {
   dpos := epos - spos
   if dpos < 0 {
-    fmt.Fprintf( os.Stderr, "ERROR: append_with_ref_and_nocall epos (%d) < spos (%d)\n", epos, spos )
+    lightlog.Error( "append_with_ref_and_nocall epos (%d) < spos (%d)", epos, spos )
     fmt.Fprintf( os.Stdout, "ERROR: append_with_ref_and_nocall epos (%d) < spos (%d)\n", epos, spos )
     panic( fmt.Errorf("ERROR: append_with_ref_and_nocall epos (%d) < spos (%d)\n", epos, spos ) )
   }
@@ -1427,6 +1429,8 @@ func initCommandLineOptions( c *cli.Context ) {
   g_randomSeed = int64(c.Int("seed"))
 
   g_verboseFlag = c.Bool("verbose")
+  if g_verboseFlag { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
   g_notes = c.String("note")
 
   if g_variantPolicy == "HETA" {
@@ -1434,25 +1438,25 @@ func initCommandLineOptions( c *cli.Context ) {
   } else if g_variantPolicy == "RANDOM" {
   } else if g_variantPolicy == "REGEX" {
   } else {
-    fmt.Fprintf( os.Stderr, "Unknown variant policy %s\n", g_variantPolicy)
+    lightlog.Error( "Unknown variant policy %s", g_variantPolicy)
     cli.ShowAppHelp(c)
     os.Exit(2)
   }
 
   if len(g_gffFileName)==0 {
-    fmt.Fprintf( os.Stderr, "Provide input GFF file\n")
+    lightlog.Error( "Provide input GFF file")
     cli.ShowAppHelp(c)
     os.Exit(2)
   }
 
   if len(g_fastjFileName)==0 {
-    fmt.Fprintf( os.Stderr, "Provide input FastJ file\n")
+    lightlog.Error( "Provide input FastJ file")
     cli.ShowAppHelp(c)
     os.Exit(2)
   }
 
   if len(g_chromFileName)==0 {
-    fmt.Fprintf( os.Stderr, "Provide chromosome FASTA file\n")
+    lightlog.Error( "Provide chromosome FASTA file")
     cli.ShowAppHelp(c)
     os.Exit(2)
   }
@@ -1460,7 +1464,7 @@ func initCommandLineOptions( c *cli.Context ) {
   var err error
   gBioEnvWriter,err = bioenv.CreateWriter( g_outputFastjFileName )
   if err != nil {
-    fmt.Fprintf( os.Stderr, "Could not open FastJ file '%s' for writing: %v\n", g_outputFastjFileName, err )
+    lightlog.Error( "Could not open FastJ file '%s' for writing: %v", g_outputFastjFileName, err )
     os.Exit(2)
   }
 
@@ -1527,6 +1531,10 @@ func main() {
       Name: "verbose, V",
       Usage: "Verbose flag" },
 
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines" },
+
     cli.BoolFlag{
       Name: "profile",
       Usage: "Profile flag" },
@@ -1589,7 +1597,7 @@ func _main( c *cli.Context ) {
   if gProfileFlag {
     prof_f,err := os.Create( gProfileFile )
     if err != nil {
-      fmt.Fprintf( os.Stderr, "Could not open profile file %s: %v\n", gProfileFile, err )
+      lightlog.Error( "Could not open profile file %s: %v", gProfileFile, err )
       os.Exit(2)
     }
 
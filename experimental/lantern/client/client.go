@@ -0,0 +1,666 @@
+package client
+
+/*
+
+Typed client for the lantern API.
+
+Every lantern query is a JSON 'LanternRequest' POSTed to the
+server root.  The typed methods below fill in the request for
+each query type and decode the matching response.  Responses
+with a 'Type' of "error" or "failure" are returned as *Error.
+
+Example:
+
+  cl := client.New( "http://localhost:8080" )
+  info,e := cl.SystemInfo( context.Background() )
+  if e!=nil { ... }
+  fmt.Printf("%v\n", info.SampleId)
+
+*/
+
+import "fmt"
+import "io"
+import "io/ioutil"
+import "bytes"
+import "strings"
+import "time"
+import "context"
+import "net/http"
+import "encoding/json"
+
+var DefaultURL string = "http://localhost:8080"
+
+type Client struct {
+  URL string
+  HTTPClient *http.Client
+
+  // Number of times a request is retried after a transport error
+  // or a 5xx status, waiting RetryWait (doubled each time) between
+  // attempts.  Only requests that are safe to send again are
+  // retried: not 'Async' ones (which could start the job twice) and
+  // none with an APIKey (which could spend the privacy budget twice).
+  //
+  Retries int
+  RetryWait time.Duration
+
+  // Sent as the X-Api-Key header if set, for servers in protected
+  // mode.
+  //
+  APIKey string
+}
+
+func New( url string ) *Client {
+  if len(url)==0 { url = DefaultURL }
+  return &Client{ URL: url, HTTPClient: http.DefaultClient, Retries: 2, RetryWait: 500*time.Millisecond }
+}
+
+type Request struct {
+  Type string
+  Dataset string `json:",omitempty"`
+  Note string `json:",omitempty"`
+  Message string `json:",omitempty"`
+
+  SampleId []string
+  CaseSampleId []string `json:",omitempty"`
+  ControlSampleId []string `json:",omitempty"`
+  TileVariantId []string `json:",omitempty"`
+  TileGroupVariantId [][]string `json:",omitempty"`
+  TileGroupVariantIdRange [][]map[string][]int `json:",omitempty"`
+  TileId []string `json:",omitempty"`
+  Position []string `json:",omitempty"`
+  Assembly string `json:",omitempty"`
+  Phase string `json:",omitempty"`
+  Gene []string `json:",omitempty"`
+
+  PathStep []string `json:",omitempty"`
+  Path string `json:",omitempty"`
+  Step string `json:",omitempty"`
+
+  Component int `json:",omitempty"`
+  MinFrequency float64 `json:",omitempty"`
+  MaxFrequency float64 `json:",omitempty"`
+  Permutation int `json:",omitempty"`
+  Seed int64 `json:",omitempty"`
+
+  VariantId map[string]string `json:",omitempty"`
+
+  Limit int `json:",omitempty"`
+  Cursor string `json:",omitempty"`
+
+  // Result format, "json" if empty.  Use Table for "tsv" and "csv".
+  //
+  Format string `json:",omitempty"`
+
+  // Ask for an 'Explain' section in the response, decode it with
+  // Do into a struct with an 'Explain *Explain' field (or DoRaw).
+  //
+  Explain bool `json:",omitempty"`
+
+  Batch []Request `json:",omitempty"`
+
+  Async bool `json:",omitempty"`
+  JobId string `json:",omitempty"`
+}
+
+// Error codes from lantern's error catalogue.
+//
+const (
+  ErrParse = "ParseError"
+  ErrInvalidRequestType = "InvalidRequestType"
+  ErrInvalidParameter = "InvalidParameter"
+  ErrInvalidTileId = "InvalidTileId"
+  ErrInvalidPosition = "InvalidPosition"
+  ErrUnknownSample = "UnknownSample"
+  ErrUnknownAssembly = "UnknownAssembly"
+  ErrUnknownJob = "UnknownJob"
+  ErrUnknownGene = "UnknownGene"
+  ErrNotFound = "NotFound"
+  ErrNotAcceptable = "NotAcceptable"
+  ErrLimitExceeded = "LimitExceeded"
+  ErrJobNotReady = "JobNotReady"
+  ErrCancelled = "Cancelled"
+  ErrNotReady = "NotReady"
+  ErrUnauthorized = "Unauthorized"
+  ErrNotPermitted = "NotPermitted"
+  ErrBudgetExhausted = "PrivacyBudgetExhausted"
+  ErrInternal = "InternalError"
+)
+
+// Error as reported by lantern in the response body.  Code is the
+// catalogue code (empty for errors from older servers or from the
+// transport) and Detail holds the code specific fields.
+//
+type Error struct {
+  Type string
+  Code string `json:"Error"`
+  Message string
+  Detail map[string]interface{}
+
+  StatusCode int `json:"-"`
+}
+
+func (e *Error) Error() string {
+  if len(e.Code)>0 { return fmt.Sprintf("lantern %s: %s", e.Code, e.Message) }
+  return fmt.Sprintf("lantern %s: %s", e.Type, e.Message)
+}
+
+// True if e is a lantern error with the catalogue code.
+//
+func IsCode( e error, code string ) bool {
+  le,ok := e.(*Error)
+  return ok && (le.Code==code)
+}
+
+type TileStats struct {
+  Total int
+  CacheHit int
+  CacheMiss int
+  DBHit int
+  DBMiss int
+}
+
+// How lantern answered a request with Explain set.
+//
+type Explain struct {
+  Type string
+  SampleCount int
+  PositionCount int
+  VariantIndex bool
+  Range map[string]interface{}
+  TileStats TileStats
+
+  ParseMs float64
+  LookupMs float64
+  SerializeMs float64
+  TotalMs float64
+}
+
+type ResultCacheStats struct {
+  Hit int
+  Miss int
+  Entries int
+  Bytes int
+  MaxBytes int
+  HitRate float64
+}
+
+// Sample residency under the memory budget, sizes in bytes.
+//
+type MemoryStats struct {
+  BudgetBytes int64
+  ResidentBytes int64
+  SampleCount int
+  ResidentSamples int
+  PinnedSamples int
+  Evictions int
+  Reloads int
+}
+
+type SystemInfoResponse struct {
+  Type string
+  Message string
+
+  LanternVersion string
+  LibraryVersion string
+  TileMapVersion string
+  CGFVersion string
+
+  Stats TileStats
+  ResultCache ResultCacheStats
+  Memory MemoryStats
+
+  SampleId []string
+}
+
+type TileSequenceResponse struct {
+  Type string
+  Message string
+  Cursor string
+  Result map[string]string
+}
+
+// Result maps the sample id to a list of tile ids per allele.
+//
+type SampleAlleleResponse struct {
+  Type string
+  Message string
+  Result map[string][][]string
+}
+
+type TileRange struct {
+  Range [2]int
+  Permit bool
+}
+
+type SampleTileGroupMatchResponse struct {
+  Type string
+  Message string
+  TileGroupVariantId []map[string][]TileRange
+  Result []string
+}
+
+type SampleIntersectResponse struct {
+  Type string
+  Message string
+  Cursor string
+  Result []string
+}
+
+type VariantFrequency struct {
+  SampleCount int
+  AlleleCount int
+  CalledAlleleCount int
+  Frequency float64
+}
+
+// Result is keyed by tile variant id.
+//
+type VariantFrequencyResponse struct {
+  Type string
+  Message string
+  Result map[string]VariantFrequency
+}
+
+type SampleSimilarity struct {
+  SampleA string
+  SampleB string
+
+  Compared int
+  IBS0 int
+  IBS1 int
+  IBS2 int
+
+  Concordance float64
+  Kinship float64
+}
+
+// Result holds one entry per sample pair.
+//
+type SampleSimilarityResponse struct {
+  Type string
+  Message string
+  Result []SampleSimilarity
+}
+
+// Coordinate is indexed by sample (in the order of Sample), then
+// component.
+//
+type PopulationPCA struct {
+  Sample []string
+  Coordinate [][]float64
+  Eigenvalue []float64
+  VarianceExplained []float64
+  TileVariantCount int
+  Iteration int
+}
+
+type PopulationPCAResponse struct {
+  Type string
+  Message string
+  Result PopulationPCA
+}
+
+// Result holds the FASTA text, one record per allele.
+//
+type SampleSequenceResponse struct {
+  Type string
+  Message string
+  Result string
+}
+
+// A gene or region and the tile positions covering it.  Region is
+// "chrom:start-end", 1 reference and inclusive.
+//
+type GeneTiles struct {
+  Name string
+  Assembly string
+  Region string
+  Strand string
+  TilePosition []string
+}
+
+// Result is keyed by the requested name.
+//
+type GeneTilesResponse struct {
+  Type string
+  Message string
+  Result map[string][]GeneTiles
+}
+
+// Carrier counts and p-values of the burden test of one gene or
+// region.  The permutation fields are only set if permutations were
+// asked for.
+//
+type BurdenResult struct {
+  Name string
+  QualifyingVariant int
+  CaseCarrier int
+  CaseCount int
+  ControlCarrier int
+  ControlCount int
+  OddsRatio float64
+  PValue float64
+  Permutation int
+  PermutationPValue float64
+}
+
+// Result is in the order of the requested genes.
+//
+type BurdenTestResponse struct {
+  Type string
+  Message string
+  Result []BurdenResult
+}
+
+// Result holds the response to each batch item, in order.  Items
+// can fail individually, check their 'Type'.
+//
+type BatchResponse struct {
+  Type string
+  Message string
+  Result []json.RawMessage
+}
+
+type JobResponse struct {
+  Type string
+  Message string
+  JobId string
+}
+
+type JobStatus struct {
+  JobId string
+  RequestType string
+  State string
+  Progress float64
+  Message string
+  Created time.Time
+  Started *time.Time
+  Finished *time.Time
+  Expires *time.Time
+}
+
+type JobStatusResponse struct {
+  Type string
+  Message string
+  Result JobStatus
+}
+
+// Can the request be sent again after a failure without any effect
+// beyond the first.
+//
+func (c *Client) retryable( req *Request ) bool {
+  if len(c.APIKey)>0 { return false }
+  if req.Async { return false }
+  for i:=0; i<len(req.Batch); i++ {
+    if !c.retryable( &req.Batch[i] ) { return false }
+  }
+  return true
+}
+
+// Send the request and return the raw response body.  Lantern
+// error responses are returned as *Error.  Tables (see Table) are
+// returned as they are.
+//
+func (c *Client) DoRaw( ctx context.Context, req *Request ) ([]byte, error) {
+  byte_req,e := json.Marshal( req )
+  if e!=nil { return nil, e }
+
+  wait := c.RetryWait
+  var err error
+
+  retries := c.Retries
+  if !c.retryable( req ) { retries = 0 }
+
+  for attempt:=0; attempt<=retries; attempt++ {
+    if attempt>0 {
+      select {
+      case <-ctx.Done(): return nil, ctx.Err()
+      case <-time.After( wait ):
+      }
+      wait *= 2
+    }
+
+    body,status,content_type,e := c.post( ctx, byte_req )
+    if e!=nil {
+      if ctx.Err()!=nil { return nil, ctx.Err() }
+      err = e
+      continue
+    }
+
+    if status >= 500 {
+      err = decode_error( body, status )
+      if err==nil { err = &Error{ Type:"error", Message:http.StatusText(status), StatusCode:status } }
+      continue
+    }
+
+    if (status < 400) && is_table_type( content_type ) { return body, nil }
+    if e := decode_error( body, status ) ; e!=nil { return nil, e }
+    return body, nil
+  }
+
+  return nil, err
+}
+
+// A "tsv" or "csv" response rather than JSON.
+//
+func is_table_type( content_type string ) bool {
+  mt := strings.TrimSpace( strings.SplitN( content_type, ";", 2 )[0] )
+  return (mt=="text/tab-separated-values") || (mt=="text/csv")
+}
+
+// Send the request and decode the response into resp.
+//
+func (c *Client) Do( ctx context.Context, req *Request, resp interface{} ) error {
+  body,e := c.DoRaw( ctx, req )
+  if e!=nil { return e }
+  return json.Unmarshal( body, resp )
+}
+
+// Send the request for a table ("tsv" or "csv") and return the body
+// as it streams in, header line first.  The caller closes it.  Only
+// the request types lantern has table columns for support this.
+// Tables are not retried.
+//
+func (c *Client) Table( ctx context.Context, req *Request, format string ) (io.ReadCloser, error) {
+  treq := *req
+  treq.Format = format
+  byte_req,e := json.Marshal( &treq )
+  if e!=nil { return nil, e }
+
+  hreq,e := http.NewRequest( "POST", c.URL, bytes.NewReader( byte_req ) )
+  if e!=nil { return nil, e }
+  hreq = hreq.WithContext( ctx )
+  hreq.Header.Set("Content-Type", "application/json")
+  if len(c.APIKey)>0 { hreq.Header.Set("X-Api-Key", c.APIKey) }
+
+  hc := c.HTTPClient
+  if hc==nil { hc = http.DefaultClient }
+
+  hresp,e := hc.Do( hreq )
+  if e!=nil { return nil, e }
+
+  if hresp.StatusCode != http.StatusOK {
+    defer hresp.Body.Close()
+    body,_ := ioutil.ReadAll( io.LimitReader( hresp.Body, 1<<20 ) )
+    if e := decode_error( body, hresp.StatusCode ) ; e!=nil { return nil, e }
+    return nil, &Error{ Type:"error", Message:http.StatusText( hresp.StatusCode ), StatusCode:hresp.StatusCode }
+  }
+
+  return hresp.Body, nil
+}
+
+func (c *Client) post( ctx context.Context, byte_req []byte ) ([]byte, int, string, error) {
+  hreq,e := http.NewRequest( "POST", c.URL, bytes.NewReader( byte_req ) )
+  if e!=nil { return nil, 0, "", e }
+  hreq = hreq.WithContext( ctx )
+  hreq.Header.Set("Content-Type", "application/json")
+  if len(c.APIKey)>0 { hreq.Header.Set("X-Api-Key", c.APIKey) }
+
+  hc := c.HTTPClient
+  if hc==nil { hc = http.DefaultClient }
+
+  hresp,e := hc.Do( hreq )
+  if e!=nil { return nil, 0, "", e }
+  defer hresp.Body.Close()
+
+  content_type := hresp.Header.Get("Content-Type")
+  body,e := ioutil.ReadAll( io.LimitReader( hresp.Body, 1<<32 ) )
+  if e!=nil { return nil, hresp.StatusCode, content_type, e }
+
+  return body, hresp.StatusCode, content_type, nil
+}
+
+func decode_error( body []byte, status int ) error {
+  if len( bytes.TrimSpace(body) )==0 {
+    return &Error{ Type:"error", Message:"empty response", StatusCode:status }
+  }
+
+  env := Error{}
+  if e := json.Unmarshal( body, &env ) ; e!=nil {
+    return &Error{ Type:"error", Message:fmt.Sprintf("invalid response: %v", e), StatusCode:status }
+  }
+
+  if (env.Type == "error") || (env.Type == "failure") {
+    env.StatusCode = status
+    return &env
+  }
+
+  return nil
+}
+
+func (c *Client) SystemInfo( ctx context.Context ) (*SystemInfoResponse, error) {
+  resp := SystemInfoResponse{}
+  e := c.Do( ctx, &Request{ Type:"system-info" }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+func (c *Client) TileSequence( ctx context.Context, tileId []string, limit int, cursor string ) (*TileSequenceResponse, error) {
+  resp := TileSequenceResponse{}
+  e := c.Do( ctx, &Request{ Type:"tile-sequence", TileId:tileId, Limit:limit, Cursor:cursor }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+func (c *Client) SamplePositionVariant( ctx context.Context, sampleId []string, position []string ) (*SampleAlleleResponse, error) {
+  resp := SampleAlleleResponse{}
+  e := c.Do( ctx, &Request{ Type:"sample-position-variant", SampleId:sampleId, Position:position }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+func (c *Client) SampleTileNeighborhood( ctx context.Context, sampleId []string, tileGroupVariantIdRange [][]map[string][]int ) (*SampleAlleleResponse, error) {
+  resp := SampleAlleleResponse{}
+  e := c.Do( ctx, &Request{ Type:"sample-tile-neighborhood", SampleId:sampleId, TileGroupVariantIdRange:tileGroupVariantIdRange }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+func (c *Client) SampleTileGroupMatch( ctx context.Context, sampleId []string, tileGroupVariantId [][]string ) (*SampleTileGroupMatchResponse, error) {
+  resp := SampleTileGroupMatchResponse{}
+  e := c.Do( ctx, &Request{ Type:"sample-tile-group-match", SampleId:sampleId, TileGroupVariantId:tileGroupVariantId }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+// A limit of 0 returns only the summary counts in the Message.
+//
+func (c *Client) SampleIntersect( ctx context.Context, sampleId []string, limit int, cursor string ) (*SampleIntersectResponse, error) {
+  resp := SampleIntersectResponse{}
+  e := c.Do( ctx, &Request{ Type:"sample-intersect", SampleId:sampleId, Limit:limit, Cursor:cursor }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+func (c *Client) VariantFrequency( ctx context.Context, sampleId []string, tileVariantId []string ) (*VariantFrequencyResponse, error) {
+  resp := VariantFrequencyResponse{}
+  e := c.Do( ctx, &Request{ Type:"variant-frequency", SampleId:sampleId, TileVariantId:tileVariantId }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+// Path restricts the comparison to hex path ranges, e.g. "247+3" for
+// paths 247 through 249, empty for all paths.
+//
+func (c *Client) SampleSimilarity( ctx context.Context, sampleId []string, path string ) (*SampleSimilarityResponse, error) {
+  resp := SampleSimilarityResponse{}
+  e := c.Do( ctx, &Request{ Type:"sample-similarity", SampleId:sampleId, Path:path }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+// A component count or minimum frequency of 0 takes the server default.
+//
+func (c *Client) PopulationPCA( ctx context.Context, sampleId []string, path string, component int, minFrequency float64 ) (*PopulationPCAResponse, error) {
+  resp := PopulationPCAResponse{}
+  e := c.Do( ctx, &Request{ Type:"population-pca", SampleId:sampleId, Path:path, Component:component, MinFrequency:minFrequency }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+// Path and step are hex, step a single range (e.g. "10+20"), empty
+// for the whole path.
+//
+func (c *Client) SampleSequence( ctx context.Context, sampleId string, path string, step string ) (*SampleSequenceResponse, error) {
+  resp := SampleSequenceResponse{}
+  e := c.Do( ctx, &Request{ Type:"sample-sequence", SampleId:[]string{ sampleId }, Path:path, Step:step }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+func (c *Client) GeneTiles( ctx context.Context, gene []string ) (*GeneTilesResponse, error) {
+  resp := GeneTilesResponse{}
+  e := c.Do( ctx, &Request{ Type:"gene-tiles", Gene:gene }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+// A maximum frequency of 0 takes the server default, permutation 0
+// skips the permutation p-value.
+//
+func (c *Client) BurdenTest( ctx context.Context, caseSampleId, controlSampleId []string, gene []string, maxFrequency float64, permutation int, seed int64 ) (*BurdenTestResponse, error) {
+  resp := BurdenTestResponse{}
+  req := Request{ Type:"burden-test", CaseSampleId:caseSampleId, ControlSampleId:controlSampleId, Gene:gene,
+    MaxFrequency:maxFrequency, Permutation:permutation, Seed:seed }
+  e := c.Do( ctx, &req, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+func (c *Client) Batch( ctx context.Context, item []Request ) (*BatchResponse, error) {
+  resp := BatchResponse{}
+  e := c.Do( ctx, &Request{ Type:"batch", Batch:item }, &resp )
+  if e!=nil { return nil, e }
+  return &resp, nil
+}
+
+// Run the request as a background job and return the job id.
+//
+func (c *Client) Submit( ctx context.Context, req *Request ) (string, error) {
+  r := *req
+  r.Async = true
+
+  resp := JobResponse{}
+  e := c.Do( ctx, &r, &resp )
+  if e!=nil { return "", e }
+  return resp.JobId, nil
+}
+
+func (c *Client) JobStatus( ctx context.Context, jobId string ) (*JobStatus, error) {
+  resp := JobStatusResponse{}
+  e := c.Do( ctx, &Request{ Type:"job-status", JobId:jobId }, &resp )
+  if e!=nil { return nil, e }
+  return &resp.Result, nil
+}
+
+func (c *Client) JobCancel( ctx context.Context, jobId string ) error {
+  _,e := c.DoRaw( ctx, &Request{ Type:"job-cancel", JobId:jobId } )
+  return e
+}
+
+// Raw response of the job's request.  Decode it as the response to
+// the request submitted, a table if the request asked for one.
+//
+func (c *Client) JobResult( ctx context.Context, jobId string ) ([]byte, error) {
+  return c.DoRaw( ctx, &Request{ Type:"job-result", JobId:jobId } )
+}
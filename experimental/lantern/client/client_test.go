@@ -0,0 +1,96 @@
+package client
+
+import "io"
+import "io/ioutil"
+import "strings"
+import "time"
+import "context"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "encoding/json"
+
+func TestClient( t *testing.T ) {
+
+  n_call := 0
+
+  srv := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+    n_call++
+
+    req := Request{}
+    if e := json.NewDecoder( r.Body ).Decode( &req ) ; e!=nil { t.Errorf("%v", e) }
+
+    switch req.Type {
+    case "system-info":
+      if n_call==1 { w.WriteHeader( http.StatusServiceUnavailable ) ; return }
+      io.WriteString(w, `{"Type":"success","Message":"system-info","LanternVersion":"0.0.3","SampleId":["0:a.cgf"]}`)
+    case "tile-sequence":
+      io.WriteString(w, `{"Type":"success","Message":"tile-sequence","Cursor":"MDowOjA6MQ","Result":{"247.00.0000.0000":"acgt"}}`)
+    case "variant-frequency":
+      if req.Format!="tsv" { t.Errorf("expected tsv, got '%s'", req.Format) }
+      w.Header().Set("Content-Type", "text/tab-separated-values")
+      io.WriteString(w, "tile_variant\tsample_count\tallele_count\tcalled_allele_count\tfrequency\n247.00.0000.0000\t1\t2\t2\t1\n")
+    case "job-result":
+      w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+      io.WriteString(w, "tile_variant,sample_count,allele_count,called_allele_count,frequency\n")
+    case "sample-intersect":
+      w.WriteHeader( http.StatusNotFound )
+      io.WriteString(w, `{"Type":"failure","Error":"UnknownSample","Message":"unknown sample 'x'","Detail":{"SampleId":"x"}}`)
+    default:
+      io.WriteString(w, `{"Type":"error","Message":"bad command"}`)
+    }
+  }))
+  defer srv.Close()
+
+  cl := New( srv.URL )
+  cl.RetryWait = time.Millisecond
+  ctx := context.Background()
+
+  info,e := cl.SystemInfo( ctx )
+  if e!=nil { t.Fatalf("%v", e) }
+  if n_call!=2 { t.Errorf("expected one retry, got %d calls", n_call) }
+  if (len(info.SampleId)!=1) || (info.SampleId[0]!="0:a.cgf") { t.Errorf("unexpected SampleId %v", info.SampleId) }
+
+  seq,e := cl.TileSequence( ctx, []string{ "247.00.0000.0000", "247.00.0000.0001" }, 1, "" )
+  if e!=nil { t.Fatalf("%v", e) }
+  if seq.Cursor!="MDowOjA6MQ" { t.Errorf("unexpected cursor %s", seq.Cursor) }
+  if seq.Result["247.00.0000.0000"]!="acgt" { t.Errorf("unexpected result %v", seq.Result) }
+
+  e = cl.Do( ctx, &Request{ Type:"no-such-type" }, &SystemInfoResponse{} )
+  if e==nil { t.Fatalf("expected error") }
+  le,ok := e.(*Error)
+  if !ok { t.Fatalf("expected *Error, got %T", e) }
+  if le.Message!="bad command" { t.Errorf("unexpected message %s", le.Message) }
+
+  tab,e := cl.Table( ctx, &Request{ Type:"variant-frequency", SampleId:[]string{ "0:a.cgf" } }, "tsv" )
+  if e!=nil { t.Fatalf("%v", e) }
+  tab_body,e := ioutil.ReadAll( tab )
+  tab.Close()
+  if e!=nil { t.Fatalf("%v", e) }
+  if !strings.HasPrefix( string(tab_body), "tile_variant\t" ) { t.Errorf("unexpected table %q", tab_body) }
+
+  job_tab,e := cl.JobResult( ctx, "0123456789abcdef0123456789abcdef" )
+  if e!=nil { t.Fatalf("table job result: %v", e) }
+  if !strings.HasPrefix( string(job_tab), "tile_variant," ) { t.Errorf("unexpected job result %q", job_tab) }
+
+  _,e = cl.Table( ctx, &Request{ Type:"sample-intersect", SampleId:[]string{ "x" } }, "tsv" )
+  if !IsCode( e, ErrUnknownSample ) { t.Errorf("expected UnknownSample from Table, got %v", e) }
+
+  // Requests that aren't safe to send twice aren't retried.
+  //
+  n_call = 0
+  _,e = cl.DoRaw( ctx, &Request{ Type:"system-info", Async:true } )
+  if (e==nil) || (n_call!=1) { t.Errorf("Async request: %d calls, %v", n_call, e) }
+
+  n_call = 0
+  cl.APIKey = "key"
+  _,e = cl.DoRaw( ctx, &Request{ Type:"system-info" } )
+  if (e==nil) || (n_call!=1) { t.Errorf("request with an APIKey: %d calls, %v", n_call, e) }
+  cl.APIKey = ""
+
+  _,e = cl.SampleIntersect( ctx, []string{ "x" }, 0, "" )
+  if !IsCode( e, ErrUnknownSample ) { t.Fatalf("expected UnknownSample, got %v", e) }
+  le = e.(*Error)
+  if (le.StatusCode!=http.StatusNotFound) || (le.Detail["SampleId"]!="x") { t.Errorf("unexpected error %+v", le) }
+
+}
@@ -0,0 +1,268 @@
+package main
+
+/*
+
+lantern-audit reads lantern audit logs (see lantern_audit.go) and
+prints the records matching the filters given, one JSON line each,
+or with '--summary' a table of requests per client and request type.
+
+Files ending in ".gz" are read decompressed, "-" (or no file) reads
+standard input.  Rotated logs can be given in any order.
+
+  --client alice            records of client 'alice'
+  --type sample-intersect   records of that request type (or batches
+                            with an item of that type)
+  --sample hu011C57.cgf     records naming the sample, by id or name,
+                            and those over all the samples
+  --since 2016-05-01        records at or after, RFC3339 or a date
+  --until 2016-06-01        records before
+
+For example, who looked at sample hu011C57 in May:
+
+  lantern-audit --sample hu011C57.cgf --since 2016-05-01 --until 2016-06-01 \
+    --summary /var/log/lantern/audit.log*
+
+  client  type                     requests  errors  samples  bytes    total_ms  max_ms
+  alice   sample-position-variant  12        0       24       61440    38.4      7.1
+  bob     population-pca           1         0       0        2048     920.5     920.5
+
+'samples' is the number of samples named over the requests (requests
+over all the samples count as none).
+
+*/
+
+import "io"
+import "os"
+import "fmt"
+import "sort"
+import "bufio"
+import "strings"
+import "time"
+import "compress/gzip"
+import "encoding/json"
+
+import "github.com/codegangsta/cli"
+
+var VERSION_STR string = "0.1.0"
+
+// The fields of lantern's LanternAuditRecord the filters and summary
+// use.  Records are printed as read, so fields added to lantern's
+// record pass through.
+//
+type audit_record struct {
+  Time time.Time
+  Client string
+  Path string
+  Type string
+  ItemType []string
+  AllSamples bool
+  SampleId []string
+  SampleCount int
+  Status int
+  Bytes int64
+  DurationMs float64
+}
+
+type audit_filter struct {
+  client string
+  req_type string
+  sample string
+  since time.Time
+  until time.Time
+}
+
+// Sample ids are "<index>:<name>" or just the name.
+//
+func sample_match( id, sample string ) bool {
+  if id==sample { return true }
+  if p := strings.Index( id, ":" ) ; (p>=0) && (id[p+1:]==sample) { return true }
+  return false
+}
+
+func (f *audit_filter) match( rec *audit_record ) bool {
+  if (len(f.client)>0) && (rec.Client!=f.client) { return false }
+
+  if len(f.req_type)>0 {
+    found := rec.Type==f.req_type
+    for i:=0; (i<len(rec.ItemType)) && !found; i++ { found = rec.ItemType[i]==f.req_type }
+    if !found { return false }
+  }
+
+  if (len(f.sample)>0) && !rec.AllSamples {
+    found := false
+    for i:=0; (i<len(rec.SampleId)) && !found; i++ { found = sample_match( rec.SampleId[i], f.sample ) }
+    if !found { return false }
+  }
+
+  if !f.since.IsZero() && rec.Time.Before( f.since ) { return false }
+  if !f.until.IsZero() && !rec.Time.Before( f.until ) { return false }
+  return true
+}
+
+type audit_summary struct {
+  Client string
+  Type string
+  Requests int
+  Errors int
+  Samples int
+  Bytes int64
+  TotalMs float64
+  MaxMs float64
+}
+
+type ByClientType []*audit_summary
+func (s ByClientType) Len() int { return len(s) }
+func (s ByClientType) Swap(i,j int) { s[i],s[j] = s[j],s[i] }
+func (s ByClientType) Less(i,j int) bool {
+  if s[i].Client!=s[j].Client { return s[i].Client < s[j].Client }
+  return s[i].Type < s[j].Type
+}
+
+func parse_time( s string ) ( time.Time, error ) {
+  if len(s)==0 { return time.Time{}, nil }
+  if t,e := time.Parse( time.RFC3339, s ) ; e==nil { return t, nil }
+  return time.Parse( "2006-01-02", s )
+}
+
+func open_log( fn string ) ( io.ReadCloser, error ) {
+  if fn=="-" { return os.Stdin, nil }
+
+  fp,e := os.Open( fn )
+  if e!=nil { return nil, e }
+  if !strings.HasSuffix( fn, ".gz" ) { return fp, nil }
+
+  gz,e := gzip.NewReader( fp )
+  if e!=nil { fp.Close() ; return nil, fmt.Errorf("%s: %v", fn, e) }
+  return struct{ io.Reader ; io.Closer }{ gz, fp }, nil
+}
+
+// Call fn on each record of the log matching the filter, with its
+// JSON line.
+//
+func scan_log( name string, r io.Reader, f *audit_filter, fn func( *audit_record, []byte ) ) error {
+  sc := bufio.NewScanner( r )
+  sc.Buffer( make( []byte, 64*1024 ), 16*1024*1024 )
+
+  line_no := 0
+  for sc.Scan() {
+    line_no++
+    line := sc.Bytes()
+    if len(line)==0 { continue }
+
+    rec := audit_record{}
+    if e := json.Unmarshal( line, &rec ) ; e!=nil { return fmt.Errorf("%s:%d: %v", name, line_no, e) }
+    if f.match( &rec ) { fn( &rec, line ) }
+  }
+  return sc.Err()
+}
+
+func _main( c *cli.Context ) {
+  f := audit_filter{ client:c.String("client"), req_type:c.String("type"), sample:c.String("sample") }
+
+  var e error
+  if f.since,e = parse_time( c.String("since") ) ; e!=nil { fmt.Fprintf( os.Stderr, "--since: %v\n", e ) ; os.Exit(1) }
+  if f.until,e = parse_time( c.String("until") ) ; e!=nil { fmt.Fprintf( os.Stderr, "--until: %v\n", e ) ; os.Exit(1) }
+
+  files := []string(c.Args())
+  if len(files)==0 { files = []string{ "-" } }
+
+  out := bufio.NewWriter( os.Stdout )
+  defer out.Flush()
+
+  summary := c.Bool("summary")
+  sum_map := make( map[string]*audit_summary )
+
+  add := func( rec *audit_record, line []byte ) {
+    if !summary {
+      out.Write( line )
+      out.WriteByte( '\n' )
+      return
+    }
+
+    // Beacon queries have no type, use the path.
+    //
+    req_type := rec.Type
+    if len(req_type)==0 { req_type = rec.Path }
+
+    key := rec.Client + "\t" + req_type
+    s,ok := sum_map[key]
+    if !ok {
+      s = &audit_summary{ Client:rec.Client, Type:req_type }
+      sum_map[key] = s
+    }
+    s.Requests++
+    if rec.Status>=400 { s.Errors++ }
+    s.Samples += rec.SampleCount
+    s.Bytes += rec.Bytes
+    s.TotalMs += rec.DurationMs
+    if rec.DurationMs > s.MaxMs { s.MaxMs = rec.DurationMs }
+  }
+
+  for i:=0; i<len(files); i++ {
+    r,e := open_log( files[i] )
+    if e!=nil { fmt.Fprintf( os.Stderr, "%v\n", e ) ; os.Exit(1) }
+    e = scan_log( files[i], r, &f, add )
+    r.Close()
+    if e!=nil { fmt.Fprintf( os.Stderr, "%v\n", e ) ; os.Exit(1) }
+  }
+
+  if !summary { return }
+
+  s := make( []*audit_summary, 0, len(sum_map) )
+  for _,v := range sum_map { s = append( s, v ) }
+  sort.Sort( ByClientType(s) )
+
+  fmt.Fprintf( out, "client\ttype\trequests\terrors\tsamples\tbytes\ttotal_ms\tmax_ms\n" )
+  for i:=0; i<len(s); i++ {
+    client := s[i].Client
+    if len(client)==0 { client = "-" }
+    fmt.Fprintf( out, "%s\t%s\t%d\t%d\t%d\t%d\t%.1f\t%.1f\n",
+      client, s[i].Type, s[i].Requests, s[i].Errors, s[i].Samples, s[i].Bytes, s[i].TotalMs, s[i].MaxMs )
+  }
+}
+
+func main() {
+  app := cli.NewApp()
+  app.Name  = "lantern-audit"
+  app.Usage = "Filter and summarise lantern audit logs"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+
+    cli.StringFlag{
+      Name: "client, c",
+      Usage: "Only records of this client",
+    },
+
+    cli.StringFlag{
+      Name: "type, t",
+      Usage: "Only records of this request type",
+    },
+
+    cli.StringFlag{
+      Name: "sample, s",
+      Usage: "Only records naming this sample (id or name), or over all samples",
+    },
+
+    cli.StringFlag{
+      Name: "since",
+      Usage: "Only records at or after this time (RFC3339 or YYYY-MM-DD)",
+    },
+
+    cli.StringFlag{
+      Name: "until",
+      Usage: "Only records before this time (RFC3339 or YYYY-MM-DD)",
+    },
+
+    cli.BoolFlag{
+      Name: "summary",
+      Usage: "Print requests per client and request type instead of the records",
+    },
+
+  }
+
+  app.Run(os.Args)
+}
@@ -0,0 +1,833 @@
+package main
+
+/*
+
+lantern-proxy fans lantern requests out to a set of lantern
+backends, each holding a shard of the samples, and merges the
+responses.
+
+On startup every backend is asked for its 'system-info'.  All
+backends must report the same tile library and tile map versions
+and the sample ids they report are used to route requests.
+
+Requests are handled as follows:
+
+  system-info                     sample ids and tile stats are combined
+  tile-sequence,
+  tile-sequence-tracer,
+  gene-tiles                      sent to the first backend that answers
+  sample-position-variant,
+  sample-tile-neighborhood,
+  tile-variant                    per sample results are merged
+  sample-tile-group-match         matching sample lists are concatenated
+  variant-frequency               counts are summed
+  job-status, job-result,
+  job-cancel                      sent to the backend running the job
+  sample-intersect,
+  sample-similarity,
+  population-pca, burden-test     only if all samples are on one backend
+
+Requests listing 'SampleId' ('CaseSampleId' and 'ControlSampleId'
+for burden-test) are only sent to the backends holding those samples
+(with the sample lists restricted to the backend).  Without them the
+request is for every sample and goes to every backend.  Other request
+types are passed through if they resolve to a single backend and are
+rejected otherwise.
+
+'Async' requests must resolve to a single backend.  The 'JobId'
+handed back is the backend's prefixed with the backend's position in
+the '--backend' list ("1-3f2a..."), which job-status, job-result and
+job-cancel are routed by.
+
+A request error (a 4xx status) is the same on every backend, so the
+first one is passed on as is.
+
+Beacon queries ('/beacon/query') are sent to every backend and the
+counts are summed.
+
+Results are always JSON, requests for TSV or CSV ('Format') are
+rejected, as are requests with 'Explain' set (ask the backends
+directly).
+
+If some, but not all, backends fail, the response 'Type' is "partial"
+and the failures are listed under 'Failed':
+
+{
+  "Type":"partial",
+  "Message":"sample-position-variant",
+  "Failed":[ { "Backend":"http://lantern1:8080", "Message":"..." } ],
+  "Result": { ... }
+}
+
+The proxy's own errors use lantern's error codes and statuses
+(docs/api/errors_v0.1.0.txt): a request that can't be parsed is a
+ParseError, a sample no backend holds is UnknownSample, an
+unsupported 'Format' or 'Explain' and requests whose samples would
+have to be on one backend are InvalidParameter.  If every backend
+the request went to failed, the response is NotReady (503) with the
+failures listed under 'Failed'.
+
+*/
+
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "sort"
+import "bytes"
+import "strings"
+import "strconv"
+import "sync"
+import "time"
+import "context"
+import "net/http"
+import "encoding/json"
+
+import "github.com/codegangsta/cli"
+
+import "../client"
+import "../../lightlog"
+
+var VERSION_STR string = "0.1.0"
+
+type Backend struct {
+  URL string
+  Client *client.Client
+  Info *client.SystemInfoResponse
+}
+
+type BackendFailure struct {
+  Backend string
+  Error string `json:",omitempty"`
+  Message string
+}
+
+type BackendResult struct {
+  Backend int
+  Body []byte
+  Err error
+}
+
+var gBackend []*Backend
+var gSampleBackend map[string]int
+var gTimeout time.Duration
+
+var gLibraryVersion string
+var gTileMapVersion string
+
+// Request types that don't depend on the samples, any backend can
+// answer them.
+//
+var gAnyBackendType map[string]bool = map[string]bool{
+  "tile-sequence" : true,
+  "tile-sequence-tracer" : true,
+  "gene-tiles" : true,
+}
+
+func backend_init( urls []string ) error {
+  gBackend = []*Backend{}
+  gSampleBackend = make( map[string]int )
+
+  for i:=0; i<len(urls); i++ {
+    b := &Backend{ URL:urls[i], Client:client.New( urls[i] ) }
+
+    ctx,cancel := context.WithTimeout( context.Background(), gTimeout )
+    info,e := b.Client.SystemInfo( ctx )
+    cancel()
+    if e!=nil { return fmt.Errorf("%s: %v", urls[i], e) }
+    b.Info = info
+
+    if i==0 {
+      gLibraryVersion = info.LibraryVersion
+      gTileMapVersion = info.TileMapVersion
+    } else if info.LibraryVersion != gLibraryVersion {
+      return fmt.Errorf("%s: tile library mismatch (%s != %s)", urls[i], info.LibraryVersion, gLibraryVersion)
+    } else if info.TileMapVersion != gTileMapVersion {
+      return fmt.Errorf("%s: tile map mismatch (%s != %s)", urls[i], info.TileMapVersion, gTileMapVersion)
+    }
+
+    for j:=0; j<len(info.SampleId); j++ {
+      if k,ok := gSampleBackend[ info.SampleId[j] ] ; ok {
+        lightlog.Warn( "sample %s on %s and %s, using %s", info.SampleId[j], gBackend[k].URL, urls[i], gBackend[k].URL )
+        continue
+      }
+      gSampleBackend[ info.SampleId[j] ] = i
+    }
+
+    lightlog.Info( "backend %s: %d samples", urls[i], len(info.SampleId) )
+    gBackend = append( gBackend, b )
+  }
+
+  return nil
+}
+
+// Split the request by backend.  Without any samples, every backend
+// gets the request as is.
+//
+func split_request( req *client.Request ) ( map[int]*client.Request, error ) {
+  sub := make( map[int]*client.Request )
+
+  if (len(req.SampleId)==0) && (len(req.CaseSampleId)==0) && (len(req.ControlSampleId)==0) {
+    for i:=0; i<len(gBackend); i++ {
+      r := *req
+      sub[i] = &r
+    }
+    return sub, nil
+  }
+
+  for i:=0; i<len(req.SampleId); i++ {
+    r,e := sub_request( sub, req, req.SampleId[i] )
+    if e!=nil { return nil, e }
+    r.SampleId = append( r.SampleId, req.SampleId[i] )
+  }
+  for i:=0; i<len(req.CaseSampleId); i++ {
+    r,e := sub_request( sub, req, req.CaseSampleId[i] )
+    if e!=nil { return nil, e }
+    r.CaseSampleId = append( r.CaseSampleId, req.CaseSampleId[i] )
+  }
+  for i:=0; i<len(req.ControlSampleId); i++ {
+    r,e := sub_request( sub, req, req.ControlSampleId[i] )
+    if e!=nil { return nil, e }
+    r.ControlSampleId = append( r.ControlSampleId, req.ControlSampleId[i] )
+  }
+
+  return sub, nil
+}
+
+// The request for the backend holding the sample, with empty sample
+// lists the first time.
+//
+func sub_request( sub map[int]*client.Request, req *client.Request, sample_id string ) ( *client.Request, error ) {
+  b,ok := gSampleBackend[ sample_id ]
+  if !ok {
+    return nil, proxy_error( http.StatusNotFound, client.ErrUnknownSample, map[string]interface{}{ "SampleId":sample_id },
+      "unknown sample %s", sample_id )
+  }
+
+  if _,ok := sub[b] ; !ok {
+    r := *req
+    r.SampleId = []string{}
+    r.CaseSampleId = nil
+    r.ControlSampleId = nil
+    sub[b] = &r
+  }
+  return sub[b], nil
+}
+
+func fan_out( ctx context.Context, sub map[int]*client.Request ) []BackendResult {
+  res := make( []BackendResult, 0, len(sub) )
+
+  var mu sync.Mutex
+  var wg sync.WaitGroup
+
+  for b := range sub {
+    wg.Add(1)
+    go func( b int, r *client.Request ) {
+      defer wg.Done()
+      body,e := gBackend[b].Client.DoRaw( ctx, r )
+
+      mu.Lock()
+      res = append( res, BackendResult{ Backend:b, Body:body, Err:e } )
+      mu.Unlock()
+    }( b, sub[b] )
+  }
+  wg.Wait()
+
+  sort.Slice( res, func(i,j int) bool { return res[i].Backend < res[j].Backend } )
+  return res
+}
+
+// Separate out the failed backends.  The error is set only if every
+// backend failed.
+//
+func collect( lg *lightlog.Logger, res []BackendResult ) ( ok []BackendResult, failed []BackendFailure, err error ) {
+  for i:=0; i<len(res); i++ {
+    if res[i].Err!=nil {
+      lg.Warn( "%s: %v", gBackend[ res[i].Backend ].URL, res[i].Err )
+      f := BackendFailure{ Backend:gBackend[ res[i].Backend ].URL, Message:fmt.Sprintf("%v", res[i].Err) }
+      if le,ok := res[i].Err.(*client.Error) ; ok { f.Error = le.Code }
+      failed = append( failed, f )
+      continue
+    }
+    ok = append( ok, res[i] )
+  }
+
+  if (len(ok)==0) && (len(failed)>0) { err = fmt.Errorf("all backends failed: %s", failed[0].Message) }
+  return
+}
+
+// Send the sub-requests and separate out the failures.  If a backend
+// returned a request error or every backend failed, the response has
+// been written and false is returned.
+//
+func gather( w http.ResponseWriter, lg *lightlog.Logger, ctx context.Context, sub map[int]*client.Request ) ( []BackendResult, []BackendFailure, bool ) {
+  res := fan_out( ctx, sub )
+  for i:=0; i<len(res); i++ {
+    if write_request_error( w, res[i].Err ) { return nil, nil, false }
+  }
+
+  ok,failed,err := collect( lg, res )
+  if err!=nil {
+    write_backend_failure( w, failed )
+    return nil, nil, false
+  }
+  return ok, failed, true
+}
+
+// Pass a request error (4xx) from a backend on as is.  Returns false
+// for anything else.
+//
+func write_request_error( w http.ResponseWriter, e error ) bool {
+  le,ok := e.(*client.Error)
+  if !ok || (le.StatusCode<400) || (le.StatusCode>=500) { return false }
+
+  write_failure( w, le, nil )
+  return true
+}
+
+// An error of the proxy's own, with the code and status lantern
+// would use (see docs/api/errors_v0.1.0.txt).
+//
+func proxy_error( status int, code string, detail map[string]interface{}, format string, args ...interface{} ) *client.Error {
+  return &client.Error{ Type:"failure", Code:code, Message:fmt.Sprintf(format, args...), Detail:detail, StatusCode:status }
+}
+
+func invalid_parameter( name string, value interface{}, reason string ) *client.Error {
+  return proxy_error( http.StatusBadRequest, client.ErrInvalidParameter,
+    map[string]interface{}{ "Parameter":name, "Value":value, "Reason":reason },
+    "invalid %s '%v': %s", name, value, reason )
+}
+
+func write_json( w http.ResponseWriter, v interface{} ) {
+  w.Header().Set("Content-Type", "application/json")
+  b,_ := json.Marshal( v )
+  io.WriteString( w, string(b) )
+}
+
+func write_failure( w http.ResponseWriter, le *client.Error, failed []BackendFailure ) {
+  m := map[string]interface{}{ "Type":"failure", "Message":le.Message }
+  if len(le.Code)>0 { m["Error"] = le.Code }
+  if le.Detail!=nil { m["Detail"] = le.Detail }
+  if len(failed)>0 { m["Failed"] = failed }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader( le.StatusCode )
+  b,_ := json.Marshal( m )
+  io.WriteString( w, string(b) )
+}
+
+// None of the backends the request went to answered.
+//
+func write_backend_failure( w http.ResponseWriter, failed []BackendFailure ) {
+  msg := "all backends failed"
+  if len(failed)>0 { msg += ": " + failed[0].Message }
+  write_failure( w, proxy_error( http.StatusServiceUnavailable, client.ErrNotReady, nil, "%s", msg ), failed )
+}
+
+// A backend's response that couldn't be merged.
+//
+func write_merge_failure( w http.ResponseWriter, e error, failed []BackendFailure ) {
+  write_failure( w, proxy_error( http.StatusInternalServerError, client.ErrInternal, nil, "%v", e ), failed )
+}
+
+func write_merged( w http.ResponseWriter, msg string, failed []BackendFailure, fields map[string]interface{} ) {
+  m := map[string]interface{}{ "Type":"success", "Message":msg }
+  if len(failed)>0 {
+    m["Type"] = "partial"
+    m["Failed"] = failed
+  }
+  for k,v := range fields { m[k] = v }
+  write_json( w, m )
+}
+
+// Merge JSON objects keyed by sample id.  If key is empty, the whole
+// response is merged, otherwise the object under key is.
+//
+func merge_sample_map( res []BackendResult, key string ) ( map[string]json.RawMessage, error ) {
+  merged := make( map[string]json.RawMessage )
+
+  for i:=0; i<len(res); i++ {
+    obj := res[i].Body
+
+    if len(key)>0 {
+      env := map[string]json.RawMessage{}
+      if e := json.Unmarshal( obj, &env ) ; e!=nil { return nil, e }
+      obj = env[key]
+      if len(obj)==0 { continue }
+    }
+
+    m := map[string]json.RawMessage{}
+    if e := json.Unmarshal( obj, &m ) ; e!=nil { return nil, e }
+    for k,v := range m { merged[k] = v }
+  }
+
+  return merged, nil
+}
+
+func system_info( w http.ResponseWriter, lg *lightlog.Logger, ctx context.Context, req *client.Request ) {
+  sub := make( map[int]*client.Request )
+  for i:=0; i<len(gBackend); i++ { sub[i] = req }
+
+  ok,failed,cont := gather( w, lg, ctx, sub )
+  if !cont { return }
+
+  sample_id := []string{}
+  stats := client.TileStats{}
+
+  for i:=0; i<len(ok); i++ {
+    info := client.SystemInfoResponse{}
+    if e := json.Unmarshal( ok[i].Body, &info ) ; e!=nil {
+      failed = append( failed, BackendFailure{ Backend:gBackend[ ok[i].Backend ].URL, Message:fmt.Sprintf("%v", e) } )
+      continue
+    }
+
+    if (info.LibraryVersion != gLibraryVersion) || (info.TileMapVersion != gTileMapVersion) {
+      failed = append( failed, BackendFailure{ Backend:gBackend[ ok[i].Backend ].URL, Message:"tile library or tile map version changed" } )
+      continue
+    }
+
+    sample_id = append( sample_id, info.SampleId... )
+    stats.Total += info.Stats.Total
+    stats.CacheHit += info.Stats.CacheHit
+    stats.CacheMiss += info.Stats.CacheMiss
+    stats.DBHit += info.Stats.DBHit
+    stats.DBMiss += info.Stats.DBMiss
+  }
+
+  write_merged( w, "system-info", failed, map[string]interface{}{
+    "LanternVersion" : VERSION_STR,
+    "LibraryVersion" : gLibraryVersion,
+    "TileMapVersion" : gTileMapVersion,
+    "CGFVersion" : gBackend[0].Info.CGFVersion,
+    "Stats" : stats,
+    "SampleId" : sample_id,
+  })
+}
+
+// Every backend has the same tile library, so the first one to
+// answer will do.
+//
+func any_backend( w http.ResponseWriter, lg *lightlog.Logger, ctx context.Context, req *client.Request ) {
+  failed := []BackendFailure{}
+
+  for i:=0; i<len(gBackend); i++ {
+    body,e := gBackend[i].Client.DoRaw( ctx, req )
+    if write_request_error( w, e ) { return }
+    if e!=nil {
+      lg.Warn( "%s: %v", gBackend[i].URL, e )
+      failed = append( failed, BackendFailure{ Backend:gBackend[i].URL, Message:fmt.Sprintf("%v", e) } )
+      continue
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write( body )
+    return
+  }
+
+  write_backend_failure( w, failed )
+}
+
+func job_id( b int, id string ) string {
+  return fmt.Sprintf("%d-%s", b, id)
+}
+
+// The backend and its request for a proxy 'JobId'.
+//
+func job_route( req *client.Request ) ( int, *client.Request, bool ) {
+  n := strings.Index( req.JobId, "-" )
+  if n<0 { return -1, nil, false }
+  b,e := strconv.Atoi( req.JobId[:n] )
+  if (e!=nil) || (b<0) || (b>=len(gBackend)) { return -1, nil, false }
+
+  r := *req
+  r.JobId = req.JobId[n+1:]
+  return b, &r, true
+}
+
+func job( w http.ResponseWriter, lg *lightlog.Logger, ctx context.Context, req *client.Request ) {
+  b,sub,ok := job_route( req )
+  if !ok {
+    write_failure( w, proxy_error( http.StatusNotFound, client.ErrUnknownJob, map[string]interface{}{ "JobId":req.JobId },
+      "unknown job %s", req.JobId ), nil )
+    return
+  }
+
+  body,e := gBackend[b].Client.DoRaw( ctx, sub )
+  if le,ok := e.(*client.Error) ; ok {
+    le.Message = strings.Replace( le.Message, sub.JobId, req.JobId, -1 )
+    if _,ok := le.Detail["JobId"] ; ok { le.Detail["JobId"] = req.JobId }
+  }
+  if write_request_error( w, e ) { return }
+  if e!=nil {
+    lg.Warn( "%s: %v", gBackend[b].URL, e )
+    write_backend_failure( w, []BackendFailure{ { Backend:gBackend[b].URL, Message:fmt.Sprintf("%v", e) } } )
+    return
+  }
+
+  // job-result is the job's own response, the others name the job.
+  //
+  if req.Type!="job-result" {
+    body = bytes.Replace( body, []byte( "\""+sub.JobId+"\"" ), []byte( "\""+req.JobId+"\"" ), -1 )
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.Write( body )
+}
+
+// Send the request to a single backend and pass the response on,
+// with the backend's 'JobId' for Async requests.
+//
+func pass_through( w http.ResponseWriter, lg *lightlog.Logger, ctx context.Context, b int, req *client.Request ) {
+  body,e := gBackend[b].Client.DoRaw( ctx, req )
+  if write_request_error( w, e ) { return }
+  if e!=nil {
+    lg.Warn( "%s: %v", gBackend[b].URL, e )
+    write_backend_failure( w, []BackendFailure{ { Backend:gBackend[b].URL, Message:fmt.Sprintf("%v", e) } } )
+    return
+  }
+
+  if req.Async {
+    jr := client.JobResponse{}
+    if e := json.Unmarshal( body, &jr ) ; (e==nil) && (len(jr.JobId)>0) {
+      jr.JobId = job_id( b, jr.JobId )
+      write_json( w, jr )
+      return
+    }
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.Write( body )
+}
+
+func handle_json_req( w http.ResponseWriter, r *http.Request ) {
+  req := client.Request{}
+  if e := json.NewDecoder( r.Body ).Decode( &req ) ; e!=nil {
+    lightlog.Info( "bad parse %v", e )
+    write_failure( w, proxy_error( http.StatusBadRequest, client.ErrParse, map[string]interface{}{ "Field":"request" },
+      "could not parse request: %v", e ), nil )
+    return
+  }
+
+  lg := lightlog.With( lightlog.Fields{ "type":req.Type } )
+
+  if (len(req.Format)>0) && (req.Format!="json") {
+    write_failure( w, invalid_parameter( "Format", req.Format, "not supported by lantern-proxy" ), nil )
+    return
+  }
+  if req.Explain {
+    write_failure( w, invalid_parameter( "Explain", req.Explain, "not supported by lantern-proxy" ), nil )
+    return
+  }
+
+  ctx,cancel := context.WithTimeout( r.Context(), gTimeout )
+  defer cancel()
+
+  switch req.Type {
+  case "system-info":
+    system_info( w, lg, ctx, &req )
+    return
+  case "job-status", "job-result", "job-cancel":
+    job( w, lg, ctx, &req )
+    return
+  }
+
+  if gAnyBackendType[ req.Type ] {
+    if req.Async { pass_through( w, lg, ctx, 0, &req ) ; return }
+    any_backend( w, lg, ctx, &req )
+    return
+  }
+
+  sub,e := split_request( &req )
+  if e!=nil { write_request_error( w, e ) ; return }
+
+  // A job's result can't be merged, it has to run on one backend.
+  //
+  if req.Async {
+    if len(sub)!=1 {
+      write_failure( w, invalid_parameter( "Async", req.Async, "samples must be on one backend" ), nil )
+      return
+    }
+    for b := range sub { pass_through( w, lg, ctx, b, sub[b] ) }
+    return
+  }
+
+  switch req.Type {
+
+  case "sample-position-variant", "sample-tile-neighborhood", "tile-variant":
+    ok,failed,cont := gather( w, lg, ctx, sub )
+    if !cont { return }
+
+    // tile-variant returns the per sample map without an envelope.
+    //
+    if req.Type == "tile-variant" {
+      merged,e := merge_sample_map( ok, "" )
+      if e!=nil { write_merge_failure( w, e, failed ) ; return }
+      if len(failed)>0 {
+        write_merged( w, req.Type, failed, map[string]interface{}{ "Result":merged } )
+        return
+      }
+      write_json( w, merged )
+      return
+    }
+
+    merged,e := merge_sample_map( ok, "Result" )
+    if e!=nil { write_merge_failure( w, e, failed ) ; return }
+    write_merged( w, req.Type, failed, map[string]interface{}{ "Result":merged } )
+
+  case "sample-tile-group-match":
+    ok,failed,cont := gather( w, lg, ctx, sub )
+    if !cont { return }
+
+    result := []string{}
+    var tgv interface{}
+    for i:=0; i<len(ok); i++ {
+      m := client.SampleTileGroupMatchResponse{}
+      if e := json.Unmarshal( ok[i].Body, &m ) ; e!=nil {
+        failed = append( failed, BackendFailure{ Backend:gBackend[ ok[i].Backend ].URL, Message:fmt.Sprintf("%v", e) } )
+        continue
+      }
+      if tgv==nil { tgv = m.TileGroupVariantId }
+      result = append( result, m.Result... )
+    }
+    sort.Strings( result )
+
+    write_merged( w, req.Type, failed, map[string]interface{}{ "TileGroupVariantId":tgv, "Result":result } )
+
+  case "variant-frequency":
+    ok,failed,cont := gather( w, lg, ctx, sub )
+    if !cont { return }
+
+    result := make( map[string]client.VariantFrequency )
+    for i:=0; i<len(ok); i++ {
+      m := client.VariantFrequencyResponse{}
+      if e := json.Unmarshal( ok[i].Body, &m ) ; e!=nil {
+        failed = append( failed, BackendFailure{ Backend:gBackend[ ok[i].Backend ].URL, Message:fmt.Sprintf("%v", e) } )
+        continue
+      }
+      for tileid,vf := range m.Result {
+        x := result[tileid]
+        x.SampleCount += vf.SampleCount
+        x.AlleleCount += vf.AlleleCount
+        x.CalledAlleleCount += vf.CalledAlleleCount
+        result[tileid] = x
+      }
+    }
+
+    for tileid,x := range result {
+      if x.CalledAlleleCount>0 {
+        x.Frequency = float64(x.AlleleCount) / float64(x.CalledAlleleCount)
+        result[tileid] = x
+      }
+    }
+
+    write_merged( w, req.Type, failed, map[string]interface{}{ "Result":result } )
+
+  default:
+
+    // Anything else (sample-intersect, sample-similarity,
+    // population-pca and burden-test in particular) can't be
+    // combined across backends, pass it through if possible.
+    //
+    if len(sub)!=1 {
+      write_failure( w, invalid_parameter( "SampleId", req.SampleId, fmt.Sprintf("%s can't be combined across backends, samples must be on one backend", req.Type) ), nil )
+      return
+    }
+
+    for b := range sub { pass_through( w, lg, ctx, b, sub[b] ) }
+
+  }
+
+}
+
+type BeaconDatasetAlleleResponse struct {
+  DatasetId string `json:"datasetId"`
+  Exists bool `json:"exists"`
+  SampleCount int `json:"sampleCount"`
+  CallCount int `json:"callCount"`
+  VariantCount int `json:"variantCount"`
+  Frequency float64 `json:"frequency"`
+}
+
+type BeaconAlleleResponse struct {
+  BeaconId string `json:"beaconId"`
+  ApiVersion string `json:"apiVersion"`
+  Exists bool `json:"exists"`
+  AlleleRequest json.RawMessage `json:"alleleRequest"`
+  DatasetAlleleResponses []BeaconDatasetAlleleResponse `json:"datasetAlleleResponses"`
+  Error json.RawMessage `json:"error,omitempty"`
+  Failed []BackendFailure `json:"failed,omitempty"`
+}
+
+func beacon_get( ctx context.Context, b *Backend, raw_query string ) ( *BeaconAlleleResponse, int, error ) {
+  hreq,e := http.NewRequest( "GET", b.URL + "/beacon/query?" + raw_query, nil )
+  if e!=nil { return nil, 0, e }
+  hreq = hreq.WithContext( ctx )
+
+  hresp,e := b.Client.HTTPClient.Do( hreq )
+  if e!=nil { return nil, 0, e }
+  defer hresp.Body.Close()
+
+  body,e := ioutil.ReadAll( hresp.Body )
+  if e!=nil { return nil, hresp.StatusCode, e }
+
+  resp := BeaconAlleleResponse{}
+  if e := json.Unmarshal( body, &resp ) ; e!=nil { return nil, hresp.StatusCode, e }
+  return &resp, hresp.StatusCode, nil
+}
+
+// Sum the beacon counts over every backend.  A request error (4xx)
+// is the same on every backend, so the first one is returned as is.
+//
+func beacon_query_handler( w http.ResponseWriter, r *http.Request ) {
+  ctx,cancel := context.WithTimeout( r.Context(), gTimeout )
+  defer cancel()
+
+  type beacon_result struct {
+    resp *BeaconAlleleResponse
+    status int
+    err error
+  }
+
+  res := make( []beacon_result, len(gBackend) )
+  var wg sync.WaitGroup
+  for i:=0; i<len(gBackend); i++ {
+    wg.Add(1)
+    go func( i int ) {
+      defer wg.Done()
+      resp,status,e := beacon_get( ctx, gBackend[i], r.URL.RawQuery )
+      res[i] = beacon_result{ resp, status, e }
+    }(i)
+  }
+  wg.Wait()
+
+  var merged *BeaconAlleleResponse
+  dres := BeaconDatasetAlleleResponse{ DatasetId:"all" }
+  failed := []BackendFailure{}
+
+  for i:=0; i<len(res); i++ {
+    if (res[i].err==nil) && (res[i].status>=400) && (res[i].status<500) {
+      w.Header().Set("Content-Type", "application/json")
+      w.WriteHeader( res[i].status )
+      b,_ := json.Marshal( res[i].resp )
+      io.WriteString( w, string(b) )
+      return
+    }
+
+    if (res[i].err!=nil) || (res[i].status!=http.StatusOK) {
+      msg := fmt.Sprintf("status %d", res[i].status)
+      if res[i].err!=nil { msg = fmt.Sprintf("%v", res[i].err) }
+      lightlog.Warn( "beacon %s: %s", gBackend[i].URL, msg )
+      failed = append( failed, BackendFailure{ Backend:gBackend[i].URL, Message:msg } )
+      continue
+    }
+
+    if merged==nil { merged = res[i].resp }
+    for j:=0; j<len(res[i].resp.DatasetAlleleResponses); j++ {
+      d := res[i].resp.DatasetAlleleResponses[j]
+      dres.SampleCount += d.SampleCount
+      dres.CallCount += d.CallCount
+      dres.VariantCount += d.VariantCount
+    }
+  }
+
+  if merged==nil {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader( http.StatusBadGateway )
+    b,_ := json.Marshal( BeaconAlleleResponse{ ApiVersion:"0.3", Failed:failed,
+      Error:json.RawMessage(`{"errorCode":502,"message":"all backends failed"}`) } )
+    io.WriteString( w, string(b) )
+    return
+  }
+
+  dres.Exists = dres.SampleCount > 0
+  if dres.CallCount > 0 { dres.Frequency = float64(dres.VariantCount) / float64(dres.CallCount) }
+
+  merged.Exists = dres.Exists
+  merged.DatasetAlleleResponses = []BeaconDatasetAlleleResponse{ dres }
+  merged.Failed = failed
+
+  write_json( w, merged )
+}
+
+func _main( c *cli.Context ) {
+
+  if e := lightlog.SetLevelString( c.String("log-level") ) ; e!=nil {
+    fmt.Fprintf( os.Stderr, "%v\n", e )
+    os.Exit(1)
+  }
+  if c.Bool("Verbose") { lightlog.SetLevel( lightlog.DEBUG ) }
+  lightlog.SetJSON( c.Bool("log-json") )
+
+  gTimeout = time.Duration( c.Int("timeout") ) * time.Second
+
+  backend := c.StringSlice("backend")
+  if len(backend)==0 {
+    fmt.Fprintf( os.Stderr, "Provide at least one backend\n" )
+    cli.ShowAppHelp( c )
+    os.Exit(1)
+  }
+
+  if e := backend_init( backend ) ; e!=nil {
+    lightlog.Fatal( "%v", e )
+  }
+
+  http.HandleFunc("/", handle_json_req)
+  http.HandleFunc("/beacon/query", beacon_query_handler)
+
+  port_str := fmt.Sprintf(":%d", c.Int("port"))
+  lightlog.Info( "listening: %v", port_str )
+  if e := http.ListenAndServe( port_str, nil ) ; e!=nil {
+    lightlog.Fatal( "%v", e )
+  }
+
+}
+
+func main() {
+  app := cli.NewApp()
+  app.Name  = "lantern-proxy"
+  app.Usage = "Send lantern requests to several lantern servers and merge the results"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+
+    cli.StringSliceFlag{
+      Name: "backend, b",
+      Value: &cli.StringSlice{},
+      Usage: "URL of lantern backend(s)",
+    },
+
+    cli.IntFlag{
+      Name: "port, p",
+      Value: 8080,
+      Usage: "Port to listen on",
+    },
+
+    cli.IntFlag{
+      Name: "timeout",
+      Value: 60,
+      Usage: "Backend request timeout (seconds)",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag (same as --log-level debug)",
+    },
+
+    cli.StringFlag{
+      Name: "log-level",
+      Value: "warn",
+      Usage: "Log level (debug, info, warn, error)",
+    },
+
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
+    },
+
+  }
+
+  app.Run(os.Args)
+}
@@ -0,0 +1,191 @@
+package main
+
+import "io"
+import "fmt"
+import "sync"
+import "time"
+import "strings"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "encoding/json"
+
+import "../client"
+
+// A lantern backend holding the samples.  sample-position-variant
+// fails with a 500 if fail is set.  Every request received is kept.
+//
+type stub_backend struct {
+  sample_id []string
+  fail bool
+
+  mu sync.Mutex
+  req []client.Request
+}
+
+func (sb *stub_backend) ServeHTTP( w http.ResponseWriter, r *http.Request ) {
+  req := client.Request{}
+  json.NewDecoder( r.Body ).Decode( &req )
+
+  sb.mu.Lock()
+  sb.req = append( sb.req, req )
+  sb.mu.Unlock()
+
+  sample_id := req.SampleId
+  if len(sample_id)==0 { sample_id = sb.sample_id }
+
+  switch req.Type {
+  case "system-info":
+    b,_ := json.Marshal( sb.sample_id )
+    fmt.Fprintf( w, `{"Type":"success","Message":"system-info","LibraryVersion":"lib","TileMapVersion":"map","SampleId":%s}`, b )
+
+  case "sample-position-variant":
+    if sb.fail {
+      w.WriteHeader( http.StatusInternalServerError )
+      io.WriteString( w, `{"Type":"failure","Error":"InternalError","Message":"internal error"}` )
+      return
+    }
+    m := map[string][][]string{}
+    for i:=0; i<len(sample_id); i++ { m[ sample_id[i] ] = [][]string{ { "247.00.0000.0000" }, { "247.00.0000.0001" } } }
+    b,_ := json.Marshal( m )
+    fmt.Fprintf( w, `{"Type":"success","Message":"sample_position_variant","Result":%s}`, b )
+
+  case "variant-frequency":
+    fmt.Fprintf( w, `{"Type":"success","Message":"variant-frequency","Result":{"247.00.0000.0001":{"SampleCount":%d,"AlleleCount":1,"CalledAlleleCount":%d}}}`, len(sample_id), 2*len(sample_id) )
+
+  case "tile-sequence":
+    w.WriteHeader( http.StatusBadRequest )
+    io.WriteString( w, `{"Type":"failure","Error":"InvalidTileId","Message":"invalid tile id x","Detail":{"TileId":"x"}}` )
+
+  case "sample-intersect", "burden-test":
+    if req.Async {
+      io.WriteString( w, `{"Type":"success","Message":"job","JobId":"0123456789abcdef0123456789abcdef"}` )
+      return
+    }
+    fmt.Fprintf( w, `{"Type":"success","Message":"%s"}`, req.Type )
+
+  case "job-status":
+    if req.JobId!="0123456789abcdef0123456789abcdef" {
+      w.WriteHeader( http.StatusNotFound )
+      fmt.Fprintf( w, `{"Type":"failure","Error":"UnknownJob","Message":"unknown job %s","Detail":{"JobId":"%s"}}`, req.JobId, req.JobId )
+      return
+    }
+    fmt.Fprintf( w, `{"Type":"success","Message":"job-status","Result":{"JobId":"%s","State":"done"}}`, req.JobId )
+
+  default:
+    w.WriteHeader( http.StatusBadRequest )
+    io.WriteString( w, `{"Type":"failure","Error":"InvalidRequestType","Message":"invalid request type"}` )
+  }
+}
+
+func (sb *stub_backend) received( typ string ) []client.Request {
+  sb.mu.Lock()
+  defer sb.mu.Unlock()
+
+  r := []client.Request{}
+  for i:=0; i<len(sb.req); i++ {
+    if sb.req[i].Type==typ { r = append( r, sb.req[i] ) }
+  }
+  return r
+}
+
+func proxy_request( t *testing.T, body string ) ( int, map[string]interface{} ) {
+  rec := httptest.NewRecorder()
+  handle_json_req( rec, httptest.NewRequest( "POST", "/", strings.NewReader( body ) ) )
+
+  resp := map[string]interface{}{}
+  if e := json.Unmarshal( rec.Body.Bytes(), &resp ) ; e!=nil { t.Fatalf("%s: %v: %s", body, e, rec.Body.String()) }
+  return rec.Code, resp
+}
+
+func TestProxy( t *testing.T ) {
+  sb := []*stub_backend{
+    &stub_backend{ sample_id:[]string{ "0:a.cgf", "1:b.cgf" } },
+    &stub_backend{ sample_id:[]string{ "2:c.cgf" } },
+  }
+
+  urls := []string{}
+  for i:=0; i<len(sb); i++ {
+    srv := httptest.NewServer( sb[i] )
+    defer srv.Close()
+    urls = append( urls, srv.URL )
+  }
+
+  gTimeout = 10*time.Second
+  if e := backend_init( urls ) ; e!=nil { t.Fatal(e) }
+  for i:=0; i<len(gBackend); i++ { gBackend[i].Client.RetryWait = time.Millisecond }
+
+  // Merged over both backends.
+  //
+  code,resp := proxy_request( t, `{"Type":"sample-position-variant","SampleId":[],"Position":["247.00.0000"]}` )
+  res,_ := resp["Result"].(map[string]interface{})
+  if (code!=http.StatusOK) || (resp["Type"]!="success") || (len(res)!=3) { t.Errorf("merge: %d %v", code, resp) }
+
+  code,resp = proxy_request( t, `{"Type":"variant-frequency","SampleId":["0:a.cgf","2:c.cgf"],"TileVariantId":["247.00.0000.0001"]}` )
+  vf,_ := resp["Result"].(map[string]interface{})["247.00.0000.0001"].(map[string]interface{})
+  if (vf["SampleCount"]!=2.0) || (vf["Frequency"]!=0.5) { t.Errorf("variant-frequency: %v", resp) }
+
+  // One backend failing.
+  //
+  sb[1].fail = true
+  code,resp = proxy_request( t, `{"Type":"sample-position-variant","SampleId":[],"Position":["247.00.0000"]}` )
+  res,_ = resp["Result"].(map[string]interface{})
+  failed,_ := resp["Failed"].([]interface{})
+  if (code!=http.StatusOK) || (resp["Type"]!="partial") || (len(res)!=2) || (len(failed)!=1) { t.Errorf("partial: %d %v", code, resp) }
+  if f,_ := failed[0].(map[string]interface{}) ; (f["Backend"]!=urls[1]) || (f["Error"]!="InternalError") { t.Errorf("partial failure: %v", failed) }
+
+  // Every backend failing.
+  //
+  sb[0].fail = true
+  code,resp = proxy_request( t, `{"Type":"sample-position-variant","SampleId":[],"Position":["247.00.0000"]}` )
+  failed,_ = resp["Failed"].([]interface{})
+  if (code!=http.StatusServiceUnavailable) || (resp["Error"]!="NotReady") || (len(failed)!=2) { t.Errorf("all failed: %d %v", code, resp) }
+  sb[0].fail = false
+  sb[1].fail = false
+
+  // Request errors are passed on, not retried on the next backend.
+  //
+  code,resp = proxy_request( t, `{"Type":"tile-sequence","TileId":["x"]}` )
+  if (code!=http.StatusBadRequest) || (resp["Error"]!="InvalidTileId") { t.Errorf("tile-sequence: %d %v", code, resp) }
+  if len(sb[1].received( "tile-sequence" ))!=0 { t.Errorf("tile-sequence error sent to the second backend") }
+
+  // burden-test goes where its cases and controls are.
+  //
+  code,resp = proxy_request( t, `{"Type":"burden-test","CaseSampleId":["0:a.cgf"],"ControlSampleId":["1:b.cgf"]}` )
+  if (code!=http.StatusOK) || (resp["Type"]!="success") || (len(sb[1].received( "burden-test" ))!=0) { t.Errorf("burden-test: %d %v", code, resp) }
+  code,resp = proxy_request( t, `{"Type":"burden-test","CaseSampleId":["0:a.cgf"],"ControlSampleId":["2:c.cgf"]}` )
+  if (code!=http.StatusBadRequest) || (resp["Error"]!="InvalidParameter") { t.Errorf("burden-test over both backends: %d %v", code, resp) }
+
+  // Jobs are routed by the backend in their JobId.
+  //
+  code,resp = proxy_request( t, `{"Type":"sample-intersect","SampleId":["2:c.cgf"],"Async":true}` )
+  job_id,_ := resp["JobId"].(string)
+  if job_id!="1-0123456789abcdef0123456789abcdef" { t.Fatalf("async: %d %v", code, resp) }
+
+  code,resp = proxy_request( t, `{"Type":"job-status","JobId":"`+job_id+`"}` )
+  st,_ := resp["Result"].(map[string]interface{})
+  if (code!=http.StatusOK) || (st["JobId"]!=job_id) || (len(sb[0].received( "job-status" ))!=0) { t.Errorf("job-status: %d %v", code, resp) }
+
+  code,resp = proxy_request( t, `{"Type":"job-status","JobId":"1-00"}` )
+  if (code!=http.StatusNotFound) || (resp["Error"]!="UnknownJob") || !strings.Contains( resp["Message"].(string), "1-00" ) { t.Errorf("unknown backend job: %d %v", code, resp) }
+
+  code,resp = proxy_request( t, `{"Type":"job-status","JobId":"7-00"}` )
+  if (code!=http.StatusNotFound) || (resp["Error"]!="UnknownJob") { t.Errorf("unknown job: %d %v", code, resp) }
+
+  code,resp = proxy_request( t, `{"Type":"sample-intersect","SampleId":[],"Async":true}` )
+  if (code!=http.StatusBadRequest) || (resp["Error"]!="InvalidParameter") { t.Errorf("async over both backends: %d %v", code, resp) }
+
+  // The proxy's own request errors.
+  //
+  for body,want := range map[string]string{
+    `{"Type":`                                                   : "ParseError",
+    `{"Type":"variant-frequency","SampleId":["9:z.cgf"]}`        : "UnknownSample",
+    `{"Type":"variant-frequency","Format":"tsv"}`                : "InvalidParameter",
+    `{"Type":"variant-frequency","Explain":true}`                : "InvalidParameter",
+  } {
+    status := http.StatusBadRequest
+    if want=="UnknownSample" { status = http.StatusNotFound }
+    code,resp = proxy_request( t, body )
+    if (code!=status) || (resp["Error"]!=want) { t.Errorf("%s: %d %v", body, code, resp) }
+  }
+}
@@ -4,7 +4,7 @@ import "fmt"
 import "os"
 
 import "net/http"
-import _ "time"
+import "time"
 import "net"
 
 import "strings"
@@ -22,6 +22,8 @@ import "runtime/pprof"
 import "github.com/codegangsta/cli"
 
 import "../cgf"
+import "../bitmap"
+import "../lightlog"
 
 import "encoding/gob"
 
@@ -79,12 +81,49 @@ type LanternRequest struct {
   TileGroupVariantIdRange [][]map[string][]int
   TileId []string
   Position []string
+  Assembly string
+  Phase string
+  Gene []string
 
   PathStep []string
+  Path string
+  Step string
+
+  Component int
+  MinFrequency float64
+  MaxFrequency float64
+  Permutation int
+  Seed int64
 
   VariantId map[string]string
   VariantClass map[string]TileMapVariantClass
 
+  Batch []LanternRequest `json:",omitempty"`
+
+  Async bool `json:",omitempty"`
+  JobId string `json:",omitempty"`
+
+  Limit int
+  Cursor string
+
+  // "json" (the default), "tsv" or "csv", see lantern_table.go.
+  //
+  Format string `json:",omitempty"`
+
+  // Add timings and counts to the response, see lantern_explain.go.
+  //
+  Explain bool `json:",omitempty"`
+
+  lg *lightlog.Logger
+  batch *batch_context
+  job *lantern_job
+  audit *LanternAuditRecord
+  api_key string
+  explain *lantern_explain
+
+  // Samples made resident for the request, see lantern_memory.go.
+  //
+  pinned []int
 }
 
 type LanternResponse struct {
@@ -92,11 +131,9 @@ type LanternResponse struct {
   Message string
 }
 
-func send_error_bad_request( e_str string, w http.ResponseWriter ) {
-  fmt.Printf("%s", e_str)
-  w.Header().Set("Content-Type", "application/json")
-  io.WriteString(w, `{"Type":"error","Message":"bad request"}` )
-  return
+func send_error_bad_request( lg *lightlog.Logger, e error, w http.ResponseWriter ) {
+  lg.Info("bad parse %v", e)
+  _errc( w, ErrParse( "request", e ) )
 }
 
 func construct_tile_map( tile_map []cgf.TileMapEntry ) {
@@ -220,27 +257,27 @@ func unpack_tile_list( TileVariantId []string ) ( map[string][]TileRange, error
 
     psv := strings.SplitN( TileVariantId[i][tv_start:], ".", 5 )
     if len(psv) != 4 {
-      return nil, fmt.Errorf("Invalid tile %s", TileVariantId[i] )
+      return nil, ErrInvalidTileId( TileVariantId[i], "expected path.version.step.variant" )
     }
 
     path_range,e := parseIntOption( psv[0], 16 )
     if e!=nil {
-      return nil, fmt.Errorf("Invalid path in %s", TileVariantId[i] )
+      return nil, ErrInvalidTileId( TileVariantId[i], "invalid path" )
     }
 
     version_range,e := parseIntOption( psv[1], 16 ) ; _ = version_range
     if e!=nil {
-      return nil, fmt.Errorf("Invalid version in %s", TileVariantId[i] )
+      return nil, ErrInvalidTileId( TileVariantId[i], "invalid version" )
     }
 
     step_range,e := parseIntOption( psv[2], 16 )
     if e!=nil {
-      return nil, fmt.Errorf("Invalid step in %s", TileVariantId[i] )
+      return nil, ErrInvalidTileId( TileVariantId[i], "invalid step" )
     }
 
     variant_range,e := parseIntOption( psv[3], 16 )
     if e!=nil {
-      return nil, fmt.Errorf("Invalid variant in %s", TileVariantId[i] )
+      return nil, ErrInvalidTileId( TileVariantId[i], "invalid variant" )
     }
 
     max_path := 864
@@ -271,7 +308,7 @@ func unpack_tile_list( TileVariantId []string ) ( map[string][]TileRange, error
               ele_count++
 
               if ele_count >= max_elements {
-                return nil, fmt.Errorf("max elements exceeded (max %d)", max_elements)
+                return nil, ErrLimitExceeded( "elements", max_elements )
               }
             }
           }
@@ -308,7 +345,7 @@ func exact_tile_class_match( w http.ResponseWriter, resp *LanternResponse, req *
 
         if int64(tile_class_rank) == variant {
 
-          fmt.Printf(">> %d %d\n", tile_class_rank, variant )
+          req.lg.Debug("exact-tile-class-match %d %d", tile_class_rank, variant )
 
           res_count[ gCGFName[i] ]++
         }
@@ -351,7 +388,7 @@ func getSampleIndexArray( sampleId []string ) ( sampleIndex []int, err error ) {
       if v,ok := gCGFIndexMap[ sampleId[i] ] ; ok {
         sampleIndex = append(sampleIndex, v)
       } else {
-        err = fmt.Errorf( "Could not find sampleId %s", sampleId[i] )
+        err = ErrUnknownSample( sampleId[i] )
         return
       }
     }
@@ -535,13 +572,13 @@ func case_control_handler( w http.ResponseWriter, resp *LanternResponse, req *La
   resp.Message = "testing tile-variant"
 
 
-  caseSampleIndex,err := getSampleIndexArray( req.CaseSampleId )
+  caseSampleIndex,err := req.sampleIndexArray( req.CaseSampleId )
   if err!=nil {
     resp.Type = "error" ; resp.Message = fmt.Sprintf("%v", err)
     return
   }
 
-  controlSampleIndex,err := getSampleIndexArray( req.ControlSampleId )
+  controlSampleIndex,err := req.sampleIndexArray( req.ControlSampleId )
   if err!=nil {
     resp.Type = "error" ; resp.Message = fmt.Sprintf("%v", err)
     return
@@ -584,12 +621,9 @@ func tile_variant_handler( w http.ResponseWriter, resp *LanternResponse, req *La
   resp.Message = "testing tile-variant"
 
 
-  sampleIndex,err := getSampleIndexArray( req.SampleId )
+  sampleIndex,err := req.sampleIndexArray( req.SampleId )
   _ = sampleIndex
-  if err!=nil {
-    resp.Type = "error" ; resp.Message = fmt.Sprintf("%v", err)
-    return
-  }
+  if err!=nil { _erre(w, err) ; return }
 
 
 
@@ -602,22 +636,13 @@ func tile_variant_handler( w http.ResponseWriter, resp *LanternResponse, req *La
   //
   for i:=0; i<len(req.TileId); i++ {
     psv := strings.SplitN( req.TileId[i], ".", 4 )
-    if len(psv) != 2 {
-      resp.Type = "error" ; resp.Message = fmt.Sprintf("Invalid TileId %s", req.TileId[i] )
-      return
-    }
+    if len(psv) != 2 { _errc(w, ErrInvalidTileId( req.TileId[i], "expected path.step" )) ; return }
 
     path_range,e := parseIntOption( psv[0], 16 )
-    if e!=nil {
-      resp.Type = "error" ; resp.Message = fmt.Sprintf("Invalid path in TileId %s", req.TileId[i] )
-      return
-    }
+    if e!=nil { _errc(w, ErrInvalidTileId( req.TileId[i], "invalid path" )) ; return }
 
     step_range,e := parseIntOption( psv[1], 16 )
-    if e!=nil {
-      resp.Type = "error" ; resp.Message = fmt.Sprintf("Invalid path in TileId %s", req.TileId[i] )
-      return
-    }
+    if e!=nil { _errc(w, ErrInvalidTileId( req.TileId[i], "invalid step" )) ; return }
 
     max_path := 864
 
@@ -638,10 +663,7 @@ func tile_variant_handler( w http.ResponseWriter, resp *LanternResponse, req *La
 
           for y:=beg_step; y<(beg_step + n_step); y++ {
             ele_count++
-            if ele_count >= max_elements {
-              resp.Type = "error" ; resp.Message = fmt.Sprintf("max elements exceeded (max %d)", max_elements)
-              return
-            }
+            if ele_count >= max_elements { _errc(w, ErrLimitExceeded( "elements", max_elements )) ; return }
 
             tilePosition = append( tilePosition, [2]int{ int(x), int(y) } )
           }
@@ -650,10 +672,10 @@ func tile_variant_handler( w http.ResponseWriter, resp *LanternResponse, req *La
     }
   }
 
-  fmt.Printf("tilePosition>> %v\n", tilePosition )
+  req.lg.Debug("tilePosition %v", tilePosition )
 
   ans,err := tile_variant( sampleIndex, tilePosition )
-  if err!=nil { fmt.Printf(">>>error\n") }
+  if err!=nil { req.lg.Warn("tile_variant: %v", err) }
 
   w.Header().Set("Content-Type", "application/json")
   res_json_bytes,_ := json.Marshal( ans )
@@ -675,7 +697,7 @@ func sample_tile_variant_handler( w http.ResponseWriter, resp *LanternResponse,
   resp.Type = "success"
   resp.Message = "testing sample-tile-variant"
 
-  sampleIndex,err := getSampleIndexArray( req.SampleId )
+  sampleIndex,err := req.sampleIndexArray( req.SampleId )
   if err!=nil {
     resp.Type = "error" ; resp.Message = fmt.Sprintf("%v", err)
     return
@@ -750,56 +772,186 @@ func sample_tile_variant( sampleIndex []int, pathStep []string ) (resSampleVaria
 //                                                         |_|                      |___/   .
 //-------------------------------------------------------------------------------------------
 
+// Example request:
+//
+// {
+//   "Type":"variant-frequency",
+//   "SampleId":[],
+//   "TileVariantId":[ "247.00.0003.0000+3" ]
+// }
+//
+// Example response:
+//
+// {
+//   "Type":"success", "Message":"variant-frequency",
+//   "Result":{
+//     "247.00.0003.0000":{ "SampleCount":172, "AlleleCount":340, "CalledAlleleCount":346, "Frequency":0.9826 },
+//     ...
+//   }
+// }
+//
+// Frequency is the allele count over the number of called alleles
+// at the position.
+//
+// An open ended variant range ("247.00.0003.0000-") gives every tile
+// variant seen in the samples at the position, as do the positions of
+// any genes or regions in 'Gene' (see lantern_gene.go).  The path,
+// version and step can be a genomic range instead
+// ("chr17:41196312-41197000.0000-", see lantern_locus.go).
+//
+// "Format":"tsv" (or "csv") gives one row per tile variant instead,
+// see lantern_table.go.
+//
+// In protected mode the counts are noisy, see lantern_privacy.go.
+//
 func variant_frequency_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
-  resp.Type = "success"
-  resp.Message = "testing sample-tile-group-match"
 
-  sampleIndex,err := getSampleIndexArray( req.SampleId )
-  if err!=nil {
-    resp.Type = "error" ; resp.Message = fmt.Sprintf("%v", err)
-    return
-  }
+  sampleIndex,err := req.sampleIndexArrayIndexed( req.SampleId )
+  if err!=nil { _erre(w, err) ; return }
 
-  //tileGroupRange := make( []map[string][][2]int, 0, 8 )
-  tileGroupRange := make( []map[string][]TileRange, 0, 8 )
+  tile_variant_id,err := request_tile_variant_id( req, req.TileVariantId )
+  if err!=nil { _erre(w, err) ; return }
 
-  // Unpack TileVariantIds
-  //
-  for g:=0; g<len(req.TileGroupVariantId); g++ {
+  tileRange,err := unpack_tile_list( tile_variant_id )
+  if err!=nil { _erre(w, err) ; return }
 
-    tileRange,e := unpack_tile_list( req.TileGroupVariantId[g] )
-    if e!=nil {
-      resp.Type = "error" ; resp.Message = fmt.Sprintf("%v", e)
-      return
+  // Open ended ranges list the variants the samples have, so only
+  // explicit ones are allowed in protected mode.
+  //
+  if protected() {
+    if len(req.Gene)>0 { _errc(w, ErrNotPermitted( "Gene", "open ended in protected mode" )) ; return }
+    for _,variantRange := range tileRange {
+      for i:=0; i<len(variantRange); i++ {
+        if variantRange[i].Range[1]<0 { _errc(w, ErrNotPermitted( "TileVariantId", "open ended ranges in protected mode" )) ; return }
+      }
     }
+    if le := privacy_spend( req.api_key ) ; le!=nil { _errc(w, le) ; return }
+  }
 
-    tileGroupRange = append( tileGroupRange, tileRange )
+  if len(req.Gene)>0 {
+    pos,e := gene_position( req.Gene )
+    if e!=nil { _erre(w, e) ; return }
 
+    for i:=0; i<len(pos); i++ {
+      path_step := fmt.Sprintf("%x:%x", pos[i][0], pos[i][1])
+      tileRange[path_step] = append( tileRange[path_step], TileRange{ Range:[2]int{ 0, -1 }, Permit:true } )
+    }
   }
 
-  resSample, err := sample_tile_group_match( sampleIndex, tileGroupRange )
-  if err!=nil {
-    resp.Type = "error" ; resp.Message = fmt.Sprintf("%v", err)
-    return
-  }
+  req.explain.ranges( "TileVariantId", tileRange )
+  req.explain.positions( len(tileRange) )
+  req.explain.parsed()
 
-  nameList := []string{}
-  for i:=0; i<len(resSample); i++ {
-    nameList = append(nameList, gCGFName[ resSample[i] ] )
-  }
+  res,err := variant_frequency( sampleIndex, tileRange )
+  if err!=nil { _erre(w, err) ; return }
+  if protected() { protect_variant_frequency( res, len(tileRange) ) }
+  req.explain.looked_up()
 
-  fmt.Printf("got: %v --> %v", resSample, nameList)
+  if is_table_format( req ) { variant_frequency_table( w, req, res ) ; return }
 
   w.Header().Set("Content-Type", "application/json")
-  res_json_bytes,_ := json.Marshal( nameList )
+  res_json_bytes,_ := json.Marshal( res )
+
+  io.WriteString(w, "{\n")
+  io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"variant-frequency\",\n")
+  io.WriteString(w, "  \"Result\": ")
   io.WriteString(w, string(res_json_bytes))
+  io.WriteString(w, "\n")
+  io.WriteString(w, "}")
+
+}
 
+type VariantFrequency struct {
+  SampleCount int
+  AlleleCount int
+  CalledAlleleCount int
+  Frequency float64
 
+  // Protected mode only, see lantern_privacy.go.
+  //
+  Suppressed bool `json:",omitempty"`
 }
 
-func variant_frequency( sampleIndex []int,
-                        tileGroupVariantRange []map[string][][2]int ) (tileVariantFrequncy map[string]map[string]int, err error)  {
-  return nil, nil
+// Per allele tile variants starting at path, step, for each sample.
+// Uses the variant index if it's been built.
+//
+func variant_frequency( sampleIndex []int, tileRange map[string][]TileRange ) ( map[string]VariantFrequency, error ) {
+  res := make( map[string]VariantFrequency )
+
+  var sset *bitmap.Bitmap
+  if gVariantIndex!=nil { sset = sample_set( sampleIndex ) }
+
+  for path_step,variantRange := range tileRange {
+    path_64,step_64,e := convert_path_step( path_step )
+    if e!=nil { return nil, e }
+    path,step := int(path_64),int(step_64)
+
+    // variant -> allele count and sample count, plus the number of
+    // called alleles at the position.
+    //
+    allele_count := make( map[int]int )
+    sample_count := make( map[int]int )
+    called := 0
+
+    if gVariantIndex!=nil {
+      ent := gVariantIndex.entries( path, step )
+
+      allele_called := make( map[int]*bitmap.Bitmap )
+      variant_sample := make( map[int]*bitmap.Bitmap )
+      for i:=0; i<len(ent); i++ {
+        x := ent[i].Sample.And( sset )
+
+        if _,ok := allele_called[ ent[i].Allele ] ; !ok { allele_called[ ent[i].Allele ] = bitmap.New( len(gCGF) ) }
+        allele_called[ ent[i].Allele ] = allele_called[ ent[i].Allele ].Or( x )
+
+        if _,ok := variant_sample[ ent[i].Variant ] ; !ok { variant_sample[ ent[i].Variant ] = bitmap.New( len(gCGF) ) }
+        variant_sample[ ent[i].Variant ] = variant_sample[ ent[i].Variant ].Or( x )
+
+        allele_count[ ent[i].Variant ] += x.Count()
+      }
+
+      for _,b := range allele_called { called += b.Count() }
+      for v,b := range variant_sample { sample_count[v] = b.Count() }
+
+    } else {
+
+      for i:=0; i<len(sampleIndex); i++ {
+        allele_tile,e := sample_allele_tiles( sampleIndex[i], path, step )
+        if e!=nil { continue }
+
+        seen := make( map[int]bool )
+        for a:=0; a<len(allele_tile); a++ {
+          if (allele_tile[a].Step!=step) || (allele_tile[a].Variant<0) { continue }
+          called++
+          allele_count[ allele_tile[a].Variant ]++
+          if !seen[ allele_tile[a].Variant ] { sample_count[ allele_tile[a].Variant ]++ }
+          seen[ allele_tile[a].Variant ] = true
+        }
+      }
+
+    }
+
+    for vpos:=0; vpos<len(variantRange); vpos++ {
+      beg,end := variantRange[vpos].Range[0],variantRange[vpos].Range[1]
+
+      variant := []int{}
+      if end<0 {
+        for v,n := range allele_count {
+          if (v>=beg) && (n>0) { variant = append( variant, v ) }
+        }
+      } else {
+        for v:=beg; v<end; v++ { variant = append( variant, v ) }
+      }
+
+      for _,v := range variant {
+        vf := VariantFrequency{ SampleCount:sample_count[v], AlleleCount:allele_count[v], CalledAlleleCount:called }
+        if called>0 { vf.Frequency = float64(vf.AlleleCount) / float64(called) }
+        res[ fmt.Sprintf("%03x.%02x.%04x.%04x", path, 0, step, v) ] = vf
+      }
+    }
+  }
+
+  return res, nil
 }
 
 
@@ -822,76 +974,167 @@ func handle_json_req( w http.ResponseWriter, r *http.Request ) {
   c := <-g_incr
   go func() { g_incr <- c+1 }()
 
+  lg := lightlog.With( lightlog.Fields{ "req":c } )
+
+  start := time.Now()
   var body_reader io.Reader = r.Body
 
-  req := LanternRequest{}
+  req := LanternRequest{ audit:request_audit( r ), api_key:request_api_key( r ) }
+  if req.audit!=nil { req.audit.RequestId = c }
 
   dec := json.NewDecoder( body_reader )
   e := dec.Decode( &req )
   if e!=nil {
-    send_error_bad_request( fmt.Sprintf("[%d] bad parse %v\n", c, e), w )
+    send_error_bad_request( lg, e, w )
     return
   }
 
+  req.lg = lg.With( lightlog.Fields{ "type":req.Type } )
+  req.lg.Debug("request content-type: %s", r.Header.Get("Content-Type") )
+
+  if req.Explain { req.explain = new_explain( req.Type, start, time.Now() ) }
+
+  accept_ok := true
+  if len(req.Format)==0 { req.Format,accept_ok = accept_format( r.Header.Get("Accept"), table_form( &req ) ) }
+  req.audit.note_request( &req )
+
+  if !accept_ok {
+    _errc(w, ErrNotAcceptable( r.Header.Get("Accept"), req.Type ))
+    return
+  }
+
+  serve_request( w, &req )
+}
+
+// Dispatch a decoded request to its handler.
+//
+func serve_request( w http.ResponseWriter, req *LanternRequest ) {
+
   resp := LanternResponse{ Type:"error", Message:"invalid command" }
 
+  if le := check_format( req ) ; le!=nil { _errc(w, le) ; return }
+  if le := privacy_check( req ) ; le!=nil { _errc(w, le) ; return }
+
+  if req.Async {
+    job_submit( w, req )
+    return
+  }
+  defer req.release_samples()
+
+  // Serve repeated queries from the result cache, keeping a copy
+  // of the response otherwise.
+  //
+  var w_cache *result_cache_writer
+  cache_key,cacheable := "",false
+  // Jobs can be cancelled part way, their results are never cached.
+  //
+  if (gResultCache!=nil) && !req.Explain && (req.job==nil) { cache_key,cacheable = result_cache_key( req ) }
+  if cacheable {
+    if body,ok := gResultCache.Get( cache_key ) ; ok {
+      req.lg.Debug("result cache hit")
+      w.Header().Set("Content-Type", "application/json")
+      w.Write( body )
+      return
+    }
+    w_cache = &result_cache_writer{ ResponseWriter:w }
+    w = w_cache
+  }
+
+  var w_explain *explain_writer
+  if req.Explain {
+    if req.explain==nil {
+      now := time.Now()
+      req.explain = new_explain( req.Type, now, now )
+    }
+    req.explain.begin()
+    w_explain = &explain_writer{ w:w, ex:req.explain }
+    w = w_explain
+  }
+
   switch req.Type {
 
   //*
   case "sample-tile-group-match":
-    sample_tile_group_match_handler( w, &resp, &req )
+    sample_tile_group_match_handler( w, &resp, req )
 
   //*
   case "tile-sequence":
-    tile_sequence_handler( w, &resp, &req )
+    tile_sequence_handler( w, &resp, req )
 
   //*
   case "tile-sequence-tracer":
-    tile_sequence_handler_tracer( w, &resp, &req )
+    tile_sequence_handler_tracer( w, &resp, req )
 
   //*
   case "system-info":
-    system_info_handler( w, &resp, &req )
+    system_info_handler( w, &resp, req )
 
   //*
   case "sample-position-variant":
-    sample_position_variant_handler( w, &resp, &req )
+    sample_position_variant_handler( w, &resp, req )
 
   case "sample-intersect":
-    sample_intersect_handler( w, &resp, &req )
+    sample_intersect_handler( w, &resp, req )
 
   //*
   case "sample-tile-neighborhood":
-    sample_tile_neighborhood_handler( w, &resp, &req )
+    sample_tile_neighborhood_handler( w, &resp, req )
+
+  case "variant-frequency":
+    variant_frequency_handler( w, &resp, req )
+
+  case "sample-similarity":
+    sample_similarity_handler( w, &resp, req )
+
+  case "population-pca":
+    population_pca_handler( w, &resp, req )
+
+  case "sample-sequence":
+    sample_sequence_handler( w, &resp, req )
+
+  case "burden-test":
+    burden_test_handler( w, &resp, req )
+
+  case "gene-tiles":
+    gene_tiles_handler( w, &resp, req )
+
+  case "batch":
+    batch_handler( w, &resp, req )
+
+  case "job-status":
+    job_status_handler( w, &resp, req )
+  case "job-cancel":
+    job_cancel_handler( w, &resp, req )
+  case "job-result":
+    job_result_handler( w, &resp, req )
 
   //case "case-control":
-  //  case_control_handler( w, &resp, &req )
+  //  case_control_handler( w, &resp, req )
 
     /*
   case "tile-variant":
-    tile_variant_handler( w, &resp, &req )
+    tile_variant_handler( w, &resp, req )
   case "exact-tile-match":
-    exact_tile_match( w, &resp, &req )
+    exact_tile_match( w, &resp, req )
   case "exact-tile-class-match":
-    exact_tile_class_match( w, &resp, &req )
+    exact_tile_class_match( w, &resp, req )
   case "sample-match":
-    sample_match( w, &resp, &req )
+    sample_match( w, &resp, req )
   case "tile-class":
-    tile_class( w, &resp, &req )
+    tile_class( w, &resp, req )
     */
 
   default:
-    io.WriteString(w, "{\n")
-    io.WriteString(w, "  \"Type\":\"error\", \"Message\":\"bad command\"\n")
-    io.WriteString(w, "}")
+    req.lg.Info("bad command")
+    _errc( w, ErrInvalidRequestType( req.Type ) )
   }
 
 
-  fmt.Printf(" content-type: %s\n", r.Header.Get("Content-Type") )
+  if w_explain!=nil { w_explain.finish() }
 
-  fmt.Println("\n--------\n")
-  //fmt.Println( req )
-  fmt.Println("\n--------\n\n")
+  if (w_cache!=nil) && result_cacheable( w_cache.buf.Bytes() ) {
+    gResultCache.Put( cache_key, w_cache.buf.Bytes() )
+  }
 
   /*
   w.Header().Set("Content-Type", "application/json")
@@ -902,54 +1145,48 @@ func handle_json_req( w http.ResponseWriter, r *http.Request ) {
 }
 
 
-func _main( c *cli.Context ) {
+// Add a loaded sample to gCGF once it's been checked.  It may be
+// evicted straight away if it doesn't fit the memory budget.
+//
+func add_sample( name string, cg *cgf.CGF, src sample_source ) error {
+  if e := validate_sample( name, cg ) ; e!=nil { return e }
+
+  gCGF = append( gCGF, cg )
+  gCGFName = append( gCGFName, name )
+  gCGFIndexMap[ name ] = len(gCGF)-1
+  gMemory.loaded( len(gCGF)-1, src )
+  return nil
+}
+
+// Load the samples, tile sequences and indexes given in cfg.
+//
+func lantern_load( cfg LanternConfig ) error {
+
+  set_health( HealthLoading, "" )
 
   g_incr = make( chan int )
   go func() { g_incr <- 0 }()
 
+  gCGF = nil
+  gCGFName = nil
   gCGFIndexMap = make( map[string]int )
 
-  g_verboseFlag   = c.Bool("Verbose")
-  gProfileFlag    = c.Bool("pprof")
-  gMemProfileFlag = c.Bool("mprof")
-
-  if gProfileFlag {
-    prof_f,err := os.Create( gProfileFile )
-    if err != nil {
-      fmt.Fprintf( os.Stderr, "Could not open profile file %s: %v\n", gProfileFile, err )
-      os.Exit(2)
-    }
-
-    pprof.StartCPUProfile( prof_f )
-    defer pprof.StopCPUProfile()
+  if e := MemoryInit( int64(cfg.MemoryBudgetMB)*1024*1024, cfg.SampleCacheDir ) ; e!=nil {
+    return fmt.Errorf("could not set up sample cache: %v", e)
   }
 
-  z := c.StringSlice("input-cgf")
-
-  if len(z)==0 {
-    fmt.Fprintf( os.Stderr, "Provide input-cgf file(s)\n" )
-    cli.ShowAppHelp(c)
-    os.Exit(1)
-  }
+  z := cfg.cgf_file
 
   e := TileSimpleInit()
-  if e!=nil {
-    fmt.Fprintf( os.Stderr, "TileSimpleInit failed %v\n", e )
-    os.Exit(1)
-  }
+  if e!=nil { return fmt.Errorf("TileSimpleInit failed %v", e) }
 
 
   cg,e := cgf.Load( z[0] )
-  if e != nil {
-    fmt.Fprintf( os.Stderr, "ERROR: could not load %s: %v\n", z[0], e )
-    os.Exit(1)
-  }
+  if e != nil { return fmt.Errorf("could not load %s: %v", z[0], e) }
 
   sampleName := fmt.Sprintf("%d:%s", 0, z[0])
 
-  gCGF = append( gCGF, cg )
-  gCGFName = append( gCGFName, sampleName )
-  gCGFIndexMap[ sampleName ] = len(gCGF)-1
+  if e := add_sample( sampleName, cg, sample_source{ fn:z[0] } ) ; e!=nil { return fmt.Errorf("invalid sample: %v", e) }
 
   gTileClassVersion = cg.EncodedTileMapMd5Sum
   gTileLibraryVersion = cg.TileLibraryVersion
@@ -958,104 +1195,191 @@ func _main( c *cli.Context ) {
   construct_tile_variant_to_tile_class_map( gCGF[0].TileMap )
 
   for i:=1; i<len(z); i++ {
-    fmt.Println(z[i])
+    lightlog.Info( "loading %s", z[i] )
 
     //cg,e := cgf.Load( z[i] )
     cg,e := cgf.LoadNoMap( z[i] )
-    if e != nil {
-      fmt.Fprintf( os.Stderr, "ERROR: could not load %s: %v\n", z[i], e )
-      os.Exit(1)
-    }
+    if e != nil { return fmt.Errorf("could not load %s: %v", z[i], e) }
 
     if cg.EncodedTileMapMd5Sum != gTileClassVersion {
-      fmt.Fprintf( os.Stderr, "ERROR: Could not load %s: Tile class mismatch (%s != %s)\n", z[i], cg.EncodedTileMapMd5Sum, gTileClassVersion )
-      os.Exit(1)
+      return fmt.Errorf("Could not load %s: Tile class mismatch (%s != %s)", z[i], cg.EncodedTileMapMd5Sum, gTileClassVersion )
     }
 
     if cg.TileLibraryVersion != gTileLibraryVersion {
-      fmt.Fprintf( os.Stderr, "ERROR: Could not load %s: Tile library mismatch (%s != %s)\n", z[i], cg.TileLibraryVersion , gTileLibraryVersion )
-      os.Exit(1)
+      return fmt.Errorf("Could not load %s: Tile library mismatch (%s != %s)", z[i], cg.TileLibraryVersion , gTileLibraryVersion )
     }
 
     sampleName := fmt.Sprintf("%d:%s", i, z[i])
 
     cg.TileMap = gCGF[0].TileMap
 
-    gCGF = append( gCGF, cg )
-    gCGFName = append( gCGFName, sampleName )
-    gCGFIndexMap[ sampleName ] = len(gCGF)-1
+    if e := add_sample( sampleName, cg, sample_source{ fn:z[i] } ) ; e!=nil { return fmt.Errorf("invalid sample: %v", e) }
 
   }
 
 
-  z = c.StringSlice("input-cgf-gob")
+  z = cfg.CGFGob
   for i:=0; i<len(z); i++ {
-    fmt.Println(z[i])
+    lightlog.Info( "loading %s", z[i] )
 
     cg := cgf.CGF{}
     fp,e := os.Open( z[i] )
-    if e!=nil { panic(e) }
+    if e!=nil { return fmt.Errorf("could not load %s: %v", z[i], e) }
 
     dec := gob.NewDecoder( fp )
     e = dec.Decode(&cg)
-    if e!=nil { panic(e) }
-
     fp.Close()
+    if e!=nil { return fmt.Errorf("could not load %s: %v", z[i], e) }
 
     if cg.EncodedTileMapMd5Sum != gTileClassVersion {
-      fmt.Fprintf( os.Stderr, "ERROR: Could not load %s: Tile class mismatch (%s != %s)\n", z[i], cg.EncodedTileMapMd5Sum, gTileClassVersion )
-      os.Exit(1)
+      return fmt.Errorf("Could not load %s: Tile class mismatch (%s != %s)", z[i], cg.EncodedTileMapMd5Sum, gTileClassVersion )
     }
 
     if cg.TileLibraryVersion != gTileLibraryVersion {
-      fmt.Fprintf( os.Stderr, "ERROR: Could not load %s: Tile library mismatch (%s != %s)\n", z[i], cg.TileLibraryVersion , gTileLibraryVersion )
-      os.Exit(1)
+      return fmt.Errorf("Could not load %s: Tile library mismatch (%s != %s)", z[i], cg.TileLibraryVersion , gTileLibraryVersion )
     }
 
     sampleName := fmt.Sprintf("%d:%s", i, z[i])
 
     cg.TileMap = gCGF[0].TileMap
 
-    gCGF = append( gCGF, &cg )
-    gCGFName = append( gCGFName, sampleName )
-    gCGFIndexMap[ sampleName ] = len(gCGF)-1
+    if e := add_sample( sampleName, &cg, sample_source{ fn:z[i], gob:true } ) ; e!=nil { return fmt.Errorf("invalid sample: %v", e) }
+
+  }
+
+
+  lightlog.Debug( "indexmap: %v", gCGFIndexMap )
+
+  sample_set_changed()
+
+  if e := PrivacyInit( cfg.Privacy ) ; e!=nil {
+    return fmt.Errorf("could not load privacy budgets: %v", e)
+  }
+
+  if e := JobInit( cfg.JobDir, time.Duration( cfg.JobTTLHours )*time.Hour, cfg.JobMax ) ; e!=nil {
+    return fmt.Errorf("could not set up job directory: %v", e)
+  }
+
+  gResultCache = nil
+  if cfg.ResultCacheMB > 0 {
+    gResultCache = NewResultCache( cfg.ResultCacheMB*1024*1024 )
+  }
+
+  if e := LocusIndexInit( cfg.TileLocus ) ; e!=nil {
+    return fmt.Errorf("could not load tile loci: %v", e)
+  }
 
+  if e := GeneAnnotationInit( cfg.GeneAnnotation ) ; e!=nil {
+    return fmt.Errorf("could not load gene annotations: %v", e)
   }
 
+  gVariantIndex = nil
+  if cfg.VariantIndex || (len(cfg.VariantIndexFile)>0) {
+    if e := VariantIndexInit( cfg.VariantIndexFile ) ; e!=nil {
+      return fmt.Errorf("could not build variant index: %v", e)
+    }
+  }
+
+  set_health( HealthReady, "" )
+  lightlog.Info( "ready, %d samples", len(gCGF) )
+  return nil
+}
+
+func lantern_mux() *http.ServeMux {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/", audit_handler( ready_handler( handle_json_req ) ))
+  mux.HandleFunc("/beacon", audit_handler( ready_handler( beacon_info_handler ) ))
+  mux.HandleFunc("/beacon/query", audit_handler( ready_handler( beacon_query_handler ) ))
+  mux.HandleFunc("/healthz", healthz_handler)
+  mux.HandleFunc("/readyz", readyz_handler)
+  return mux
+}
+
+func _main( c *cli.Context ) {
+
+  g_verboseFlag   = c.Bool("Verbose")
+  gProfileFlag    = c.Bool("pprof")
+  gMemProfileFlag = c.Bool("mprof")
+
+  cfg := config_init( c )
+
+  if gProfileFlag {
+    prof_f,err := os.Create( gProfileFile )
+    if err != nil {
+      lightlog.Error( "Could not open profile file %s: %v", gProfileFile, err )
+      os.Exit(2)
+    }
+
+    pprof.StartCPUProfile( prof_f )
+    defer pprof.StopCPUProfile()
+  }
 
-  fmt.Printf("indexmap:\n\n%v\n\n", gCGFIndexMap)
+  if e := AuditInit( cfg.AuditLog, cfg.AuditLogMaxMB, cfg.AuditIdentityHeader ) ; e!=nil {
+    lightlog.Fatal( "could not open audit log: %v", e )
+  }
+  defer AuditClose()
 
+  // Listen first, so /healthz and /readyz answer while the samples
+  // load (see lantern_health.go).
+  //
   listener,err := net.Listen("tcp", gPortStr )
   if err!=nil {
-    fmt.Fprintf( os.Stderr, "net.Listen%s: %v", gPortStr, err )
-    os.Exit(1)
+    lightlog.Fatal( "net.Listen%s: %v", gPortStr, err )
   }
 
   term := make(chan os.Signal, 1)
-  go func( sig <-chan os.Signal) {
-    s:= <-sig
-    fmt.Println("caught signal:", s)
-    listener.Close()
-  }(term)
-  signal.Notify(term, syscall.SIGTERM)
+  signal.Notify(term, syscall.SIGINT, syscall.SIGTERM)
 
-  if c.Bool( "Test" ) {
-    flint()
-    return
-  }
+  srv := &http.Server{ Addr: gPortStr, Handler: lantern_mux() }
 
+  serve_err := make( chan error, 1 )
+  go func() { serve_err <- srv.Serve(listener) }()
+  lightlog.Info( "listening: %v", gPortStr )
 
-  http.HandleFunc("/", handle_json_req)
+  loaded := make( chan error, 1 )
+  go func() { loaded <- lantern_load( cfg ) }()
 
-  srv := &http.Server{ Addr: gPortStr }
-
-  fmt.Printf(">>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>\n")
+  select {
+  case s := <-term:
+    lightlog.Info( "caught signal while loading: %v", s )
+    lantern_shutdown( srv, gShutdownTimeout )
+    return
+  case e := <-serve_err:
+    lightlog.Fatal( "serve: %v", e )
+  case e := <-loaded:
+    if e!=nil {
+      set_health( HealthFailed, fmt.Sprintf("%v", e) )
+      if c.Bool( "Test" ) { lightlog.Fatal( "%v", e ) }
+      lightlog.Error( "load failed, waiting for a signal to exit: %v", e )
+
+      // Keep answering the probes, with "failed" on /readyz.
+      //
+      select {
+      case s := <-term:
+        lightlog.Info( "caught signal: %v", s )
+        lantern_shutdown( srv, gShutdownTimeout )
+      case se := <-serve_err:
+        lightlog.Error( "serve: %v", se )
+      }
+      lightlog.Fatal( "%v", e )
+    }
+  }
 
-  fmt.Printf("listening: %v\n", gPortStr )
-  srv.Serve(listener)
+  if c.Bool( "Test" ) {
+    flint()
+    lantern_shutdown( srv, gShutdownTimeout )
+    return
+  }
 
-  fmt.Printf("lantern finished, shutting down\n")
+  select {
+  case s := <-term:
+    lightlog.Info( "caught signal: %v", s )
+    lantern_shutdown( srv, gShutdownTimeout )
+  case e := <-serve_err:
+    lightlog.Fatal( "serve: %v", e )
+  }
 
+  lightlog.Info( "lantern finished" )
 
 }
 
@@ -1070,10 +1394,27 @@ func main() {
 
   app.Flags = []cli.Flag{
 
+    cli.StringFlag{
+      Name: "config, c",
+      Usage: "JSON config file (flags given on the command line override it)",
+    },
+
+    cli.StringFlag{
+      Name: "listen, l",
+      Value: gPortStr,
+      Usage: "Address to listen on",
+    },
+
     cli.StringSliceFlag{
       Name: "input-cgf, i",
       Value: &cli.StringSlice{},
-      Usage: "CGF file(s)",
+      Usage: "CGF file(s) or glob pattern(s)",
+    },
+
+    cli.StringSliceFlag{
+      Name: "cgf-manifest",
+      Value: &cli.StringSlice{},
+      Usage: "File(s) listing CGF files, one per line",
     },
 
     cli.StringSliceFlag{
@@ -1082,6 +1423,169 @@ func main() {
       Usage: "CGF gob file(s)",
     },
 
+    cli.IntFlag{
+      Name: "shutdown-timeout",
+      Value: int( gShutdownTimeout/time.Second ),
+      Usage: "Seconds to wait for requests in flight on shutdown",
+    },
+
+    cli.StringFlag{
+      Name: "tile-cache-csv",
+      Value: gTileCacheCSV,
+      Usage: "Tile sequence cache CSV",
+    },
+
+    cli.StringFlag{
+      Name: "tile-db",
+      Value: gTileDB,
+      Usage: "Tile sequence sqlite database",
+    },
+
+    cli.StringSliceFlag{
+      Name: "tile-locus",
+      Value: &cli.StringSlice{},
+      Usage: "Tile library FastJ file(s) to read tile loci from (for beacon queries)",
+    },
+
+    cli.StringSliceFlag{
+      Name: "gene-annotation",
+      Value: &cli.StringSlice{},
+      Usage: "Gene annotation (GFF3, GTF) or region (BED) file(s), as [assembly:]file",
+    },
+
+    cli.BoolFlag{
+      Name: "variant-index",
+      Usage: "Build the tile variant to sample bitmap index at load time",
+    },
+
+    cli.StringFlag{
+      Name: "variant-index-file",
+      Usage: "Load the variant index from (or save it to) file (implies --variant-index)",
+    },
+
+    cli.IntFlag{
+      Name: "result-cache-mb",
+      Value: 64,
+      Usage: "Size of the query result cache in MB (0 to disable)",
+    },
+
+    cli.IntFlag{
+      Name: "memory-budget-mb",
+      Value: 0,
+      Usage: "Memory budget for resident samples in MB, least recently used samples are evicted past it (0 for no limit)",
+    },
+
+    cli.StringFlag{
+      Name: "sample-cache-dir",
+      Value: "",
+      Usage: "Directory to cache evicted samples in, as gob files, for reloading",
+    },
+
+    cli.BoolFlag{
+      Name: "protected",
+      Usage: "Only answer noisy aggregate queries, with a privacy budget per API key (see lantern_privacy.go)",
+    },
+
+    cli.IntFlag{
+      Name: "min-cohort",
+      Value: gPrivacy.MinCohort,
+      Usage: "Protected mode: minimum number of samples a query runs over",
+    },
+
+    cli.IntFlag{
+      Name: "min-cell",
+      Value: gPrivacy.MinCell,
+      Usage: "Protected mode: suppress counts below this",
+    },
+
+    cli.Float64Flag{
+      Name: "privacy-epsilon",
+      Value: gPrivacy.Epsilon,
+      Usage: "Protected mode: epsilon spent per query",
+    },
+
+    cli.Float64Flag{
+      Name: "privacy-budget",
+      Value: gPrivacy.Budget,
+      Usage: "Protected mode: total epsilon per API key",
+    },
+
+    cli.StringFlag{
+      Name: "api-key-header",
+      Value: gPrivacy.KeyHeader,
+      Usage: "Protected mode: HTTP header holding the API key",
+    },
+
+    cli.StringFlag{
+      Name: "privacy-budget-file",
+      Usage: "Protected mode: file to keep the budget spent per API key in",
+    },
+
+    cli.StringFlag{
+      Name: "audit-log",
+      Usage: "Append a JSON line per request to this file (see lantern_audit.go)",
+    },
+
+    cli.IntFlag{
+      Name: "audit-log-max-mb",
+      Value: 100,
+      Usage: "Rotate the audit log once it's over this size in MB (0 to never rotate)",
+    },
+
+    cli.StringFlag{
+      Name: "audit-identity-header",
+      Value: gAuditIdentityHeader,
+      Usage: "HTTP header holding the client identity for the audit log",
+    },
+
+    cli.StringFlag{
+      Name: "job-dir",
+      Value: gJobDir,
+      Usage: "Directory to store asynchronous job results in",
+    },
+
+    cli.IntFlag{
+      Name: "job-ttl",
+      Value: 24,
+      Usage: "Hours to keep asynchronous job results for",
+    },
+
+    cli.IntFlag{
+      Name: "job-max",
+      Value: gJobMax,
+      Usage: "Maximum number of asynchronous jobs to run at once",
+    },
+
+    cli.IntFlag{
+      Name: "max-batch-items",
+      Value: gBatchMaxItems,
+      Usage: "Maximum number of requests in a batch",
+    },
+
+    cli.IntFlag{
+      Name: "max-similarity-samples",
+      Value: gSimilarityMaxSamples,
+      Usage: "Maximum number of samples for sample-similarity",
+    },
+
+    cli.IntFlag{
+      Name: "max-pca-samples",
+      Value: gPCAMaxSamples,
+      Usage: "Maximum number of samples for population-pca",
+    },
+
+    cli.IntFlag{
+      Name: "max-sequence-steps",
+      Value: gSampleSequenceMaxStep,
+      Usage: "Maximum number of steps for sample-sequence",
+    },
+
+    cli.IntFlag{
+      Name: "max-burden-permutations",
+      Value: gBurdenMaxPermutation,
+      Usage: "Maximum number of permutations for burden-test",
+    },
+
     cli.BoolFlag{
       Name: "Test, T",
       Usage: "Run tests (for debugging purposes)",
@@ -1089,7 +1593,18 @@ func main() {
 
     cli.BoolFlag{
       Name: "Verbose, V",
-      Usage: "Verbose flag",
+      Usage: "Verbose flag (same as --log-level debug)",
+    },
+
+    cli.StringFlag{
+      Name: "log-level",
+      Value: "warn",
+      Usage: "Log level (debug, info, warn, error)",
+    },
+
+    cli.BoolFlag{
+      Name: "log-json",
+      Usage: "Write log messages as JSON lines",
     },
 
     cli.BoolFlag{
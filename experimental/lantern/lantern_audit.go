@@ -0,0 +1,325 @@
+package main
+
+import "os"
+import "fmt"
+import "sync"
+import "time"
+import "strings"
+import "context"
+import "net/http"
+import "encoding/json"
+
+import "../lightlog"
+
+/*
+
+Query audit log.
+
+With '--audit-log' ("AuditLog" in the config file), every request to
+lantern (queries, beacon queries, health checks excepted) is appended
+to the audit log as one line of JSON once it's been answered:
+
+  {
+    "Time":"2016-05-02T10:31:07.52Z", "RequestId":12,
+    "Client":"alice", "KeyHash":"9f86d0...", "RemoteAddr":"10.0.0.7:53312", "Path":"/",
+    "Type":"sample-position-variant", "Dataset":"all",
+    "SampleId":[ "0:hu011C57.cgf", "1:hu016B28.cgf" ], "SampleCount":2,
+    "TileRange":[ "247.00.0000", "247.00.0003-000f" ], "TileRangeCount":2,
+    "Status":200, "Bytes":5120, "DurationMs":3.2
+  }
+
+'Client' is the value of the '--audit-identity-header' HTTP header
+(X-Remote-User by default, set by an authenticating proxy in front of
+lantern), falling back on the common name of a TLS client certificate.
+'KeyHash' is the SHA-256 hash of the request's API key, if it sent one
+(see lantern_privacy.go), the same hash the privacy budget is kept
+under, so the requests spending a key's budget can be traced to it.
+'SampleId' lists every sample named in the request (including the
+case and control samples and those of batch items) and 'AllSamples'
+is set for queries over all the loaded samples.  'TileRange' holds the
+tile ids, positions, paths, steps and genes of the request, up to
+gAuditMaxTileRange of them, 'TileRangeCount' is the full count.  For
+batches 'ItemType' lists the item types.  Async submissions carry the
+'JobId' handed out, and job-result requests the one asked for.
+'Bytes' is the size of the response body.
+
+The log is only ever appended to.  Once it grows past
+'--audit-log-max-mb' it's renamed to "<audit log>.<UTC time>" and a
+new one is started, old logs are left for archiving.  See
+lantern-audit for summarising and filtering the logs.
+
+*/
+
+var gAuditMaxTileRange int = 256
+var gAuditIdentityHeader string = "X-Remote-User"
+
+var gAudit *audit_log
+
+type LanternAuditRecord struct {
+  Time time.Time
+  RequestId int
+
+  Client string `json:",omitempty"`
+  KeyHash string `json:",omitempty"`
+  RemoteAddr string
+  Path string
+  Query string `json:",omitempty"`
+
+  Type string `json:",omitempty"`
+  Dataset string `json:",omitempty"`
+  ItemType []string `json:",omitempty"`
+  Async bool `json:",omitempty"`
+  JobId string `json:",omitempty"`
+  Format string `json:",omitempty"`
+
+  AllSamples bool `json:",omitempty"`
+  SampleId []string `json:",omitempty"`
+  SampleCount int
+
+  TileRange []string `json:",omitempty"`
+  TileRangeCount int `json:",omitempty"`
+
+  Status int
+  Bytes int64
+  DurationMs float64
+
+  seen_sample map[string]bool
+}
+
+// Request types that run over all the loaded samples when 'SampleId'
+// is empty.
+//
+var gAuditAllSampleType map[string]bool = map[string]bool{
+  "sample-tile-group-match" : true,
+  "sample-position-variant" : true,
+  "sample-intersect" : true,
+  "sample-tile-neighborhood" : true,
+  "variant-frequency" : true,
+  "sample-similarity" : true,
+  "population-pca" : true,
+}
+
+func (rec *LanternAuditRecord) add_sample( ids []string ) {
+  if rec.seen_sample==nil { rec.seen_sample = make( map[string]bool ) }
+  for i:=0; i<len(ids); i++ {
+    if rec.seen_sample[ ids[i] ] { continue }
+    rec.seen_sample[ ids[i] ] = true
+    rec.SampleId = append( rec.SampleId, ids[i] )
+  }
+  rec.SampleCount = len(rec.SampleId)
+}
+
+func (rec *LanternAuditRecord) add_range( prefix string, r ...string ) {
+  for i:=0; i<len(r); i++ {
+    if len(r[i])==0 { continue }
+    rec.TileRangeCount++
+    if len(rec.TileRange) < gAuditMaxTileRange { rec.TileRange = append( rec.TileRange, prefix + r[i] ) }
+  }
+}
+
+// Fill in what the request asks for.  Does nothing if auditing is
+// off (rec is nil).
+//
+func (rec *LanternAuditRecord) note_request( req *LanternRequest ) {
+  if rec==nil { return }
+
+  rec.note_key( req.api_key )
+  rec.Type = req.Type
+  rec.Dataset = req.Dataset
+  rec.Async = req.Async
+  rec.JobId = req.JobId
+  rec.Format = req.Format
+
+  rec.note_item( req )
+  for i:=0; i<len(req.Batch); i++ {
+    rec.ItemType = append( rec.ItemType, req.Batch[i].Type )
+    rec.note_item( &(req.Batch[i]) )
+  }
+}
+
+// Record the hash of the API key, if any.  Does nothing if auditing
+// is off (rec is nil).
+//
+func (rec *LanternAuditRecord) note_key( key string ) {
+  if (rec==nil) || (len(key)==0) { return }
+  rec.KeyHash = privacy_key_hash( key )
+}
+
+func (rec *LanternAuditRecord) note_item( req *LanternRequest ) {
+  if (len(req.SampleId)==0) && gAuditAllSampleType[ req.Type ] { rec.AllSamples = true }
+  rec.add_sample( req.SampleId )
+  rec.add_sample( req.CaseSampleId )
+  rec.add_sample( req.ControlSampleId )
+
+  rec.add_range( "", req.TileVariantId... )
+  rec.add_range( "", req.TileId... )
+  rec.add_range( "", req.Position... )
+  rec.add_range( "", req.PathStep... )
+  for i:=0; i<len(req.TileGroupVariantId); i++ { rec.add_range( "", req.TileGroupVariantId[i]... ) }
+  rec.add_range( "gene:", req.Gene... )
+  rec.add_range( "path:", req.Path )
+  rec.add_range( "step:", req.Step )
+}
+
+func (rec *LanternAuditRecord) set_job( id string ) {
+  if rec==nil { return }
+  rec.JobId = id
+}
+
+type audit_key int
+var gAuditKey audit_key = 0
+
+// The audit record of the HTTP request, nil if auditing is off.
+//
+func request_audit( r *http.Request ) *LanternAuditRecord {
+  rec,_ := r.Context().Value( gAuditKey ).(*LanternAuditRecord)
+  return rec
+}
+
+func audit_client( r *http.Request ) string {
+  if id := r.Header.Get( gAuditIdentityHeader ) ; len(id)>0 { return id }
+  if (r.TLS!=nil) && (len(r.TLS.PeerCertificates)>0) { return r.TLS.PeerCertificates[0].Subject.CommonName }
+  return ""
+}
+
+// Counts the response status and size.  Passes Flush on so streamed
+// tables still stream.
+//
+type audit_writer struct {
+  http.ResponseWriter
+  status int
+  n int64
+}
+
+func (aw *audit_writer) WriteHeader( status int ) {
+  if aw.status==0 { aw.status = status }
+  aw.ResponseWriter.WriteHeader( status )
+}
+
+func (aw *audit_writer) Write( b []byte ) (int, error) {
+  if aw.status==0 { aw.status = http.StatusOK }
+  n,e := aw.ResponseWriter.Write( b )
+  aw.n += int64(n)
+  return n, e
+}
+
+func (aw *audit_writer) Flush() {
+  if f,ok := aw.ResponseWriter.(http.Flusher) ; ok { f.Flush() }
+}
+
+func audit_handler( h http.HandlerFunc ) http.HandlerFunc {
+  return func( w http.ResponseWriter, r *http.Request ) {
+    if gAudit==nil { h( w, r ) ; return }
+
+    start := time.Now()
+    rec := &LanternAuditRecord{
+      Time : start.UTC(),
+      Client : audit_client( r ),
+      RemoteAddr : r.RemoteAddr,
+      Path : r.URL.Path,
+      Query : r.URL.RawQuery,
+    }
+    aw := &audit_writer{ ResponseWriter:w }
+
+    h( aw, r.WithContext( context.WithValue( r.Context(), gAuditKey, rec ) ) )
+
+    rec.Status = aw.status
+    if rec.Status==0 { rec.Status = http.StatusOK }
+    rec.Bytes = aw.n
+    rec.DurationMs = float64( time.Since( start ) ) / float64( time.Millisecond )
+
+    if e := gAudit.Write( rec ) ; e!=nil { lightlog.Error( "audit log: %v", e ) }
+  }
+}
+
+type audit_log struct {
+  mu sync.Mutex
+  fn string
+  fp *os.File
+  size int64
+  max_bytes int64
+}
+
+func OpenAuditLog( fn string, max_bytes int64 ) ( *audit_log, error ) {
+  al := &audit_log{ fn:fn, max_bytes:max_bytes }
+  if e := al.open() ; e!=nil { return nil, e }
+  return al, nil
+}
+
+func (al *audit_log) open() error {
+  fp,e := os.OpenFile( al.fn, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640 )
+  if e!=nil { return e }
+  st,e := fp.Stat()
+  if e!=nil { fp.Close() ; return e }
+  al.fp = fp
+  al.size = st.Size()
+  return nil
+}
+
+// Move the current log aside and start a new one.  Must be called
+// with the lock held.  If the move fails the current log is reopened
+// and keeps growing, so no records are lost; if that fails too, the
+// log is left closed and Write reports it.
+//
+func (al *audit_log) rotate() error {
+  e := al.fp.Close()
+  al.fp = nil
+  if e!=nil { return e }
+
+  old_fn := fmt.Sprintf( "%s.%s", al.fn, time.Now().UTC().Format( "20060102T150405.000000000Z" ) )
+  if e := os.Rename( al.fn, old_fn ) ; e!=nil {
+    lightlog.Warn( "could not rotate audit log: %v", e )
+  } else {
+    lightlog.Info( "audit log rotated to %s", old_fn )
+  }
+
+  return al.open()
+}
+
+func (al *audit_log) Write( rec *LanternAuditRecord ) error {
+  b,e := json.Marshal( rec )
+  if e!=nil { return e }
+  b = append( b, '\n' )
+
+  al.mu.Lock()
+  defer al.mu.Unlock()
+
+  if al.fp==nil { return fmt.Errorf("%s is closed", al.fn) }
+
+  if (al.max_bytes>0) && (al.size>0) && (al.size + int64(len(b)) > al.max_bytes) {
+    if e := al.rotate() ; e!=nil { return e }
+  }
+
+  n,e := al.fp.Write( b )
+  al.size += int64(n)
+  return e
+}
+
+func (al *audit_log) Close() error {
+  al.mu.Lock()
+  defer al.mu.Unlock()
+
+  if al.fp==nil { return nil }
+  e := al.fp.Close()
+  al.fp = nil
+  return e
+}
+
+// Start the audit log, none if fn is empty.
+//
+func AuditInit( fn string, max_mb int, identity_header string ) error {
+  AuditClose()
+  if len(identity_header)>0 { gAuditIdentityHeader = identity_header }
+  if len(strings.TrimSpace(fn))==0 { return nil }
+
+  al,e := OpenAuditLog( fn, int64(max_mb)*1024*1024 )
+  if e!=nil { return e }
+  gAudit = al
+  return nil
+}
+
+func AuditClose() {
+  if gAudit==nil { return }
+  if e := gAudit.Close() ; e!=nil { lightlog.Error( "audit log: %v", e ) }
+  gAudit = nil
+}
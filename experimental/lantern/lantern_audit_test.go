@@ -0,0 +1,106 @@
+package main
+
+import "os"
+import "bufio"
+import "strings"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+import "net/http"
+import "net/http/httptest"
+import "encoding/json"
+
+func read_audit( t *testing.T, fn string ) []LanternAuditRecord {
+  fp,e := os.Open( fn )
+  if e!=nil { t.Fatal(e) }
+  defer fp.Close()
+
+  recs := []LanternAuditRecord{}
+  sc := bufio.NewScanner( fp )
+  for sc.Scan() {
+    rec := LanternAuditRecord{}
+    if e := json.Unmarshal( sc.Bytes(), &rec ) ; e!=nil { t.Fatal(e) }
+    recs = append( recs, rec )
+  }
+  return recs
+}
+
+func TestAuditRecord( t *testing.T ) {
+  req := LanternRequest{
+    Type : "batch",
+    Batch : []LanternRequest{
+      LanternRequest{ Type:"sample-position-variant", SampleId:[]string{ "0:a", "1:b" }, Position:[]string{ "247.00.0000" } },
+      LanternRequest{ Type:"burden-test", CaseSampleId:[]string{ "1:b" }, ControlSampleId:[]string{ "2:c" }, Gene:[]string{ "BRCA1" } },
+      LanternRequest{ Type:"variant-frequency", TileVariantId:[]string{ "247.00.0001.0000", "247.00.0002.0000" } },
+    },
+  }
+  rec := &LanternAuditRecord{}
+  rec.note_request( &req )
+
+  if strings.Join( rec.ItemType, "," )!="sample-position-variant,burden-test,variant-frequency" { t.Errorf("item types %v", rec.ItemType) }
+  if (rec.SampleCount!=3) || (strings.Join( rec.SampleId, "," )!="0:a,1:b,2:c") { t.Errorf("samples %v (%d)", rec.SampleId, rec.SampleCount) }
+  if !rec.AllSamples { t.Errorf("variant-frequency over all samples not noted") }
+  if rec.TileRangeCount!=4 { t.Errorf("tile ranges %v", rec.TileRange) }
+
+  var none *LanternAuditRecord
+  none.note_request( &req )
+  none.set_job( "x" )
+}
+
+func TestAuditLog( t *testing.T ) {
+  dir,e := ioutil.TempDir( "", "lantern-audit" )
+  if e!=nil { t.Fatal(e) }
+  defer os.RemoveAll( dir )
+
+  prev := health()
+  defer set_health( prev.Status, prev.Message )
+  set_health( HealthReady, "" )
+
+  fn := filepath.Join( dir, "audit.log" )
+  if e := AuditInit( fn, 0, "X-Test-User" ) ; e!=nil { t.Fatal(e) }
+  defer AuditClose()
+  defer func() { gAuditIdentityHeader = "X-Remote-User" }()
+
+  if g_incr==nil {
+    g_incr = make( chan int )
+    go func() { g_incr <- 0 }()
+  }
+
+  srv := httptest.NewServer( lantern_mux() )
+
+  hreq,_ := http.NewRequest( "POST", srv.URL, strings.NewReader( `{"Type":"job-status","JobId":"nope"}` ) )
+  hreq.Header.Set( "X-Test-User", "alice" )
+  hreq.Header.Set( gPrivacy.KeyHeader, "key" )
+  resp,e := http.DefaultClient.Do( hreq )
+  if e!=nil { t.Fatal(e) }
+  resp.Body.Close()
+
+  // Close waits for the handler, so the record has been written.
+  //
+  srv.Close()
+
+  recs := read_audit( t, fn )
+  if len(recs)!=1 { t.Fatalf("%d records", len(recs)) }
+  r := recs[0]
+  if (r.Client!="alice") || (r.Type!="job-status") || (r.JobId!="nope") || (r.Status!=http.StatusNotFound) || (r.Bytes==0) ||
+     (r.KeyHash!=privacy_key_hash( "key" )) {
+    t.Errorf("record %+v", r)
+  }
+
+  // A tiny limit rotates before every record after the first.
+  //
+  gAudit.max_bytes = 1
+  for i:=0; i<2; i++ {
+    if e := gAudit.Write( &LanternAuditRecord{ Type:"system-info" } ) ; e!=nil { t.Fatal(e) }
+  }
+  m,_ := filepath.Glob( fn + ".*" )
+  if len(m)!=2 { t.Errorf("%d rotated logs, expected 2", len(m)) }
+  if n := len( read_audit( t, fn ) ) ; n!=1 { t.Errorf("%d records in current log, expected 1", n) }
+
+  // With the current log gone the move fails, and the log is reopened.
+  //
+  if e := os.Remove( fn ) ; e!=nil { t.Fatal(e) }
+  if e := gAudit.Write( &LanternAuditRecord{ Type:"system-info" } ) ; e!=nil { t.Fatal(e) }
+  if n := len( read_audit( t, fn ) ) ; n!=1 { t.Errorf("%d records in reopened log, expected 1", n) }
+  if m,_ := filepath.Glob( fn + ".*" ) ; len(m)!=2 { t.Errorf("%d rotated logs, expected 2", len(m)) }
+}
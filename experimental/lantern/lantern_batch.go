@@ -0,0 +1,165 @@
+package main
+
+import "io"
+import "fmt"
+import "bytes"
+import "strings"
+import "net/http"
+import "encoding/json"
+
+import "../lightlog"
+
+/*
+
+Batch requests (see docs/api/batch_processing_v0.1.1.txt).
+
+A 'batch' request carries a list of ordinary requests in 'Batch'.
+They are run in order and their responses are returned, in the
+same order, in 'Result'.  A failed item doesn't stop the batch,
+its error response is returned in its place.  Items can't be batches
+themselves or 'Async' (submit the whole batch as a job instead).
+
+Sample id resolution and tile sequence lookups are shared between
+the items of a batch.
+
+Example request:
+
+{
+  "Type":"batch",
+  "Batch":[
+    { "Type":"sample-position-variant", "Assembly":"hg19", "Position":[ "chr17:41196312" ] },
+    { "Type":"tile-sequence", "TileId":[ "247.00.0003.0000" ] }
+  ]
+}
+
+Example response:
+
+{
+  "Type":"success", "Message":"batch",
+  "Result":[
+    { "Type":"success", "Message":"sample_position_variant", "Result":{ ... } },
+    { "Type":"success", "Message":"tile-sequence", "Result":{ ... } }
+  ]
+}
+
+*/
+
+var gBatchMaxItems int = 1000
+
+type batch_context struct {
+  sample_index map[string][]int
+  tile_seq map[string]string
+}
+
+// The samples' indexes into gCGF, made resident until the request is
+// done.
+//
+func (req *LanternRequest) sampleIndexArray( sampleId []string ) ([]int, error) {
+  idx,e := req.batch_sample_index( sampleId )
+  if e!=nil { return nil, e }
+  if e := req.resident( idx ) ; e!=nil { return nil, e }
+  req.explain.samples( len(idx) )
+  return idx, nil
+}
+
+// As sampleIndexArray, for handlers that only need the variant index
+// when it's built.  The samples are only made resident without it.
+//
+func (req *LanternRequest) sampleIndexArrayIndexed( sampleId []string ) ([]int, error) {
+  if gVariantIndex==nil { return req.sampleIndexArray( sampleId ) }
+  idx,e := req.batch_sample_index( sampleId )
+  if e==nil { req.explain.samples( len(idx) ) }
+  return idx, e
+}
+
+func (req *LanternRequest) batch_sample_index( sampleId []string ) ([]int, error) {
+  if req.batch==nil { return getSampleIndexArray( sampleId ) }
+
+  key := strings.Join( sampleId, "\x00" )
+  if idx,ok := req.batch.sample_index[key] ; ok { return idx, nil }
+
+  idx,e := getSampleIndexArray( sampleId )
+  if e!=nil { return nil, e }
+  req.batch.sample_index[key] = idx
+  return idx, nil
+}
+
+func (req *LanternRequest) tileSeq( tileid string ) (string, error) {
+  if req.batch==nil { return GetTileSeq( tileid ) }
+
+  if seq,ok := req.batch.tile_seq[tileid] ; ok { return seq, nil }
+
+  seq,e := GetTileSeq( tileid )
+  if e!=nil { return "", e }
+  req.batch.tile_seq[tileid] = seq
+  return seq, nil
+}
+
+// Collects a batch item's response.
+//
+type batch_writer struct {
+  header http.Header
+  buf bytes.Buffer
+}
+
+func (bw *batch_writer) Header() http.Header { return bw.header }
+func (bw *batch_writer) Write( b []byte ) (int, error) { return bw.buf.Write( b ) }
+func (bw *batch_writer) WriteHeader( status int ) { }
+
+func batch_item_error( le *LanternError ) json.RawMessage {
+  return json.RawMessage( lantern_error_body( le ) )
+}
+
+func batch_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
+
+  if len(req.Batch) > gBatchMaxItems { _errc(w, ErrLimitExceeded( "batch items", gBatchMaxItems )) ; return }
+
+  bctx := &batch_context{ sample_index:make( map[string][]int ), tile_seq:make( map[string]string ) }
+
+  result := make( []json.RawMessage, len(req.Batch) )
+
+  for i:=0; i<len(req.Batch); i++ {
+    item := &(req.Batch[i])
+    item.lg = req.lg.With( lightlog.Fields{ "item":i, "type":item.Type } )
+    item.batch = bctx
+    item.api_key = req.api_key
+
+    if item.Type == "batch" {
+      result[i] = batch_item_error( ErrInvalidRequestType( "batch" ) )
+      continue
+    }
+    if item.Async {
+      result[i] = batch_item_error( ErrInvalidParameter( "Async", item.Async, "not available in a batch" ) )
+      continue
+    }
+
+    bw := &batch_writer{ header:make( http.Header ) }
+    serve_request( bw, item )
+
+    body := bytes.TrimSpace( bw.buf.Bytes() )
+    if len(body)==0 {
+      result[i] = batch_item_error( ErrInternal( fmt.Errorf("%s: no response", item.Type) ) )
+      continue
+    }
+    if !json.Valid( body ) {
+      result[i] = batch_item_error( ErrInternal( fmt.Errorf("%s: invalid response", item.Type) ) )
+      continue
+    }
+
+    result[i] = json.RawMessage( body )
+  }
+
+  resp.Type = "success"
+  resp.Message = "batch"
+
+  w.Header().Set("Content-Type", "application/json")
+  res_json_bytes,_ := json.Marshal( result )
+
+  io.WriteString(w, "{\n")
+  io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"batch\",\n")
+  io.WriteString(w, "  \"Result\": ")
+  io.WriteString(w, string(res_json_bytes))
+  io.WriteString(w, "\n")
+  io.WriteString(w, "}")
+
+}
@@ -0,0 +1,278 @@
+package main
+
+import "fmt"
+import "io"
+import "strings"
+import "strconv"
+import "net/http"
+import "encoding/json"
+
+import "../lightlog"
+
+/*
+
+GA4GH Beacon (v0.3 style) endpoint.
+
+  GET /beacon        : beacon information
+  GET /beacon/query  : allele query
+
+Query parameters:
+
+  referenceName   chromosome ("17" or "chr17")
+  start           0 reference position of the allele
+  referenceBases  reference bases at start (optional, checked if given)
+  alternateBases  bases to look for
+  assemblyId      assembly of the tile library loci (e.g. "hg19" or "GRCh37")
+
+The coordinate is mapped to a tile position through the locus
+index (see lantern_locus.go).  For every loaded sample, the tile
+variant of each allele covering that position is fetched and the
+bases at the corresponding offset are compared to alternateBases.
+
+Only substitutions are supported (len(referenceBases) must equal
+len(alternateBases) when referenceBases is given).  Alleles that
+are no-calls at the position, or whose tile variant differs in
+length from the reference tile, are not counted.
+
+In protected mode (see lantern_privacy.go) queries need an API key
+and the counts are noisy.
+
+Example response:
+
+{
+  "beaconId":"lantern",
+  "apiVersion":"0.3",
+  "exists":true,
+  "alleleRequest":{ "referenceName":"17", "start":41196311, "referenceBases":"A", "alternateBases":"G", "assemblyId":"hg19" },
+  "datasetAlleleResponses":[ { "datasetId":"all", "exists":true, "sampleCount":2, "callCount":8, "variantCount":3, "frequency":0.375 } ]
+}
+
+*/
+
+var gBeaconId string = "lantern"
+var gBeaconApiVersion string = "0.3"
+
+type BeaconAlleleRequest struct {
+  ReferenceName string `json:"referenceName"`
+  Start int `json:"start"`
+  ReferenceBases string `json:"referenceBases,omitempty"`
+  AlternateBases string `json:"alternateBases"`
+  AssemblyId string `json:"assemblyId"`
+}
+
+type BeaconDatasetAlleleResponse struct {
+  DatasetId string `json:"datasetId"`
+  Exists bool `json:"exists"`
+  SampleCount int `json:"sampleCount"`
+  CallCount int `json:"callCount"`
+  VariantCount int `json:"variantCount"`
+  Frequency float64 `json:"frequency"`
+}
+
+type BeaconError struct {
+  ErrorCode int `json:"errorCode"`
+  Message string `json:"message"`
+}
+
+type BeaconAlleleResponse struct {
+  BeaconId string `json:"beaconId"`
+  ApiVersion string `json:"apiVersion"`
+  Exists bool `json:"exists"`
+  AlleleRequest BeaconAlleleRequest `json:"alleleRequest"`
+  DatasetAlleleResponses []BeaconDatasetAlleleResponse `json:"datasetAlleleResponses"`
+  Error *BeaconError `json:"error,omitempty"`
+}
+
+type AlleleTile struct {
+  Path int
+  Step int
+  Variant int
+  Length int
+}
+
+// Return, for each allele of the sample, the tile variant covering
+// path and step.  Spanning tiles that start before step are returned
+// with their starting step.  A no-call gives an empty list.
+//
+func sample_allele_tiles( cgf_ind, path, step int ) ( []AlleleTile, error ) {
+  p,s,tmv,e := gCGF[cgf_ind].LookupABVStartTileMapVariant( path, step )
+  if e!=nil { return nil, e }
+  if (tmv<0) || (tmv>=len(gCGF[0].TileMap)) { return nil, nil }
+
+  tme := gCGF[0].TileMap[tmv]
+
+  res := []AlleleTile{}
+  for allele:=0; allele<len(tme.Variant); allele++ {
+    x := 0
+    for v_ind:=0; v_ind<len(tme.Variant[allele]); v_ind++ {
+      l := tme.VariantLength[allele][v_ind]
+      if ((s+x) <= step) && (step < (s+x+l)) {
+        res = append( res, AlleleTile{ Path:p, Step:s+x, Variant:tme.Variant[allele][v_ind], Length:l } )
+        break
+      }
+      x += l
+    }
+  }
+
+  return res, nil
+}
+
+func beacon_error( w http.ResponseWriter, status int, areq BeaconAlleleRequest, msg string ) {
+  resp := BeaconAlleleResponse{ BeaconId:gBeaconId, ApiVersion:gBeaconApiVersion, AlleleRequest:areq }
+  resp.DatasetAlleleResponses = []BeaconDatasetAlleleResponse{}
+  resp.Error = &BeaconError{ ErrorCode:status, Message:msg }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader( status )
+  b,_ := json.Marshal( resp )
+  io.WriteString( w, string(b) )
+}
+
+func beacon_info_handler( w http.ResponseWriter, r *http.Request ) {
+  assemblies := []string{}
+  for assembly := range gLocusIndex { assemblies = append( assemblies, assembly ) }
+
+  info := map[string]interface{}{
+    "id" : gBeaconId,
+    "name" : "Lantern beacon",
+    "apiVersion" : gBeaconApiVersion,
+    "datasets" : []map[string]interface{}{
+      { "id":"all", "sampleCount":len(gCGF), "assemblyId":assemblies },
+    },
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  b,_ := json.Marshal( info )
+  io.WriteString( w, string(b) )
+}
+
+func beacon_query_handler( w http.ResponseWriter, r *http.Request ) {
+  request_audit( r ).note_key( request_api_key( r ) )
+
+  q := r.URL.Query()
+
+  areq := BeaconAlleleRequest{}
+  areq.ReferenceName  = q.Get("referenceName")
+  areq.ReferenceBases = strings.ToUpper( q.Get("referenceBases") )
+  areq.AlternateBases = strings.ToUpper( q.Get("alternateBases") )
+  areq.AssemblyId     = q.Get("assemblyId")
+
+  lg := lightlog.With( lightlog.Fields{ "type":"beacon" } )
+  lg.Debug("beacon query %s", r.URL.RawQuery)
+
+  start,e := strconv.Atoi( q.Get("start") )
+  if (e!=nil) || (start<0) { beacon_error( w, http.StatusBadRequest, areq, "invalid start" ) ; return }
+  areq.Start = start
+
+  if len(areq.ReferenceName)==0 { beacon_error( w, http.StatusBadRequest, areq, "referenceName required" ) ; return }
+  if len(areq.AlternateBases)==0 { beacon_error( w, http.StatusBadRequest, areq, "alternateBases required" ) ; return }
+  if (len(areq.ReferenceBases)>0) && (len(areq.ReferenceBases)!=len(areq.AlternateBases)) {
+    beacon_error( w, http.StatusBadRequest, areq, "only substitutions are supported" )
+    return
+  }
+
+  if !locus_assembly_loaded( areq.AssemblyId ) {
+    beacon_error( w, http.StatusBadRequest, areq, (&UnknownAssemblyError{ Assembly:areq.AssemblyId }).Error() )
+    return
+  }
+
+  n := len(areq.AlternateBases)
+  tloci,e := locus_lookup( areq.AssemblyId, areq.ReferenceName, start, start+n )
+  if e!=nil { beacon_error( w, http.StatusBadRequest, areq, fmt.Sprintf("%v", e) ) ; return }
+
+  // The allele has to fall entirely within one tile.  If it falls
+  // in two (on the overlapping tag), either will do, take the first.
+  //
+  var tloc *TileLocus
+  for i:=0; i<len(tloci); i++ {
+    if (tloci[i].Start <= start) && ((start+n) <= tloci[i].End) { tloc = &tloci[i] ; break }
+  }
+  if tloc==nil {
+    beacon_error( w, http.StatusBadRequest, areq, "position not covered by a single tile" )
+    return
+  }
+
+  if len(areq.ReferenceBases)>0 {
+    ref_tileid := fmt.Sprintf("%03x.%02x.%04x.%04x", tloc.Path, tloc.Version, tloc.Step, 0)
+    ref_seq,e := GetTileSeq( ref_tileid )
+    if e!=nil { beacon_error( w, http.StatusInternalServerError, areq, fmt.Sprintf("%v", e) ) ; return }
+
+    off := start - tloc.Start
+    if (off+n > len(ref_seq)) || (strings.ToUpper( ref_seq[off:off+n] ) != areq.ReferenceBases) {
+      beacon_error( w, http.StatusBadRequest, areq, "referenceBases do not match the reference" )
+      return
+    }
+  }
+
+  if protected() {
+    if key := request_api_key( r ) ; len(key)==0 {
+      beacon_error( w, http.StatusUnauthorized, areq, fmt.Sprintf("no API key (%s header)", gPrivacy.KeyHeader) )
+      return
+    } else if le := privacy_cohort() ; le!=nil {
+      beacon_error( w, le.Status(), areq, le.Message )
+      return
+    } else if le := privacy_spend( key ) ; le!=nil {
+      beacon_error( w, le.Status(), areq, le.Message )
+      return
+    }
+  }
+
+  dres := BeaconDatasetAlleleResponse{ DatasetId:"all" }
+
+  for cgf_ind:=0; cgf_ind<len(gCGF); cgf_ind++ {
+    if e := gMemory.acquire( []int{ cgf_ind } ) ; e!=nil {
+      beacon_error( w, http.StatusInternalServerError, areq, fmt.Sprintf("%v", e) )
+      return
+    }
+    allele_tile,e := sample_allele_tiles( cgf_ind, tloc.Path, tloc.Step )
+    gMemory.release( []int{ cgf_ind } )
+    if e!=nil { continue }
+
+    carrier := false
+    for a:=0; a<len(allele_tile); a++ {
+      at := allele_tile[a]
+      if at.Variant<0 { continue }
+
+      // Reference span of the (possibly spanning) tile variant.
+      //
+      beg_loc,ok0 := locus_path_step( areq.AssemblyId, at.Path, at.Step )
+      end_loc,ok1 := locus_path_step( areq.AssemblyId, at.Path, at.Step+at.Length-1 )
+      if !ok0 || !ok1 { continue }
+
+      tileid := fmt.Sprintf("%03x.%02x.%04x.%04x", at.Path, tloc.Version, at.Step, at.Variant)
+      seq,e := GetTileSeq( tileid )
+      if e!=nil {
+        lg.Debug("%s: %v", tileid, e)
+        continue
+      }
+
+      if len(seq) != (end_loc.End - beg_loc.Start) { continue }
+
+      off := start - beg_loc.Start
+      if (off<0) || (off+n > len(seq)) { continue }
+
+      sub := strings.ToUpper( seq[off:off+n] )
+      if strings.Contains( sub, "N" ) { continue }
+
+      dres.CallCount++
+      if sub == areq.AlternateBases {
+        dres.VariantCount++
+        carrier = true
+      }
+    }
+
+    if carrier { dres.SampleCount++ }
+  }
+
+  dres.Exists = dres.SampleCount > 0
+  if dres.CallCount > 0 { dres.Frequency = float64(dres.VariantCount) / float64(dres.CallCount) }
+  if protected() { protect_beacon( &dres ) }
+
+  resp := BeaconAlleleResponse{ BeaconId:gBeaconId, ApiVersion:gBeaconApiVersion, AlleleRequest:areq }
+  resp.Exists = dres.Exists
+  resp.DatasetAlleleResponses = []BeaconDatasetAlleleResponse{ dres }
+
+  w.Header().Set("Content-Type", "application/json")
+  b,_ := json.Marshal( resp )
+  io.WriteString( w, string(b) )
+}
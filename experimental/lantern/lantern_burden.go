@@ -0,0 +1,139 @@
+package main
+
+import "io"
+import "net/http"
+import "encoding/json"
+
+import "../cgf"
+import "../burden"
+
+/*
+
+Rare tile variant burden test per gene or region.
+
+The tile positions of each gene or region in 'Gene' (see
+lantern_gene.go) are tested separately, the samples in 'CaseSampleId'
+against those in 'ControlSampleId'.  Non-reference tile variants with
+a frequency of at most 'MaxFrequency' (0.01 by default) over the cases
+and controls together qualify, and a sample carrying any of them in
+the gene or region is a carrier.  The carrier counts are compared with
+a two sided Fisher's exact test and, with 'Permutation' set, a
+permutation p-value from that many shuffles of the case and control
+labels ('Seed' seeds the shuffles, so the same request gets the same
+answer).  See the burden package for the details.
+
+Samples not called anywhere in a gene or region aren't counted for
+it.  Results are in the order of 'Gene'.  "Format":"tsv" or "csv"
+returns them as a table (see lantern_table.go).
+
+For large sample sets or many permutations, run the request with
+"Async":true.
+
+Example request:
+
+{
+  "Type":"burden-test",
+  "CaseSampleId":[ "0:hu011C57.cgf", "1:hu016B28.cgf", ... ],
+  "ControlSampleId":[ "7:hu0D879F.cgf", "8:hu1DD730.cgf", ... ],
+  "Gene":[ "BRCA1", "BRCA2" ],
+  "MaxFrequency":0.01,
+  "Permutation":1000,
+  "Seed":1
+}
+
+Example response:
+
+{
+  "Type":"success", "Message":"burden-test",
+  "Result":[
+    {
+      "Name":"BRCA1", "QualifyingVariant":31,
+      "CaseCarrier":12, "CaseCount":150, "ControlCarrier":4, "ControlCount":152,
+      "OddsRatio":3.2, "PValue":0.043,
+      "Permutation":1000, "PermutationPValue":0.045
+    },
+    ...
+  ]
+}
+
+*/
+
+var gBurdenMaxPermutation int = 10000
+
+func burden_test( req *LanternRequest, caseIndex, controlIndex []int ) ( []burden.Result, error ) {
+  groups := make( []burden.Group, len(req.Gene) )
+  for i:=0; i<len(req.Gene); i++ {
+    pos,e := gene_position( []string{ req.Gene[i] } )
+    if e!=nil { return nil, e }
+    groups[i] = burden.Group{ Name:req.Gene[i], Position:pos }
+    req.explain.positions( len(pos) )
+  }
+  req.explain.parsed()
+
+  cases := make( []*cgf.CGF, len(caseIndex) )
+  for i:=0; i<len(caseIndex); i++ { cases[i] = gCGF[ caseIndex[i] ] }
+  controls := make( []*cgf.CGF, len(controlIndex) )
+  for i:=0; i<len(controlIndex); i++ { controls[i] = gCGF[ controlIndex[i] ] }
+
+  opt := burden.Option{ MaxFrequency:req.MaxFrequency, Permutation:req.Permutation, Seed:req.Seed }
+
+  res,e := burden.Burden( cases, controls, gCGF[0].TileMap, groups, opt, req.job.progress, req.job.cancelled )
+  if (e!=nil) && req.job.cancelled() { return nil, ErrCancelled() }
+  if e!=nil { return nil, e }
+  req.lg.Debug( "burden-test: %d cases, %d controls, %d groups", len(cases), len(controls), len(groups) )
+  return res, nil
+}
+
+func burden_test_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
+
+  if len(req.CaseSampleId)==0 { _errc(w, ErrInvalidParameter( "CaseSampleId", req.CaseSampleId, "no cases given" )) ; return }
+  if len(req.ControlSampleId)==0 { _errc(w, ErrInvalidParameter( "ControlSampleId", req.ControlSampleId, "no controls given" )) ; return }
+  if len(req.Gene)==0 { _errc(w, ErrInvalidParameter( "Gene", req.Gene, "no genes given" )) ; return }
+
+  caseIndex,err := req.sampleIndexArray( req.CaseSampleId )
+  if err!=nil { _erre(w, err) ; return }
+  controlIndex,err := req.sampleIndexArray( req.ControlSampleId )
+  if err!=nil { _erre(w, err) ; return }
+
+  is_case := make( map[int]bool )
+  for i:=0; i<len(caseIndex); i++ { is_case[ caseIndex[i] ] = true }
+  for i:=0; i<len(controlIndex); i++ {
+    if is_case[ controlIndex[i] ] {
+      _errc(w, ErrInvalidParameter( "ControlSampleId", gCGFName[ controlIndex[i] ], "sample is also a case" ))
+      return
+    }
+  }
+
+  if (req.MaxFrequency<0) || (req.MaxFrequency>1) {
+    _errc(w, ErrInvalidParameter( "MaxFrequency", req.MaxFrequency, "must be in [0,1]" ))
+    return
+  }
+  if req.Permutation<0 {
+    _errc(w, ErrInvalidParameter( "Permutation", req.Permutation, "must be >= 0" ))
+    return
+  }
+  if req.Permutation > gBurdenMaxPermutation {
+    _errc(w, ErrLimitExceeded( "permutations", gBurdenMaxPermutation ))
+    return
+  }
+
+  res,err := burden_test( req, caseIndex, controlIndex )
+  if err!=nil { _erre(w, err) ; return }
+  req.explain.looked_up()
+
+  resp.Type = "success"
+  resp.Message = "burden-test"
+
+  if is_table_format( req ) { burden_test_table( w, req, res ) ; return }
+
+  w.Header().Set("Content-Type", "application/json")
+  res_json_bytes,_ := json.Marshal( res )
+
+  io.WriteString(w, "{\n")
+  io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"burden-test\",\n")
+  io.WriteString(w, "  \"Result\": ")
+  io.WriteString(w, string(res_json_bytes))
+  io.WriteString(w, "\n")
+  io.WriteString(w, "}")
+
+}
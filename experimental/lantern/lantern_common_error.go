@@ -3,32 +3,191 @@ package main
 import "io"
 import "fmt"
 import "net/http"
+import "encoding/json"
 
+/*
 
+Error catalogue (see docs/api/errors_v0.1.0.txt).
 
-func _errp( w http.ResponseWriter ) {
+Every error response has the form:
 
-  io.WriteString(w, "{\n")
-  io.WriteString(w, "  \"Type\":\"failure\", \"Message\":\"parse failure\"\n")
-  io.WriteString(w, "}")
+{
+  "Type":"failure",
+  "Error":"UnknownSample",
+  "Message":"unknown sample '9:hu000000.cgf'",
+  "Detail":{ "SampleId":"9:hu000000.cgf" }
+}
+
+with the HTTP status of the error.  'Error' is the stable code to
+branch on, 'Message' is for people and may change.
+
+*/
+
+const (
+  ErrCodeParse = "ParseError"
+  ErrCodeInvalidRequestType = "InvalidRequestType"
+  ErrCodeInvalidParameter = "InvalidParameter"
+  ErrCodeInvalidTileId = "InvalidTileId"
+  ErrCodeInvalidPosition = "InvalidPosition"
+  ErrCodeUnknownSample = "UnknownSample"
+  ErrCodeUnknownAssembly = "UnknownAssembly"
+  ErrCodeUnknownJob = "UnknownJob"
+  ErrCodeUnknownGene = "UnknownGene"
+  ErrCodeNotFound = "NotFound"
+  ErrCodeNotAcceptable = "NotAcceptable"
+  ErrCodeLimitExceeded = "LimitExceeded"
+  ErrCodeJobNotReady = "JobNotReady"
+  ErrCodeCancelled = "Cancelled"
+  ErrCodeNotReady = "NotReady"
+  ErrCodeUnauthorized = "Unauthorized"
+  ErrCodeNotPermitted = "NotPermitted"
+  ErrCodeBudgetExhausted = "PrivacyBudgetExhausted"
+  ErrCodeInternal = "InternalError"
+)
+
+var gErrorStatus map[string]int = map[string]int{
+  ErrCodeParse : http.StatusBadRequest,
+  ErrCodeInvalidRequestType : http.StatusBadRequest,
+  ErrCodeInvalidParameter : http.StatusBadRequest,
+  ErrCodeInvalidTileId : http.StatusBadRequest,
+  ErrCodeInvalidPosition : http.StatusBadRequest,
+  ErrCodeUnknownSample : http.StatusNotFound,
+  ErrCodeUnknownAssembly : http.StatusNotFound,
+  ErrCodeUnknownJob : http.StatusNotFound,
+  ErrCodeUnknownGene : http.StatusNotFound,
+  ErrCodeNotFound : http.StatusNotFound,
+  ErrCodeNotAcceptable : http.StatusNotAcceptable,
+  ErrCodeLimitExceeded : http.StatusRequestEntityTooLarge,
+  ErrCodeJobNotReady : http.StatusConflict,
+  ErrCodeCancelled : http.StatusConflict,
+  ErrCodeNotReady : http.StatusServiceUnavailable,
+  ErrCodeUnauthorized : http.StatusUnauthorized,
+  ErrCodeNotPermitted : http.StatusForbidden,
+  ErrCodeBudgetExhausted : http.StatusTooManyRequests,
+  ErrCodeInternal : http.StatusInternalServerError,
+}
 
+type LanternError struct {
+  Code string
+  Message string
+  Detail map[string]interface{}
 }
 
-func _errm( w http.ResponseWriter ) {
+func (e *LanternError) Error() string { return e.Message }
 
-  io.WriteString(w, "{\n")
-  io.WriteString(w, "  \"Type\":\"failure\", \"Message\":\"max elements exceeded\"\n")
-  io.WriteString(w, "}")
+func (e *LanternError) Status() int {
+  if s,ok := gErrorStatus[e.Code] ; ok { return s }
+  return http.StatusInternalServerError
+}
 
+func lantern_errorf( code string, detail map[string]interface{}, format string, args ...interface{} ) *LanternError {
+  return &LanternError{ Code:code, Message:fmt.Sprintf(format, args...), Detail:detail }
 }
 
+func ErrParse( what string, e error ) *LanternError {
+  return lantern_errorf( ErrCodeParse, map[string]interface{}{ "Field":what }, "could not parse %s: %v", what, e )
+}
 
-func _erre( w http.ResponseWriter, e error ) {
+func ErrInvalidRequestType( req_type string ) *LanternError {
+  return lantern_errorf( ErrCodeInvalidRequestType, map[string]interface{}{ "Type":req_type }, "unknown request type '%s'", req_type )
+}
+
+func ErrInvalidParameter( name string, value interface{}, reason string ) *LanternError {
+  return lantern_errorf( ErrCodeInvalidParameter, map[string]interface{}{ "Parameter":name, "Value":value, "Reason":reason },
+    "invalid %s '%v': %s", name, value, reason )
+}
+
+func ErrInvalidTileId( tileid string, reason string ) *LanternError {
+  return lantern_errorf( ErrCodeInvalidTileId, map[string]interface{}{ "TileId":tileid, "Reason":reason }, "invalid tile id '%s': %s", tileid, reason )
+}
+
+func ErrInvalidPosition( pos string, reason string ) *LanternError {
+  return lantern_errorf( ErrCodeInvalidPosition, map[string]interface{}{ "Position":pos, "Reason":reason }, "invalid position '%s': %s", pos, reason )
+}
+
+func ErrUnknownSample( id string ) *LanternError {
+  return lantern_errorf( ErrCodeUnknownSample, map[string]interface{}{ "SampleId":id }, "unknown sample '%s'", id )
+}
+
+func ErrUnknownAssembly( assembly string ) *LanternError {
+  return lantern_errorf( ErrCodeUnknownAssembly, map[string]interface{}{ "Assembly":assembly }, "assembly '%s' is not loaded", assembly )
+}
+
+func ErrUnknownJob( id string ) *LanternError {
+  return lantern_errorf( ErrCodeUnknownJob, map[string]interface{}{ "JobId":id }, "unknown job %s", id )
+}
+
+func ErrUnknownGene( name string ) *LanternError {
+  return lantern_errorf( ErrCodeUnknownGene, map[string]interface{}{ "Gene":name }, "unknown gene or region '%s'", name )
+}
 
-  io.WriteString(w, "{\n")
-  io.WriteString(w, fmt.Sprintf("  \"Type\":\"failure\", \"Message\":\"%v\"\n", e) )
-  io.WriteString(w, "}")
+func ErrNotFound( what string, id string ) *LanternError {
+  return lantern_errorf( ErrCodeNotFound, map[string]interface{}{ "What":what, "Id":id }, "%s %s not found", what, id )
+}
+
+func ErrLimitExceeded( what string, limit int ) *LanternError {
+  return lantern_errorf( ErrCodeLimitExceeded, map[string]interface{}{ "Limit":limit, "What":what }, "too many %s (max %d)", what, limit )
+}
+
+func ErrJobNotReady( id, state string ) *LanternError {
+  return lantern_errorf( ErrCodeJobNotReady, map[string]interface{}{ "JobId":id, "State":state }, "job %s is %s", id, state )
+}
+
+func ErrNotAcceptable( accept string, req_type string ) *LanternError {
+  return lantern_errorf( ErrCodeNotAcceptable, map[string]interface{}{ "Accept":accept },
+    "%s only returns json, which Accept '%s' doesn't allow", req_type, accept )
+}
+
+func ErrCancelled() *LanternError {
+  return lantern_errorf( ErrCodeCancelled, nil, "cancelled" )
+}
+
+func ErrNotReady( status string ) *LanternError {
+  return lantern_errorf( ErrCodeNotReady, map[string]interface{}{ "Status":status }, "lantern is not ready (%s)", status )
+}
+
+func ErrUnauthorized( reason string ) *LanternError {
+  return lantern_errorf( ErrCodeUnauthorized, map[string]interface{}{ "Reason":reason }, "unauthorized: %s", reason )
+}
+
+func ErrNotPermitted( what, reason string ) *LanternError {
+  return lantern_errorf( ErrCodeNotPermitted, map[string]interface{}{ "What":what, "Reason":reason }, "%s not permitted: %s", what, reason )
+}
+
+func ErrBudgetExhausted( spent, cost, budget float64 ) *LanternError {
+  return lantern_errorf( ErrCodeBudgetExhausted, map[string]interface{}{ "Spent":spent, "Cost":cost, "Budget":budget },
+    "privacy budget exhausted (%g of %g spent, request needs %g)", spent, budget, cost )
+}
+
+func ErrInternal( e error ) *LanternError {
+  return lantern_errorf( ErrCodeInternal, nil, "%v", e )
+}
+
+// Map any error onto the catalogue.  Errors that aren't already
+// LanternErrors are internal, request errors are reported as
+// LanternErrors where they're found.
+//
+func lantern_error( e error ) *LanternError {
+  switch x := e.(type) {
+  case *LanternError: return x
+  case *UnknownAssemblyError: return ErrUnknownAssembly( x.Assembly )
+  }
+  return ErrInternal( e )
+}
 
+func lantern_error_body( le *LanternError ) []byte {
+  m := map[string]interface{}{ "Type":"failure", "Error":le.Code, "Message":le.Message }
+  if len(le.Detail)>0 { m["Detail"] = le.Detail }
+  b,_ := json.Marshal( m )
+  return b
 }
 
+func _errc( w http.ResponseWriter, le *LanternError ) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader( le.Status() )
+  io.WriteString( w, string( lantern_error_body( le ) ) )
+}
 
+func _erre( w http.ResponseWriter, e error ) {
+  _errc( w, lantern_error( e ) )
+}
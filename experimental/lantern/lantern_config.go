@@ -0,0 +1,375 @@
+package main
+
+import "os"
+import "fmt"
+import "net"
+import "time"
+import "bufio"
+import "strings"
+import "path/filepath"
+import "encoding/json"
+
+import "github.com/codegangsta/cli"
+
+import "../lightlog"
+
+/*
+
+Lantern configuration.
+
+Settings are taken from, in increasing order of precedence, the
+defaults below, the JSON config file given with '--config' and the
+command line flags.  Any flag given on the command line overrides
+the config file.
+
+The configuration is validated at startup and every problem found is
+reported before lantern exits.
+
+Example config file:
+
+{
+  "Listen":":8080",
+  "ShutdownTimeoutSeconds":30,
+
+  "CGF":[ "/data/cgf/hu*.cgf" ],
+  "CGFManifest":[ "/data/cgf/extra.manifest" ],
+
+  "TileCacheCSV":"/data/tile/tile_seq_first6.csv",
+  "TileDB":"/data/tile/tiledb.sqlite3",
+  "TileLocus":[ "/data/tile/hg19/*.fj.gz" ],
+  "GeneAnnotation":[ "hg19:/data/gencode.v19.annotation.gtf.gz" ],
+
+  "VariantIndexFile":"/data/lantern/variant.idx",
+  "ResultCacheMB":256,
+
+  "MemoryBudgetMB":16384,
+  "SampleCacheDir":"/var/cache/lantern/sample",
+
+  "JobDir":"/var/lib/lantern/job",
+  "JobTTLHours":24,
+  "JobMax":4,
+
+  "Limit":{ "BatchItems":1000, "SimilaritySamples":500, "PCASamples":5000, "SequenceSteps":4096, "BurdenPermutations":10000 },
+
+  "Privacy":{ "Protected":false, "MinCohort":20, "MinCell":5, "Epsilon":0.1, "Budget":10, "KeyHeader":"X-Api-Key", "BudgetFile":"" },
+
+  "AuditLog":"/var/log/lantern/audit.log",
+  "AuditLogMaxMB":100,
+  "AuditIdentityHeader":"X-Remote-User",
+
+  "LogLevel":"info",
+  "LogJSON":true
+}
+
+'CGF' entries are file names or glob patterns.  A 'CGFManifest' is a
+text file with one CGF file name per line (blank lines and lines
+starting with '#' are skipped), relative names are taken relative to
+the manifest.  Samples are numbered in the order they're listed,
+CGF entries first.
+
+*/
+
+type LanternLimitConfig struct {
+  BatchItems int
+  SimilaritySamples int
+  PCASamples int
+  SequenceSteps int
+  BurdenPermutations int
+}
+
+// Protected mode, see lantern_privacy.go.
+//
+type LanternPrivacyConfig struct {
+  Protected bool
+  MinCohort int
+  MinCell int
+  Epsilon float64
+  Budget float64
+  KeyHeader string
+  BudgetFile string
+}
+
+type LanternConfig struct {
+  Listen string
+  ShutdownTimeoutSeconds int
+
+  CGF []string
+  CGFManifest []string
+  CGFGob []string
+
+  TileCacheCSV string
+  TileDB string
+  TileLocus []string
+  GeneAnnotation []string
+
+  VariantIndex bool
+  VariantIndexFile string
+
+  ResultCacheMB int
+
+  // Sample eviction, see lantern_memory.go.
+  //
+  MemoryBudgetMB int
+  SampleCacheDir string
+
+  JobDir string
+  JobTTLHours int
+  JobMax int
+
+  Limit LanternLimitConfig
+  Privacy LanternPrivacyConfig
+
+  AuditLog string
+  AuditLogMaxMB int
+  AuditIdentityHeader string
+
+  LogLevel string
+  LogJSON bool
+
+  // CGF file list after expanding globs and manifests.
+  //
+  cgf_file []string
+}
+
+func DefaultConfig() LanternConfig {
+  return LanternConfig{
+    Listen : gPortStr,
+    ShutdownTimeoutSeconds : int( gShutdownTimeout/time.Second ),
+    TileCacheCSV : gTileCacheCSV,
+    TileDB : gTileDB,
+    ResultCacheMB : 64,
+    JobDir : gJobDir,
+    JobTTLHours : 24,
+    JobMax : gJobMax,
+    Limit : LanternLimitConfig{
+      BatchItems : gBatchMaxItems,
+      SimilaritySamples : gSimilarityMaxSamples,
+      PCASamples : gPCAMaxSamples,
+      SequenceSteps : gSampleSequenceMaxStep,
+      BurdenPermutations : gBurdenMaxPermutation,
+    },
+    Privacy : gPrivacy,
+    AuditLogMaxMB : 100,
+    AuditIdentityHeader : gAuditIdentityHeader,
+    LogLevel : "warn",
+  }
+}
+
+// Read fn over the defaults.  Unknown fields are an error so that
+// misspelled settings don't go unnoticed.
+//
+func LoadConfig( fn string ) ( LanternConfig, error ) {
+  cfg := DefaultConfig()
+
+  fp,e := os.Open( fn )
+  if e!=nil { return cfg, fmt.Errorf("config %s: %v", fn, e) }
+  defer fp.Close()
+
+  dec := json.NewDecoder( fp )
+  dec.DisallowUnknownFields()
+  if e := dec.Decode( &cfg ) ; e!=nil { return cfg, fmt.Errorf("config %s: %v", fn, e) }
+
+  return cfg, nil
+}
+
+// Override the config with any flags given on the command line.
+//
+func (cfg *LanternConfig) apply_flags( c *cli.Context ) {
+  if c.IsSet("listen") { cfg.Listen = c.String("listen") }
+  if c.IsSet("shutdown-timeout") { cfg.ShutdownTimeoutSeconds = c.Int("shutdown-timeout") }
+
+  if len(c.StringSlice("input-cgf"))>0 { cfg.CGF = c.StringSlice("input-cgf") }
+  if len(c.StringSlice("cgf-manifest"))>0 { cfg.CGFManifest = c.StringSlice("cgf-manifest") }
+  if len(c.StringSlice("input-cgf-gob"))>0 { cfg.CGFGob = c.StringSlice("input-cgf-gob") }
+
+  if c.IsSet("tile-cache-csv") { cfg.TileCacheCSV = c.String("tile-cache-csv") }
+  if c.IsSet("tile-db") { cfg.TileDB = c.String("tile-db") }
+  if len(c.StringSlice("tile-locus"))>0 { cfg.TileLocus = c.StringSlice("tile-locus") }
+  if len(c.StringSlice("gene-annotation"))>0 { cfg.GeneAnnotation = c.StringSlice("gene-annotation") }
+
+  if c.IsSet("variant-index") { cfg.VariantIndex = c.Bool("variant-index") }
+  if c.IsSet("variant-index-file") { cfg.VariantIndexFile = c.String("variant-index-file") }
+
+  if c.IsSet("result-cache-mb") { cfg.ResultCacheMB = c.Int("result-cache-mb") }
+
+  if c.IsSet("memory-budget-mb") { cfg.MemoryBudgetMB = c.Int("memory-budget-mb") }
+  if c.IsSet("sample-cache-dir") { cfg.SampleCacheDir = c.String("sample-cache-dir") }
+
+  if c.IsSet("protected") { cfg.Privacy.Protected = c.Bool("protected") }
+  if c.IsSet("min-cohort") { cfg.Privacy.MinCohort = c.Int("min-cohort") }
+  if c.IsSet("min-cell") { cfg.Privacy.MinCell = c.Int("min-cell") }
+  if c.IsSet("privacy-epsilon") { cfg.Privacy.Epsilon = c.Float64("privacy-epsilon") }
+  if c.IsSet("privacy-budget") { cfg.Privacy.Budget = c.Float64("privacy-budget") }
+  if c.IsSet("api-key-header") { cfg.Privacy.KeyHeader = c.String("api-key-header") }
+  if c.IsSet("privacy-budget-file") { cfg.Privacy.BudgetFile = c.String("privacy-budget-file") }
+
+  if c.IsSet("audit-log") { cfg.AuditLog = c.String("audit-log") }
+  if c.IsSet("audit-log-max-mb") { cfg.AuditLogMaxMB = c.Int("audit-log-max-mb") }
+  if c.IsSet("audit-identity-header") { cfg.AuditIdentityHeader = c.String("audit-identity-header") }
+
+  if c.IsSet("job-dir") { cfg.JobDir = c.String("job-dir") }
+  if c.IsSet("job-ttl") { cfg.JobTTLHours = c.Int("job-ttl") }
+  if c.IsSet("job-max") { cfg.JobMax = c.Int("job-max") }
+
+  if c.IsSet("max-batch-items") { cfg.Limit.BatchItems = c.Int("max-batch-items") }
+  if c.IsSet("max-similarity-samples") { cfg.Limit.SimilaritySamples = c.Int("max-similarity-samples") }
+  if c.IsSet("max-pca-samples") { cfg.Limit.PCASamples = c.Int("max-pca-samples") }
+  if c.IsSet("max-sequence-steps") { cfg.Limit.SequenceSteps = c.Int("max-sequence-steps") }
+  if c.IsSet("max-burden-permutations") { cfg.Limit.BurdenPermutations = c.Int("max-burden-permutations") }
+
+  if c.IsSet("log-level") { cfg.LogLevel = c.String("log-level") }
+  if c.Bool("Verbose") { cfg.LogLevel = "debug" }
+  if c.IsSet("log-json") { cfg.LogJSON = c.Bool("log-json") }
+}
+
+func read_manifest( fn string ) ( []string, error ) {
+  fp,e := os.Open( fn )
+  if e!=nil { return nil, e }
+  defer fp.Close()
+
+  dir := filepath.Dir( fn )
+  res := []string{}
+
+  scanner := bufio.NewScanner( fp )
+  for line_no:=1; scanner.Scan(); line_no++ {
+    line := strings.TrimSpace( scanner.Text() )
+    if (len(line)==0) || strings.HasPrefix( line, "#" ) { continue }
+    if !filepath.IsAbs( line ) { line = filepath.Join( dir, line ) }
+    res = append( res, line )
+  }
+  return res, scanner.Err()
+}
+
+func file_exists( fn string ) error {
+  st,e := os.Stat( fn )
+  if e!=nil { return e }
+  if st.IsDir() { return fmt.Errorf("%s is a directory", fn) }
+  return nil
+}
+
+// Check the configuration, expanding the CGF globs and manifests.
+// Every problem found is reported in the error, one per line.
+//
+func (cfg *LanternConfig) Validate() error {
+  problem := []string{}
+  add := func( format string, args ...interface{} ) { problem = append( problem, fmt.Sprintf(format, args...) ) }
+
+  if _,_,e := net.SplitHostPort( cfg.Listen ) ; e!=nil { add( "Listen '%s': %v", cfg.Listen, e ) }
+  if cfg.ShutdownTimeoutSeconds<0 { add( "ShutdownTimeoutSeconds must be >= 0 (is %d)", cfg.ShutdownTimeoutSeconds ) }
+
+  cfg.cgf_file = nil
+  for i:=0; i<len(cfg.CGF); i++ {
+    m,e := filepath.Glob( cfg.CGF[i] )
+    if e!=nil { add( "CGF '%s': %v", cfg.CGF[i], e ) ; continue }
+    if len(m)==0 { add( "CGF '%s': no such file", cfg.CGF[i] ) ; continue }
+    cfg.cgf_file = append( cfg.cgf_file, m... )
+  }
+  for i:=0; i<len(cfg.CGFManifest); i++ {
+    m,e := read_manifest( cfg.CGFManifest[i] )
+    if e!=nil { add( "CGFManifest '%s': %v", cfg.CGFManifest[i], e ) ; continue }
+    for j:=0; j<len(m); j++ {
+      if e := file_exists( m[j] ) ; e!=nil { add( "CGFManifest '%s': %v", cfg.CGFManifest[i], e ) ; continue }
+      cfg.cgf_file = append( cfg.cgf_file, m[j] )
+    }
+  }
+  if len(cfg.cgf_file)==0 { add( "no CGF files given (CGF, CGFManifest or --input-cgf)" ) }
+
+  for i:=0; i<len(cfg.CGFGob); i++ {
+    if e := file_exists( cfg.CGFGob[i] ) ; e!=nil { add( "CGFGob: %v", e ) }
+  }
+
+  if e := file_exists( cfg.TileCacheCSV ) ; e!=nil { add( "TileCacheCSV: %v", e ) }
+  if e := file_exists( cfg.TileDB ) ; e!=nil { add( "TileDB: %v", e ) }
+
+  for i:=0; i<len(cfg.GeneAnnotation); i++ {
+    _,fn := gene_annotation_source( cfg.GeneAnnotation[i] )
+    if e := file_exists( fn ) ; e!=nil { add( "GeneAnnotation: %v", e ) ; continue }
+    if len(gene_annotation_format( fn ))==0 { add( "GeneAnnotation '%s': unknown format (expected .gff3, .gff, .gtf or .bed)", fn ) }
+  }
+  if (len(cfg.GeneAnnotation)>0) && (len(cfg.TileLocus)==0) { add( "GeneAnnotation needs TileLocus" ) }
+
+  if cfg.ResultCacheMB<0 { add( "ResultCacheMB must be >= 0 (is %d)", cfg.ResultCacheMB ) }
+  if cfg.MemoryBudgetMB<0 { add( "MemoryBudgetMB must be >= 0 (is %d)", cfg.MemoryBudgetMB ) }
+  if len(cfg.SampleCacheDir)>0 {
+    if _,e := os.Stat( filepath.Dir( filepath.Clean( cfg.SampleCacheDir ) ) ) ; e!=nil { add( "SampleCacheDir: %v", e ) }
+  }
+  if len(cfg.JobDir)==0 { add( "JobDir must be given" ) }
+  if cfg.JobTTLHours<1 { add( "JobTTLHours must be >= 1 (is %d)", cfg.JobTTLHours ) }
+  if cfg.JobMax<1 { add( "JobMax must be >= 1 (is %d)", cfg.JobMax ) }
+
+  if cfg.Limit.BatchItems<1 { add( "Limit.BatchItems must be >= 1 (is %d)", cfg.Limit.BatchItems ) }
+  if cfg.Limit.SimilaritySamples<2 { add( "Limit.SimilaritySamples must be >= 2 (is %d)", cfg.Limit.SimilaritySamples ) }
+  if cfg.Limit.PCASamples<2 { add( "Limit.PCASamples must be >= 2 (is %d)", cfg.Limit.PCASamples ) }
+  if cfg.Limit.SequenceSteps<1 { add( "Limit.SequenceSteps must be >= 1 (is %d)", cfg.Limit.SequenceSteps ) }
+  if cfg.Limit.BurdenPermutations<0 { add( "Limit.BurdenPermutations must be >= 0 (is %d)", cfg.Limit.BurdenPermutations ) }
+
+  if cfg.Privacy.Protected {
+    if cfg.Privacy.MinCohort<1 { add( "Privacy.MinCohort must be >= 1 (is %d)", cfg.Privacy.MinCohort ) }
+    if cfg.Privacy.MinCell<0 { add( "Privacy.MinCell must be >= 0 (is %d)", cfg.Privacy.MinCell ) }
+    if !(cfg.Privacy.Epsilon>0) { add( "Privacy.Epsilon must be > 0 (is %g)", cfg.Privacy.Epsilon ) }
+    if cfg.Privacy.Budget<cfg.Privacy.Epsilon { add( "Privacy.Budget must be >= Privacy.Epsilon (is %g)", cfg.Privacy.Budget ) }
+    if len(cfg.Privacy.KeyHeader)==0 { add( "Privacy.KeyHeader must be given" ) }
+    if len(cfg.Privacy.BudgetFile)>0 {
+      if _,e := os.Stat( filepath.Dir( cfg.Privacy.BudgetFile ) ) ; e!=nil { add( "Privacy.BudgetFile: %v", e ) }
+    }
+  }
+
+  if len(cfg.AuditLog)>0 {
+    if _,e := os.Stat( filepath.Dir( cfg.AuditLog ) ) ; e!=nil { add( "AuditLog: %v", e ) }
+  }
+  if cfg.AuditLogMaxMB<0 { add( "AuditLogMaxMB must be >= 0 (is %d)", cfg.AuditLogMaxMB ) }
+  if len(cfg.AuditIdentityHeader)==0 { add( "AuditIdentityHeader must be given" ) }
+
+  if _,e := lightlog.ParseLevel( cfg.LogLevel ) ; e!=nil { add( "LogLevel: %v", e ) }
+
+  if len(problem)>0 {
+    return fmt.Errorf("invalid configuration:\n  %s", strings.Join( problem, "\n  " ))
+  }
+  return nil
+}
+
+// Set the globals the rest of lantern reads its settings from.
+//
+func (cfg *LanternConfig) apply() {
+  gPortStr = cfg.Listen
+  gShutdownTimeout = time.Duration( cfg.ShutdownTimeoutSeconds )*time.Second
+  gTileCacheCSV = cfg.TileCacheCSV
+  gTileDB = cfg.TileDB
+
+  gBatchMaxItems = cfg.Limit.BatchItems
+  gSimilarityMaxSamples = cfg.Limit.SimilaritySamples
+  gPCAMaxSamples = cfg.Limit.PCASamples
+  gSampleSequenceMaxStep = cfg.Limit.SequenceSteps
+  gBurdenMaxPermutation = cfg.Limit.BurdenPermutations
+  gPrivacy = cfg.Privacy
+  gAuditIdentityHeader = cfg.AuditIdentityHeader
+
+  lightlog.SetLevelString( cfg.LogLevel )
+  lightlog.SetJSON( cfg.LogJSON )
+}
+
+// The configuration from the config file (if any) and the command
+// line.  Exits with the list of problems if it isn't valid.
+//
+func config_init( c *cli.Context ) LanternConfig {
+  cfg := DefaultConfig()
+
+  if fn := c.String("config") ; len(fn)>0 {
+    var e error
+    cfg,e = LoadConfig( fn )
+    if e!=nil {
+      fmt.Fprintf( os.Stderr, "%v\n", e )
+      os.Exit(1)
+    }
+  }
+
+  cfg.apply_flags( c )
+
+  if e := cfg.Validate() ; e!=nil {
+    fmt.Fprintf( os.Stderr, "%v\n", e )
+    os.Exit(1)
+  }
+
+  cfg.apply()
+  return cfg
+}
@@ -0,0 +1,107 @@
+package main
+
+import "fmt"
+import "strings"
+import "strconv"
+import "hash/fnv"
+import "encoding/json"
+import "encoding/base64"
+
+/*
+
+Requests can carry an optional 'Limit' to bound the number of
+results returned.  If more results remain, the response holds
+an opaque 'Cursor' which, when sent back with the same request,
+resumes the query where the previous page stopped.
+
+The cursor encodes a hash of the request it came from and the
+position to resume from: the path and step of the tile position and,
+for queries that walk a request supplied list (e.g. the TileId list
+of 'tile-sequence'), the index into that list.  A cursor sent with a
+different request (other than in 'Limit', 'Format', 'Explain',
+'Note' or 'Message') is rejected as an invalid 'Cursor'.  Clients
+should not rely on its contents.
+
+Example request:
+
+{
+  "Type":"sample-intersect",
+  "SampleId" : [ ... ],
+  "Limit" : 100,
+  "Cursor" : "MDoyNDc6YjA6MA"
+}
+
+*/
+
+type LanternCursor struct {
+  Hash int
+  Path int
+  Step int
+  Index int
+}
+
+func (cur LanternCursor) Encode() string {
+  s := fmt.Sprintf("%x:%x:%x:%x", cur.Hash, cur.Path, cur.Step, cur.Index)
+  return base64.RawURLEncoding.EncodeToString( []byte(s) )
+}
+
+// Hash of the request without the fields that don't change which
+// results are paged through.
+//
+func cursor_hash( req *LanternRequest ) int {
+  canon := *req
+  canon.Note = ""
+  canon.Message = ""
+  canon.Cursor = ""
+  canon.Limit = 0
+  canon.Format = ""
+  canon.Explain = false
+  canon.Async = false
+
+  b,_ := json.Marshal( canon )
+  h := fnv.New32a()
+  h.Write( b )
+  return int( h.Sum32() )
+}
+
+func decode_cursor( cursor_str string ) (cur LanternCursor, err error) {
+  if len(cursor_str)==0 { return }
+
+  b,e := base64.RawURLEncoding.DecodeString( cursor_str )
+  if e!=nil { err = ErrInvalidParameter( "Cursor", cursor_str, "not a cursor" ) ; return }
+
+  parts := strings.Split( string(b), ":" )
+  if len(parts)!=4 { err = ErrInvalidParameter( "Cursor", cursor_str, "not a cursor" ) ; return }
+
+  v := [4]int{}
+  for i:=0; i<4; i++ {
+    x,e := strconv.ParseInt( parts[i], 16, 64 )
+    if (e!=nil) || (x<0) { err = ErrInvalidParameter( "Cursor", cursor_str, "not a cursor" ) ; return }
+    v[i] = int(x)
+  }
+
+  cur.Hash, cur.Path, cur.Step, cur.Index = v[0], v[1], v[2], v[3]
+  return
+}
+
+// Returns the decoded cursor and limit of the request.  A limit
+// of 0 means no limit.  The cursor has the request's hash, for the
+// next page's cursor, and must have been issued for the same
+// request.
+//
+func request_page( req *LanternRequest ) (cur LanternCursor, limit int, err error) {
+  if req.Limit < 0 { err = ErrInvalidParameter( "Limit", req.Limit, "must be >= 0" ) ; return }
+
+  cur,err = decode_cursor( req.Cursor )
+  if err!=nil { return }
+
+  hash := cursor_hash( req )
+  if (len(req.Cursor)>0) && (cur.Hash!=hash) {
+    err = ErrInvalidParameter( "Cursor", req.Cursor, "cursor is for a different request" )
+    return
+  }
+  cur.Hash = hash
+
+  limit = req.Limit
+  return
+}
@@ -0,0 +1,194 @@
+package main
+
+import "fmt"
+import "time"
+import "bytes"
+import "net/http"
+import "encoding/json"
+
+/*
+
+Query explain.
+
+Any request with "Explain":true gets an 'Explain' section next to
+its result (or error) describing how it was answered:
+
+{
+  "Type":"success", "Message":"variant-frequency",
+  "Result":{ ... },
+  "Explain":{
+    "Type":"variant-frequency",
+    "SampleCount":174, "PositionCount":2, "VariantIndex":true,
+    "Range":{ "TileVariantId":{ "247:3":[ { "Range":[0,3], "Permit":true } ], "247:a":[ { "Range":[0,-1], "Permit":true } ] } },
+    "TileStats":{ "Total":0, "CacheHit":0, "CacheMiss":0, "DBHit":0, "DBMiss":0 },
+    "ParseMs":0.21, "LookupMs":3.92, "SerializeMs":0.05, "TotalMs":4.2
+  }
+}
+
+'SampleCount' and 'PositionCount' are the number of samples and tile
+positions the request went over.  'Range' holds the parsed form of
+the request's range expressions, by field, as the handler uses them
+(variant ranges ending in -1 are open ended).  'TileStats' are the
+tile sequence lookups (see LanternTileStats) made while the request
+ran, counted over the whole server so concurrent requests add to
+them.
+
+'ParseMs' is the time spent decoding the request and parsing its
+ranges, 'LookupMs' the time spent finding the answer and
+'SerializeMs' the time spent writing it out.  Handlers mark the end of
+parsing and of the lookup, where they don't the whole of the handler
+up to its first write counts as lookup.
+
+Explained requests bypass the result cache.  Explain isn't available
+for "tsv" or "csv" results.  Within a batch, items can ask for their
+own explain, the batch's covers the batch as a whole.  This replaces
+timing tile-sequence lookups with 'tile-sequence-tracer'.
+
+*/
+
+type LanternExplain struct {
+  Type string
+  SampleCount int
+  PositionCount int
+  VariantIndex bool
+  Range map[string]interface{} `json:",omitempty"`
+  TileStats LanternTileStats
+
+  ParseMs float64
+  LookupMs float64
+  SerializeMs float64
+  TotalMs float64
+}
+
+type lantern_explain struct {
+  LanternExplain
+
+  start time.Time
+  decoded time.Time
+  handler time.Time
+  parse_done time.Time
+  lookup_done time.Time
+
+  tile_stats LanternTileStats
+}
+
+// start is when the request came in, decoded when its JSON was
+// decoded.
+//
+func new_explain( req_type string, start, decoded time.Time ) *lantern_explain {
+  ex := &lantern_explain{ start:start, decoded:decoded }
+  ex.Type = req_type
+  return ex
+}
+
+func (ex *lantern_explain) samples( n int ) {
+  if ex==nil { return }
+  ex.SampleCount += n
+}
+
+func (ex *lantern_explain) positions( n int ) {
+  if ex==nil { return }
+  ex.PositionCount += n
+}
+
+func (ex *lantern_explain) ranges( field string, parsed interface{} ) {
+  if ex==nil { return }
+  if ex.Range==nil { ex.Range = make( map[string]interface{} ) }
+  ex.Range[field] = parsed
+}
+
+// The request's ranges are parsed.
+//
+func (ex *lantern_explain) parsed() {
+  if ex==nil { return }
+  if ex.parse_done.IsZero() { ex.parse_done = time.Now() }
+}
+
+// The answer is found, what's left is writing it out.
+//
+func (ex *lantern_explain) looked_up() {
+  if ex==nil { return }
+  if ex.lookup_done.IsZero() { ex.lookup_done = time.Now() }
+}
+
+func ms( d time.Duration ) float64 {
+  return float64(d) / float64(time.Millisecond)
+}
+
+func tile_stats_diff( a, b LanternTileStats ) LanternTileStats {
+  return LanternTileStats{
+    Total : b.Total - a.Total,
+    CacheHit : b.CacheHit - a.CacheHit,
+    CacheMiss : b.CacheMiss - a.CacheMiss,
+    DBHit : b.DBHit - a.DBHit,
+    DBMiss : b.DBMiss - a.DBMiss,
+  }
+}
+
+func (ex *lantern_explain) begin() {
+  ex.handler = time.Now()
+  ex.tile_stats = gLanternTileStats
+  ex.VariantIndex = gVariantIndex!=nil
+}
+
+func (ex *lantern_explain) finish() {
+  end := time.Now()
+  ex.TileStats = tile_stats_diff( ex.tile_stats, gLanternTileStats )
+
+  parse_end := ex.handler
+  if !ex.parse_done.IsZero() { parse_end = ex.parse_done }
+  lookup_end := end
+  if !ex.lookup_done.IsZero() { lookup_end = ex.lookup_done }
+
+  ex.ParseMs = ms( ex.decoded.Sub( ex.start ) + parse_end.Sub( ex.handler ) )
+  ex.LookupMs = ms( lookup_end.Sub( parse_end ) )
+  ex.SerializeMs = ms( end.Sub( lookup_end ) )
+  ex.TotalMs = ms( end.Sub( ex.start ) )
+}
+
+// Holds the response back so the explain section can be added to it.
+// The first write marks the end of the lookup if the handler didn't.
+//
+type explain_writer struct {
+  w http.ResponseWriter
+  ex *lantern_explain
+  status int
+  buf bytes.Buffer
+}
+
+func (ew *explain_writer) Header() http.Header { return ew.w.Header() }
+func (ew *explain_writer) WriteHeader( status int ) { ew.status = status }
+
+func (ew *explain_writer) Write( b []byte ) (int, error) {
+  ew.ex.looked_up()
+  return ew.buf.Write( b )
+}
+
+// Write the response out with the explain section added as the last
+// field of the response object.  Responses that aren't JSON objects
+// are passed on as they are.
+//
+func (ew *explain_writer) finish() {
+  ew.ex.finish()
+
+  body := bytes.TrimSpace( ew.buf.Bytes() )
+  if (len(body)>=2) && (body[0]=='{') && (body[len(body)-1]=='}') {
+    ex_bytes,e := json.Marshal( ew.ex.LanternExplain )
+    if e!=nil { ex_bytes = []byte( fmt.Sprintf("{\"Error\":%q}", e.Error()) ) }
+
+    inner := bytes.TrimSpace( body[1:len(body)-1] )
+    out := bytes.Buffer{}
+    out.WriteString( "{\n  " )
+    if len(inner)>0 {
+      out.Write( inner )
+      out.WriteString( ",\n  " )
+    }
+    out.WriteString( "\"Explain\":" )
+    out.Write( ex_bytes )
+    out.WriteString( "\n}" )
+    body = out.Bytes()
+  }
+
+  if ew.status!=0 { ew.w.WriteHeader( ew.status ) }
+  ew.w.Write( body )
+}
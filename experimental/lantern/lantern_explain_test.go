@@ -0,0 +1,48 @@
+package main
+
+import "time"
+import "testing"
+import "net/http/httptest"
+import "encoding/json"
+
+func TestExplainWriter( t *testing.T ) {
+  tab := []struct {
+    body string
+    explained bool
+  }{
+    { "{\n  \"Type\":\"success\", \"Message\":\"x\",\n  \"Result\": [1,2]\n}", true },
+    { "{}", true },
+    { "[1,2]", false },
+  }
+
+  for _,x := range tab {
+    now := time.Now()
+    ex := new_explain( "x", now, now )
+    ex.begin()
+    ex.samples( 3 )
+    ex.positions( 2 )
+    ex.ranges( "Path", [][2]int64{ { 1, 3 } } )
+    ex.parsed()
+
+    rec := httptest.NewRecorder()
+    ew := &explain_writer{ w:rec, ex:ex }
+    ew.Write( []byte(x.body) )
+    ew.finish()
+
+    if !x.explained {
+      if rec.Body.String()!=x.body { t.Errorf("%q: changed to %q", x.body, rec.Body.String()) }
+      continue
+    }
+
+    res := struct {
+      Result []int
+      Explain *LanternExplain
+    }{}
+    if e := json.Unmarshal( rec.Body.Bytes(), &res ) ; e!=nil { t.Errorf("%q: %v: %s", x.body, e, rec.Body.String()) ; continue }
+    if res.Explain==nil { t.Errorf("%q: no explain", x.body) ; continue }
+    if (res.Explain.SampleCount!=3) || (res.Explain.PositionCount!=2) || (res.Explain.Range["Path"]==nil) {
+      t.Errorf("%q: explain %+v", x.body, res.Explain)
+    }
+    if res.Explain.TotalMs < res.Explain.ParseMs + res.Explain.LookupMs { t.Errorf("%q: times %+v", x.body, res.Explain) }
+  }
+}
@@ -0,0 +1,402 @@
+package main
+
+import "io"
+import "fmt"
+import "sort"
+import "strings"
+import "strconv"
+import "net/http"
+import "encoding/json"
+
+import "../aux"
+import "../lightlog"
+
+/*
+
+Gene and region annotations.
+
+Gene annotations (GFF3 or GTF) and named regions (BED) are loaded
+with '--gene-annotation' ("GeneAnnotation" in the config file).  Each
+entry is a file name, optionally prefixed by the assembly the
+coordinates are in, e.g. "hg19:/data/gencode.v19.annotation.gtf.gz".
+Without an assembly, the file is taken to be in the only assembly
+loaded with '--tile-locus'.  The format comes from the file extension
+(.gff, .gff3, .gtf or .bed, optionally gzipped or bzipped).
+
+In GFF3 and GTF files, every feature with a gene name (the
+'gene_name', 'Name' or 'gene' attribute, falling back on 'gene_id')
+extends the extent of that gene on its chromosome, so files with only
+transcript or exon records work too.  In BED files the fourth column
+names the region.  Names are matched case insensitively and a name
+found in more than one place (e.g. PAR genes) covers all of them.
+
+A gene or region maps to the tile positions whose loci overlap it.
+Names can be given anywhere a genomic range is accepted (see
+lantern_locus.go), e.g. "BRCA1.0000-" in a 'TileVariantId', and in
+the 'Gene' request field, which is understood by:
+
+  sample-position-variant     the gene's positions are added
+  variant-frequency           every tile variant seen at the gene's
+                              positions is added
+  tile-sequence               the reference tiles (variant 0) at the
+                              gene's positions are added
+  sample-similarity,
+  population-pca,
+  sample-intersect            only the gene's positions are compared
+  sample-sequence             the gene's step range ('Path' and 'Step'
+                              are ignored)
+  burden-test                 one test per gene or region
+
+sample-tile-group-match and sample-tile-neighborhood match on tile
+variants, so 'Gene' is rejected there; give the names in the tile
+variant ids instead.  Other request types ignore 'Gene'.
+
+The gene-tiles request lists the mapping.  'Region' is 1 reference,
+inclusive (as accepted in 'Position') and 'TilePosition' lists the
+step ranges (end non-inclusive) covering the feature.
+
+Example request:
+
+{
+  "Type":"gene-tiles",
+  "Gene":[ "BRCA1" ]
+}
+
+Example response:
+
+{
+  "Type":"success", "Message":"gene-tiles",
+  "Result":{
+    "BRCA1":[
+      {
+        "Name":"BRCA1",
+        "Assembly":"hg19",
+        "Region":"chr17:41196312-41277500",
+        "Strand":"-",
+        "TilePosition":[ "247.00.0000-0151" ]
+      }
+    ]
+  }
+}
+
+*/
+
+type GeneFeature struct {
+  Name string
+  Assembly string
+  Chrom string
+  Start int
+  End int
+  Strand string
+}
+
+type GeneTiles struct {
+  Name string
+  Assembly string
+  Region string
+  Strand string
+  TilePosition []string
+}
+
+type ByPathStep [][2]int
+func (x ByPathStep) Len() int { return len(x) }
+func (x ByPathStep) Swap(i, j int) { x[i],x[j] = x[j],x[i] }
+func (x ByPathStep) Less(i,j int) bool {
+  if x[i][0]!=x[j][0] { return x[i][0] < x[j][0] }
+  return x[i][1] < x[j][1]
+}
+
+// Keyed by upper case name.
+//
+var gGeneIndex map[string][]GeneFeature
+
+// The features named, in a case insensitive lookup.
+//
+func gene_feature( name string ) ( []GeneFeature, bool ) {
+  gf,ok := gGeneIndex[ strings.ToUpper(name) ]
+  return gf, ok && (len(gf)>0)
+}
+
+func is_gene_name( name string ) bool {
+  _,ok := gene_feature( name )
+  return ok
+}
+
+// Tile positions (path, step) overlapping the named genes or regions,
+// in path, step order.
+//
+func gene_position( names []string ) ( [][2]int, error ) {
+  seen := make( map[[2]int]bool )
+  res := [][2]int{}
+
+  for i:=0; i<len(names); i++ {
+    gf,ok := gene_feature( names[i] )
+    if !ok { return nil, ErrUnknownGene( names[i] ) }
+
+    for j:=0; j<len(gf); j++ {
+      tl,e := locus_lookup( gf[j].Assembly, gf[j].Chrom, gf[j].Start, gf[j].End )
+      if e!=nil { return nil, e }
+
+      for k:=0; k<len(tl); k++ {
+        p := [2]int{ tl[k].Path, tl[k].Step }
+        if seen[p] { continue }
+        seen[p] = true
+        res = append( res, p )
+      }
+    }
+  }
+
+  sort.Sort( ByPathStep( res ) )
+  return res, nil
+}
+
+// Membership test for a list of tile positions.
+//
+func position_filter( pos [][2]int ) func( path, step int ) bool {
+  m := make( map[[2]int]bool )
+  for i:=0; i<len(pos); i++ { m[ pos[i] ] = true }
+  return func( path, step int ) bool { return m[ [2]int{ path, step } ] }
+}
+
+// Positions passing both filters, either of which can be nil.
+//
+func both_filter( a, b func( path, step int ) bool ) func( path, step int ) bool {
+  if a==nil { return b }
+  if b==nil { return a }
+  return func( path, step int ) bool { return a( path, step ) && b( path, step ) }
+}
+
+// Filter on the positions of the genes or regions in the request
+// 'Gene', nil if there are none.
+//
+func request_gene_filter( req *LanternRequest ) ( func( path, step int ) bool, error ) {
+  if len(req.Gene)==0 { return nil, nil }
+  pos,e := gene_position( req.Gene )
+  if e!=nil { return nil, e }
+  return position_filter( pos ), nil
+}
+
+// Sorted positions as "path.00.step" or "path.00.beg-end" ranges of
+// consecutive steps (end non-inclusive).
+//
+func position_range_string( pos [][2]int ) []string {
+  res := []string{}
+  for i:=0; i<len(pos); {
+    j := i+1
+    for (j<len(pos)) && (pos[j][0]==pos[i][0]) && (pos[j][1]==pos[j-1][1]+1) { j++ }
+
+    if j-i == 1 {
+      res = append( res, fmt.Sprintf("%03x.%02x.%04x", pos[i][0], 0, pos[i][1]) )
+    } else {
+      res = append( res, fmt.Sprintf("%03x.%02x.%04x-%04x", pos[i][0], 0, pos[i][1], pos[j-1][1]+1) )
+    }
+    i = j
+  }
+  return res
+}
+
+// Split "assembly:file" into its parts.  A prefix with a '/' in it is
+// part of the file name.
+//
+func gene_annotation_source( s string ) ( assembly, fn string ) {
+  f := strings.SplitN( s, ":", 2 )
+  if (len(f)==2) && (len(f[0])>0) && !strings.Contains( f[0], "/" ) { return f[0], f[1] }
+  return "", s
+}
+
+func gene_annotation_format( fn string ) string {
+  fn = strings.ToLower( fn )
+  fn = strings.TrimSuffix( strings.TrimSuffix( fn, ".gz" ), ".bz2" )
+  for _,ext := range []string{ ".gff3", ".gff", ".gtf", ".bed" } {
+    if strings.HasSuffix( fn, ext ) { return ext[1:] }
+  }
+  return ""
+}
+
+// GFF3 ("key=value;...") or GTF ('key "value"; ...') attributes.
+//
+func gff_attribute( s string ) map[string]string {
+  m := make( map[string]string )
+  for _,kv := range strings.Split( s, ";" ) {
+    kv = strings.TrimSpace( kv )
+    if len(kv)==0 { continue }
+
+    var k,v string
+    if eq := strings.Index( kv, "=" ) ; (eq>0) && !strings.Contains( kv[:eq], " " ) {
+      k,v = kv[:eq],kv[eq+1:]
+    } else {
+      f := strings.SplitN( kv, " ", 2 )
+      if len(f)!=2 { continue }
+      k,v = f[0],strings.Trim( strings.TrimSpace( f[1] ), "\"" )
+    }
+    if _,ok := m[k] ; !ok { m[k] = v }
+  }
+  return m
+}
+
+func gff_gene_name( attr map[string]string ) string {
+  for _,k := range []string{ "gene_name", "Name", "gene", "gene_id" } {
+    if v,ok := attr[k] ; ok && (len(v)>0) { return v }
+  }
+  return ""
+}
+
+// Gene extents from a GFF3 or GTF file, in file order.
+//
+func load_gff( fn, assembly string ) ( []GeneFeature, error ) {
+  fp,scanner,e := aux.OpenScanner( fn )
+  if e!=nil { return nil, e }
+  defer fp.Close()
+
+  res := []GeneFeature{}
+  pos := make( map[string]int )
+
+  line_no := 0
+  for scanner.Scan() {
+    line_no++
+    l := scanner.Text()
+    if strings.HasPrefix( l, "##FASTA" ) { break }
+    if (len(l)==0) || (l[0]=='#') { continue }
+
+    f := strings.Split( l, "\t" )
+    if len(f)<9 { return nil, fmt.Errorf("%s line %d: expected 9 columns, got %d", fn, line_no, len(f)) }
+
+    name := gff_gene_name( gff_attribute( f[8] ) )
+    if len(name)==0 { continue }
+
+    s,e0 := strconv.Atoi( f[3] )
+    t,e1 := strconv.Atoi( f[4] )
+    if (e0!=nil) || (e1!=nil) || (s<1) || (t<s) { return nil, fmt.Errorf("%s line %d: invalid range %s-%s", fn, line_no, f[3], f[4]) }
+
+    chrom := normalize_chrom( f[0] )
+    key := name + "\t" + chrom
+
+    if i,ok := pos[key] ; ok {
+      if s-1 < res[i].Start { res[i].Start = s-1 }
+      if t > res[i].End { res[i].End = t }
+      continue
+    }
+
+    pos[key] = len(res)
+    res = append( res, GeneFeature{ Name:name, Assembly:assembly, Chrom:chrom, Start:s-1, End:t, Strand:f[6] } )
+  }
+
+  return res, scanner.Err()
+}
+
+// Named regions from a BED file.  Unnamed regions are skipped.
+//
+func load_bed( fn, assembly string ) ( []GeneFeature, error ) {
+  fp,scanner,e := aux.OpenScanner( fn )
+  if e!=nil { return nil, e }
+  defer fp.Close()
+
+  res := []GeneFeature{}
+
+  line_no := 0
+  for scanner.Scan() {
+    line_no++
+    l := scanner.Text()
+    if (len(l)==0) || (l[0]=='#') || strings.HasPrefix( l, "track" ) || strings.HasPrefix( l, "browser" ) { continue }
+
+    f := strings.Fields( l )
+    if len(f)<4 { continue }
+
+    s,e0 := strconv.Atoi( f[1] )
+    t,e1 := strconv.Atoi( f[2] )
+    if (e0!=nil) || (e1!=nil) || (s<0) || (t<=s) { return nil, fmt.Errorf("%s line %d: invalid range %s-%s", fn, line_no, f[1], f[2]) }
+
+    strand := "."
+    if len(f)>=6 { strand = f[5] }
+
+    res = append( res, GeneFeature{ Name:f[3], Assembly:assembly, Chrom:normalize_chrom( f[0] ), Start:s, End:t, Strand:strand } )
+  }
+
+  return res, scanner.Err()
+}
+
+// Load the gene annotations and regions.  Must be called after the
+// tile loci are loaded.
+//
+func GeneAnnotationInit( sources []string ) error {
+  gGeneIndex = make( map[string][]GeneFeature )
+
+  for i:=0; i<len(sources); i++ {
+    assembly,fn := gene_annotation_source( sources[i] )
+
+    if len(assembly)==0 {
+      if len(gLocusIndex)!=1 { return fmt.Errorf("%s: assembly required (e.g. hg19:%s)", fn, fn) }
+      for a := range gLocusIndex { assembly = a }
+    }
+    assembly = canonical_assembly( assembly )
+    if _,ok := gLocusIndex[assembly] ; !ok { return fmt.Errorf("%s: no tile loci loaded for assembly '%s'", fn, assembly) }
+
+    lightlog.Info( "loading gene annotation %s (%s)", fn, assembly )
+
+    var gf []GeneFeature
+    var e error
+    switch gene_annotation_format( fn ) {
+    case "gff3", "gff", "gtf": gf,e = load_gff( fn, assembly )
+    case "bed": gf,e = load_bed( fn, assembly )
+    default: e = fmt.Errorf("%s: unknown annotation format (expected .gff3, .gff, .gtf or .bed)", fn)
+    }
+    if e!=nil { return e }
+
+    for j:=0; j<len(gf); j++ {
+      k := strings.ToUpper( gf[j].Name )
+      gGeneIndex[k] = append( gGeneIndex[k], gf[j] )
+    }
+  }
+
+  return nil
+}
+
+func gene_tiles( names []string ) ( map[string][]GeneTiles, error ) {
+  res := make( map[string][]GeneTiles )
+
+  for i:=0; i<len(names); i++ {
+    gf,ok := gene_feature( names[i] )
+    if !ok { return nil, ErrUnknownGene( names[i] ) }
+
+    res[ names[i] ] = []GeneTiles{}
+    for j:=0; j<len(gf); j++ {
+      tl,e := locus_lookup( gf[j].Assembly, gf[j].Chrom, gf[j].Start, gf[j].End )
+      if e!=nil { return nil, e }
+
+      pos := make( [][2]int, len(tl) )
+      for k:=0; k<len(tl); k++ { pos[k] = [2]int{ tl[k].Path, tl[k].Step } }
+
+      res[ names[i] ] = append( res[ names[i] ], GeneTiles{
+        Name : gf[j].Name,
+        Assembly : gf[j].Assembly,
+        Region : fmt.Sprintf("%s:%d-%d", gf[j].Chrom, gf[j].Start+1, gf[j].End),
+        Strand : gf[j].Strand,
+        TilePosition : position_range_string( pos ),
+      })
+    }
+  }
+
+  return res, nil
+}
+
+func gene_tiles_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
+
+  if len(req.Gene)==0 { _errc(w, ErrInvalidParameter( "Gene", req.Gene, "no genes given" )) ; return }
+
+  res,err := gene_tiles( req.Gene )
+  if err!=nil { _erre(w, err) ; return }
+
+  resp.Type = "success"
+  resp.Message = "gene-tiles"
+
+  w.Header().Set("Content-Type", "application/json")
+  res_json_bytes,_ := json.Marshal( res )
+
+  io.WriteString(w, "{\n")
+  io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"gene-tiles\",\n")
+  io.WriteString(w, "  \"Result\": ")
+  io.WriteString(w, string(res_json_bytes))
+  io.WriteString(w, "\n")
+  io.WriteString(w, "}")
+
+}
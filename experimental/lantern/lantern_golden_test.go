@@ -0,0 +1,257 @@
+package main
+
+import "os"
+import "fmt"
+import "flag"
+import "bytes"
+import "regexp"
+import "strconv"
+import "strings"
+import "time"
+import "testing"
+import "io/ioutil"
+import "net/http"
+import "net/http/httptest"
+import "path/filepath"
+import "database/sql"
+import "encoding/json"
+
+/*
+
+Golden file tests for the lantern request types.
+
+Lantern is started in-process on the synthetic samples in
+testdata/cgf, with the tile sequences for path 247 in the tile cache
+CSV (testdata/tile/tile_seq.csv), those for path 248 in a SQLite tile
+database built from testdata/tile/tiledb.sql, hg19 loci from
+testdata/tile/hg19.fj and genes and regions from testdata/tile/genes.*
+and testdata/tile/regions.bed.
+
+Each request in testdata/golden/<name>.json is posted to lantern and
+the response is compared against testdata/golden/<name>.golden: the
+HTTP status on the first line, then the response body (indented, if
+it's JSON).  Floating point values are compared to 8 significant
+digits.
+
+A request file holding a JSON array is a sequence of requests, posted
+in order, and its golden file holds every response in turn.  "$JobId"
+in a request is replaced by the JobId of the last response that had
+one, and a job-result first waits for the job to finish.  JobIds in
+the responses are masked, they're random.
+
+The requests are run once scanning the samples, once with the
+variant index and once scanning with every sample but the first
+evicted (see lantern_memory.go), against the same golden files.  Any
+difference between the runs fails, except for the responses listed
+in gGoldenModeDiffers, which are kept in <name>.<mode>.golden.
+
+After an intended change to a query's semantics, rewrite the golden
+files with
+
+  go test -run TestGolden -update
+
+and review the diff.
+
+*/
+
+var gUpdateGolden = flag.Bool("update", false, "rewrite the golden files with the current responses")
+
+var gGoldenDir string = "testdata/golden"
+
+// Responses expected to differ from the scan, by run.  The eviction
+// counters show up in system-info.
+//
+var gGoldenModeDiffers map[string][]string = map[string][]string{
+  "evict" : []string{ "system-info" },
+}
+
+func golden_mode_differs( mode, name string ) bool {
+  for _,x := range gGoldenModeDiffers[mode] {
+    if x==name { return true }
+  }
+  return false
+}
+
+func golden_tile_db( fn, sql_fn string ) error {
+  b,e := ioutil.ReadFile( sql_fn )
+  if e!=nil { return e }
+
+  db,e := sql.Open( "sqlite3", fn )
+  if e!=nil { return e }
+  defer db.Close()
+
+  _,e = db.Exec( string(b) )
+  return e
+}
+
+func golden_config( t *testing.T, dir string, mode string ) LanternConfig {
+  tile_db := filepath.Join( dir, "tiledb.sqlite3" )
+  if _,e := os.Stat( tile_db ) ; os.IsNotExist(e) {
+    if e := golden_tile_db( tile_db, "testdata/tile/tiledb.sql" ) ; e!=nil { t.Fatalf("tile db: %v", e) }
+  }
+
+  cfg := DefaultConfig()
+  cfg.CGF = []string{ "testdata/cgf/*.cgf" }
+  cfg.TileCacheCSV = "testdata/tile/tile_seq.csv"
+  cfg.TileDB = tile_db
+  cfg.TileLocus = []string{ "testdata/tile/hg19.fj" }
+  cfg.GeneAnnotation = []string{ "hg19:testdata/tile/genes.gff3", "testdata/tile/genes.gtf", "testdata/tile/regions.bed" }
+  cfg.JobDir = filepath.Join( dir, "job" )
+  cfg.ResultCacheMB = 0
+  cfg.VariantIndex = (mode=="index")
+  if mode=="evict" {
+    cfg.MemoryBudgetMB = 1
+    cfg.SampleCacheDir = filepath.Join( dir, "sample" )
+  }
+  cfg.LogLevel = "error"
+
+  if e := cfg.Validate() ; e!=nil { t.Fatal(e) }
+  cfg.apply()
+
+  return cfg
+}
+
+var gGoldenFloat *regexp.Regexp = regexp.MustCompile( `-?[0-9]+\.[0-9]{8,}(e[-+]?[0-9]+)?` )
+
+func golden_float( b []byte ) []byte {
+  f,e := strconv.ParseFloat( string(b), 64 )
+  if e!=nil { return b }
+  return []byte( strconv.FormatFloat( f, 'g', 8, 64 ) )
+}
+
+// Status line, then the body, indented if it's JSON.
+//
+func golden_response( status int, body []byte ) []byte {
+  var out bytes.Buffer
+  fmt.Fprintf( &out, "%d\n", status )
+  if e := json.Indent( &out, body, "", "  " ) ; e!=nil {
+    out.Truncate( 0 )
+    fmt.Fprintf( &out, "%d\n", status )
+    out.Write( body )
+  }
+  out.WriteString( "\n" )
+  return gGoldenFloat.ReplaceAllFunc( out.Bytes(), golden_float )
+}
+
+var gGoldenJobId *regexp.Regexp = regexp.MustCompile( `"JobId":"[0-9a-f]{32}"` )
+
+func golden_post( srv *httptest.Server, body []byte ) ( int, []byte, error ) {
+  resp,e := http.Post( srv.URL, "application/json", bytes.NewReader( body ) )
+  if e!=nil { return 0, nil, e }
+  defer resp.Body.Close()
+  res_body,e := ioutil.ReadAll( resp.Body )
+  return resp.StatusCode, res_body, e
+}
+
+// Poll job-status until the job is no longer queued or running.
+//
+func golden_job_wait( srv *httptest.Server, job_id string ) error {
+  body := []byte( fmt.Sprintf(`{"Type":"job-status","JobId":%q}`, job_id) )
+  for i:=0; i<500; i++ {
+    _,res_body,e := golden_post( srv, body )
+    if e!=nil { return e }
+    st := struct { Result LanternJobStatus }{}
+    if e := json.Unmarshal( res_body, &st ) ; e!=nil { return fmt.Errorf("job-status: %v", e) }
+    if (st.Result.State!="queued") && (st.Result.State!="running") { return nil }
+    time.Sleep( 10*time.Millisecond )
+  }
+  return fmt.Errorf("job %s didn't finish", job_id)
+}
+
+// The responses to the request, or sequence of requests, in body.
+//
+func golden_request( srv *httptest.Server, body []byte ) ( []byte, error ) {
+  seq := []json.RawMessage{}
+  if len(bytes.TrimSpace( body ))>0 && (bytes.TrimSpace( body )[0]=='[') {
+    if e := json.Unmarshal( body, &seq ) ; e!=nil { return nil, e }
+  } else {
+    seq = append( seq, json.RawMessage( body ) )
+  }
+
+  var out bytes.Buffer
+  job_id := ""
+  for i:=0; i<len(seq); i++ {
+    req_body := bytes.Replace( seq[i], []byte("$JobId"), []byte(job_id), -1 )
+
+    x := struct { Type string }{}
+    json.Unmarshal( req_body, &x )
+    if (x.Type=="job-result") && (len(job_id)>0) {
+      if e := golden_job_wait( srv, job_id ) ; e!=nil { return nil, e }
+    }
+
+    status,res_body,e := golden_post( srv, req_body )
+    if e!=nil { return nil, e }
+
+    if m := gGoldenJobId.Find( res_body ) ; m!=nil { job_id = string( m[9:len(m)-1] ) }
+    res_body = gGoldenJobId.ReplaceAll( res_body, []byte(`"JobId":"<JobId>"`) )
+
+    out.Write( golden_response( status, res_body ) )
+  }
+  return out.Bytes(), nil
+}
+
+func golden_run( t *testing.T, srv *httptest.Server, mode string, update bool ) {
+  req_fns,e := filepath.Glob( filepath.Join( gGoldenDir, "*.json" ) )
+  if e!=nil { t.Fatal(e) }
+  if len(req_fns)==0 { t.Fatalf("no requests in %s", gGoldenDir) }
+
+  for _,req_fn := range req_fns {
+    name := strings.TrimSuffix( filepath.Base( req_fn ), ".json" )
+    golden_fn := strings.TrimSuffix( req_fn, ".json" ) + ".golden"
+    mode_fn := strings.TrimSuffix( req_fn, ".json" ) + "." + mode + ".golden"
+    differs := golden_mode_differs( mode, name )
+
+    body,e := ioutil.ReadFile( req_fn )
+    if e!=nil { t.Fatal(e) }
+
+    // Tile lookup counts show up in system-info.
+    //
+    gLanternTileStats = LanternTileStats{}
+    if gMemory!=nil { gMemory.evictions, gMemory.reloads = 0, 0 }
+
+    got,e := golden_request( srv, body )
+    if e!=nil { t.Errorf("%s: %v", name, e) ; continue }
+
+    if update && ((mode=="scan") || differs) {
+      fn := golden_fn
+      if differs { fn = mode_fn }
+      if e := ioutil.WriteFile( fn, got, 0644 ) ; e!=nil { t.Fatal(e) }
+      continue
+    }
+
+    if differs { golden_fn = mode_fn }
+
+    expect,e := ioutil.ReadFile( golden_fn )
+    if e!=nil { t.Errorf("%s: %v (run with -update to create it)", name, e) ; continue }
+
+    if !bytes.Equal( got, expect ) {
+      t.Errorf("%s (%s): response differs from %s\n--- expected\n%s--- got\n%s", name, mode, golden_fn, expect, got)
+    }
+  }
+}
+
+func TestGolden( t *testing.T ) {
+  dir,e := ioutil.TempDir( "", "lantern-golden" )
+  if e!=nil { t.Fatal(e) }
+  defer os.RemoveAll( dir )
+
+  // Count every ABV entry as 1MB, so only sample 0 fits the budget.
+  //
+  prev_overhead := gMemoryEntryOverhead
+  defer func() { gMemoryEntryOverhead = prev_overhead }()
+
+  for _,mode := range []string{ "scan", "index", "evict" } {
+    gMemoryEntryOverhead = prev_overhead
+    if mode=="evict" { gMemoryEntryOverhead = 1024*1024 }
+
+    t.Run( mode, func( t *testing.T ) {
+      cfg := golden_config( t, dir, mode )
+      if e := lantern_load( cfg ) ; e!=nil { t.Fatal(e) }
+
+      srv := httptest.NewServer( lantern_mux() )
+      defer srv.Close()
+
+      golden_run( t, srv, mode, *gUpdateGolden )
+    })
+  }
+}
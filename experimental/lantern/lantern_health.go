@@ -0,0 +1,147 @@
+package main
+
+import "io"
+import "fmt"
+import "sync"
+import "time"
+import "context"
+import "strconv"
+import "net/http"
+import "encoding/json"
+
+import "../cgf"
+import "../lightlog"
+
+/*
+
+Liveness, readiness and shutdown.
+
+Lantern starts listening before the samples are loaded so the
+probes below can answer while it loads:
+
+  /healthz  200 as long as the server is up, whatever its state
+  /readyz   200 once every sample is loaded and validated, 503 while
+            loading, after a failed load and once shutting down
+
+Both return a small JSON status:
+
+  { "Status":"ready", "Samples":174, "Since":"2016-05-02T10:31:07Z" }
+
+Until lantern is ready, queries (including beacon queries) get a
+'NotReady' error (503).
+
+If loading fails, lantern stays up in the "failed" state, with the
+error as the 'Message' on /readyz, until it gets SIGINT or SIGTERM,
+then exits with an error.  With '--Test' it exits straight away.
+
+On SIGINT or SIGTERM lantern reports itself as "draining" on
+/readyz, stops accepting connections and waits up to
+'--shutdown-timeout' seconds for the requests in flight to finish
+before closing whatever is left.  Async jobs still queued or running
+are dropped.
+
+*/
+
+var gShutdownTimeout time.Duration = 30*time.Second
+
+const (
+  HealthLoading = "loading"
+  HealthReady = "ready"
+  HealthFailed = "failed"
+  HealthDraining = "draining"
+)
+
+type LanternHealth struct {
+  Status string
+  Message string `json:",omitempty"`
+  Samples int
+  Since time.Time
+}
+
+var gHealth LanternHealth = LanternHealth{ Status:HealthLoading, Since:time.Now() }
+var gHealthLock sync.Mutex
+
+func set_health( status, msg string ) {
+  gHealthLock.Lock()
+  defer gHealthLock.Unlock()
+
+  gHealth.Status = status
+  gHealth.Message = msg
+  gHealth.Since = time.Now()
+  if status==HealthReady { gHealth.Samples = len(gCGF) }
+}
+
+func health() LanternHealth {
+  gHealthLock.Lock()
+  defer gHealthLock.Unlock()
+  return gHealth
+}
+
+func is_ready() bool {
+  return health().Status == HealthReady
+}
+
+// Check a loaded sample's ABVs only use characters of its char map,
+// on hex paths.
+//
+func validate_sample( name string, cg *cgf.CGF ) error {
+  if len(cg.TileMap)==0 { return fmt.Errorf("%s: empty tile map", name) }
+  if len(cg.ABV)==0 { return fmt.Errorf("%s: no paths", name) }
+
+  var valid [256]bool
+  for k := range cg.CharMap {
+    if len(k)==1 { valid[ k[0] ] = true }
+  }
+
+  for path_str,abv := range cg.ABV {
+    if _,e := strconv.ParseUint( path_str, 16, 64 ) ; e!=nil { return fmt.Errorf("%s: invalid path '%s'", name, path_str) }
+    for i:=0; i<len(abv); i++ {
+      if !valid[ abv[i] ] { return fmt.Errorf("%s: path %s step %x: invalid character %q", name, path_str, i, abv[i]) }
+    }
+  }
+  return nil
+}
+
+func write_health( w http.ResponseWriter, status int ) {
+  b,_ := json.Marshal( health() )
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader( status )
+  io.WriteString( w, string(b) )
+}
+
+func healthz_handler( w http.ResponseWriter, r *http.Request ) {
+  write_health( w, http.StatusOK )
+}
+
+func readyz_handler( w http.ResponseWriter, r *http.Request ) {
+  if is_ready() { write_health( w, http.StatusOK ) ; return }
+  write_health( w, http.StatusServiceUnavailable )
+}
+
+// Refuse queries until the samples are loaded.
+//
+func ready_handler( h http.HandlerFunc ) http.HandlerFunc {
+  return func( w http.ResponseWriter, r *http.Request ) {
+    if st := health() ; st.Status!=HealthReady {
+      _errc(w, ErrNotReady( st.Status ))
+      return
+    }
+    h( w, r )
+  }
+}
+
+// Stop accepting connections and wait for the requests in flight,
+// up to timeout, closing the rest after that.
+//
+func lantern_shutdown( srv *http.Server, timeout time.Duration ) {
+  set_health( HealthDraining, "" )
+  lightlog.Info( "shutting down, waiting up to %v for requests in flight", timeout )
+
+  ctx,cancel := context.WithTimeout( context.Background(), timeout )
+  defer cancel()
+
+  if e := srv.Shutdown( ctx ) ; e!=nil {
+    lightlog.Warn( "requests still in flight after %v, closing: %v", timeout, e )
+    srv.Close()
+  }
+}
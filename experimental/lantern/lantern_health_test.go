@@ -0,0 +1,74 @@
+package main
+
+import "os"
+import "strings"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "io/ioutil"
+import "path/filepath"
+
+import "../cgf"
+
+func TestReadiness( t *testing.T ) {
+  prev := health()
+  defer set_health( prev.Status, prev.Message )
+
+  srv := httptest.NewServer( lantern_mux() )
+  defer srv.Close()
+
+  get := func( path string ) int {
+    resp,e := http.Get( srv.URL + path )
+    if e!=nil { t.Fatal(e) }
+    resp.Body.Close()
+    return resp.StatusCode
+  }
+  post := func() int {
+    resp,e := http.Post( srv.URL, "application/json", strings.NewReader( `{"Type":"system-info"}` ) )
+    if e!=nil { t.Fatal(e) }
+    resp.Body.Close()
+    return resp.StatusCode
+  }
+
+  for _,status := range []string{ HealthLoading, HealthFailed, HealthDraining } {
+    set_health( status, "" )
+    if s := get("/healthz") ; s!=http.StatusOK { t.Errorf("%s: /healthz %d", status, s) }
+    if s := get("/readyz") ; s!=http.StatusServiceUnavailable { t.Errorf("%s: /readyz %d", status, s) }
+    if s := get("/beacon") ; s!=http.StatusServiceUnavailable { t.Errorf("%s: /beacon %d", status, s) }
+    if s := post() ; s!=http.StatusServiceUnavailable { t.Errorf("%s: query %d", status, s) }
+  }
+
+  set_health( HealthReady, "" )
+  if s := get("/readyz") ; s!=http.StatusOK { t.Errorf("ready: /readyz %d", s) }
+}
+
+func TestValidateSample( t *testing.T ) {
+  cg := &cgf.CGF{
+    CharMap : map[string]int{ ".":0, "A":1, "-":-1 },
+    TileMap : []cgf.TileMapEntry{ cgf.TileMapEntry{ Type:"het" } },
+    ABV : map[string]string{ "247":"..A.-." },
+  }
+  if e := validate_sample( "x", cg ) ; e!=nil { t.Errorf("%v", e) }
+
+  cg.ABV["248"] = "..Z."
+  if e := validate_sample( "x", cg ) ; e==nil { t.Errorf("invalid character not caught") }
+
+  delete( cg.ABV, "248" )
+  cg.ABV["zz"] = "...."
+  if e := validate_sample( "x", cg ) ; e==nil { t.Errorf("invalid path not caught") }
+}
+
+func TestLoadCorruptGob( t *testing.T ) {
+  dir,e := ioutil.TempDir( "", "lantern-load" )
+  if e!=nil { t.Fatal(e) }
+  defer os.RemoveAll( dir )
+
+  gob_fn := filepath.Join( dir, "bad.gob" )
+  if e := ioutil.WriteFile( gob_fn, []byte("not a gob"), 0644 ) ; e!=nil { t.Fatal(e) }
+
+  cfg := golden_config( t, dir, "scan" )
+  cfg.CGFGob = []string{ gob_fn }
+
+  e = lantern_load( cfg )
+  if e==nil || !strings.Contains( e.Error(), gob_fn ) { t.Errorf("corrupt gob not reported: %v", e) }
+}
@@ -0,0 +1,352 @@
+package main
+
+import "os"
+import "io"
+import "fmt"
+import "sync"
+import "time"
+import "bufio"
+import "regexp"
+import "net/http"
+import "crypto/rand"
+import "path/filepath"
+import "encoding/hex"
+import "encoding/json"
+
+import "../lightlog"
+
+/*
+
+Asynchronous jobs.
+
+Any request with "Async":true is run in the background.  The
+response only holds the job id:
+
+  { "Type":"success", "Message":"job", "JobId":"3f2a..." }
+
+The job is then managed with the following request types, each
+taking the 'JobId':
+
+  job-status   : state ("queued", "running", "done", "cancelled" or "failed"),
+                 progress (0 to 1) and times
+  job-cancel   : stop the job and remove its result
+  job-result   : the response of the original request, once done
+
+Results are written to '--job-dir' and removed, along with the job,
+'--job-ttl' hours after the job finishes.  At most '--job-max' jobs
+run at once, the rest are queued.
+
+Handlers report progress and check for cancellation through
+req.job (see progress and cancelled below), both of which are no-ops
+for ordinary requests.
+
+*/
+
+var gJobDir string = "./lantern-job"
+var gJobTTL time.Duration = 24*time.Hour
+var gJobMax int = 4
+
+type lantern_job struct {
+  mu sync.Mutex
+
+  id string
+  req_type string
+  state string
+  progress_frac float64
+  message string
+
+  // Of the result, JSON unless a table was asked for.
+  //
+  content_type string
+
+  created time.Time
+  started time.Time
+  finished time.Time
+
+  cancel_flag bool
+}
+
+type LanternJobStatus struct {
+  JobId string
+  RequestType string
+  State string
+  Progress float64
+  Message string `json:",omitempty"`
+  Created time.Time
+  Started *time.Time `json:",omitempty"`
+  Finished *time.Time `json:",omitempty"`
+  Expires *time.Time `json:",omitempty"`
+}
+
+var gJob map[string]*lantern_job = make( map[string]*lantern_job )
+var gJobLock sync.Mutex
+var gJobSem chan bool
+
+func job_id() string {
+  b := make( []byte, 16 )
+  rand.Read( b )
+  return hex.EncodeToString( b )
+}
+
+func job_result_fn( id string ) string {
+  return filepath.Join( gJobDir, id + ".json" )
+}
+
+// Fraction of the work done, given as done out of total.
+//
+func (jb *lantern_job) progress( done, total int ) {
+  if (jb==nil) || (total<=0) { return }
+  jb.mu.Lock()
+  jb.progress_frac = float64(done)/float64(total)
+  jb.mu.Unlock()
+}
+
+func (jb *lantern_job) cancelled() bool {
+  if jb==nil { return false }
+  jb.mu.Lock()
+  defer jb.mu.Unlock()
+  return jb.cancel_flag
+}
+
+func (jb *lantern_job) status() LanternJobStatus {
+  jb.mu.Lock()
+  defer jb.mu.Unlock()
+
+  st := LanternJobStatus{ JobId:jb.id, RequestType:jb.req_type, State:jb.state, Progress:jb.progress_frac, Message:jb.message, Created:jb.created }
+  if !jb.started.IsZero() { t := jb.started ; st.Started = &t }
+  if !jb.finished.IsZero() {
+    t := jb.finished ; st.Finished = &t
+    x := jb.finished.Add( gJobTTL ) ; st.Expires = &x
+  }
+  return st
+}
+
+func (jb *lantern_job) set_state( state, msg string ) {
+  jb.mu.Lock()
+  defer jb.mu.Unlock()
+
+  // A cancelled job stays cancelled.
+  //
+  if jb.state == "cancelled" { return }
+
+  jb.state = state
+  jb.message = msg
+  if state=="running" { jb.started = time.Now() }
+  if (state=="done") || (state=="failed") || (state=="cancelled") { jb.finished = time.Now() }
+  if state=="done" { jb.progress_frac = 1 }
+}
+
+// Writes a job's response to its result file, keeping the status the
+// handler gave.
+//
+type job_writer struct {
+  header http.Header
+  w *bufio.Writer
+  status int
+}
+
+func (jw *job_writer) Header() http.Header { return jw.header }
+func (jw *job_writer) WriteHeader( status int ) {
+  if jw.status==0 { jw.status = status }
+}
+func (jw *job_writer) Write( b []byte ) (int, error) {
+  if jw.status==0 { jw.status = http.StatusOK }
+  return jw.w.Write( b )
+}
+
+func run_job( jb *lantern_job, req *LanternRequest ) {
+  gJobSem <- true
+  defer func() { <-gJobSem }()
+
+  if jb.cancelled() { return }
+  jb.set_state( "running", "" )
+  req.lg.Info( "job %s started", jb.id )
+
+  fn := job_result_fn( jb.id )
+  fp,e := os.Create( fn )
+  if e!=nil {
+    req.lg.Error( "job %s: %v", jb.id, e )
+    jb.set_state( "failed", fmt.Sprintf("%v", e) )
+    return
+  }
+
+  jw := &job_writer{ header:make( http.Header ), w:bufio.NewWriter( fp ) }
+  serve_request( jw, req )
+  e = jw.w.Flush()
+  fp.Close()
+
+  jb.mu.Lock()
+  jb.content_type = jw.header.Get("Content-Type")
+  jb.mu.Unlock()
+
+  if jb.cancelled() {
+    os.Remove( fn )
+    req.lg.Info( "job %s cancelled", jb.id )
+    return
+  }
+
+  if e!=nil {
+    jb.set_state( "failed", fmt.Sprintf("%v", e) )
+    return
+  }
+
+  // Errors are written with their status (see _errc).
+  //
+  state,msg := "done",""
+  if jw.status >= 400 {
+    state,msg = "failed","request failed, see job-result"
+  }
+  jb.set_state( state, msg )
+  req.lg.Info( "job %s %s", jb.id, state )
+}
+
+func job_submit( w http.ResponseWriter, req *LanternRequest ) {
+  jb := &lantern_job{ id:job_id(), req_type:req.Type, state:"queued", created:time.Now() }
+
+  gJobLock.Lock()
+  gJob[jb.id] = jb
+  gJobLock.Unlock()
+
+  job_req := *req
+  job_req.Async = false
+  job_req.job = jb
+  job_req.batch = nil
+  job_req.lg = req.lg.With( lightlog.Fields{ "job":jb.id } )
+  job_req.audit = nil
+  req.audit.set_job( jb.id )
+
+  go run_job( jb, &job_req )
+
+  w.Header().Set("Content-Type", "application/json")
+  io.WriteString(w, "{\n")
+  io.WriteString(w, fmt.Sprintf("  \"Type\":\"success\", \"Message\":\"job\", \"JobId\":\"%s\"\n", jb.id))
+  io.WriteString(w, "}")
+}
+
+func job_lookup( id string ) (*lantern_job, error) {
+  gJobLock.Lock()
+  defer gJobLock.Unlock()
+  jb,ok := gJob[id]
+  if !ok { return nil, ErrUnknownJob( id ) }
+  return jb, nil
+}
+
+func job_status_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
+  jb,e := job_lookup( req.JobId )
+  if e!=nil { _erre(w, e) ; return }
+
+  res_json_bytes,_ := json.Marshal( jb.status() )
+
+  w.Header().Set("Content-Type", "application/json")
+  io.WriteString(w, "{\n")
+  io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"job-status\",\n")
+  io.WriteString(w, "  \"Result\": ")
+  io.WriteString(w, string(res_json_bytes))
+  io.WriteString(w, "\n")
+  io.WriteString(w, "}")
+}
+
+func job_cancel_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
+  jb,e := job_lookup( req.JobId )
+  if e!=nil { _erre(w, e) ; return }
+
+  jb.mu.Lock()
+  running := (jb.state=="queued") || (jb.state=="running")
+  if running {
+    jb.cancel_flag = true
+    jb.state = "cancelled"
+    jb.finished = time.Now()
+  }
+  jb.mu.Unlock()
+
+  // A finished job just has its result removed.
+  //
+  if !running {
+    gJobLock.Lock()
+    delete( gJob, jb.id )
+    gJobLock.Unlock()
+    os.Remove( job_result_fn( jb.id ) )
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  io.WriteString(w, "{\n")
+  io.WriteString(w, fmt.Sprintf("  \"Type\":\"success\", \"Message\":\"job-cancel\", \"JobId\":\"%s\"\n", jb.id))
+  io.WriteString(w, "}")
+}
+
+func job_result_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
+  jb,e := job_lookup( req.JobId )
+  if e!=nil { _erre(w, e) ; return }
+
+  st := jb.status()
+  if (st.State!="done") && (st.State!="failed") {
+    _errc(w, ErrJobNotReady( jb.id, st.State ))
+    return
+  }
+
+  fp,e := os.Open( job_result_fn( jb.id ) )
+  if e!=nil { _errc(w, ErrInternal( e )) ; return }
+  defer fp.Close()
+
+  jb.mu.Lock()
+  content_type := jb.content_type
+  jb.mu.Unlock()
+  if len(content_type)==0 { content_type = "application/json" }
+
+  w.Header().Set("Content-Type", content_type)
+  io.Copy( w, fp )
+}
+
+// Remove finished jobs, and their results, once they expire.
+//
+func job_expire() {
+  now := time.Now()
+
+  gJobLock.Lock()
+  defer gJobLock.Unlock()
+
+  for id,jb := range gJob {
+    jb.mu.Lock()
+    expired := !jb.finished.IsZero() && now.After( jb.finished.Add( gJobTTL ) )
+    jb.mu.Unlock()
+
+    if expired {
+      lightlog.Debug( "job %s expired", id )
+      os.Remove( job_result_fn( id ) )
+      delete( gJob, id )
+    }
+  }
+}
+
+var gJobResultName *regexp.Regexp = regexp.MustCompile( `^[0-9a-f]{32}\.json$` )
+var gJobExpireOnce sync.Once
+
+// Results left over from a previous run can't be retrieved, clear
+// them out.  Only files named like job results are removed, the job
+// directory may hold other things.
+//
+func JobInit( dir string, ttl time.Duration, max_job int ) error {
+  gJobDir = dir
+  gJobTTL = ttl
+  gJobMax = max_job
+  if gJobMax < 1 { gJobMax = 1 }
+  gJobSem = make( chan bool, gJobMax )
+
+  if e := os.MkdirAll( gJobDir, 0755 ) ; e!=nil { return e }
+
+  old,_ := filepath.Glob( filepath.Join( gJobDir, "*.json" ) )
+  for i:=0; i<len(old); i++ {
+    if gJobResultName.MatchString( filepath.Base( old[i] ) ) { os.Remove( old[i] ) }
+  }
+
+  gJobExpireOnce.Do( func() {
+    go func() {
+      for {
+        time.Sleep( time.Minute )
+        job_expire()
+      }
+    }()
+  })
+
+  return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import "os"
+import "fmt"
+import "testing"
+import "io/ioutil"
+import "net/http/httptest"
+import "encoding/json"
+
+func TestJobState( t *testing.T ) {
+  dir,e := ioutil.TempDir( "", "lantern-job" )
+  if e!=nil { t.Fatal(e) }
+  defer os.RemoveAll( dir )
+
+  cfg := golden_config( t, dir, "scan" )
+  if e := lantern_load( cfg ) ; e!=nil { t.Fatal(e) }
+
+  srv := httptest.NewServer( lantern_mux() )
+  defer srv.Close()
+
+  for body,want := range map[string]string{
+    `{"Type":"tile-sequence","Async":true,"TileId":["247.00.0002.0001"]}` : "done",
+    `{"Type":"sample-sequence","Async":true,"SampleId":[]}` : "failed",
+    `{"Type":"population-pca","Async":true,"SampleId":["0:testdata/cgf/hu000001.cgf"]}` : "failed",
+  } {
+    _,res_body,e := golden_post( srv, []byte(body) )
+    if e!=nil { t.Fatal(e) }
+    jr := struct { JobId string }{}
+    if e := json.Unmarshal( res_body, &jr ) ; (e!=nil) || (len(jr.JobId)==0) { t.Fatalf("%s: %s", body, res_body) }
+
+    if e := golden_job_wait( srv, jr.JobId ) ; e!=nil { t.Fatal(e) }
+
+    _,res_body,e = golden_post( srv, []byte( fmt.Sprintf(`{"Type":"job-status","JobId":%q}`, jr.JobId) ) )
+    if e!=nil { t.Fatal(e) }
+    st := struct { Result LanternJobStatus }{}
+    if e := json.Unmarshal( res_body, &st ) ; e!=nil { t.Fatal(e) }
+    if st.Result.State!=want { t.Errorf("%s: job %s, expected %s", body, st.Result.State, want) }
+  }
+}
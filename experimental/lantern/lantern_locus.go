@@ -0,0 +1,364 @@
+package main
+
+import "fmt"
+import "sort"
+import "strings"
+import "strconv"
+import "encoding/json"
+
+import "../aux"
+import "../tile"
+import "../lightlog"
+
+/*
+
+Index of tile positions by reference coordinate.
+
+The locus index is built from the headers of the tile library
+FastJ files given with '--tile-locus'.  Each header carries a
+locus entry per assembly of the form:
+
+  "locus":[{"build":"hg19 chr17 41196288 41196537"}]
+
+The start is 0 reference and the end is non-inclusive.  The
+"-x"/"+x" suffixes some tile sets put on the start and end are
+ignored.
+
+Assembly names are stored in their canonical form (see
+canonical_assembly) so that, for example, "GRCh37" and "hg19"
+refer to the same loci.
+
+Genomic ranges ("chrom:start-end", see parse_genomic_range) are in
+the request 'Assembly', which can be left out if only one assembly
+is loaded.  A range stands for the tile positions whose loci overlap
+it and, as does the name of a gene or region (see lantern_gene.go),
+is accepted in:
+
+  'Position'                  sample-position-variant
+  'Path'                      sample-sequence (the steps covering the
+                              range, on a single path), sample-similarity
+                              and population-pca (only the positions
+                              covered are compared)
+
+and, in place of the path, version and step of a tile variant id
+(the variant, or variant range, follows the last '.', as in
+"chr17:41196312-41197000.0000-"), in:
+
+  'TileId'                    tile-sequence
+  'TileVariantId'             variant-frequency
+  'TileGroupVariantId'        sample-tile-group-match
+  'TileGroupVariantIdRange'   sample-tile-neighborhood
+
+Beacon queries ('/beacon/query') map their single coordinate through
+the same index.
+
+*/
+
+type TileLocus struct {
+  Assembly string
+  Chrom string
+  Start int
+  End int
+
+  Path int
+  Version int
+  Step int
+}
+
+type ByLocusStart []TileLocus
+func (x ByLocusStart) Len() int { return len(x) }
+func (x ByLocusStart) Swap(i, j int) { x[i],x[j] = x[j],x[i] }
+func (x ByLocusStart) Less(i,j int) bool { return x[i].Start < x[j].Start }
+
+// Returned when a query names an assembly that has no loci loaded.
+// Reported to the client as the 'UnknownAssembly' error.
+//
+type UnknownAssemblyError struct {
+  Assembly string
+}
+
+func (e *UnknownAssemblyError) Error() string {
+  return fmt.Sprintf("UnknownAssembly: assembly '%s' is not loaded", e.Assembly)
+}
+
+// Alternate names for the same reference build, keyed by lower case name.
+//
+var gAssemblyAlias map[string]string = map[string]string{
+  "hg18" : "hg18", "ncbi36" : "hg18",
+  "hg19" : "hg19", "grch37" : "hg19", "b37" : "hg19", "hs37d5" : "hg19",
+  "hg38" : "hg38", "grch38" : "hg38",
+}
+
+// Keyed by assembly, then by chromosome.  Each list is sorted
+// by start position.
+//
+var gLocusIndex map[string]map[string][]TileLocus
+
+// Keyed by assembly, then by "path:step" (hex).
+//
+var gLocusPathStep map[string]map[string]TileLocus
+
+func canonical_assembly( assembly string ) string {
+  if a,ok := gAssemblyAlias[ strings.ToLower(assembly) ] ; ok { return a }
+  return assembly
+}
+
+func normalize_chrom( chrom string ) string {
+  if strings.HasPrefix( chrom, "chr" ) { return chrom }
+  return "chr" + chrom
+}
+
+func parse_build_locus( build string ) (assembly, chrom string, start, end int, err error) {
+  f := strings.Fields( build )
+  if len(f)!=4 { err = fmt.Errorf("invalid build locus '%s'", build) ; return }
+
+  assembly = canonical_assembly( f[0] )
+  chrom = normalize_chrom( f[1] )
+
+  s,e := strconv.Atoi( strings.SplitN( f[2], "-", 2 )[0] )
+  if e!=nil { err = fmt.Errorf("invalid start in build locus '%s'", build) ; return }
+
+  t,e := strconv.Atoi( strings.SplitN( f[3], "+", 2 )[0] )
+  if e!=nil { err = fmt.Errorf("invalid end in build locus '%s'", build) ; return }
+
+  start,end = s,t
+  return
+}
+
+func locus_add( tile_id string, locus []map[string]string ) error {
+  parts := strings.Split( tile_id, "." )
+  if len(parts)!=4 { return fmt.Errorf("invalid tileID '%s'", tile_id) }
+
+  v := [3]int{}
+  for i:=0; i<3; i++ {
+    x,e := strconv.ParseInt( parts[i], 16, 64 )
+    if e!=nil { return fmt.Errorf("invalid tileID '%s'", tile_id) }
+    v[i] = int(x)
+  }
+
+  path_step := fmt.Sprintf("%x:%x", v[0], v[2])
+
+  for i:=0; i<len(locus); i++ {
+    build,ok := locus[i]["build"]
+    if !ok { continue }
+
+    assembly,chrom,start,end,e := parse_build_locus( build )
+    if e!=nil { return e }
+
+    if _,ok := gLocusPathStep[assembly] ; !ok {
+      gLocusPathStep[assembly] = make( map[string]TileLocus )
+      gLocusIndex[assembly] = make( map[string][]TileLocus )
+    }
+
+    // Every tile variant at a position has the same locus, only
+    // keep the first.
+    //
+    if _,ok := gLocusPathStep[assembly][path_step] ; ok { continue }
+
+    tl := TileLocus{ Assembly:assembly, Chrom:chrom, Start:start, End:end, Path:v[0], Version:v[1], Step:v[2] }
+    gLocusPathStep[assembly][path_step] = tl
+    gLocusIndex[assembly][chrom] = append( gLocusIndex[assembly][chrom], tl )
+  }
+
+  return nil
+}
+
+func LocusIndexInit( fastj_fns []string ) error {
+  gLocusIndex = make( map[string]map[string][]TileLocus )
+  gLocusPathStep = make( map[string]map[string]TileLocus )
+
+  for i:=0; i<len(fastj_fns); i++ {
+    lightlog.Info( "loading tile loci from %s", fastj_fns[i] )
+
+    fp,scanner,e := aux.OpenScanner( fastj_fns[i] )
+    if e!=nil { return e }
+
+    line_no := 0
+    for scanner.Scan() {
+      line_no++
+      l := scanner.Text()
+      if (len(l)==0) || (l[0]!='>') { continue }
+
+      hdr := tile.TileHeader{}
+      if e := json.Unmarshal( []byte(l[1:]), &hdr ) ; e!=nil {
+        fp.Close()
+        return fmt.Errorf("%s line %d: %v", fastj_fns[i], line_no, e)
+      }
+
+      if e := locus_add( hdr.TileID, hdr.Locus ) ; e!=nil {
+        fp.Close()
+        return fmt.Errorf("%s line %d: %v", fastj_fns[i], line_no, e)
+      }
+    }
+    e = scanner.Err()
+    fp.Close()
+    if e!=nil { return e }
+  }
+
+  for assembly := range gLocusIndex {
+    for chrom := range gLocusIndex[assembly] {
+      sort.Sort( ByLocusStart( gLocusIndex[assembly][chrom] ) )
+    }
+  }
+
+  return nil
+}
+
+func locus_assembly_loaded( assembly string ) bool {
+  _,ok := gLocusIndex[ canonical_assembly(assembly) ]
+  return ok
+}
+
+// Assembly to use for a request.  If none is given and only one
+// assembly is loaded, that one is used.
+//
+func request_assembly( req *LanternRequest ) (string, error) {
+  if len(req.Assembly)==0 {
+    if len(gLocusIndex)==1 {
+      for assembly := range gLocusIndex { return assembly, nil }
+    }
+    return "", ErrInvalidParameter( "Assembly", req.Assembly, "required for genomic coordinates with more than one assembly loaded" )
+  }
+
+  assembly := canonical_assembly( req.Assembly )
+  if _,ok := gLocusIndex[assembly] ; !ok { return "", &UnknownAssemblyError{ Assembly:req.Assembly } }
+  return assembly, nil
+}
+
+// Return the tile positions whose locus overlaps [start,end) (0 reference,
+// end non-inclusive) in order of start position.  Neighboring tiles overlap
+// on their tags, so a single base can fall in two tiles.
+//
+func locus_lookup( assembly, chrom string, start, end int ) ( []TileLocus, error ) {
+  asm,ok := gLocusIndex[ canonical_assembly(assembly) ]
+  if !ok { return nil, &UnknownAssemblyError{ Assembly:assembly } }
+
+  tl := asm[ normalize_chrom(chrom) ]
+
+  // Tiles along a chromosome only overlap on their tags, so ends
+  // increase with starts.  Scan back from the first tile starting
+  // at or after end.
+  //
+  k := sort.Search( len(tl), func(i int) bool { return tl[i].Start >= end } )
+
+  res := []TileLocus{}
+  for i:=k-1; i>=0; i-- {
+    if tl[i].End <= start { break }
+    res = append( res, tl[i] )
+  }
+
+  for i,j := 0,len(res)-1; i<j; i,j = i+1,j-1 { res[i],res[j] = res[j],res[i] }
+  return res, nil
+}
+
+func locus_path_step( assembly string, path, step int ) (TileLocus, bool) {
+  tl,ok := gLocusPathStep[ canonical_assembly(assembly) ][ fmt.Sprintf("%x:%x", path, step) ]
+  return tl, ok
+}
+
+// Tile positions (path, step) overlapping a genomic range of the
+// form 'chrom:start-end' (see parse_genomic_range).
+//
+func locus_range_position( assembly, range_str string ) ( [][2]int, error ) {
+  chrom,start,end,e := parse_genomic_range( range_str )
+  if e!=nil { return nil, ErrInvalidPosition( range_str, e.Error() ) }
+
+  tl,e := locus_lookup( assembly, chrom, start, end )
+  if e!=nil { return nil, e }
+
+  pos := make( [][2]int, 0, len(tl) )
+  for i:=0; i<len(tl); i++ {
+    pos = append( pos, [2]int{ tl[i].Path, tl[i].Step } )
+  }
+  return pos, nil
+}
+
+// A genomic range or the name of a gene or region (see
+// lantern_gene.go).
+//
+func is_locus_range( s string ) bool {
+  return is_genomic_range( s ) || is_gene_name( s )
+}
+
+// Tile positions (path, step) for a genomic range in the request
+// 'Assembly' or a gene or region name.
+//
+func request_range_position( req *LanternRequest, range_str string ) ( [][2]int, error ) {
+  if is_gene_name( range_str ) { return gene_position( []string{ range_str } ) }
+
+  assembly,e := request_assembly( req )
+  if e!=nil { return nil, e }
+  return locus_range_position( assembly, range_str )
+}
+
+// Split a tile variant id into its permit prefix ("~" or nothing),
+// what comes before the last '.' and the variant after it.
+//
+func split_tile_variant_id( s string ) ( permit_ch, range_str, variant string ) {
+  range_str = s
+  if strings.HasPrefix( range_str, "~" ) { permit_ch,range_str = "~",range_str[1:] }
+
+  n := strings.LastIndex( range_str, "." )
+  if n<0 { return permit_ch, range_str, "" }
+  return permit_ch, range_str[:n], range_str[n+1:]
+}
+
+// Tile variant ids ("path.version.step.variant") for one whose path,
+// version and step are a genomic range or a gene or region name.  The
+// variant (or variant range) follows the last '.', e.g.
+// "chr17:41196312-41197000.0000-" or, negated, "~BRCA1.0001".
+//
+func locus_tile_variant_id( req *LanternRequest, s string ) ( []string, error ) {
+  permit_ch,range_str,variant := split_tile_variant_id( s )
+  if len(variant)==0 { return nil, ErrInvalidTileId( s, "expected chrom:start-end.variant" ) }
+
+  pos,e := request_range_position( req, range_str )
+  if e!=nil { return nil, e }
+
+  res := make( []string, 0, len(pos) )
+  for i:=0; i<len(pos); i++ {
+    res = append( res, fmt.Sprintf("%s%03x.%02x.%04x.%s", permit_ch, pos[i][0], 0, pos[i][1], variant) )
+  }
+  return res, nil
+}
+
+// Tile variant ids with any given by genomic range or gene name
+// expanded (see locus_tile_variant_id).
+//
+func request_tile_variant_id( req *LanternRequest, ids []string ) ( []string, error ) {
+  res := make( []string, 0, len(ids) )
+  for i:=0; i<len(ids); i++ {
+    _,range_str,_ := split_tile_variant_id( ids[i] )
+    if !is_genomic_range( ids[i] ) && !is_gene_name( range_str ) { res = append( res, ids[i] ) ; continue }
+
+    x,e := locus_tile_variant_id( req, ids[i] )
+    if e!=nil { return nil, e }
+    res = append( res, x... )
+  }
+  return res, nil
+}
+
+// Path range for the request 'Path', either hex path ranges (see
+// parseIntOption) or a genomic range or gene name.  For the latter,
+// keep restricts the paths to the positions covered.
+//
+func request_path_range( req *LanternRequest ) ( path_range [][2]int64, keep func( path, step int ) bool, err error ) {
+  if len(req.Path)==0 { return nil, nil, nil }
+
+  if !is_locus_range( req.Path ) {
+    path_range,err = parseIntOption( req.Path, 16 )
+    if err!=nil { return nil, nil, ErrInvalidParameter( "Path", req.Path, err.Error() ) }
+    return path_range, nil, nil
+  }
+
+  pos,err := request_range_position( req, req.Path )
+  if err!=nil { return nil, nil, err }
+  if len(pos)==0 { return nil, nil, ErrNotFound( "tiles for", req.Path ) }
+
+  sort.Sort( ByPathStep( pos ) )
+  for i:=0; i<len(pos); i++ {
+    if (i>0) && (pos[i][0]==pos[i-1][0]) { continue }
+    path_range = append( path_range, [2]int64{ int64(pos[i][0]), int64(pos[i][0]+1) } )
+  }
+  return path_range, position_filter( pos ), nil
+}
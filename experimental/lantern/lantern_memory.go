@@ -0,0 +1,368 @@
+package main
+
+import "os"
+import "fmt"
+import "sync"
+import "path/filepath"
+import "encoding/gob"
+
+import "../cgf"
+import "../lightlog"
+
+/*
+
+Sample memory budget.
+
+Every sample's ABV (with its overflow maps) is counted against
+'--memory-budget-mb' ("MemoryBudgetMB" in the config file, 0, the
+default, for no limit).  Once the resident samples go over the
+budget, the least recently used ones have their ABV dropped, keeping
+only the header (versions, char map and the shared tile map).  An
+evicted sample is loaded again when a request needs it: from its gob
+file for '--input-cgf-gob' samples, otherwise from the
+'--sample-cache-dir' gob cache ("SampleCacheDir"), which is written
+the first time a sample is evicted (after the lock is released, so
+other requests aren't held up), or from the CGF file if there's no
+cache.  Startup loads the samples one at a time under the same
+budget.
+
+Requests make the samples they ask for resident
+(LanternRequest.sampleIndexArray) for as long as they run and they
+can't be evicted in the meantime, so the budget is a soft one: a
+request over more samples than fit goes over it until it's done.
+Sample 0 holds the tile map and is always resident.  With the variant
+index built, variant-frequency and sample-tile-group-match are
+answered from the index and load nothing.
+
+Sizes are estimates from the length of the ABV and overflow map
+entries plus gMemoryEntryOverhead per entry, the Go runtime will use
+somewhat more.  The budget, resident bytes and samples and the
+eviction and reload counts are reported in system-info under
+'Memory'.
+
+Example config:
+
+  "MemoryBudgetMB":16384,
+  "SampleCacheDir":"/var/cache/lantern/sample"
+
+*/
+
+// Rough per entry cost of a Go map of strings, over the key and
+// value bytes.
+//
+var gMemoryEntryOverhead int64 = 48
+
+var gMemory *sample_memory
+
+type LanternMemoryStats struct {
+  BudgetBytes int64
+  ResidentBytes int64
+  SampleCount int
+  ResidentSamples int
+  PinnedSamples int
+  Evictions int
+  Reloads int
+}
+
+// Where an evicted sample is loaded back from.
+//
+type sample_source struct {
+  fn string
+  gob bool
+}
+
+// A sample evicted before it was written to the gob cache.
+//
+type cache_write struct {
+  idx int
+  cg *cgf.CGF
+}
+
+type sample_memory struct {
+  mu sync.Mutex
+
+  budget int64
+  cache_dir string
+  used int64
+  tick int64
+
+  src []sample_source
+  size []int64
+  resident []bool
+  pin []int
+  last_use []int64
+  cached []bool
+  caching []bool
+
+  // Gob cache writes queued by evict, done by write_cache once the
+  // lock is released.
+  //
+  pending []cache_write
+
+  // Per sample ABV lengths, by path, so coverage can be checked
+  // without loading the sample.
+  //
+  path_len []map[string]int
+
+  // Serialises reloads of each sample.
+  //
+  loading []*sync.Mutex
+
+  evictions int
+  reloads int
+}
+
+func sample_size( cg *cgf.CGF ) int64 {
+  n := int64(0)
+  for k,v := range cg.ABV { n += int64( len(k) + len(v) ) + gMemoryEntryOverhead }
+  for k := range cg.OverflowMap { n += int64( len(k) + 8 ) + gMemoryEntryOverhead }
+  for k,v := range cg.FinalOverflowMap { n += int64( len(k) + len(v.Type) + len(v.Data) ) + gMemoryEntryOverhead }
+  return n
+}
+
+// The sample without its ABV.
+//
+func sample_stub( cg *cgf.CGF ) *cgf.CGF {
+  stub := *cg
+  stub.ABV = nil
+  stub.OverflowMap = nil
+  stub.FinalOverflowMap = nil
+  stub.TileMapLookupCache = nil
+  return &stub
+}
+
+// Start tracking the samples as they're loaded.  budget is in bytes,
+// 0 for no limit.
+//
+func MemoryInit( budget int64, cache_dir string ) error {
+  gMemory = &sample_memory{ budget:budget, cache_dir:cache_dir }
+  if len(cache_dir)>0 {
+    if e := os.MkdirAll( cache_dir, 0755 ) ; e!=nil { return e }
+  }
+  if budget>0 { lightlog.Info( "memory budget %d MB for samples", budget/(1024*1024) ) }
+  return nil
+}
+
+// Account for a sample just added to gCGF, evicting others if it
+// takes the resident samples over budget.
+//
+func (m *sample_memory) loaded( idx int, src sample_source ) {
+  if m==nil { return }
+  defer m.write_cache()
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  cg := gCGF[idx]
+  path_len := make( map[string]int, len(cg.ABV) )
+  for k,v := range cg.ABV { path_len[k] = len(v) }
+
+  m.tick++
+  m.src = append( m.src, src )
+  m.size = append( m.size, sample_size( cg ) )
+  m.resident = append( m.resident, true )
+  m.pin = append( m.pin, 0 )
+  m.last_use = append( m.last_use, m.tick )
+  m.cached = append( m.cached, false )
+  m.caching = append( m.caching, false )
+  m.path_len = append( m.path_len, path_len )
+  m.loading = append( m.loading, &sync.Mutex{} )
+  m.used += m.size[idx]
+
+  if idx==0 { m.pin[0] = 1 }
+
+  m.evict_cold()
+}
+
+// Make the samples resident and keep them so until released.
+//
+func (m *sample_memory) acquire( idx []int ) error {
+  if m==nil { return nil }
+
+  m.mu.Lock()
+  m.tick++
+  missing := []int{}
+  for i:=0; i<len(idx); i++ {
+    m.pin[ idx[i] ]++
+    m.last_use[ idx[i] ] = m.tick
+    if !m.resident[ idx[i] ] { missing = append( missing, idx[i] ) }
+  }
+  m.mu.Unlock()
+
+  for i:=0; i<len(missing); i++ {
+    if e := m.reload( missing[i] ) ; e!=nil {
+      m.release( idx )
+      return ErrInternal( fmt.Errorf("could not load sample %s: %v", gCGFName[ missing[i] ], e) )
+    }
+  }
+  return nil
+}
+
+func (m *sample_memory) release( idx []int ) {
+  if m==nil { return }
+  defer m.write_cache()
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  for i:=0; i<len(idx); i++ { m.pin[ idx[i] ]-- }
+  m.evict_cold()
+}
+
+func (m *sample_memory) reload( idx int ) error {
+  defer m.write_cache()
+  m.loading[idx].Lock()
+  defer m.loading[idx].Unlock()
+
+  m.mu.Lock()
+  resident,src,cached := m.resident[idx],m.src[idx],m.cached[idx]
+  m.mu.Unlock()
+  if resident { return nil }
+
+  if cached { src = sample_source{ fn:m.cache_fn( idx ), gob:true } }
+
+  cg,e := sample_read( src )
+  if e!=nil { return e }
+  if cg.EncodedTileMapMd5Sum != gTileClassVersion {
+    return fmt.Errorf("%s: tile class mismatch (%s != %s)", src.fn, cg.EncodedTileMapMd5Sum, gTileClassVersion)
+  }
+  cg.TileMap = gCGF[0].TileMap
+
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  gCGF[idx] = cg
+  m.size[idx] = sample_size( cg )
+  m.resident[idx] = true
+  m.used += m.size[idx]
+  m.reloads++
+  lightlog.Debug( "reloaded sample %s from %s", gCGFName[idx], src.fn )
+
+  m.evict_cold()
+  return nil
+}
+
+func sample_read( src sample_source ) ( *cgf.CGF, error ) {
+  if !src.gob { return cgf.LoadNoMap( src.fn ) }
+
+  fp,e := os.Open( src.fn )
+  if e!=nil { return nil, e }
+  defer fp.Close()
+
+  cg := cgf.CGF{}
+  if e := gob.NewDecoder( fp ).Decode( &cg ) ; e!=nil { return nil, e }
+  return &cg, nil
+}
+
+func (m *sample_memory) cache_fn( idx int ) string {
+  return filepath.Join( m.cache_dir, fmt.Sprintf("sample-%d.gob", idx) )
+}
+
+// Write the sample to the gob cache (without the shared tile map).
+//
+func (m *sample_memory) cache( idx int, cg *cgf.CGF ) error {
+  x := *cg
+  x.TileMap = nil
+  x.TileMapLookupCache = nil
+
+  fn := m.cache_fn( idx )
+  fp,e := os.Create( fn + ".tmp" )
+  if e!=nil { return e }
+  if e := gob.NewEncoder( fp ).Encode( &x ) ; e!=nil { fp.Close() ; return e }
+  if e := fp.Close() ; e!=nil { return e }
+  return os.Rename( fn + ".tmp", fn )
+}
+
+// Evict the least recently used samples that aren't in use until the
+// resident ones fit the budget.  Must be called with the lock held.
+//
+func (m *sample_memory) evict_cold() {
+  if m.budget<=0 { return }
+
+  for m.used > m.budget {
+    v := -1
+    for i:=0; i<len(m.resident); i++ {
+      if !m.resident[i] || (m.pin[i]>0) { continue }
+      if (v<0) || (m.last_use[i] < m.last_use[v]) { v = i }
+    }
+    if v<0 { return }
+    m.evict( v )
+  }
+}
+
+// Must be called with the lock held.  The gob cache write, if the
+// sample needs one, is left to write_cache.
+//
+func (m *sample_memory) evict( idx int ) {
+  if (len(m.cache_dir)>0) && !m.src[idx].gob && !m.cached[idx] && !m.caching[idx] {
+    m.caching[idx] = true
+    m.pending = append( m.pending, cache_write{ idx:idx, cg:gCGF[idx] } )
+  }
+
+  gCGF[idx] = sample_stub( gCGF[idx] )
+  m.used -= m.size[idx]
+  m.resident[idx] = false
+  m.evictions++
+  lightlog.Debug( "evicted sample %s", gCGFName[idx] )
+}
+
+// Write the samples queued by evict to the gob cache.  Must be called
+// without the lock held.  Holding the sample's loading lock keeps a
+// reload from reading a partly written file, a reload before the
+// write reads the sample's own file instead.
+//
+func (m *sample_memory) write_cache() {
+  m.mu.Lock()
+  pending := m.pending
+  m.pending = nil
+  m.mu.Unlock()
+
+  for i:=0; i<len(pending); i++ {
+    idx := pending[i].idx
+
+    m.loading[idx].Lock()
+    e := m.cache( idx, pending[i].cg )
+
+    m.mu.Lock()
+    m.caching[idx] = false
+    if e!=nil {
+      lightlog.Warn( "could not cache sample %s: %v", gCGFName[idx], e )
+    } else {
+      m.cached[idx] = true
+    }
+    m.mu.Unlock()
+    m.loading[idx].Unlock()
+  }
+}
+
+// Lengths of the sample's ABV, by path, whether it's resident or not.
+//
+func sample_path_lens( idx int ) map[string]int {
+  if gMemory!=nil { return gMemory.path_len[idx] }
+
+  path_len := make( map[string]int, len(gCGF[idx].ABV) )
+  for k,v := range gCGF[idx].ABV { path_len[k] = len(v) }
+  return path_len
+}
+
+func (m *sample_memory) Stats() LanternMemoryStats {
+  if m==nil { return LanternMemoryStats{} }
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  s := LanternMemoryStats{ BudgetBytes:m.budget, ResidentBytes:m.used, SampleCount:len(m.resident), Evictions:m.evictions, Reloads:m.reloads }
+  for i:=0; i<len(m.resident); i++ {
+    if m.resident[i] { s.ResidentSamples++ }
+    if m.pin[i]>0 { s.PinnedSamples++ }
+  }
+  return s
+}
+
+func (req *LanternRequest) resident( idx []int ) error {
+  if e := gMemory.acquire( idx ) ; e!=nil { return e }
+  req.pinned = append( req.pinned, idx... )
+  return nil
+}
+
+func (req *LanternRequest) release_samples() {
+  gMemory.release( req.pinned )
+  req.pinned = nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import "os"
+import "reflect"
+import "testing"
+import "io/ioutil"
+
+func TestMemoryEviction( t *testing.T ) {
+  dir,e := ioutil.TempDir( "", "lantern-memory" )
+  if e!=nil { t.Fatal(e) }
+  defer os.RemoveAll( dir )
+
+  cfg := golden_config( t, dir, "scan" )
+  if e := lantern_load( cfg ) ; e!=nil { t.Fatal(e) }
+
+  m := gMemory
+  if s := m.Stats() ; (s.ResidentSamples!=3) || (s.Evictions!=0) { t.Fatalf("no budget: %+v", s) }
+
+  abv1 := gCGF[1].ABV
+  path_len := sample_path_lens( 1 )
+
+  // Room for sample 0 and one other, sample 1 is the least recently
+  // used.
+  //
+  m.mu.Lock()
+  m.budget = m.size[0] + m.size[1] + m.size[2] - 1
+  m.evict_cold()
+  m.mu.Unlock()
+
+  if m.resident[1] || !m.resident[2] || (gCGF[1].ABV!=nil) { t.Fatalf("sample 1 not evicted: %v", m.resident) }
+  if gCGF[1].TileMap==nil { t.Errorf("evicted sample lost its tile map") }
+  if !reflect.DeepEqual( sample_path_lens( 1 ), path_len ) { t.Errorf("path lengths changed on eviction") }
+
+  if e := m.acquire( []int{ 1 } ) ; e!=nil { t.Fatal(e) }
+  if !reflect.DeepEqual( gCGF[1].ABV, abv1 ) { t.Errorf("reloaded sample differs") }
+  if m.resident[2] { t.Errorf("sample 2 not evicted for sample 1") }
+
+  // Pinned samples stay over budget until released.
+  //
+  if e := m.acquire( []int{ 2 } ) ; e!=nil { t.Fatal(e) }
+  if s := m.Stats() ; (s.ResidentSamples!=3) || (s.PinnedSamples!=3) || (s.ResidentBytes<=s.BudgetBytes) { t.Errorf("pinned: %+v", s) }
+
+  // Evicted samples are written to the gob cache after the lock is
+  // released.
+  //
+  m.mu.Lock()
+  m.cache_dir = dir
+  m.mu.Unlock()
+
+  m.release( []int{ 1, 2 } )
+  if m.resident[1] || !m.resident[2] { t.Errorf("least recently used not evicted: %v", m.resident) }
+  if _,e := os.Stat( m.cache_fn( 1 ) ) ; (e!=nil) || !m.cached[1] || m.caching[1] { t.Errorf("sample 1 not cached: %v", e) }
+
+  s := m.Stats()
+  if (s.Evictions!=3) || (s.Reloads!=2) || (s.PinnedSamples!=1) { t.Errorf("counts: %+v", s) }
+}
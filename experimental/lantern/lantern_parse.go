@@ -76,3 +76,41 @@ func parseIntOption( istr string, base int ) ([][2]int64, error) {
   return r,nil
 }
 
+
+// Parses a genomic range of the form:
+//
+//   chrom:start[-end]
+//
+// where start and end are 1 reference and inclusive, as they
+// would be reported by a genome browser.  Commas in the numbers
+// are ignored.  The returned start and end are 0 reference, end
+// non-inclusive.
+//
+// Some examples:
+//
+// 'chr17:41,196,312'           -> chr17 41196311 41196312
+// '17:41196312-41197000'       -> chr17 41196311 41197000
+//
+func parse_genomic_range( s string ) (chrom string, start, end int, err error) {
+  f := strings.SplitN( s, ":", 2 )
+  if (len(f)!=2) || (len(f[0])==0) { err = fmt.Errorf("invalid genomic range %s", s) ; return }
+  chrom = normalize_chrom( f[0] )
+
+  r := strings.SplitN( strings.Replace( f[1], ",", "", -1 ), "-", 2 )
+
+  a,e := strconv.Atoi( r[0] )
+  if (e!=nil) || (a<1) { err = fmt.Errorf("invalid start in genomic range %s", s) ; return }
+
+  b := a
+  if len(r)==2 {
+    b,e = strconv.Atoi( r[1] )
+    if (e!=nil) || (b<a) { err = fmt.Errorf("invalid end in genomic range %s", s) ; return }
+  }
+
+  start,end = a-1,b
+  return
+}
+
+func is_genomic_range( s string ) bool {
+  return strings.Contains( s, ":" )
+}
@@ -0,0 +1,226 @@
+package main
+
+import "fmt"
+
+import "../bitmap"
+import "../lightlog"
+
+/*
+
+Phase-aware matching for sample-tile-group-match and
+sample-tile-neighborhood.
+
+With "Phase" set in the request, a sample matches only if, on top
+of every group (clause) matching, the alleles the groups match on
+satisfy:
+
+  "cis"   : one allele matches every group
+  "trans" : every pair of groups can be put on different alleles,
+            that is, for each pair one group matches on some allele
+            and the other on another (e.g.
+            [["247.00.0002.0001"],["247.00.0007.0003"]] for a
+            compound heterozygote)
+
+"trans" needs at least two groups. With more than two the rule is
+checked pair by pair, not as one assignment of groups to alleles.
+
+An empty Phase (or "any") is the usual either-allele match.
+
+For a '~' (excluded) tile variant, an allele matches if it doesn't
+carry the variant.
+
+*/
+
+func valid_phase( phase string, n_group int ) error {
+  if (phase=="trans") && (n_group<2) { return ErrInvalidParameter( "Phase", phase, "trans needs at least two groups" ) }
+  if (phase=="") || (phase=="any") || (phase=="cis") || (phase=="trans") { return nil }
+  return ErrInvalidParameter( "Phase", phase, "must be any, cis or trans" )
+}
+
+func phased( phase string ) bool {
+  return (phase=="cis") || (phase=="trans")
+}
+
+func allele_count() int {
+  if (len(gCGF)==0) || (len(gCGF[0].TileMap)==0) { return 2 }
+  return len(gCGF[0].TileMap[0].Variant)
+}
+
+func popcount_int( x int ) int {
+  c := 0
+  for ; x!=0; c++ { x &= x-1 }
+  return c
+}
+
+// Bit a is set if allele a has tile variant starting at path, step
+// (or, if permit is false, if allele a doesn't).
+//
+func allele_variant_mask( cgf_ind, path, step, variant int, permit bool ) int {
+  m := 0
+  allele_tile,e := sample_allele_tiles( cgf_ind, path, step )
+  if e==nil {
+    for a:=0; a<len(allele_tile); a++ {
+      if (allele_tile[a].Step==step) && (allele_tile[a].Variant==variant) { m |= 1<<uint(a) }
+    }
+  }
+  if permit { return m }
+  return ((1<<uint(allele_count()))-1) &^ m
+}
+
+// Check the per group allele masks against the phase.
+//
+func phase_check( phase string, mask []int ) bool {
+  all := -1
+  for g:=0; g<len(mask); g++ {
+    if mask[g]==0 { return false }
+    all &= mask[g]
+  }
+
+  if phase=="cis" { return all!=0 }
+  if phase=="trans" {
+    if len(mask)<2 { return false }
+
+    // Both groups non-empty, so the pair can only be put on different
+    // alleles if between them they cover two.
+    //
+    for g:=0; g<len(mask); g++ {
+      for h:=g+1; h<len(mask); h++ {
+        if popcount_int( mask[g] | mask[h] ) < 2 { return false }
+      }
+    }
+  }
+  return true
+}
+
+func sample_tile_group_match_phased( lg *lightlog.Logger, jb *lantern_job, phase string, sampleIndex []int, tileGroupVariantRange []map[string][]TileRange ) (resSample []int, err error) {
+  n_group := len(tileGroupVariantRange)
+
+  for spos:=0; spos<len(sampleIndex); spos++ {
+    if jb.cancelled() { err = ErrCancelled() ; return }
+    jb.progress( spos, len(sampleIndex) )
+
+    cgf_ind := sampleIndex[spos]
+    mask := make( []int, n_group )
+
+    for g:=0; g<n_group; g++ {
+      for path_step,variantRange := range tileGroupVariantRange[g] {
+        path,step,e := convert_path_step( path_step )
+        if e!=nil { err = e ; return }
+
+        abv,abv_ok := gCGF[cgf_ind].ABV[ fmt.Sprintf("%x", path) ]
+        if !abv_ok { continue }
+        if (step<0) || (step>=int64(len(abv))) { continue }
+
+        for vpos:=0; vpos<len(variantRange); vpos++ {
+          for tile_variant:=variantRange[vpos].Range[0]; tile_variant<variantRange[vpos].Range[1]; tile_variant++ {
+            mask[g] |= allele_variant_mask( cgf_ind, int(path), int(step), tile_variant, variantRange[vpos].Permit )
+          }
+        }
+      }
+
+      if mask[g]==0 { break }
+    }
+
+    lg.Debug("sample %d allele masks %v", cgf_ind, mask)
+
+    if phase_check( phase, mask ) { resSample = append( resSample, cgf_ind ) }
+  }
+
+  return resSample, nil
+}
+
+// Samples with the tile variant on allele.
+//
+func (vi *VariantIndex) allele_variant_samples( path, step, variant, allele int ) *bitmap.Bitmap {
+  e := vi.entries( path, step )
+  for i:=0; i<len(e); i++ {
+    if (e[i].Variant==variant) && (e[i].Allele==allele) { return e[i].Sample }
+  }
+  return bitmap.New( len(gCGF) )
+}
+
+// Bitmap version of sample_tile_group_match_phased.
+//
+func sample_tile_group_match_phased_index( lg *lightlog.Logger, jb *lantern_job, phase string, sampleIndex []int, tileGroupVariantRange []map[string][]TileRange ) (resSample []int, err error) {
+  n := len(gCGF)
+  n_allele := allele_count()
+
+  // Samples matching each group, by allele.
+  //
+  group_allele := make( [][]*bitmap.Bitmap, len(tileGroupVariantRange) )
+
+  for g:=0; g<len(tileGroupVariantRange); g++ {
+    if jb.cancelled() { err = ErrCancelled() ; return }
+    jb.progress( g, len(tileGroupVariantRange) )
+
+    group_allele[g] = make( []*bitmap.Bitmap, n_allele )
+    for a:=0; a<n_allele; a++ { group_allele[g][a] = bitmap.New( n ) }
+
+    for path_step,variantRange := range tileGroupVariantRange[g] {
+      path_64,step_64,e := convert_path_step( path_step )
+      if e!=nil { err = e ; return }
+      path,step := int(path_64),int(step_64)
+
+      var covered *bitmap.Bitmap
+
+      for vpos:=0; vpos<len(variantRange); vpos++ {
+        for tile_variant:=variantRange[vpos].Range[0]; tile_variant<variantRange[vpos].Range[1]; tile_variant++ {
+          for a:=0; a<n_allele; a++ {
+            has := gVariantIndex.allele_variant_samples( path, step, tile_variant, a )
+
+            if variantRange[vpos].Permit {
+              group_allele[g][a] = group_allele[g][a].Or( has )
+            } else {
+              if covered==nil { covered = covered_samples( path, step ) }
+              group_allele[g][a] = group_allele[g][a].Or( covered.AndNot( has ) )
+            }
+          }
+        }
+      }
+    }
+  }
+
+  res := sample_set( sampleIndex )
+
+  // Every group has to match on some allele.
+  //
+  for g:=0; g<len(group_allele); g++ {
+    x := bitmap.New( n )
+    for a:=0; a<n_allele; a++ { x = x.Or( group_allele[g][a] ) }
+    res = res.And( x )
+  }
+
+  if phase=="cis" {
+    x := bitmap.New( n )
+    for a:=0; a<n_allele; a++ {
+      y := bitmap.Full( n )
+      for g:=0; g<len(group_allele); g++ { y = y.And( group_allele[g][a] ) }
+      x = x.Or( y )
+    }
+    res = res.And( x )
+  } else if phase=="trans" {
+    if len(group_allele)<2 { res = bitmap.New( n ) }
+
+    // Every pair of groups on some pair of different alleles.
+    //
+    for g:=0; g<len(group_allele); g++ {
+      for h:=g+1; h<len(group_allele); h++ {
+        x := bitmap.New( n )
+        for a:=0; a<n_allele; a++ {
+          for b:=0; b<n_allele; b++ {
+            if a!=b { x = x.Or( group_allele[g][a].And( group_allele[h][b] ) ) }
+          }
+        }
+        res = res.And( x )
+      }
+    }
+  }
+
+  lg.Debug("phased (%s) match: %d samples", phase, res.Count())
+
+  for i:=0; i<len(sampleIndex); i++ {
+    if res.Contains( sampleIndex[i] ) { resSample = append( resSample, sampleIndex[i] ) }
+  }
+
+  return resSample, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import "io"
+import "net/http"
+import "encoding/json"
+
+import "../cgf"
+import "../pca"
+
+/*
+
+Principal component analysis of a sample set.
+
+Tile variant dosages (0, 1 or 2 copies) for the samples in
+'SampleId' (all loaded samples if empty), restricted to the paths in
+'Path' (hex, parsed with parseIntOption, or a genomic range, see
+lantern_locus.go), are reduced to the top 'Component' principal
+components (10 by default).  Tile variants with a minor frequency in
+the sample set below 'MinFrequency' (0.01 by default) are dropped.
+With 'Gene', only the positions of those genes or regions are used
+(see lantern_gene.go).  See the pca package for the details.
+
+For large sample sets or path ranges, run the request with
+"Async":true.
+
+Example request:
+
+{
+  "Type":"population-pca",
+  "SampleId":[],
+  "Path":"247+3",
+  "Component":2,
+  "MinFrequency":0.05
+}
+
+Example response:
+
+{
+  "Type":"success", "Message":"population-pca",
+  "Result":{
+    "Sample":[ "0:hu011C57.cgf", "1:hu016B28.cgf", ... ],
+    "Coordinate":[ [ 1.92, -0.31 ], [ -0.87, 2.04 ], ... ],
+    "Eigenvalue":[ 3.1, 2.2 ],
+    "VarianceExplained":[ 0.041, 0.029 ],
+    "TileVariantCount":7014,
+    "Iteration":37
+  }
+}
+
+*/
+
+var gPCAMaxSamples int = 5000
+var gPCADefaultComponent int = 10
+
+type PopulationPCA struct {
+  Sample []string
+  Coordinate [][]float64
+  Eigenvalue []float64
+  VarianceExplained []float64
+  TileVariantCount int
+  Iteration int
+}
+
+func population_pca( req *LanternRequest, sampleIndex []int, path_range [][2]int64, keep func( path, step int ) bool ) ( *PopulationPCA, error ) {
+  k := req.Component
+  if k<=0 { k = gPCADefaultComponent }
+
+  opt := pca.DosageOption{ PathRange:path_range, Keep:keep, MinFrequency:req.MinFrequency }
+  if opt.MinFrequency<=0 { opt.MinFrequency = pca.DefaultMinFrequency }
+
+  cgs := make( []*cgf.CGF, len(sampleIndex) )
+  res := &PopulationPCA{}
+  for i:=0; i<len(sampleIndex); i++ {
+    cgs[i] = gCGF[ sampleIndex[i] ]
+    res.Sample = append( res.Sample, gCGFName[ sampleIndex[i] ] )
+  }
+
+  // The dosage matrix is the bulk of the work, the solver is
+  // reported as the last step.
+  //
+  progress := func( done, total int ) { req.job.progress( done, total+1 ) }
+
+  g,e := pca.DosageGram( cgs, gCGF[0].TileMap, opt, progress, req.job.cancelled )
+  if (e!=nil) && req.job.cancelled() { return nil, ErrCancelled() }
+  if e!=nil { return nil, e }
+  req.lg.Debug( "population-pca: %d samples, %d tile variants", len(sampleIndex), g.Feature )
+  if g.Feature==0 { return nil, ErrNotFound( "informative tile variants for", "the samples" ) }
+
+  r,e := g.PCA( k, 0, 0, req.job.cancelled )
+  if (e!=nil) && req.job.cancelled() { return nil, ErrCancelled() }
+  if e!=nil { return nil, e }
+
+  res.Coordinate = r.Coordinate
+  res.Eigenvalue = r.Eigenvalue
+  res.VarianceExplained = r.VarianceExplained
+  res.TileVariantCount = r.Feature
+  res.Iteration = r.Iteration
+  return res, nil
+}
+
+func population_pca_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
+
+  sampleIndex,err := req.sampleIndexArray( req.SampleId )
+  if err!=nil { _erre(w, err) ; return }
+
+  if len(sampleIndex) < 2 {
+    _errc(w, ErrInvalidParameter( "SampleId", req.SampleId, "need at least 2 samples" ))
+    return
+  }
+  if len(sampleIndex) > gPCAMaxSamples {
+    _errc(w, ErrLimitExceeded( "samples", gPCAMaxSamples ))
+    return
+  }
+  if (req.MinFrequency<0) || (req.MinFrequency>0.5) {
+    _errc(w, ErrInvalidParameter( "MinFrequency", req.MinFrequency, "must be in [0,0.5]" ))
+    return
+  }
+
+  path_range,keep,err := request_path_range( req )
+  if err!=nil { _erre(w, err) ; return }
+
+  gene_keep,err := request_gene_filter( req )
+  if err!=nil { _erre(w, err) ; return }
+  keep = both_filter( keep, gene_keep )
+
+  if len(path_range)>0 { req.explain.ranges( "Path", path_range ) }
+  req.explain.parsed()
+
+  res,err := population_pca( req, sampleIndex, path_range, keep )
+  if err!=nil { _erre(w, err) ; return }
+  req.explain.looked_up()
+
+  resp.Type = "success"
+  resp.Message = "population-pca"
+
+  w.Header().Set("Content-Type", "application/json")
+  res_json_bytes,_ := json.Marshal( res )
+
+  io.WriteString(w, "{\n")
+  io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"population-pca\",\n")
+  io.WriteString(w, "  \"Result\": ")
+  io.WriteString(w, string(res_json_bytes))
+  io.WriteString(w, "\n")
+  io.WriteString(w, "}")
+
+}
@@ -0,0 +1,280 @@
+package main
+
+import "os"
+import "io"
+import "fmt"
+import "math"
+import "sync"
+import "net/http"
+import "io/ioutil"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+import "encoding/binary"
+
+import "../lightlog"
+
+/*
+
+Protected mode.
+
+For public facing instances, '--protected' ("Privacy":{"Protected":true}
+in the config file) only releases noisy aggregates:
+
+  variant-frequency         counts and frequencies of explicit tile
+                            variants (no open ended ranges or 'Gene')
+  sample-tile-group-match   the number of matching samples instead of
+                            their names
+  /beacon/query             as usual, with noisy counts
+
+'system-info' leaves out the sample ids, 'tile-sequence',
+'tile-sequence-tracer' and 'gene-tiles' (which only read the tile
+library) work as usual and batches of any of these are allowed.
+Everything else, per sample requests and async jobs included, gets a
+'NotPermitted' error (403).  Queries run over all the loaded samples,
+'SampleId' can't be given, and are refused if there are fewer than
+'MinCohort' samples.
+
+Every request needs an API key in the 'KeyHeader' HTTP header
+(X-Api-Key by default).  Each noisy query spends 'Epsilon' of the
+key's privacy budget, 'Budget' in total, and once that's spent the
+key gets 'PrivacyBudgetExhausted' errors (429).  Queries are charged
+before they run, whatever their outcome.  Keys are tracked by their
+SHA-256 hash and the amounts spent are kept in 'BudgetFile' (if
+given) across restarts.  Budgets are only reset by the operator,
+removing the key from the file while lantern is stopped.
+
+Counts get Laplace noise scaled to the most one sample can change
+all the counts the query releases, divided by 'Epsilon', so each query
+is Epsilon-differentially private:
+
+  variant-frequency         6 per position (sample counts 2, allele
+                            counts 2, called alleles 2)
+  sample-tile-group-match   1
+  /beacon/query             5 (samples 1, calls 2, variants 2)
+
+Noisy counts are rounded and clamped at zero, frequencies are
+computed from the noisy counts.  Cells whose noisy sample count is
+below 'MinCell', and whole positions where the noisy number of called
+alleles is below twice 'MinCohort', are suppressed: their counts are
+reported as 0 with "Suppressed":true (empty cells in tables) and the
+beacon answers "exists":false.
+
+Example config:
+
+  "Privacy":{
+    "Protected":true, "MinCohort":20, "MinCell":5,
+    "Epsilon":0.1, "Budget":10,
+    "KeyHeader":"X-Api-Key", "BudgetFile":"/var/lib/lantern/privacy-budget.json"
+  }
+
+*/
+
+var gPrivacy LanternPrivacyConfig = LanternPrivacyConfig{ MinCohort:20, MinCell:5, Epsilon:0.1, Budget:10, KeyHeader:"X-Api-Key" }
+var gPrivacyBudget *privacy_budget
+
+// Request types allowed in protected mode.  Those set to true
+// release noisy counts and spend budget.
+//
+var gProtectedType map[string]bool = map[string]bool{
+  "system-info" : false,
+  "tile-sequence" : false,
+  "tile-sequence-tracer" : false,
+  "gene-tiles" : false,
+  "batch" : false,
+  "variant-frequency" : true,
+  "sample-tile-group-match" : true,
+}
+
+func protected() bool {
+  return gPrivacy.Protected
+}
+
+// Check a request is allowed in protected mode.  Spending is left
+// to the handlers, once the request has been parsed.
+//
+func privacy_check( req *LanternRequest ) *LanternError {
+  if !protected() { return nil }
+
+  if req.Async { return ErrNotPermitted( "Async", "job results are not protected" ) }
+
+  noisy,ok := gProtectedType[ req.Type ]
+  if !ok { return ErrNotPermitted( req.Type, "not available in protected mode" ) }
+
+  if len(req.api_key)==0 { return ErrUnauthorized( fmt.Sprintf("no API key (%s header)", gPrivacy.KeyHeader) ) }
+
+  if noisy {
+    if len(req.SampleId)>0 { return ErrNotPermitted( "SampleId", "protected queries are over all samples" ) }
+    if le := privacy_cohort() ; le!=nil { return le }
+  }
+  return nil
+}
+
+func privacy_cohort() *LanternError {
+  if len(gCGF) < gPrivacy.MinCohort {
+    return ErrNotPermitted( "cohort", fmt.Sprintf("%d samples, below the minimum of %d", len(gCGF), gPrivacy.MinCohort) )
+  }
+  return nil
+}
+
+// Spend the query's epsilon from the key's budget.
+//
+func privacy_spend( key string ) *LanternError {
+  if gPrivacyBudget==nil { return ErrInternal( fmt.Errorf("privacy budget not set up") ) }
+  return gPrivacyBudget.Spend( key, gPrivacy.Epsilon )
+}
+
+// Uniform in [0,1) from crypto/rand, so the noise can't be predicted
+// from earlier answers.
+//
+func privacy_uniform() float64 {
+  var b [8]byte
+  if _,e := io.ReadFull( rand.Reader, b[:] ) ; e!=nil { panic( fmt.Sprintf("crypto/rand: %v", e) ) }
+  return float64( binary.LittleEndian.Uint64( b[:] ) >> 11 ) / float64( uint64(1) << 53 )
+}
+
+func laplace( scale float64 ) float64 {
+  for {
+    u := privacy_uniform() - 0.5
+    if math.Abs(u) >= 0.5 { continue }
+    if u<0 { return scale * math.Log( 1 + 2*u ) }
+    return -scale * math.Log( 1 - 2*u )
+  }
+}
+
+// Count with Laplace noise for a query whose counts together have L1
+// sensitivity 'sensitivity'.
+//
+func noisy_count( n int, sensitivity float64 ) int {
+  v := math.Floor( float64(n) + laplace( sensitivity/gPrivacy.Epsilon ) + 0.5 )
+  if v<0 { return 0 }
+  return int(v)
+}
+
+// Replace the variant frequencies with noisy ones.  n_pos is the
+// number of positions queried.
+//
+func protect_variant_frequency( res map[string]VariantFrequency, n_pos int ) {
+  sensitivity := float64( 6*n_pos )
+
+  // The called allele count is per position, release one noisy count
+  // for all the variants there.
+  //
+  called := make( map[string]int )
+  for k,vf := range res {
+    pos := k[:len(k)-5]
+    if _,ok := called[pos] ; !ok { called[pos] = noisy_count( vf.CalledAlleleCount, sensitivity ) }
+  }
+
+  for k,vf := range res {
+    x := VariantFrequency{ CalledAlleleCount:called[ k[:len(k)-5] ] }
+    x.SampleCount = noisy_count( vf.SampleCount, sensitivity )
+    x.AlleleCount = noisy_count( vf.AlleleCount, sensitivity )
+
+    if (x.CalledAlleleCount < 2*gPrivacy.MinCohort) || (x.SampleCount < gPrivacy.MinCell) {
+      x = VariantFrequency{ Suppressed:true }
+    } else {
+      x.Frequency = math.Min( 1, float64(x.AlleleCount) / float64(x.CalledAlleleCount) )
+    }
+    res[k] = x
+  }
+}
+
+func protect_beacon( dres *BeaconDatasetAlleleResponse ) {
+  sensitivity := 5.0
+
+  x := BeaconDatasetAlleleResponse{ DatasetId:dres.DatasetId }
+  x.SampleCount = noisy_count( dres.SampleCount, sensitivity )
+  x.CallCount = noisy_count( dres.CallCount, sensitivity )
+  x.VariantCount = noisy_count( dres.VariantCount, sensitivity )
+
+  if (x.CallCount >= 2*gPrivacy.MinCohort) && (x.SampleCount >= gPrivacy.MinCell) {
+    x.Exists = true
+    x.Frequency = math.Min( 1, float64(x.VariantCount) / float64(x.CallCount) )
+  } else {
+    x.SampleCount, x.CallCount, x.VariantCount = 0, 0, 0
+  }
+  *dres = x
+}
+
+type ProtectedCount struct {
+  SampleCount int
+  Suppressed bool `json:",omitempty"`
+}
+
+func protect_count( n int ) ProtectedCount {
+  c := ProtectedCount{ SampleCount:noisy_count( n, 1 ) }
+  if c.SampleCount < gPrivacy.MinCell { c = ProtectedCount{ Suppressed:true } }
+  return c
+}
+
+// Spent budget per API key (SHA-256 hash, so the budget file holds no
+// keys).
+//
+type privacy_budget struct {
+  mu sync.Mutex
+  fn string
+  budget float64
+  spent map[string]float64
+}
+
+func privacy_key_hash( key string ) string {
+  h := sha256.Sum256( []byte(key) )
+  return hex.EncodeToString( h[:] )
+}
+
+func (pb *privacy_budget) Spend( key string, cost float64 ) *LanternError {
+  pb.mu.Lock()
+  defer pb.mu.Unlock()
+
+  // Allow for rounding, so a budget of 1 is ten queries at 0.1.
+  //
+  h := privacy_key_hash( key )
+  if pb.spent[h] + cost > pb.budget + 1e-9 { return ErrBudgetExhausted( pb.spent[h], cost, pb.budget ) }
+  pb.spent[h] += cost
+
+  if e := pb.save() ; e!=nil {
+    pb.spent[h] -= cost
+    lightlog.Error( "privacy budget: %v", e )
+    return ErrInternal( fmt.Errorf("could not record privacy budget") )
+  }
+  return nil
+}
+
+// Must be called with the lock held.
+//
+func (pb *privacy_budget) save() error {
+  if len(pb.fn)==0 { return nil }
+
+  b,e := json.MarshalIndent( pb.spent, "", "  " )
+  if e!=nil { return e }
+
+  tmp_fn := pb.fn + ".tmp"
+  if e := ioutil.WriteFile( tmp_fn, b, 0600 ) ; e!=nil { return e }
+  return os.Rename( tmp_fn, pb.fn )
+}
+
+func PrivacyInit( cfg LanternPrivacyConfig ) error {
+  gPrivacyBudget = nil
+  if !cfg.Protected { return nil }
+
+  pb := &privacy_budget{ fn:cfg.BudgetFile, budget:cfg.Budget, spent:make( map[string]float64 ) }
+  if len(pb.fn)>0 {
+    b,e := ioutil.ReadFile( pb.fn )
+    if (e!=nil) && !os.IsNotExist(e) { return e }
+    if e==nil {
+      if e := json.Unmarshal( b, &pb.spent ) ; e!=nil { return fmt.Errorf("%s: %v", pb.fn, e) }
+    }
+  }
+  gPrivacyBudget = pb
+
+  lightlog.Info( "protected mode, epsilon %g per query, budget %g per key, %d keys seen", cfg.Epsilon, cfg.Budget, len(pb.spent) )
+  return nil
+}
+
+// API key of the HTTP request.
+//
+func request_api_key( r *http.Request ) string {
+  return r.Header.Get( gPrivacy.KeyHeader )
+}
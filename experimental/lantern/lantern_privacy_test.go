@@ -0,0 +1,95 @@
+package main
+
+import "os"
+import "math"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+
+import "../cgf"
+
+func TestLaplace( t *testing.T ) {
+  n,scale := 20000,2.0
+  sum,abs_sum := 0.0,0.0
+  for i:=0; i<n; i++ {
+    x := laplace( scale )
+    sum += x
+    abs_sum += math.Abs(x)
+  }
+
+  // Mean 0, mean absolute deviation 'scale'.
+  //
+  if m := sum/float64(n) ; math.Abs(m) > 0.1 { t.Errorf("mean %f", m) }
+  if d := abs_sum/float64(n) ; math.Abs(d-scale) > 0.1 { t.Errorf("mean absolute deviation %f, expected %f", d, scale) }
+}
+
+func TestPrivacyCheck( t *testing.T ) {
+  prev,prev_cgf := gPrivacy,gCGF
+  defer func() { gPrivacy,gCGF = prev,prev_cgf }()
+
+  gPrivacy.Protected = true
+  gPrivacy.MinCohort = 2
+  gCGF = make( []*cgf.CGF, 3 )
+
+  tab := []struct {
+    req LanternRequest
+    code string
+  }{
+    { LanternRequest{ Type:"variant-frequency", api_key:"k" }, "" },
+    { LanternRequest{ Type:"system-info", api_key:"k" }, "" },
+    { LanternRequest{ Type:"variant-frequency" }, ErrCodeUnauthorized },
+    { LanternRequest{ Type:"sample-position-variant", api_key:"k" }, ErrCodeNotPermitted },
+    { LanternRequest{ Type:"variant-frequency", api_key:"k", Async:true }, ErrCodeNotPermitted },
+    { LanternRequest{ Type:"variant-frequency", api_key:"k", SampleId:[]string{ "0" } }, ErrCodeNotPermitted },
+  }
+  for i,x := range tab {
+    le := privacy_check( &x.req )
+    if (le==nil) && (x.code!="") { t.Errorf("[%d] %s: expected %s", i, x.req.Type, x.code) }
+    if (le!=nil) && (le.Code!=x.code) { t.Errorf("[%d] %s: got %s, expected '%s'", i, x.req.Type, le.Code, x.code) }
+  }
+
+  gPrivacy.MinCohort = 4
+  if le := privacy_check( &tab[0].req ) ; (le==nil) || (le.Code!=ErrCodeNotPermitted) { t.Errorf("small cohort allowed") }
+}
+
+func TestProtectVariantFrequency( t *testing.T ) {
+  prev := gPrivacy
+  defer func() { gPrivacy = prev }()
+  gPrivacy = LanternPrivacyConfig{ Protected:true, MinCohort:10, MinCell:5, Epsilon:1000 }
+
+  res := map[string]VariantFrequency{
+    "247.00.0003.0000" : VariantFrequency{ SampleCount:90, AlleleCount:170, CalledAlleleCount:200 },
+    "247.00.0003.0001" : VariantFrequency{ SampleCount:2, AlleleCount:2, CalledAlleleCount:200 },
+    "247.00.0004.0000" : VariantFrequency{ SampleCount:6, AlleleCount:12, CalledAlleleCount:12 },
+  }
+  protect_variant_frequency( res, 2 )
+
+  if x := res["247.00.0003.0000"] ; x.Suppressed || (x.CalledAlleleCount<190) || (x.Frequency<0.8) || (x.Frequency>0.9) { t.Errorf("common variant %+v", x) }
+  if x := res["247.00.0003.0001"] ; !x.Suppressed || (x.SampleCount!=0) { t.Errorf("rare variant not suppressed %+v", x) }
+  if x := res["247.00.0004.0000"] ; !x.Suppressed { t.Errorf("small cohort position not suppressed %+v", x) }
+}
+
+func TestPrivacyBudget( t *testing.T ) {
+  dir,e := ioutil.TempDir( "", "lantern-privacy" )
+  if e!=nil { t.Fatal(e) }
+  defer os.RemoveAll( dir )
+
+  prev,prev_budget := gPrivacy,gPrivacyBudget
+  defer func() { gPrivacy,gPrivacyBudget = prev,prev_budget }()
+
+  cfg := LanternPrivacyConfig{ Protected:true, Epsilon:0.1, Budget:0.2, BudgetFile:filepath.Join( dir, "budget.json" ) }
+  gPrivacy = cfg
+  if e := PrivacyInit( cfg ) ; e!=nil { t.Fatal(e) }
+
+  for i:=0; i<2; i++ {
+    if le := privacy_spend( "k" ) ; le!=nil { t.Fatalf("spend %d: %v", i, le) }
+  }
+  if le := privacy_spend( "k" ) ; (le==nil) || (le.Code!=ErrCodeBudgetExhausted) { t.Errorf("budget not enforced: %v", le) }
+  if le := privacy_spend( "other" ) ; le!=nil { t.Errorf("other key: %v", le) }
+
+  // Budgets survive a restart.
+  //
+  if e := PrivacyInit( cfg ) ; e!=nil { t.Fatal(e) }
+  if le := privacy_spend( "k" ) ; le==nil { t.Errorf("budget forgotten") }
+  if le := privacy_spend( "other" ) ; le!=nil { t.Errorf("other key: %v", le) }
+}
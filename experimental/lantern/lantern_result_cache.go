@@ -0,0 +1,226 @@
+package main
+
+import "bytes"
+import "sort"
+import "sync"
+import "sync/atomic"
+import "container/list"
+import "net/http"
+import "encoding/json"
+
+/*
+
+Bounded (LRU) cache of complete responses for repeated queries.
+
+The key is the request with the fields that don't change the
+result ('Note' and 'Message') cleared, re-encoded as JSON, with the
+sample lists ('SampleId', 'CaseSampleId' and 'ControlSampleId')
+replaced by the sample indexes they resolve to, sorted and without
+repeats, so the order of the samples, repeated samples and an empty
+list versus all the samples give the same key.  Types whose result
+follows the order of the samples (gResultCacheOrdered) keep the
+resolved indexes in request order instead.  The key also includes
+the dataset and any paging cursor.
+Only successful JSON responses are stored, tables (see
+lantern_table.go) are streamed and not kept.
+
+The cache is cleared whenever the loaded sample set changes (see
+sample_set_changed).
+
+*/
+
+// Request types whose responses only depend on the request and the
+// loaded samples.
+//
+var gResultCacheType map[string]bool = map[string]bool{
+  "sample-tile-group-match" : true,
+  "variant-frequency" : true,
+  "sample-intersect" : true,
+  "sample-position-variant" : true,
+  "sample-tile-neighborhood" : true,
+  "sample-similarity" : true,
+  "population-pca" : true,
+  "sample-sequence" : true,
+  "burden-test" : true,
+}
+
+// Request types whose result lists the samples in the order they
+// were asked for.
+//
+var gResultCacheOrdered map[string]bool = map[string]bool{
+  "sample-tile-group-match" : true,
+  "sample-similarity" : true,
+  "population-pca" : true,
+  "sample-sequence" : true,
+}
+
+type LanternResultCacheStats struct {
+  Hit int
+  Miss int
+  Entries int
+  Bytes int
+  MaxBytes int
+  HitRate float64
+}
+
+type result_cache_entry struct {
+  key string
+  body []byte
+}
+
+type ResultCache struct {
+  mu sync.Mutex
+
+  max_bytes int
+  n_bytes int
+
+  lru *list.List
+  entry map[string]*list.Element
+
+  generation int64
+
+  hit int
+  miss int
+}
+
+var gResultCache *ResultCache
+
+// Incremented every time the loaded samples change.  Only accessed
+// through sync/atomic.
+//
+var gSampleGeneration int64
+
+func sample_set_changed() {
+  atomic.AddInt64( &gSampleGeneration, 1 )
+}
+
+func NewResultCache( max_bytes int ) *ResultCache {
+  return &ResultCache{ max_bytes:max_bytes, lru:list.New(), entry:make( map[string]*list.Element ), generation:atomic.LoadInt64( &gSampleGeneration ) }
+}
+
+// Drop everything cached if the samples changed.  Must be called
+// with the lock held.
+//
+func (rc *ResultCache) check_generation() {
+  generation := atomic.LoadInt64( &gSampleGeneration )
+  if rc.generation == generation { return }
+  rc.lru.Init()
+  rc.entry = make( map[string]*list.Element )
+  rc.n_bytes = 0
+  rc.generation = generation
+}
+
+func (rc *ResultCache) Get( key string ) ([]byte, bool) {
+  rc.mu.Lock()
+  defer rc.mu.Unlock()
+  rc.check_generation()
+
+  ele,ok := rc.entry[key]
+  if !ok {
+    rc.miss++
+    return nil, false
+  }
+
+  rc.hit++
+  rc.lru.MoveToFront( ele )
+  return ele.Value.(*result_cache_entry).body, true
+}
+
+func (rc *ResultCache) Put( key string, body []byte ) {
+  if len(body) > rc.max_bytes { return }
+
+  rc.mu.Lock()
+  defer rc.mu.Unlock()
+  rc.check_generation()
+
+  if _,ok := rc.entry[key] ; ok { return }
+
+  for (rc.n_bytes + len(body)) > rc.max_bytes {
+    back := rc.lru.Back()
+    if back==nil { break }
+    ent := back.Value.(*result_cache_entry)
+    rc.n_bytes -= len(ent.body)
+    delete( rc.entry, ent.key )
+    rc.lru.Remove( back )
+  }
+
+  rc.entry[key] = rc.lru.PushFront( &result_cache_entry{ key:key, body:body } )
+  rc.n_bytes += len(body)
+}
+
+func (rc *ResultCache) Stats() LanternResultCacheStats {
+  rc.mu.Lock()
+  defer rc.mu.Unlock()
+  rc.check_generation()
+
+  s := LanternResultCacheStats{ Hit:rc.hit, Miss:rc.miss, Entries:rc.lru.Len(), Bytes:rc.n_bytes, MaxBytes:rc.max_bytes }
+  if (rc.hit+rc.miss) > 0 { s.HitRate = float64(rc.hit) / float64(rc.hit+rc.miss) }
+  return s
+}
+
+func result_cache_key( req *LanternRequest ) (string, bool) {
+  if !gResultCacheType[ req.Type ] { return "", false }
+  if is_table_format( req ) { return "", false }
+  if protected() { return "", false }
+
+  canon := result_cache_canon{ LanternRequest:*req }
+  canon.Note = ""
+  canon.Message = ""
+
+  var e error
+  ordered := gResultCacheOrdered[ req.Type ]
+  if canon.SampleIndex,e = result_cache_samples( req.SampleId, ordered ) ; e!=nil { return "", false }
+  if canon.CaseSampleIndex,e = result_cache_samples( req.CaseSampleId, ordered ) ; e!=nil { return "", false }
+  if canon.ControlSampleIndex,e = result_cache_samples( req.ControlSampleId, ordered ) ; e!=nil { return "", false }
+  canon.SampleId = nil
+  canon.CaseSampleId = nil
+  canon.ControlSampleId = nil
+
+  b,e := json.Marshal( canon )
+  if e!=nil { return "", false }
+  return string(b), true
+}
+
+type result_cache_canon struct {
+  LanternRequest
+  SampleIndex []int
+  CaseSampleIndex []int
+  ControlSampleIndex []int
+}
+
+// The sample indexes the list resolves to, sorted and without repeats
+// unless ordered.  Unknown samples are left for the handler to report
+// (and aren't cached).
+//
+func result_cache_samples( sampleId []string, ordered bool ) ([]int, error) {
+  idx,e := getSampleIndexArray( sampleId )
+  if e!=nil { return nil, e }
+  if ordered { return idx, nil }
+
+  sort.Ints( idx )
+  n := 0
+  for i:=0; i<len(idx); i++ {
+    if (n>0) && (idx[n-1]==idx[i]) { continue }
+    idx[n] = idx[i]
+    n++
+  }
+  return idx[:n], nil
+}
+
+// Keeps a copy of everything written so it can be cached.
+//
+type result_cache_writer struct {
+  http.ResponseWriter
+  buf bytes.Buffer
+}
+
+func (rw *result_cache_writer) Write( b []byte ) (int, error) {
+  rw.buf.Write( b )
+  return rw.ResponseWriter.Write( b )
+}
+
+func result_cacheable( body []byte ) bool {
+  env := struct { Type string }{}
+  if e := json.Unmarshal( body, &env ) ; e!=nil { return false }
+  return env.Type == "success"
+}
@@ -0,0 +1,34 @@
+package main
+
+import "os"
+import "testing"
+import "io/ioutil"
+
+func TestResultCacheKey( t *testing.T ) {
+  dir,e := ioutil.TempDir( "", "lantern-result-cache" )
+  if e!=nil { t.Fatal(e) }
+  defer os.RemoveAll( dir )
+
+  cfg := golden_config( t, dir, "scan" )
+  if e := lantern_load( cfg ) ; e!=nil { t.Fatal(e) }
+
+  key := func( typ string, sampleId ...string ) string {
+    k,ok := result_cache_key( &LanternRequest{ Type:typ, SampleId:sampleId, Note:"x" } )
+    if !ok { t.Fatalf("%s %v: not cacheable", typ, sampleId) }
+    return k
+  }
+
+  a,b,c := gCGFName[0],gCGFName[1],gCGFName[2]
+
+  all := key( "sample-intersect" )
+  if key( "sample-intersect", c, a, b )!=all { t.Errorf("sample order changes the key") }
+  if key( "sample-intersect", a, b, b, c, a )!=all { t.Errorf("repeated samples change the key") }
+  if key( "sample-intersect", a, b )==all { t.Errorf("different samples give the same key") }
+
+  if key( "sample-similarity", a, b, c )!=key( "sample-similarity" ) { t.Errorf("all samples differ from no samples") }
+  if key( "sample-similarity", b, a, c )==key( "sample-similarity", a, b, c ) { t.Errorf("ordered type ignores sample order") }
+
+  if _,ok := result_cache_key( &LanternRequest{ Type:"sample-intersect", SampleId:[]string{ "nope" } } ) ; ok {
+    t.Errorf("unknown sample cached")
+  }
+}
@@ -2,93 +2,128 @@ package main
 
 import "io"
 import "fmt"
+import "sort"
 import "strconv"
 import "net/http"
+import "encoding/json"
 
-func sample_intersect( sampleIndex []int ) string {
-  no_match := -5
+import "../bitmap"
+import "../lightlog"
 
-  s0 := sampleIndex[0]
-
-  v := make( map[string][]int )
-
-  for path_str,abv := range gCGF[s0].ABV {
-    m:=len(abv)
-    v[path_str] = make( []int, m)
-
-    path_64,_ := strconv.ParseInt( path_str, 16, 64 )
-    path := int(path_64)
+type SampleIntersectResult struct {
+  Found []string
+  FoundCount int
+  DefaultCount int
+  Total int
+}
 
-    for i:=0; i<m; i++ {
+type SampleIntersectPath struct {
+  Path int
+  PathStr string
+}
 
-      if (abv[i] == '#') || (abv[i] == '-') {
-        v[path_str][i],_ = gCGF[s0].LookupABVTileMapVariant( path, i )
-        continue
-      }
+type ByPath []SampleIntersectPath
+func (x ByPath) Len() int { return len(x) }
+func (x ByPath) Swap(i, j int) { x[i],x[j] = x[j],x[i] }
+func (x ByPath) Less(i,j int) bool { return x[i].Path < x[j].Path }
+
+func abv_tile_variant( cgf_ind, path, step int, abv string ) (x int) {
+  if (abv[step] == '#') || (abv[step] == '-') {
+    x,_ = gCGF[cgf_ind].LookupABVTileMapVariant( path, step )
+  } else if  abv[step] == '.' { x = 0
+  } else if (abv[step] <= '9') && (abv[step] >= '0') { x = int(abv[step]-'0')
+  } else if (abv[step] <= 'Z') && (abv[step] >= 'A') { x = int(abv[step]-'A')
+  } else if (abv[step] <= 'z') && (abv[step] >= 'z') { x = int(abv[step]-'z') }
+  return
+}
 
-      if abv[i] == '.' { v[path_str][i] = 0
-      } else if (abv[i] <= '9') && (abv[i] >= '0') { v[path_str][i] = int(abv[i]-'0')
-      } else if (abv[i] <= 'Z') && (abv[i] >= 'A') { v[path_str][i] = int(abv[i]-'A')
-      } else if (abv[i] <= 'z') && (abv[i] >= 'z') { v[path_str][i] = int(abv[i]-'z') }
+// Walk the tile positions of the first sample in path, step order,
+// starting at the cursor, and collect the positions where every
+// sample has the same tile variant.
+//
+// If limit is greater than 0, at most limit matching non-default
+// positions are collected and, if there are more, the cursor to
+// resume from is returned.
+//
+// keep, if not nil, restricts the walk to the tile positions it
+// accepts.
+//
+// Progress is reported per path to jb, which may be nil.  A cancelled
+// job gets ErrCancelled.
+//
+func sample_intersect( lg *lightlog.Logger, jb *lantern_job, sampleIndex []int, cur LanternCursor, limit int, keep func( path, step int ) bool ) (res SampleIntersectResult, next *LanternCursor, err error) {
 
-    }
+  s0 := sampleIndex[0]
 
+  paths := make( []SampleIntersectPath, 0, len(gCGF[s0].ABV) )
+  for path_str := range gCGF[s0].ABV {
+    path_64,_ := strconv.ParseInt( path_str, 16, 64 )
+    paths = append( paths, SampleIntersectPath{ int(path_64), path_str } )
   }
+  sort.Sort( ByPath( paths ) )
 
-  for s:=1; s<len(sampleIndex); s++ {
-    sample_ind := sampleIndex[s]
+  debug_flag := lightlog.Enabled( lightlog.DEBUG )
 
-    for path_str,abv := range gCGF[sample_ind].ABV {
-      m:=len(abv)
+  // With the variant index, a position matches if no sample has a
+  // different tile class there.
+  //
+  var sset *bitmap.Bitmap
+  if gVariantIndex!=nil { sset = sample_set( sampleIndex ) }
 
-      path_64,_ := strconv.ParseInt( path_str, 16, 64 )
-      path := int(path_64)
+  for pi:=0; pi<len(paths); pi++ {
+    if jb.cancelled() { err = ErrCancelled() ; return }
+    jb.progress( pi, len(paths) )
 
-      mm := m
-      if mm > len(v[path_str]) { mm = len(v[path_str]) }
+    path := paths[pi].Path
+    path_str := paths[pi].PathStr
+    if path < cur.Path { continue }
 
-      for i:=0; i<mm; i++ {
-        if v[path_str][i] == no_match { continue }
+    abv0 := gCGF[s0].ABV[path_str]
 
-        var x int
+    beg_step := 0
+    if path == cur.Path { beg_step = cur.Step }
 
-        if (abv[i] == '#') || (abv[i] == '-') {
-          x,_ = gCGF[sample_ind].LookupABVTileMapVariant( path, i )
-        } else if  abv[i] == '.' { x = 0
-        } else if (abv[i] <= '9') && (abv[i] >= '0') { x = int(abv[i]-'0')
-        } else if (abv[i] <= 'Z') && (abv[i] >= 'A') { x = int(abv[i]-'A')
-        } else if (abv[i] <= 'z') && (abv[i] >= 'z') { x = int(abv[i]-'z') }
+    path_found := ""
 
-        if v[path_str][i] != x { v[path_str][i] = no_match }
-      }
-    }
+    for step:=beg_step; step<len(abv0); step++ {
+      if (keep!=nil) && !keep( path, step ) { continue }
+      x0 := abv_tile_variant( s0, path, step, abv0 )
 
-  }
+      match := true
+      if gVariantIndex!=nil {
+        match = gVariantIndex.same_variant( path, step, x0, sset )
+      } else {
+        for s:=1; (s<len(sampleIndex)) && match; s++ {
+          abv,ok := gCGF[ sampleIndex[s] ].ABV[path_str]
+          if !ok || (step>=len(abv)) { continue }
+          if abv_tile_variant( sampleIndex[s], path, step, abv ) != x0 { match = false }
+        }
+      }
 
-  ll := 0
-  found_count := 0
-  default_count := 0
+      if match && (x0 > 0) && (limit > 0) && (len(res.Found) >= limit) {
+        next = &LanternCursor{ Hash: cur.Hash, Path: path, Step: step }
+        return
+      }
 
-  for path_str,_ := range v {
+      res.Total++
+      if !match { continue }
 
-    fmt.Printf(" %s: [[", path_str)
-    for i:=0; i<len(v[path_str]); i++  {
-      if v[path_str][i] > 0 {
-        fmt.Printf(" (%x.%d)", i, v[path_str][i])
-        found_count++
-      } else if v[path_str][i] == 0 {
-        default_count++
+      if x0 > 0 {
+        res.Found = append( res.Found, fmt.Sprintf("%03x.%02x.%04x.%04x", path, 0, step, x0) )
+        res.FoundCount++
+        if debug_flag { path_found += fmt.Sprintf(" (%x.%d)", step, x0) }
+      } else if x0 == 0 {
+        res.DefaultCount++
       }
     }
-    fmt.Printf(" ]]\n")
 
-    ll += len(v[path_str])
+    if debug_flag { lg.Debug("%s: [[%s ]]", path_str, path_found) }
 
   }
 
-  fmt.Printf("  total: %d / %d, default %d / %d\n", found_count, ll, default_count, ll  )
+  lg.Debug("total: %d / %d, default %d / %d", res.FoundCount, res.Total, res.DefaultCount, res.Total )
 
-  return fmt.Sprintf("{ \"Message\":\"total %d / %d, default %d / %d\" }", found_count, ll, default_count, ll )
+  return
 
 }
 
@@ -98,19 +133,47 @@ func sample_intersect_handler( w http.ResponseWriter, resp *LanternResponse, req
   resp.Message = "testing tile-variant"
 
 
-  sampleIndex,err := getSampleIndexArray( req.SampleId )
+  sampleIndex,err := req.sampleIndexArray( req.SampleId )
   _ = sampleIndex
   if err!=nil {
-    fmt.Printf("ERROR: %v\n", err )
-    resp.Type = "error" ; resp.Message = fmt.Sprintf("%v", err)
+    req.lg.Info("%v", err )
+    _erre(w, err)
     return
   }
 
-  str := sample_intersect( sampleIndex )
+  if len(sampleIndex)==0 { _errc(w, ErrInvalidParameter( "SampleId", "", "no samples" )) ; return }
+
+  cur,limit,err := request_page( req )
+  if err!=nil { _erre(w, err) ; return }
+
+  keep,err := request_gene_filter( req )
+  if err!=nil { _erre(w, err) ; return }
+
+  res,next,err := sample_intersect( req.lg, req.job, sampleIndex, cur, limit, keep )
+  if err!=nil { _erre(w, err) ; return }
 
   w.Header().Set("Content-Type", "application/json")
-  //res_json_bytes,_ := json.Marshal( nameList )
-  io.WriteString( w, str )
 
-}
+  // Without a limit, only the summary is returned.
+  //
+  if limit==0 {
+    io.WriteString( w, fmt.Sprintf("{ \"Message\":\"total %d / %d, default %d / %d\" }",
+      res.FoundCount, res.Total, res.DefaultCount, res.Total ) )
+    return
+  }
+
+  if res.Found == nil { res.Found = []string{} }
+  res_json_bytes,_ := json.Marshal( res.Found )
 
+  io.WriteString(w, "{\n")
+  io.WriteString(w, fmt.Sprintf("  \"Type\":\"success\", \"Message\":\"total %d / %d, default %d / %d\",\n",
+    res.FoundCount, res.Total, res.DefaultCount, res.Total ) )
+  if next!=nil {
+    io.WriteString(w, fmt.Sprintf("  \"Cursor\":\"%s\",\n", next.Encode()) )
+  }
+  io.WriteString(w, "  \"Result\": ")
+  io.WriteString(w, string(res_json_bytes))
+  io.WriteString(w, "\n")
+  io.WriteString(w, "}")
+
+}
@@ -22,6 +22,33 @@ Example request:
   "Position" : [ "247.00.0000", "247.00.0003-000f" ]
 }
 
+Positions can also be given as genomic ranges ("chrom:start-end",
+1 reference, inclusive) in the request 'Assembly', provided the
+tile loci for that assembly were loaded with '--tile-locus':
+
+{
+  "Type":"sample-position-variant",
+  "SampleId" : [ ... ],
+  "Assembly" : "hg19",
+  "Position" : [ "chr17:41,196,312-41,197,000" ]
+}
+
+An assembly that isn't loaded gives an 'UnknownAssembly' error.
+
+Gene and region names (see lantern_gene.go) can be given in
+'Position' or in 'Gene':
+
+{
+  "Type":"sample-position-variant",
+  "SampleId" : [ ... ],
+  "Gene" : [ "BRCA1" ]
+}
+
+With "Format":"tsv" or "csv", the tile variants are streamed as a
+table (sample, allele, tile variant) as they're found, and up to
+gTableMaxElements sample positions can be asked for (see
+lantern_table.go).
+
 Example response:
 
 {
@@ -71,9 +98,10 @@ func sample_position_variant_handler( w http.ResponseWriter, resp *LanternRespon
 
   n_ele := 0
   max_elements := 20000
+  if is_table_format( req ) { max_elements = gTableMaxElements }
 
-  sampleIndex,err := getSampleIndexArray( req.SampleId )
-  if err!=nil { _errp(w) ; return }
+  sampleIndex,err := req.sampleIndexArray( req.SampleId )
+  if err!=nil { _erre(w, err) ; return }
 
   // result is a map of sampleid to an array of maps.
   // Position for the array of maps represents the allele.
@@ -93,15 +121,55 @@ func sample_position_variant_handler( w http.ResponseWriter, resp *LanternRespon
     }
   }
 
+  // Positions are given either as hex tile coordinates
+  // ("path.version.step"), as a genomic range in the request
+  // Assembly ("chrom:start-end") or as a gene or region name
+  // (see lantern_gene.go).  Any genes in 'Gene' are added.
+  //
+  tilePosition := [][2]int{}
+
+  if len(req.Gene)>0 {
+    pos,e := gene_position( req.Gene )
+    if e!=nil { _erre(w, e) ; return }
+
+    n_ele += len(pos)*len(sampleIndex)
+    if n_ele >= max_elements { _errc(w, ErrLimitExceeded( "elements", max_elements )) ; return }
+
+    tilePosition = append( tilePosition, pos... )
+  }
+
   for i:=0; i<len(req.Position); i++ {
+
+    if is_gene_name( req.Position[i] ) {
+      pos,e := gene_position( req.Position[i:i+1] )
+      if e!=nil { _erre(w, e) ; return }
+
+      n_ele += len(pos)*len(sampleIndex)
+      if n_ele >= max_elements { _errc(w, ErrLimitExceeded( "elements", max_elements )) ; return }
+
+      tilePosition = append( tilePosition, pos... )
+      continue
+    }
+
+    if is_genomic_range( req.Position[i] ) {
+      pos,e := request_range_position( req, req.Position[i] )
+      if e!=nil { _erre(w, e) ; return }
+
+      n_ele += len(pos)*len(sampleIndex)
+      if n_ele >= max_elements { _errc(w, ErrLimitExceeded( "elements", max_elements )) ; return }
+
+      tilePosition = append( tilePosition, pos... )
+      continue
+    }
+
     pvs := strings.SplitN( req.Position[i], ".", 3 )
-    if len(pvs)!=3 { _errp(w) ; return }
+    if len(pvs)!=3 { _errc(w, ErrInvalidPosition( req.Position[i], "expected path.version.step or chrom:start-end" )) ; return }
 
     path_range,e := parseIntOption( pvs[0], 16 )
-    if e!= nil { _errp(w) ; return }
+    if e!= nil { _errc(w, ErrInvalidPosition( req.Position[i], "invalid path" )) ; return }
 
     step_range,e := parseIntOption( pvs[2], 16 )
-    if e!= nil { _errp(w) ; return }
+    if e!= nil { _errc(w, ErrInvalidPosition( req.Position[i], "invalid step" )) ; return }
 
     for pi:=0; pi<len(path_range); pi++ {
       for path:=path_range[pi][0]; path<path_range[pi][1]; path++ {
@@ -109,61 +177,89 @@ func sample_position_variant_handler( w http.ResponseWriter, resp *LanternRespon
         for si:=0; si<len(step_range); si++ {
           for step:=step_range[si][0]; step<step_range[si][1]; step++ {
 
-            for k:=0; k<len(sampleIndex); k++ {
-              cgf_ind := sampleIndex[k]
-              name := gCGFName[cgf_ind]
+            n_ele += len(sampleIndex)
+            if n_ele >= max_elements { _errc(w, ErrLimitExceeded( "elements", max_elements )) ; return }
+
+            tilePosition = append( tilePosition, [2]int{ int(path), int(step) } )
+          }
+        }
+
+      }
+    }
+
+  }
+
+  req.explain.ranges( "Position", position_range_string( tilePosition ) )
+  req.explain.positions( len(tilePosition) )
+  req.explain.parsed()
 
-              n_ele ++
-              if n_ele >= max_elements { _errm(w) ; return }
+  // Tables are written out as the tile variants are found, JSON
+  // results are collected first.
+  //
+  var tw *table_writer
+  if is_table_format( req ) { tw = new_table_writer( w, req ) }
 
-              p,s,tmv,e := gCGF[cgf_ind].LookupABVStartTileMapVariant( int(path), int(step) )
-              if e!=nil { continue }
+  seen_pos := make( map[[2]int]bool )
 
-              str_path := fmt.Sprintf("%x", path)
-              abv := gCGF[cgf_ind].ABV[str_path]
-              if (step>=0) && (int(step)<len(abv)) {
-                fmt.Printf(">>>> len(abv) %d, path %x (%s) step %x %s\n", len(abv), int(path), str_path, step, abv[step:step+1]  )
-              }
+  for i:=0; i<len(tilePosition); i++ {
+    if req.job.cancelled() { _errc(w, ErrCancelled()) ; return }
+    req.job.progress( i, len(tilePosition) )
 
-              fmt.Printf(">>> tmv %d lentilemap %d \n", tmv, len(gCGF[0].TileMap) )
+    if seen_pos[ tilePosition[i] ] { continue }
+    seen_pos[ tilePosition[i] ] = true
 
-              if tmv<0 {
-                fmt.Printf(">>> WARNING tmv (%d) < 0! for %s, path %x, step %x\n", tmv, name, int(path), int(step) )
-                continue
-              }
+    path := tilePosition[i][0]
+    step := tilePosition[i][1]
 
-              tme := gCGF[0].TileMap[tmv]
+    for k:=0; k<len(sampleIndex); k++ {
+      cgf_ind := sampleIndex[k]
+      name := gCGFName[cgf_ind]
 
-              for allele:=0; allele<len(tme.Variant); allele++ {
-                x := 0
-                for v_ind:=0; v_ind<len(tme.Variant[allele]); v_ind++ {
-                  if (p==int(path)) && ((s+x)==int(step)) {
+      p,s,tmv,e := gCGF[cgf_ind].LookupABVStartTileMapVariant( path, step )
+      if e!=nil { continue }
 
-                    len_opt_str := ""
-                    if tme.VariantLength[allele][v_ind] > 1 {
-                      len_opt_str = fmt.Sprintf("+%x", tme.VariantLength[allele][v_ind])
-                    }
+      str_path := fmt.Sprintf("%x", path)
+      abv := gCGF[cgf_ind].ABV[str_path]
+      if (step>=0) && (step<len(abv)) {
+        req.lg.Debug("len(abv) %d, path %x (%s) step %x %s", len(abv), path, str_path, step, abv[step:step+1]  )
+      }
 
-                    //result_tileid := fmt.Sprintf("%03x.%02x.%04x.%04x", p, library_version, s+x, tme.Variant[allele][v_ind] )
-                    result_tileid := fmt.Sprintf("%03x.%02x.%04x.%04x%s",
-                      p,
-                      library_version,
-                      s+x,
-                      tme.Variant[allele][v_ind],
-                      len_opt_str )
+      req.lg.Debug("tmv %d lentilemap %d", tmv, len(gCGF[0].TileMap) )
 
-                    result[name][allele][result_tileid] = true
-                    break
-                  }
-                  x += tme.VariantLength[allele][v_ind]
-                }
-              }
+      if tmv<0 {
+        req.lg.Warn("tmv (%d) < 0! for %s, path %x, step %x", tmv, name, path, step )
+        continue
+      }
 
+      tme := gCGF[0].TileMap[tmv]
+
+      for allele:=0; allele<len(tme.Variant); allele++ {
+        x := 0
+        for v_ind:=0; v_ind<len(tme.Variant[allele]); v_ind++ {
+          if (p==path) && ((s+x)==step) {
+
+            len_opt_str := ""
+            if tme.VariantLength[allele][v_ind] > 1 {
+              len_opt_str = fmt.Sprintf("+%x", tme.VariantLength[allele][v_ind])
             }
 
+            //result_tileid := fmt.Sprintf("%03x.%02x.%04x.%04x", p, library_version, s+x, tme.Variant[allele][v_ind] )
+            result_tileid := fmt.Sprintf("%03x.%02x.%04x.%04x%s",
+              p,
+              library_version,
+              s+x,
+              tme.Variant[allele][v_ind],
+              len_opt_str )
+
+            if tw!=nil {
+              tw.Row( name, fmt.Sprintf("%d", allele), result_tileid )
+            } else {
+              result[name][allele][result_tileid] = true
+            }
+            break
           }
+          x += tme.VariantLength[allele][v_ind]
         }
-
       }
 
     }
@@ -174,6 +270,9 @@ func sample_position_variant_handler( w http.ResponseWriter, resp *LanternRespon
   resp.Type = "success"
   resp.Message = "system-info"
 
+  if tw!=nil { tw.Flush() ; return }
+  req.explain.looked_up()
+
   fin_result := map[string][][]string{}
 
   for name := range result {
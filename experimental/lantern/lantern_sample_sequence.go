@@ -0,0 +1,169 @@
+package main
+
+import "io"
+import "fmt"
+import "sort"
+import "strings"
+import "strconv"
+import "net/http"
+import "encoding/json"
+
+import "../cgf"
+
+/*
+
+Sequence of a sample over a tile path and step range, one FASTA
+record per allele.
+
+The sample is the single entry in 'SampleId', the path is 'Path'
+(hex) and the steps are 'Step' (hex, parsed with parseIntOption, so
+"10+20" is the 0x20 steps from 0x10, "10-" runs to the end of the
+path).  With no 'Step' the whole path is returned.  Alternatively,
+'Path' can be a genomic range (see lantern_locus.go) or 'Gene' can
+name genes or regions (see lantern_gene.go), and the steps covering
+them are returned ('Step' is ignored); they have to be on a single
+path.
+
+Tiles are stitched together on their shared tags.  No-call tiles
+are masked with 'N' over the length of the reference tile.  If the
+range starts inside a spanning tile, the sequence starts at the
+beginning of that tile.
+
+Example request:
+
+{
+  "Type":"sample-sequence",
+  "SampleId":[ "0:hu011C57.cgf" ],
+  "Path":"247",
+  "Step":"0+3"
+}
+
+Example response:
+
+{
+  "Type":"success", "Message":"sample-sequence",
+  "Result":">0:hu011C57.cgf allele=0 path=247 step=0000-0003\nagctagctt...\n>0:hu011C57.cgf allele=1 path=247 step=0000-0003\nagctagctt...\n"
+}
+
+*/
+
+var gSampleSequenceMaxStep int = 4096
+var gFastaWidth int = 60
+
+func sample_sequence( req *LanternRequest, cgf_ind, path, beg, end int ) ( string, error ) {
+  allele_tiles,e := gCGF[cgf_ind].AlleleTiles( gCGF[0].TileMap, path, beg, end )
+  if e!=nil { return "", e }
+
+  n_tile := 0
+  for a:=0; a<len(allele_tiles); a++ { n_tile += len(allele_tiles[a]) }
+
+  fasta := ""
+  done := 0
+
+  for a:=0; a<len(allele_tiles); a++ {
+    tiles := allele_tiles[a]
+    if len(tiles)==0 { continue }
+
+    seq := make( []string, len(tiles) )
+    nocall := make( []bool, len(tiles) )
+
+    for i:=0; i<len(tiles); i++ {
+      if req.job.cancelled() { return "", ErrCancelled() }
+      req.job.progress( done, n_tile )
+      done++
+
+      // The reference tile gives the length to mask a no-call with.
+      //
+      variant := tiles[i].Variant
+      if tiles[i].NoCall { variant = 0 }
+
+      tileid := fmt.Sprintf("%03x.%02x.%04x.%04x", path, 0, tiles[i].Step, variant)
+      s,e := req.tileSeq( tileid )
+      if e!=nil { return "", ErrNotFound( "tile", tileid ) }
+
+      seq[i] = s
+      nocall[i] = tiles[i].NoCall
+    }
+
+    last := tiles[len(tiles)-1]
+    header := fmt.Sprintf("%s allele=%d path=%03x step=%04x-%04x",
+      gCGFName[cgf_ind], a, path, tiles[0].Step, last.Step+last.Length)
+    fasta += cgf.FastaRecord( header, cgf.StitchTileSequence( seq, nocall ), gFastaWidth )
+  }
+
+  return fasta, nil
+}
+
+func sample_sequence_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
+
+  if len(req.SampleId)!=1 { _errc(w, ErrInvalidParameter( "SampleId", req.SampleId, "sample-sequence takes exactly one sample" )) ; return }
+
+  sampleIndex,err := req.sampleIndexArray( req.SampleId )
+  if err!=nil { _erre(w, err) ; return }
+
+  // Steps covering the genes or the genomic range in 'Path'.
+  //
+  var range_pos [][2]int
+  if len(req.Gene)>0 {
+    range_pos,err = gene_position( req.Gene )
+    if err!=nil { _erre(w, err) ; return }
+    if len(range_pos)==0 { _errc(w, ErrNotFound( "tiles for", strings.Join( req.Gene, "," ) )) ; return }
+    if range_pos[0][0]!=range_pos[len(range_pos)-1][0] { _errc(w, ErrInvalidParameter( "Gene", req.Gene, "spans more than one path" )) ; return }
+  } else if is_locus_range( req.Path ) {
+    range_pos,err = request_range_position( req, req.Path )
+    if err!=nil { _erre(w, err) ; return }
+    if len(range_pos)==0 { _errc(w, ErrNotFound( "tiles for", req.Path )) ; return }
+    sort.Sort( ByPathStep( range_pos ) )
+    if range_pos[0][0]!=range_pos[len(range_pos)-1][0] { _errc(w, ErrInvalidParameter( "Path", req.Path, "spans more than one path" )) ; return }
+  }
+
+  path := 0
+  if len(range_pos)>0 {
+    path = range_pos[0][0]
+  } else {
+    path_64,err := strconv.ParseInt( req.Path, 16, 64 )
+    if err!=nil { _errc(w, ErrInvalidParameter( "Path", req.Path, "must be a hex path" )) ; return }
+    path = int(path_64)
+  }
+
+  abv,ok := gCGF[ sampleIndex[0] ].ABV[ fmt.Sprintf("%x", path) ]
+  if !ok { _errc(w, ErrNotFound( "path", fmt.Sprintf("%x", path) )) ; return }
+
+  beg,end := 0,len(abv)
+  if len(range_pos)>0 {
+    beg,end = range_pos[0][1],range_pos[len(range_pos)-1][1]+1
+    if end>len(abv) { end = len(abv) }
+  } else if len(req.Step)>0 {
+    r,e := parseIntOption( req.Step, 16 )
+    if (e!=nil) || (len(r)!=1) { _errc(w, ErrInvalidParameter( "Step", req.Step, "must be a single hex step range" )) ; return }
+    beg,end = int(r[0][0]),int(r[0][1])
+    if (end<0) || (end>len(abv)) { end = len(abv) }
+  }
+
+  if (end-beg) > gSampleSequenceMaxStep {
+    _errc(w, ErrLimitExceeded( "steps", gSampleSequenceMaxStep ))
+    return
+  }
+
+  req.explain.ranges( "Step", [2]int{ beg, end } )
+  req.explain.positions( end-beg )
+  req.explain.parsed()
+
+  fasta,err := sample_sequence( req, sampleIndex[0], path, beg, end )
+  if err!=nil { _erre(w, err) ; return }
+  req.explain.looked_up()
+
+  resp.Type = "success"
+  resp.Message = "sample-sequence"
+
+  w.Header().Set("Content-Type", "application/json")
+  res_json_bytes,_ := json.Marshal( fasta )
+
+  io.WriteString(w, "{\n")
+  io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"sample-sequence\",\n")
+  io.WriteString(w, "  \"Result\": ")
+  io.WriteString(w, string(res_json_bytes))
+  io.WriteString(w, "\n")
+  io.WriteString(w, "}")
+
+}
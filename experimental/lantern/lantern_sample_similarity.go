@@ -0,0 +1,259 @@
+package main
+
+import "io"
+import "fmt"
+import "sort"
+import "strconv"
+import "net/http"
+import "encoding/json"
+
+/*
+
+Pairwise identity-by-state between samples.
+
+For every pair of samples in 'SampleId' (all loaded samples if
+empty), every tile position both samples have called is compared,
+optionally restricted to the paths in 'Path' (hex, parsed with
+parseIntOption, so "247,2c5+3" is path 247 and paths 2c5 through
+2c7, the end of a '-' range is exclusive, or a genomic range, see
+lantern_locus.go) and to the positions of the genes or regions in
+'Gene' (see lantern_gene.go).
+
+Spanning tiles are compared at the step they start at, positions
+inside a spanning tile are skipped.
+
+For each pair:
+
+  Compared     : number of tile positions compared
+  IBS0/1/2     : positions sharing 0, 1 or 2 tile variants
+  Concordance  : fraction of positions with identical tile
+                 map entries (same variants, same phase)
+  Kinship      : KING-robust style kinship estimate,
+
+                   ( N(het,het same) - 2 N(IBS0, both hom) ) / ( N(het i) + N(het j) )
+
+                 about 0.5 for duplicates, 0.25 for first degree
+                 relatives and 0 for unrelated samples
+
+The pairs can also be had as a TSV or CSV table with 'Format' (see
+lantern_table.go).
+
+Example request:
+
+{
+  "Type":"sample-similarity",
+  "SampleId":[ "0:hu011C57.cgf", "1:hu016B28.cgf", "2:hu0D879F.cgf" ],
+  "Path":"247+3"
+}
+
+Example response:
+
+{
+  "Type":"success", "Message":"sample-similarity",
+  "Result":[
+    { "SampleA":"0:hu011C57.cgf", "SampleB":"1:hu016B28.cgf", "Compared":10712, "IBS0":102, "IBS1":2270, "IBS2":8340, "Concordance":0.77, "Kinship":0.012 },
+    ...
+  ]
+}
+
+*/
+
+var gSimilarityMaxSamples int = 500
+
+type SampleSimilarity struct {
+  SampleA string
+  SampleB string
+
+  Compared int
+  IBS0 int
+  IBS1 int
+  IBS2 int
+
+  Concordance float64
+  Kinship float64
+
+  concordant int
+  het_het int
+  hom_ibs0 int
+  het_a int
+  het_b int
+}
+
+type sample_genotype struct {
+  called bool
+  code int
+  v [2]int
+}
+
+// Tile variants starting at path, step for the sample.
+//
+func abv_genotype( cgf_ind, path, step int, abv string ) (g sample_genotype) {
+  cg := gCGF[cgf_ind]
+
+  code := cg.CharMap[ abv[step:step+1] ]
+  if code == -2 {
+    var e error
+    code,e = cg.LookupABVTileMapVariant( path, step )
+    if e!=nil { return }
+  }
+  if (code<0) || (code>=len(gCGF[0].TileMap)) { return }
+
+  tme := gCGF[0].TileMap[code]
+  if (len(tme.Variant)==0) || (len(tme.Variant[0])==0) { return }
+
+  g.called = true
+  g.code = code
+  g.v[0] = tme.Variant[0][0]
+  g.v[1] = g.v[0]
+  if (len(tme.Variant)>1) && (len(tme.Variant[1])>0) { g.v[1] = tme.Variant[1][0] }
+
+  if (g.v[0]<0) || (g.v[1]<0) { g.called = false }
+  return
+}
+
+// Number of tile variants shared (as a multiset) between a and b.
+//
+func ibs( a, b [2]int ) int {
+  if (a[0]==b[0]) && (a[1]==b[1]) { return 2 }
+  if (a[0]==b[1]) && (a[1]==b[0]) { return 2 }
+  if (a[0]==b[0]) || (a[0]==b[1]) || (a[1]==b[0]) || (a[1]==b[1]) { return 1 }
+  return 0
+}
+
+func path_in_range( path int, path_range [][2]int64 ) bool {
+  if len(path_range)==0 { return true }
+  for i:=0; i<len(path_range); i++ {
+    if (int64(path) >= path_range[i][0]) && ((path_range[i][1] < 0) || (int64(path) < path_range[i][1])) { return true }
+  }
+  return false
+}
+
+// keep, if not nil, restricts the comparison to the tile positions it
+// returns true for.
+//
+func sample_similarity( req *LanternRequest, sampleIndex []int, path_range [][2]int64, keep func( path, step int ) bool ) ( []SampleSimilarity, error ) {
+  n := len(sampleIndex)
+
+  res := []SampleSimilarity{}
+  for i:=0; i<n; i++ {
+    for j:=i+1; j<n; j++ {
+      res = append( res, SampleSimilarity{ SampleA:gCGFName[ sampleIndex[i] ], SampleB:gCGFName[ sampleIndex[j] ] } )
+    }
+  }
+  if n<2 { return res, nil }
+
+  paths := []int{}
+  for path_str := range gCGF[ sampleIndex[0] ].ABV {
+    p,e := strconv.ParseInt( path_str, 16, 64 )
+    if e!=nil { continue }
+    if path_in_range( int(p), path_range ) { paths = append( paths, int(p) ) }
+  }
+  sort.Ints( paths )
+
+  gt := make( []sample_genotype, n )
+
+  for pi:=0; pi<len(paths); pi++ {
+    if req.job.cancelled() { return nil, ErrCancelled() }
+    req.job.progress( pi, len(paths) )
+
+    path := paths[pi]
+    path_str := fmt.Sprintf("%x", path)
+
+    abv := make( []string, n )
+    n_step := 0
+    for i:=0; i<n; i++ {
+      abv[i] = gCGF[ sampleIndex[i] ].ABV[path_str]
+      if len(abv[i]) > n_step { n_step = len(abv[i]) }
+    }
+
+    for step:=0; step<n_step; step++ {
+      if (keep!=nil) && !keep( path, step ) { continue }
+
+      for i:=0; i<n; i++ {
+        gt[i] = sample_genotype{}
+        if step < len(abv[i]) { gt[i] = abv_genotype( sampleIndex[i], path, step, abv[i] ) }
+      }
+
+      k := 0
+      for i:=0; i<n; i++ {
+        for j:=i+1; j<n; j++ {
+          r := &(res[k])
+          k++
+
+          a,b := gt[i],gt[j]
+          if !a.called || !b.called { continue }
+
+          r.Compared++
+          if a.code == b.code { r.concordant++ }
+
+          het_a := a.v[0]!=a.v[1]
+          het_b := b.v[0]!=b.v[1]
+          if het_a { r.het_a++ }
+          if het_b { r.het_b++ }
+
+          switch ibs( a.v, b.v ) {
+          case 0:
+            r.IBS0++
+            if !het_a && !het_b { r.hom_ibs0++ }
+          case 1:
+            r.IBS1++
+          case 2:
+            r.IBS2++
+            if het_a && het_b { r.het_het++ }
+          }
+        }
+      }
+    }
+  }
+
+  for k:=0; k<len(res); k++ {
+    r := &(res[k])
+    if r.Compared > 0 { r.Concordance = float64(r.concordant) / float64(r.Compared) }
+    if (r.het_a+r.het_b) > 0 {
+      r.Kinship = float64( r.het_het - 2*r.hom_ibs0 ) / float64( r.het_a + r.het_b )
+    }
+  }
+
+  return res, nil
+}
+
+func sample_similarity_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
+
+  sampleIndex,err := req.sampleIndexArray( req.SampleId )
+  if err!=nil { _erre(w, err) ; return }
+
+  if len(sampleIndex) > gSimilarityMaxSamples {
+    _errc(w, ErrLimitExceeded( "samples", gSimilarityMaxSamples ))
+    return
+  }
+
+  path_range,keep,err := request_path_range( req )
+  if err!=nil { _erre(w, err) ; return }
+
+  gene_keep,err := request_gene_filter( req )
+  if err!=nil { _erre(w, err) ; return }
+  keep = both_filter( keep, gene_keep )
+
+  if len(path_range)>0 { req.explain.ranges( "Path", path_range ) }
+  req.explain.parsed()
+
+  res,err := sample_similarity( req, sampleIndex, path_range, keep )
+  if err!=nil { _erre(w, err) ; return }
+  req.explain.looked_up()
+
+  resp.Type = "success"
+  resp.Message = "sample-similarity"
+
+  if is_table_format( req ) { sample_similarity_table( w, req, res ) ; return }
+
+  w.Header().Set("Content-Type", "application/json")
+  res_json_bytes,_ := json.Marshal( res )
+
+  io.WriteString(w, "{\n")
+  io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"sample-similarity\",\n")
+  io.WriteString(w, "  \"Result\": ")
+  io.WriteString(w, string(res_json_bytes))
+  io.WriteString(w, "\n")
+  io.WriteString(w, "}")
+
+}
@@ -5,6 +5,8 @@ import "fmt"
 import "net/http"
 import "encoding/json"
 
+import "../lightlog"
+
 
 
 //----------------------------------------------------------------------------------------------------------------------------------
@@ -21,11 +23,10 @@ func sample_tile_group_match_handler( w http.ResponseWriter, resp *LanternRespon
   resp.Type = "success"
   resp.Message = "testing sample-tile-group-match"
 
-  sampleIndex,err := getSampleIndexArray( req.SampleId )
-  if err!=nil {
-    resp.Type = "error" ; resp.Message = fmt.Sprintf("%v", err)
-    return
-  }
+  sampleIndex,err := req.sampleIndexArrayIndexed( req.SampleId )
+  if err!=nil { _erre(w, err) ; return }
+
+  if len(req.Gene)>0 { _errc(w, ErrInvalidParameter( "Gene", req.Gene, "give gene names in 'TileGroupVariantId'" )) ; return }
 
   //tileGroupRange := make( []map[string][][2]int, 0, 8 )
   tileGroupRange := make( []map[string][]TileRange, 0, 8 )
@@ -34,36 +35,67 @@ func sample_tile_group_match_handler( w http.ResponseWriter, resp *LanternRespon
   //
   for g:=0; g<len(req.TileGroupVariantId); g++ {
 
-    tileRange,e := unpack_tile_list( req.TileGroupVariantId[g] )
-    if e!=nil {
-      resp.Type = "error" ; resp.Message = fmt.Sprintf("%v", e)
-      return
-    }
+    tile_variant_id,e := request_tile_variant_id( req, req.TileGroupVariantId[g] )
+    if e!=nil { _erre(w, e) ; return }
+
+    tileRange,e := unpack_tile_list( tile_variant_id )
+    if e!=nil { _erre(w, e) ; return }
 
     tileGroupRange = append( tileGroupRange, tileRange )
 
   }
 
 
-  //DEBUG
-  fmt.Printf(">>> sampleIndex %v\n", sampleIndex )
+  req.lg.Debug("sampleIndex %v", sampleIndex )
 
-  for g:=0; g<len(tileGroupRange); g++ {
-    tileRange := tileGroupRange[g]
-    for k,v := range tileRange {
-      fmt.Printf("...>>> [%d] tileRange %v %v\n", g, k, v)
+  if lightlog.Enabled( lightlog.DEBUG ) {
+    for g:=0; g<len(tileGroupRange); g++ {
+      tileRange := tileGroupRange[g]
+      for k,v := range tileRange {
+        req.lg.Debug("[%d] tileRange %v %v", g, k, v)
+      }
     }
   }
 
 
-  resSample, err := sample_tile_group_match( sampleIndex, tileGroupRange )
+  if e := valid_phase( req.Phase, len(tileGroupRange) ) ; e!=nil { _erre(w, e) ; return }
+
+  req.explain.ranges( "TileGroupVariantId", tileGroupRange )
+  for g:=0; g<len(tileGroupRange); g++ { req.explain.positions( len(tileGroupRange[g]) ) }
+  req.explain.parsed()
+
+  if protected() {
+    if le := privacy_spend( req.api_key ) ; le!=nil { _errc(w, le) ; return }
+  }
+
+  var resSample []int
+  if phased( req.Phase ) && (gVariantIndex!=nil) {
+    resSample, err = sample_tile_group_match_phased_index( req.lg, req.job, req.Phase, sampleIndex, tileGroupRange )
+  } else if phased( req.Phase ) {
+    resSample, err = sample_tile_group_match_phased( req.lg, req.job, req.Phase, sampleIndex, tileGroupRange )
+  } else if gVariantIndex!=nil {
+    resSample, err = sample_tile_group_match_index( req.lg, req.job, sampleIndex, tileGroupRange )
+  } else {
+    resSample, err = sample_tile_group_match( req.lg, req.job, sampleIndex, tileGroupRange )
+  }
+
+  req.explain.looked_up()
 
-  if err!=nil {
+  if err!=nil { _erre(w, err) ; return }
+
+  // Only the (noisy) number of matching samples in protected mode,
+  // see lantern_privacy.go.
+  //
+  if protected() {
     w.Header().Set("Content-Type", "application/json")
+    res_json_bytes,_ := json.Marshal( protect_count( len(resSample) ) )
+
     io.WriteString(w, "{\n")
-    io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"sample-tile-group-match\" \n")
+    io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"sample-tile-group-match\",\n")
+    io.WriteString(w, "  \"Result\":")
+    io.WriteString(w, string(res_json_bytes))
+    io.WriteString(w, "\n")
     io.WriteString(w, "}")
-
     return
   }
 
@@ -73,7 +105,7 @@ func sample_tile_group_match_handler( w http.ResponseWriter, resp *LanternRespon
   }
 
 /*
-  fmt.Printf("got: %v --> %v", resSample, nameList)
+  req.lg.Debug("got: %v --> %v", resSample, nameList)
 
   w.Header().Set("Content-Type", "application/json")
   res_json_bytes,_ := json.Marshal( nameList )
@@ -124,13 +156,22 @@ func sample_tile_group_match_handler( w http.ResponseWriter, resp *LanternRespon
 // To get back all samples that have "247.0.2.0" AND "247.0.3.1", this would be the query:
 // [ [ "247.0.2.0" ], [ "247.0.3.1" ] ]
 //
+// The path, version and step can be given as a genomic range instead, e.g.
+// [ [ "chr17:41196312-41197000.1" ] ] (see lantern_locus.go).
+//
+// A request 'Phase' of "cis" or "trans" further restricts which
+// alleles the groups can match on (see lantern_phase.go).
+//
 
-func sample_tile_group_match( sampleIndex []int, tileGroupVariantRange []map[string][]TileRange ) (resSample []int, err error)  {
+func sample_tile_group_match( lg *lightlog.Logger, jb *lantern_job, sampleIndex []int, tileGroupVariantRange []map[string][]TileRange ) (resSample []int, err error)  {
 
   n_group := len(tileGroupVariantRange)
   res_count := make( []int, len(sampleIndex) )
 
   for spos:=0; spos<len(sampleIndex); spos++ {
+    if jb.cancelled() { err = ErrCancelled() ; return }
+    jb.progress( spos, len(sampleIndex) )
+
     cgf_ind := sampleIndex[spos]
 
     variant_so_far := 0
@@ -175,7 +216,7 @@ func sample_tile_group_match( sampleIndex []int, tileGroupVariantRange []map[str
 
   for spos:=0; spos<len(sampleIndex); spos++ {
 
-    fmt.Printf("sample_tile_match>>> res_count[%d]:%d\n", spos, res_count[spos] )
+    lg.Debug("sample_tile_match res_count[%d]:%d", spos, res_count[spos] )
 
     if res_count[spos] == n_group {
       resSample = append( resSample, sampleIndex[spos] )
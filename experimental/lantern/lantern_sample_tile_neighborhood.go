@@ -11,6 +11,8 @@ import "sort"
 import "strings"
 import "strconv"
 
+import "../lightlog"
+
 
 /*
 
@@ -20,12 +22,17 @@ The function should answer queries of the form:
 
 TileId consists of an array of array objects, where each object
 consistes of a TileId (range)  and an array of beginning and end
-indexes.  The last index is non-inclusive.
+indexes.  The last index is non-inclusive.  The path, version and
+step of a TileId can be a genomic range instead
+("chr17:41196312-41197000.0001", see lantern_locus.go).
 
 Similar to the sample-tile-group-match, results are returned
 only if there was at least one match from each group.  All
 matched elements in each group are returned.
 
+A 'Phase' of "cis" or "trans" requires the groups to match on
+the same, or on different, alleles (see lantern_phase.go).
+
 Example request:
 
 {
@@ -111,7 +118,10 @@ func parseBoolInt4( dotstr4 string, base int ) (permit_flag bool,a,b,c,d int64,
 // cnf holds a list of clauses, where each clause is a tile id and a range.  The range is to
 // construct the resulting neighborhood.
 
-func find_tile_match_set( cgf_ind int, cnf []map[string][2]int ) ( matchTile map[string]bool, resInterval map[string][2]int, err error ) {
+// If phase is "cis" or "trans", the clauses must also match on the
+// alleles required (see phase_check).
+//
+func find_tile_match_set( lg *lightlog.Logger, cgf_ind int, phase string, cnf []map[string][2]int ) ( matchTile map[string]bool, resInterval map[string][2]int, err error ) {
   ABV := gCGF[cgf_ind].ABV
 
   still_matching := true
@@ -121,6 +131,8 @@ func find_tile_match_set( cgf_ind int, cnf []map[string][2]int ) ( matchTile map
 
   err = nil
 
+  mask := make( []int, len(cnf) )
+
   for c:=0; c<len(cnf); c++ {
     if !still_matching { return nil, nil, nil }
 
@@ -136,8 +148,7 @@ func find_tile_match_set( cgf_ind int, cnf []map[string][2]int ) ( matchTile map
       permit_ch := ""
       if !permit_flag { permit_ch = "~" }
 
-      //DEBUG
-      fmt.Printf("  tileId %v, path %v, ver %v, step %v, variant %v, e %v\n",
+      lg.Debug("tileId %v, path %v, ver %v, step %v, variant %v, e %v",
         tileId, path, ver, step, variant, e)
 
       str_hex_path := fmt.Sprintf("%x", path)
@@ -153,6 +164,10 @@ func find_tile_match_set( cgf_ind int, cnf []map[string][2]int ) ( matchTile map
         matchedTileId := fmt.Sprintf("%s%03x.%02x.%04x.%04x", permit_ch, path,ver,step,variant)
         matchTile[ matchedTileId ] = true
         resInterval[ matchedTileId ] = [2]int{ cnf[c][tileId][0], cnf[c][tileId][1] }
+
+        if phased( phase ) {
+          mask[c] |= allele_variant_mask( cgf_ind, int(path), int(step), int(variant), permit_flag )
+        }
         continue
       }
 
@@ -177,6 +192,7 @@ func find_tile_match_set( cgf_ind int, cnf []map[string][2]int ) ( matchTile map
   }
 
   if !still_matching { return nil,nil,nil }
+  if phased( phase ) && !phase_check( phase, mask ) { return nil,nil,nil }
 
   return
 
@@ -206,32 +222,32 @@ func unpack_tileid_range_into_tile_list( tileIdRange string ) ( tileList []strin
 
   psv := strings.SplitN( tileIdRange, ".", 5 )
   if len(psv) != 4 {
-    return nil, fmt.Errorf("Invalid tile %s", tileIdRange )
+    return nil, ErrInvalidTileId( tileIdRange, "expected path.version.step.variant" )
   }
 
-  if len(psv[0]) == 0 { return nil, fmt.Errorf("Invalid path (0 length)") }
+  if len(psv[0]) == 0 { return nil, ErrInvalidTileId( tileIdRange, "empty path" ) }
   path_str_start := 0 ; permit_flag := true
   if psv[0][0]=='~' { path_str_start=1 ; permit_flag = false }
 
   //path_range,e := parseIntOption( psv[0], 16 )
   path_range,e := parseIntOption( psv[0][path_str_start:], 16 )
   if e!=nil {
-    return nil, fmt.Errorf("Invalid path in %s", tileIdRange )
+    return nil, ErrInvalidTileId( tileIdRange, "invalid path" )
   }
 
   version_range,e := parseIntOption( psv[1], 16 ) ; _ = version_range
   if e!=nil {
-    return nil, fmt.Errorf("Invalid version in %s", tileIdRange )
+    return nil, ErrInvalidTileId( tileIdRange, "invalid version" )
   }
 
   step_range,e := parseIntOption( psv[2], 16 )
   if e!=nil {
-    return nil, fmt.Errorf("Invalid step in %s", tileIdRange )
+    return nil, ErrInvalidTileId( tileIdRange, "invalid step" )
   }
 
   variant_range,e := parseIntOption( psv[3], 16 )
   if e!=nil {
-    return nil, fmt.Errorf("Invalid variant in %s", tileIdRange )
+    return nil, ErrInvalidTileId( tileIdRange, "invalid variant" )
   }
 
   for i:=0 ; i < len(path_range); i++ {
@@ -351,8 +367,12 @@ func construct_result_set( cgf_ind int, base_set map[string][2]int ) ( []map[str
 
 func sample_tile_neighborhood_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
 
-  sampleIndex, err := getSampleIndexArray( req.SampleId ) ; _ = sampleIndex
-  if err!=nil { _errp(w) ; return }
+  sampleIndex, err := req.sampleIndexArray( req.SampleId ) ; _ = sampleIndex
+  if err!=nil { _erre(w, err) ; return }
+
+  if e := valid_phase( req.Phase, len(req.TileGroupVariantIdRange) ) ; e!=nil { _erre(w, e) ; return }
+
+  if len(req.Gene)>0 { _errc(w, ErrInvalidParameter( "Gene", req.Gene, "give gene names in 'TileGroupVariantIdRange'" )) ; return }
 
   tgvir := req.TileGroupVariantIdRange
   tileGroupRange := make( []map[string][2]int, len(tgvir) )
@@ -369,8 +389,15 @@ func sample_tile_neighborhood_handler( w http.ResponseWriter, resp *LanternRespo
       ele_map := clause[ele_ind]
 
       for tileIdRange,matchedInterval := range ele_map {
-        tileList,e := unpack_tileid_range_into_tile_list( tileIdRange )
-        if e!=nil { _errp(w) ; return }
+        tileIdList,e := request_tile_variant_id( req, []string{ tileIdRange } )
+        if e!=nil { _erre(w, e) ; return }
+
+        tileList := []string{}
+        for k:=0; k<len(tileIdList); k++ {
+          x,e := unpack_tileid_range_into_tile_list( tileIdList[k] )
+          if e!=nil { _erre(w, e) ; return }
+          tileList = append( tileList, x... )
+        }
 
         for k:=0; k<len(tileList); k++ {
           mm := [2]int{ matchedInterval[0], matchedInterval[1] }
@@ -381,20 +408,23 @@ func sample_tile_neighborhood_handler( w http.ResponseWriter, resp *LanternRespo
     }
   }
 
+  req.explain.ranges( "TileGroupVariantIdRange", tileGroupRange )
+  for g:=0; g<len(tileGroupRange); g++ { req.explain.positions( len(tileGroupRange[g]) ) }
+  req.explain.parsed()
+
   //result := make( map[string][]string )
   result := make( map[string][][]string )
 
   for ii:=0; ii<len(sampleIndex); ii++ {
+    if req.job.cancelled() { _errc(w, ErrCancelled()) ; return }
+    req.job.progress( ii, len(sampleIndex) )
 
+    req.lg.Debug("tileGroupRange %v", tileGroupRange)
 
-    //DEBUG
-    fmt.Printf(">>> tileGroupRange %v\n", tileGroupRange)
-
-    match_set,result_map,e := find_tile_match_set( sampleIndex[ii], tileGroupRange )
+    match_set,result_map,e := find_tile_match_set( req.lg, sampleIndex[ii], req.Phase, tileGroupRange )
     if e!=nil { _erre(w, e) ; return }
 
-    //DEBUG
-    fmt.Printf("match_set: %v\nresult_set: %v\n", match_set, result_map)
+    req.lg.Debug("match_set: %v result_set: %v", match_set, result_map)
 
     _ = match_set
 
@@ -419,6 +449,7 @@ func sample_tile_neighborhood_handler( w http.ResponseWriter, resp *LanternRespo
       sort.Sort( ByString( result[name][allele] ) )
     }
   }
+  req.explain.looked_up()
 
 
   w.Header().Set("Content-Type", "application/json")
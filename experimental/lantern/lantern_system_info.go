@@ -15,6 +15,8 @@ type LanternInfo struct {
   CGFVersion string
 
   Stats LanternTileStats
+  ResultCache LanternResultCacheStats
+  Memory LanternMemoryStats
 
   SampleId []string
 
@@ -28,7 +30,10 @@ func system_info_handler(  w http.ResponseWriter, resp *LanternResponse, req *La
   info.TileMapVersion = gCGF[0].EncodedTileMapMd5Sum
   info.CGFVersion = gCGF[0].CGFVersion
   info.Stats = gLanternTileStats
+  if gResultCache!=nil { info.ResultCache = gResultCache.Stats() }
+  info.Memory = gMemory.Stats()
   info.SampleId = gCGFName
+  if protected() { info.SampleId = []string{} }
 
   resp.Type = "success"
   resp.Message = "system-info"
@@ -0,0 +1,240 @@
+package main
+
+import "fmt"
+import "sort"
+import "bufio"
+import "strconv"
+import "strings"
+import "net/http"
+import "encoding/csv"
+
+import "../burden"
+
+/*
+
+Tabular (TSV or CSV) results.
+
+Results are JSON unless the request 'Format' is "tsv" or "csv", or,
+with no 'Format', the HTTP 'Accept' header prefers
+"text/tab-separated-values" or "text/csv".  Tables have a header
+line with the column names below, one row per line after it.
+Columns are only ever added at the end.
+
+Only an explicit 'Format' is an error (InvalidParameter) for request
+types without a table or with 'Explain' set.  A table asked for by
+'Accept' falls back to JSON for those, or fails with NotAcceptable
+(406) if the header doesn't accept JSON ("application/json" or a
+wildcard) either.
+
+  sample-position-variant
+    sample, allele, tile_variant
+
+    One row per tile variant found, in the order of the positions
+    asked for, then the samples.
+
+  variant-frequency
+    tile_variant, sample_count, allele_count, called_allele_count, frequency
+
+    Sorted by tile variant.
+
+  sample-similarity
+    sample_a, sample_b, compared, ibs0, ibs1, ibs2, concordance, kinship
+
+  burden-test
+    gene, qualifying_variants, case_carriers, cases, control_carriers,
+    controls, odds_ratio, p_value, permutations, permutation_p_value
+
+    The permutation columns are empty without permutations.
+
+TSV fields have tabs and newlines replaced by spaces, CSV is quoted
+as needed (RFC 4180).  Errors are still reported as JSON, with the
+error status.  Rows are written as they're produced and flushed to the
+client every gTableFlushRows rows, so large exports aren't held in
+memory.  For sample-position-variant the element limit is raised to
+gTableMaxElements.
+
+Tabular responses aren't kept in the result cache and can't be used
+in a batch.  Async jobs keep the format, job-result returns the table.
+
+Other request types only return JSON.
+
+*/
+
+var gTableFlushRows int = 4096
+var gTableMaxElements int = 10000000
+
+var gTableColumn map[string][]string = map[string][]string{
+  "sample-position-variant" : []string{ "sample", "allele", "tile_variant" },
+  "variant-frequency" : []string{ "tile_variant", "sample_count", "allele_count", "called_allele_count", "frequency" },
+  "sample-similarity" : []string{ "sample_a", "sample_b", "compared", "ibs0", "ibs1", "ibs2", "concordance", "kinship" },
+  "burden-test" : []string{ "gene", "qualifying_variants", "case_carriers", "cases", "control_carriers", "controls",
+    "odds_ratio", "p_value", "permutations", "permutation_p_value" },
+}
+
+var gTableContentType map[string]string = map[string]string{
+  "tsv" : "text/tab-separated-values; charset=utf-8",
+  "csv" : "text/csv; charset=utf-8",
+}
+
+func is_table_format( req *LanternRequest ) bool {
+  return (req.Format=="tsv") || (req.Format=="csv")
+}
+
+// Does the request have a table form.
+//
+func table_form( req *LanternRequest ) bool {
+  _,ok := gTableColumn[ req.Type ]
+  return ok && !req.Explain
+}
+
+// Pick the format from an HTTP Accept header, "" if it doesn't ask for
+// one of the table formats over JSON.  Without a table form (table
+// false) the format is always "", and ok is false if the header asks
+// for a table but doesn't accept JSON.
+//
+func accept_format( accept string, table bool ) (format string, ok bool) {
+  best,best_q := "",0.0
+  named_table,json_q := false,0.0
+
+  for _,media := range strings.Split( accept, "," ) {
+    parts := strings.Split( media, ";" )
+    mt := strings.ToLower( strings.TrimSpace( parts[0] ) )
+
+    q := 1.0
+    for _,param := range parts[1:] {
+      kv := strings.SplitN( strings.TrimSpace( param ), "=", 2 )
+      if (len(kv)==2) && (kv[0]=="q") {
+        if f,e := strconv.ParseFloat( kv[1], 64 ) ; e==nil { q = f }
+      }
+    }
+
+    f := ""
+    switch mt {
+    case "text/tab-separated-values": f = "tsv"
+    case "text/csv": f = "csv"
+    case "application/json": f = "json"
+    case "application/*", "*/*":
+      if q > json_q { json_q = q }
+      continue
+    default: continue
+    }
+
+    if f=="json" {
+      if q > json_q { json_q = q }
+    } else {
+      named_table = true
+    }
+
+    if q > best_q { best,best_q = f,q }
+  }
+
+  if !table { return "", !named_table || (json_q>0) }
+  if best=="json" { return "", true }
+  return best, true
+}
+
+func check_format( req *LanternRequest ) *LanternError {
+  switch req.Format {
+  case "", "json": return nil
+  case "tsv", "csv":
+  default:
+    return ErrInvalidParameter( "Format", req.Format, "must be json, tsv or csv" )
+  }
+
+  if _,ok := gTableColumn[ req.Type ] ; !ok {
+    return ErrInvalidParameter( "Format", req.Format, fmt.Sprintf("%s only returns json", req.Type) )
+  }
+  if req.batch!=nil {
+    return ErrInvalidParameter( "Format", req.Format, "not available in a batch" )
+  }
+  if req.Explain {
+    return ErrInvalidParameter( "Explain", req.Explain, "not available for tsv or csv" )
+  }
+  return nil
+}
+
+type table_writer struct {
+  w http.ResponseWriter
+  bw *bufio.Writer
+  cw *csv.Writer
+  n_row int
+}
+
+// Start a table for the request, writing the header line.
+//
+func new_table_writer( w http.ResponseWriter, req *LanternRequest ) *table_writer {
+  tw := &table_writer{ w:w }
+  w.Header().Set("Content-Type", gTableContentType[ req.Format ])
+
+  if req.Format=="csv" {
+    tw.cw = csv.NewWriter( w )
+  } else {
+    tw.bw = bufio.NewWriter( w )
+  }
+
+  tw.write( gTableColumn[ req.Type ] )
+  return tw
+}
+
+var gTSVField *strings.Replacer = strings.NewReplacer( "\t", " ", "\n", " ", "\r", " " )
+
+func (tw *table_writer) write( field []string ) {
+  if tw.cw!=nil {
+    tw.cw.Write( field )
+    return
+  }
+  for i:=0; i<len(field); i++ {
+    if i>0 { tw.bw.WriteByte( '\t' ) }
+    tw.bw.WriteString( gTSVField.Replace( field[i] ) )
+  }
+  tw.bw.WriteByte( '\n' )
+}
+
+func (tw *table_writer) Row( field ...string ) {
+  tw.write( field )
+  tw.n_row++
+  if (tw.n_row % gTableFlushRows)==0 { tw.Flush() }
+}
+
+// Send everything written so far on to the client.
+//
+func (tw *table_writer) Flush() {
+  if tw.cw!=nil { tw.cw.Flush() } else { tw.bw.Flush() }
+  if f,ok := tw.w.(http.Flusher) ; ok { f.Flush() }
+}
+
+func itoa( x int ) string { return strconv.Itoa( x ) }
+func ftoa( x float64 ) string { return strconv.FormatFloat( x, 'g', -1, 64 ) }
+
+func variant_frequency_table( w http.ResponseWriter, req *LanternRequest, res map[string]VariantFrequency ) {
+  tileid := make( []string, 0, len(res) )
+  for k := range res { tileid = append( tileid, k ) }
+  sort.Strings( tileid )
+
+  tw := new_table_writer( w, req )
+  for _,k := range tileid {
+    vf := res[k]
+    if vf.Suppressed { tw.Row( k, "", "", "", "" ) ; continue }
+    tw.Row( k, itoa(vf.SampleCount), itoa(vf.AlleleCount), itoa(vf.CalledAlleleCount), ftoa(vf.Frequency) )
+  }
+  tw.Flush()
+}
+
+func sample_similarity_table( w http.ResponseWriter, req *LanternRequest, res []SampleSimilarity ) {
+  tw := new_table_writer( w, req )
+  for _,x := range res {
+    tw.Row( x.SampleA, x.SampleB, itoa(x.Compared), itoa(x.IBS0), itoa(x.IBS1), itoa(x.IBS2), ftoa(x.Concordance), ftoa(x.Kinship) )
+  }
+  tw.Flush()
+}
+
+func burden_test_table( w http.ResponseWriter, req *LanternRequest, res []burden.Result ) {
+  tw := new_table_writer( w, req )
+  for _,x := range res {
+    perm,perm_p := "",""
+    if x.Permutation>0 { perm,perm_p = itoa(x.Permutation),ftoa(x.PermutationPValue) }
+    tw.Row( x.Name, itoa(x.QualifyingVariant), itoa(x.CaseCarrier), itoa(x.CaseCount), itoa(x.ControlCarrier), itoa(x.ControlCount),
+      ftoa(x.OddsRatio), ftoa(x.PValue), perm, perm_p )
+  }
+  tw.Flush()
+}
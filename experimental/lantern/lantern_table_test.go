@@ -0,0 +1,80 @@
+package main
+
+import "strings"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+
+func TestAcceptFormat( t *testing.T ) {
+  tab := []struct {
+    accept string
+    format string
+  }{
+    { "", "" },
+    { "*/*", "" },
+    { "application/json", "" },
+    { "text/tab-separated-values", "tsv" },
+    { "text/csv; charset=utf-8", "csv" },
+    { "application/json, text/csv", "json" },
+    { "application/json;q=0.5, text/csv", "csv" },
+    { "text/csv;q=0.2, text/tab-separated-values;q=0.8, application/json;q=0.1", "tsv" },
+    { "text/html, TEXT/CSV", "csv" },
+  }
+
+  for _,x := range tab {
+    expect := x.format
+    if expect=="json" { expect = "" }
+    if f,ok := accept_format( x.accept, true ) ; (f!=expect) || !ok {
+      t.Errorf("%q: got %q, expected %q", x.accept, f, expect)
+    }
+  }
+
+  // Without a table form, JSON if it's acceptable.
+  //
+  json_tab := []struct {
+    accept string
+    ok bool
+  }{
+    { "", true },
+    { "text/html", true },
+    { "text/csv, application/json;q=0.5", true },
+    { "text/csv, */*;q=0.1", true },
+    { "text/csv", false },
+    { "text/tab-separated-values, application/json;q=0", false },
+  }
+
+  for _,x := range json_tab {
+    if f,ok := accept_format( x.accept, false ) ; (f!="") || (ok!=x.ok) {
+      t.Errorf("%q without a table: got %q %v, expected %v", x.accept, f, ok, x.ok)
+    }
+  }
+}
+
+func TestAcceptNotAcceptable( t *testing.T ) {
+  prev := health()
+  defer set_health( prev.Status, prev.Message )
+  set_health( HealthReady, "" )
+
+  if g_incr==nil {
+    g_incr = make( chan int )
+    go func() { g_incr <- 0 }()
+  }
+
+  tab := []struct {
+    accept string
+    body string
+    status int
+  }{
+    { "text/csv, application/json;q=0.5", `{"Type":"job-status","JobId":"nope"}`, http.StatusNotFound },
+    { "text/csv", `{"Type":"job-status","JobId":"nope"}`, http.StatusNotAcceptable },
+    { "text/csv", `{"Type":"job-status","JobId":"nope","Format":"csv"}`, http.StatusBadRequest },
+  }
+
+  for _,x := range tab {
+    hreq := httptest.NewRequest( "POST", "/", strings.NewReader( x.body ) )
+    hreq.Header.Set( "Accept", x.accept )
+    rec := httptest.NewRecorder()
+    lantern_mux().ServeHTTP( rec, hreq )
+    if rec.Code!=x.status { t.Errorf("%q %s: status %d, expected %d: %s", x.accept, x.body, rec.Code, x.status, rec.Body.String()) }
+  }
+}
@@ -82,9 +82,13 @@ func GetTileSeqDummy( tileid string ) (string,error) {
   return seq,nil
 }
 
-func TileStatsPrint() {
-  fmt.Printf("Total:%d,CacheHit:%d,CacheMiss:%d,DBHit:%d,DBMiss:%d\n",
+func TileStatsString() string {
+  return fmt.Sprintf("Total:%d,CacheHit:%d,CacheMiss:%d,DBHit:%d,DBMiss:%d",
     gLanternTileStats.Total,
     gLanternTileStats.CacheHit, gLanternTileStats.CacheMiss,
     gLanternTileStats.DBHit, gLanternTileStats.DBMiss )
 }
+
+func TileStatsPrint() {
+  fmt.Printf("%s\n", TileStatsString())
+}
@@ -3,36 +3,65 @@ package main
 import "fmt"
 import "net/http"
 import "encoding/json"
-import "os"
 import "io"
 
+// TileId entries can give the path, version and step as a genomic
+// range, "chr17:41196312-41197000.0000" is the reference tiles covering
+// it (see lantern_locus.go).  The reference tiles of the genes or
+// regions in 'Gene' are added.
+//
+// If a Limit is given, at most Limit tile ids are looked up and the
+// Cursor in the response holds the index to resume from.
+//
 func tile_sequence_handler( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
 
   seqmap := make( map[string]string )
 
   error_count:=0
 
-  for i:=0; i<len(req.TileId); i++ {
+  cur,limit,err := request_page( req )
+  if err!=nil { _erre(w, err) ; return }
+
+  tile_id,err := request_tile_variant_id( req, req.TileId )
+  if err!=nil { _erre(w, err) ; return }
+
+  if len(req.Gene)>0 {
+    pos,e := gene_position( req.Gene )
+    if e!=nil { _erre(w, e) ; return }
+    for i:=0; i<len(pos); i++ {
+      tile_id = append( tile_id, fmt.Sprintf("%03x.%02x.%04x.%04x", pos[i][0], 0, pos[i][1], 0) )
+    }
+  }
+
+  end := len(tile_id)
+  if (limit>0) && (cur.Index+limit < end) { end = cur.Index+limit }
+
+  req.explain.positions( end-cur.Index )
+  req.explain.parsed()
+
+  for i:=cur.Index; i<end; i++ {
+    if req.job.cancelled() { _errc(w, ErrCancelled()) ; return }
+    req.job.progress( i-cur.Index, end-cur.Index )
     //fmt.Printf(">> %s\n", req.TileId[i])
 
-    seq,e := GetTileSeq( req.TileId[i] )
+    seq,e := req.tileSeq( tile_id[i] )
     if e!=nil {
       error_count ++
       if (error_count%1000)==0 {
-        fmt.Fprintf( os.Stderr, "ERROR: error count %d.  Latest error: tile_sequence_handler(%s): %v\n", error_count, req.TileId[i], e )
+        req.lg.Warn("error count %d.  Latest error: tile_sequence_handler(%s): %v", error_count, tile_id[i], e )
       }
       //fmt.Fprintf( os.Stderr, "ERROR: tile_sequence_handler(%s): %v\n", req.TileId[i], e )
       continue
     }
 
-    seqmap[ req.TileId[i] ] = seq
+    seqmap[ tile_id[i] ] = seq
   }
 
   //for k,v := range seqmap { fmt.Printf("%s %s\n", k, v[0:10]) }
+  req.explain.looked_up()
 
 
-  //DEBUG
-  TileStatsPrint()
+  req.lg.Debug("%s", TileStatsString())
 
 
   resp.Type = "success"
@@ -43,6 +72,9 @@ func tile_sequence_handler( w http.ResponseWriter, resp *LanternResponse, req *L
 
   io.WriteString(w, "{\n")
   io.WriteString(w, "  \"Type\":\"success\", \"Message\":\"tile-sequence\",\n")
+  if end < len(tile_id) {
+    io.WriteString(w, fmt.Sprintf("  \"Cursor\":\"%s\",\n", LanternCursor{ Hash: cur.Hash, Index: end }.Encode()) )
+  }
   io.WriteString(w, "  \"Result\":")
   io.WriteString(w, string(res_json_bytes))
   io.WriteString(w, "\n")
@@ -51,7 +83,9 @@ func tile_sequence_handler( w http.ResponseWriter, resp *LanternResponse, req *L
 }
 
 
-/* Do lookups without returning the actual sequence to test lookup speed
+/* Do lookups without returning the actual sequence to test lookup speed.
+   "Explain":true on any request (see lantern_explain.go) gives the same
+   counts and the timings.
 */
 func tile_sequence_handler_tracer( w http.ResponseWriter, resp *LanternResponse, req *LanternRequest ) {
 
@@ -62,15 +96,14 @@ func tile_sequence_handler_tracer( w http.ResponseWriter, resp *LanternResponse,
     if e!=nil {
       error_count ++
       if (error_count%1000)==0 {
-        fmt.Fprintf( os.Stderr, "ERROR: error count %d.  Latest error: tile_sequence_handler(%s): %v\n", error_count, req.TileId[i], e )
+        req.lg.Warn("error count %d.  Latest error: tile_sequence_handler(%s): %v", error_count, req.TileId[i], e )
       }
       continue
     }
 
   }
 
-  //DEBUG
-  TileStatsPrint()
+  req.lg.Debug("%s", TileStatsString())
 
   resp.Type = "success"
   resp.Message = "tile-sequence"
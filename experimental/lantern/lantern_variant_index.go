@@ -0,0 +1,357 @@
+package main
+
+import "os"
+import "fmt"
+import "sort"
+import "strconv"
+import "encoding/gob"
+
+import "../bitmap"
+import "../lightlog"
+
+/*
+
+Inverted tile variant to sample index.
+
+For every tile position (path, step), the index holds one entry
+per (tile variant, allele) with the bitmap of samples (by index
+into gCGF) carrying that tile variant on that allele.  Spanning
+tiles are indexed at the step they start at, the same as
+HasTileVariant.
+
+For sample-intersect, which compares the tile class at each step
+rather than tile variants, the index also holds, per position, the
+bitmap of samples for each tile class (as abv_tile_variant gives it)
+over the samples covering the step.
+
+With the index built ('--variant-index'), sample-tile-group-match,
+sample-intersect and variant-frequency are answered with bitmap
+operations instead of scanning every sample's ABV.  The answers are
+the same either way.
+
+The index can be saved to, and loaded from, a gob file with
+'--variant-index-file'.  A saved index is only used if it has the
+current gVariantIndexVersion and the tile map and sample list it was
+built with match the loaded CGFs, otherwise it's rebuilt and saved
+again.
+
+*/
+
+type VariantBitmap struct {
+  Variant int
+  Allele int
+  Sample *bitmap.Bitmap
+}
+
+type ClassBitmap struct {
+  Class int
+  Sample *bitmap.Bitmap
+}
+
+type VariantIndex struct {
+  Version int
+  TileMapVersion string
+  SampleName []string
+
+  // Keyed by path, then indexed by step.
+  //
+  Path map[int][][]VariantBitmap
+  Class map[int][][]ClassBitmap
+}
+
+// Bumped whenever what's indexed changes, so saved indexes are
+// rebuilt.
+//
+var gVariantIndexVersion int = 2
+
+var gVariantIndex *VariantIndex
+
+type variant_acc struct {
+  variant int
+  allele int
+  sample []int
+}
+
+func variant_index_add( acc [][]variant_acc, step, variant, allele, sample int ) {
+  for i:=0; i<len(acc[step]); i++ {
+    a := &(acc[step][i])
+    if (a.variant==variant) && (a.allele==allele) {
+      if a.sample[len(a.sample)-1]!=sample { a.sample = append( a.sample, sample ) }
+      return
+    }
+  }
+  acc[step] = append( acc[step], variant_acc{ variant, allele, []int{ sample } } )
+}
+
+type class_acc struct {
+  class int
+  sample []int
+}
+
+func class_index_add( acc [][]class_acc, step, class, sample int ) {
+  for i:=0; i<len(acc[step]); i++ {
+    if acc[step][i].class==class {
+      acc[step][i].sample = append( acc[step][i].sample, sample )
+      return
+    }
+  }
+  acc[step] = append( acc[step], class_acc{ class, []int{ sample } } )
+}
+
+// Samples are made resident one at a time, see lantern_memory.go.
+//
+func VariantIndexBuild() ( *VariantIndex, error ) {
+  n := len(gCGF)
+  tile_map := gCGF[0].TileMap
+
+  vi := &VariantIndex{ Version:gVariantIndexVersion, TileMapVersion:gCGF[0].EncodedTileMapMd5Sum }
+  vi.Path = make( map[int][][]VariantBitmap )
+  vi.Class = make( map[int][][]ClassBitmap )
+  vi.SampleName = append( vi.SampleName, gCGFName... )
+
+  // Collect the paths (and longest ABV for each) over all samples.
+  //
+  path_len := make( map[string]int )
+  for s:=0; s<n; s++ {
+    for path_str,n_step := range sample_path_lens( s ) {
+      if n_step > path_len[path_str] { path_len[path_str] = n_step }
+    }
+  }
+
+  acc := make( map[string][][]variant_acc )
+  cacc := make( map[string][][]class_acc )
+  for path_str,n_step := range path_len {
+    if _,e := strconv.ParseInt( path_str, 16, 64 ) ; e!=nil { continue }
+    acc[path_str] = make( [][]variant_acc, n_step )
+    cacc[path_str] = make( [][]class_acc, n_step )
+  }
+
+  for s:=0; s<n; s++ {
+    if e := gMemory.acquire( []int{ s } ) ; e!=nil { return nil, e }
+    cg := gCGF[s]
+
+    for path_str,abv := range cg.ABV {
+      path_acc,ok := acc[path_str]
+      if !ok { continue }
+      path_64,_ := strconv.ParseInt( path_str, 16, 64 )
+      path := int(path_64)
+      n_step := len(path_acc)
+
+      for step:=0; step<len(abv); step++ {
+        class_index_add( cacc[path_str], step, abv_tile_variant( s, path, step, abv ), s )
+
+        code := cg.CharMap[ abv[step:step+1] ]
+
+        // Overflow
+        //
+        if code == -2 {
+          var e error
+          code,e = cg.LookupABVTileMapVariant( path, step )
+          if e!=nil { continue }
+        }
+
+        // No-calls and the interior of spanning tiles are skipped,
+        // the latter are added from their starting step.
+        //
+        if (code<0) || (code>=len(tile_map)) { continue }
+
+        tme := tile_map[code]
+        for allele:=0; allele<len(tme.Variant); allele++ {
+          x := 0
+          for v_ind:=0; v_ind<len(tme.Variant[allele]); v_ind++ {
+            if (step+x < n_step) && (tme.Variant[allele][v_ind] >= 0) {
+              variant_index_add( path_acc, step+x, tme.Variant[allele][v_ind], allele, s )
+            }
+            x += tme.VariantLength[allele][v_ind]
+          }
+        }
+      }
+    }
+
+    gMemory.release( []int{ s } )
+  }
+
+  for path_str,path_acc := range acc {
+    path_64,_ := strconv.ParseInt( path_str, 16, 64 )
+
+    vb := make( [][]VariantBitmap, len(path_acc) )
+    for step:=0; step<len(path_acc); step++ {
+      for i:=0; i<len(path_acc[step]); i++ {
+        a := path_acc[step][i]
+        vb[step] = append( vb[step], VariantBitmap{ Variant:a.variant, Allele:a.allele, Sample:bitmap.FromSorted( n, a.sample ) } )
+      }
+    }
+    vi.Path[ int(path_64) ] = vb
+
+    path_cacc := cacc[path_str]
+    cb := make( [][]ClassBitmap, len(path_cacc) )
+    for step:=0; step<len(path_cacc); step++ {
+      for i:=0; i<len(path_cacc[step]); i++ {
+        c := path_cacc[step][i]
+        cb[step] = append( cb[step], ClassBitmap{ Class:c.class, Sample:bitmap.FromSorted( n, c.sample ) } )
+      }
+    }
+    vi.Class[ int(path_64) ] = cb
+  }
+
+  return vi, nil
+}
+
+func (vi *VariantIndex) Save( fn string ) error {
+  fp,e := os.Create( fn )
+  if e!=nil { return e }
+  if e := gob.NewEncoder( fp ).Encode( vi ) ; e!=nil { fp.Close() ; return e }
+  return fp.Close()
+}
+
+func VariantIndexLoad( fn string ) ( *VariantIndex, error ) {
+  fp,e := os.Open( fn )
+  if e!=nil { return nil, e }
+  defer fp.Close()
+
+  vi := VariantIndex{}
+  if e := gob.NewDecoder( fp ).Decode( &vi ) ; e!=nil { return nil, e }
+  return &vi, nil
+}
+
+// True if the index was built from the currently loaded samples.
+//
+func (vi *VariantIndex) current() bool {
+  if vi.Version != gVariantIndexVersion { return false }
+  if vi.TileMapVersion != gCGF[0].EncodedTileMapMd5Sum { return false }
+  if len(vi.SampleName) != len(gCGFName) { return false }
+  for i:=0; i<len(gCGFName); i++ {
+    if vi.SampleName[i] != gCGFName[i] { return false }
+  }
+  return true
+}
+
+// Load the index from fn if it's there and current, otherwise build
+// it (and save it to fn if given).
+//
+func VariantIndexInit( fn string ) error {
+  if len(fn)>0 {
+    vi,e := VariantIndexLoad( fn )
+    if (e==nil) && vi.current() {
+      lightlog.Info( "loaded variant index %s", fn )
+      gVariantIndex = vi
+      return nil
+    }
+    if e==nil {
+      lightlog.Info( "variant index %s is out of date, rebuilding", fn )
+    } else if !os.IsNotExist(e) {
+      lightlog.Warn( "could not load variant index %s: %v", fn, e )
+    }
+  }
+
+  lightlog.Info( "building variant index" )
+  vi,e := VariantIndexBuild()
+  if e!=nil { return e }
+  gVariantIndex = vi
+
+  if len(fn)>0 {
+    if e := gVariantIndex.Save( fn ) ; e!=nil { return fmt.Errorf("%s: %v", fn, e) }
+    lightlog.Info( "saved variant index %s", fn )
+  }
+
+  return nil
+}
+
+func (vi *VariantIndex) entries( path, step int ) []VariantBitmap {
+  vb,ok := vi.Path[path]
+  if !ok || (step<0) || (step>=len(vb)) { return nil }
+  return vb[step]
+}
+
+// Samples with the tile variant on any allele.
+//
+func (vi *VariantIndex) variant_samples( path, step, variant int ) *bitmap.Bitmap {
+  r := bitmap.New( len(gCGF) )
+  e := vi.entries( path, step )
+  for i:=0; i<len(e); i++ {
+    if e[i].Variant==variant { r = r.Or( e[i].Sample ) }
+  }
+  return r
+}
+
+// Samples with the path covering step, whether called or not.
+//
+func covered_samples( path, step int ) *bitmap.Bitmap {
+  path_str := fmt.Sprintf("%x", path)
+  s := []int{}
+  for i:=0; i<len(gCGF); i++ {
+    if n_step,ok := sample_path_lens( i )[path_str] ; ok && (step>=0) && (step<n_step) { s = append( s, i ) }
+  }
+  return bitmap.FromSorted( len(gCGF), s )
+}
+
+func sample_set( sampleIndex []int ) *bitmap.Bitmap {
+  s := append( []int{}, sampleIndex... )
+  sort.Ints( s )
+
+  u := []int{}
+  for i:=0; i<len(s); i++ {
+    if (i==0) || (s[i]!=s[i-1]) { u = append( u, s[i] ) }
+  }
+  return bitmap.FromSorted( len(gCGF), u )
+}
+
+// Bitmap version of sample_tile_group_match.  Samples are returned
+// in the order given.
+//
+func sample_tile_group_match_index( lg *lightlog.Logger, jb *lantern_job, sampleIndex []int, tileGroupVariantRange []map[string][]TileRange ) ( resSample []int, err error ) {
+  n := len(gCGF)
+  res := sample_set( sampleIndex )
+
+  for g:=0; g<len(tileGroupVariantRange); g++ {
+    if jb.cancelled() { err = ErrCancelled() ; return }
+    jb.progress( g, len(tileGroupVariantRange) )
+
+    group := bitmap.New( n )
+
+    for path_step,variantRange := range tileGroupVariantRange[g] {
+      path_64,step_64,e := convert_path_step( path_step )
+      if e!=nil { err = e ; return }
+      path,step := int(path_64),int(step_64)
+
+      var covered *bitmap.Bitmap
+
+      for vpos:=0; vpos<len(variantRange); vpos++ {
+        for tile_variant:=variantRange[vpos].Range[0]; tile_variant<variantRange[vpos].Range[1]; tile_variant++ {
+          has := gVariantIndex.variant_samples( path, step, tile_variant )
+
+          if variantRange[vpos].Permit {
+            group = group.Or( has )
+          } else {
+            if covered==nil { covered = covered_samples( path, step ) }
+            group = group.Or( covered.AndNot( has ) )
+          }
+        }
+      }
+    }
+
+    res = res.And( group )
+    lg.Debug("group %d: %d samples", g, res.Count())
+    if res.Count()==0 { break }
+  }
+
+  for i:=0; i<len(sampleIndex); i++ {
+    if res.Contains( sampleIndex[i] ) { resSample = append( resSample, sampleIndex[i] ) }
+  }
+
+  return resSample, nil
+}
+
+// True if every sample in s covering path, step has tile class x0
+// there, the same as the scan in sample_intersect.  That is, no
+// other class at the position holds any of s.
+//
+func (vi *VariantIndex) same_variant( path, step, x0 int, s *bitmap.Bitmap ) bool {
+  cb,ok := vi.Class[path]
+  if !ok || (step<0) || (step>=len(cb)) { return true }
+  for i:=0; i<len(cb[step]); i++ {
+    if cb[step][i].Class==x0 { continue }
+    if cb[step][i].Sample.And( s ).Count() > 0 { return false }
+  }
+  return true
+}
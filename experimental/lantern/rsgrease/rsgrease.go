@@ -9,28 +9,19 @@ import _ "github.com/lib/pq"
 import "database/sql"
 import "encoding/json"
 
-import "net/http"
-import "io/ioutil"
-
-import "bytes"
+import "context"
 
 
 import "github.com/codegangsta/cli"
 
+import "../client"
+
 var VERSION_STR string = "0.1, AGPLv3.0"
 var g_verboseFlag bool
-var g_URL string = "http://localhost:8080"
+var g_URL string = client.DefaultURL
 
 var g_db *sql.DB
 
-type LightningRequest struct {
-  Type string
-  Dataset string
-  Note string
-  SampleId []string
-  TileGroupVariantId [][]string
-}
-
 
 //func get_rsid( c *cli.Context ) ([]int, error) {
 func get_rsid( rsid_slice []string ) ([]int, error) {
@@ -81,26 +72,15 @@ func get_tile( rsid_list []int ) ( [][]string, error ) {
   return tileid_group_list, nil
 }
 
-func send_lightning_request( byte_dat []byte ) {
-  //url := "http://localhost:8080"
-
-  req, err := http.NewRequest("POST", g_URL, bytes.NewBuffer( byte_dat ) )
-  req.Header.Set("Content-Type", "application/json")
+func send_lightning_request( req *client.Request ) {
+  cl := client.New( g_URL )
 
-  client := &http.Client{}
-  resp, err := client.Do(req)
+  body,err := cl.DoRaw( context.Background(), req )
   if err != nil {
-      panic(err)
-  }
-  defer resp.Body.Close()
-
-  if g_verboseFlag {
-    fmt.Println("response Status:", resp.Status)
-    fmt.Println("response Headers:", resp.Header)
+    fmt.Fprintf( os.Stderr, "%v\n", err )
+    os.Exit(1)
   }
 
-  body, _ := ioutil.ReadAll(resp.Body)
-
   if g_verboseFlag {
     fmt.Printf("response Body:")
   }
@@ -134,18 +114,20 @@ func _main( c *cli.Context ) {
   tile_group_list,e := get_tile( rsid )
 
 
-  req := LightningRequest{ Type:"sample-tile-group-match", Dataset:"all", Note:"...", SampleId:[]string{} }
+  req := client.Request{ Type:"sample-tile-group-match", Dataset:"all", Note:"...", SampleId:[]string{} }
   req.TileGroupVariantId = tile_group_list
 
   if g_verboseFlag { fmt.Printf("%v\n", req.TileGroupVariantId ) }
 
-  byte_req,e := json.Marshal( req )
-  if e!= nil { panic(e) }
-  if g_verboseFlag { fmt.Printf("%s\n", byte_req ) }
+  if g_verboseFlag {
+    byte_req,e := json.Marshal( req )
+    if e!= nil { panic(e) }
+    fmt.Printf("%s\n", byte_req )
+  }
 
   //
   //
-  send_lightning_request( byte_req )
+  send_lightning_request( &req )
 
 }
 
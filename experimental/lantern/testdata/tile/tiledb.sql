@@ -0,0 +1,32 @@
+create table tile_seq ( tileid text, md5sum text, seq text );
+insert into tile_seq values ( '248.00.0000.0000', '0b46fa41766400b6cd54bde4c0cc316a', 'taattcatcacagacttaccagaaaatacttcggaatttcttgggtagtgtgaccagtgtggaatgtaacacaa' );
+insert into tile_seq values ( '248.00.0000.0001', 'fed8cdb65e88c2b9e8ab4658822ff425', 'taattcatcacagacttaccagaaaatacttcggaatatcttgggtagtgtgaccagtgtggaatgtaacacaa' );
+insert into tile_seq values ( '248.00.0000.0002', '7b64d4d0a4ace4aba1a78c64c9a4219a', 'taattcatcacagacttaccagaaaatacttcggaatctcttgggtagtgtgaccagtgtggaatgtaacacaa' );
+insert into tile_seq values ( '248.00.0000.0003', '137f320e989cbb1a6d50625bdaabaecf', 'taattcatcacagacttaccagaaaatacttcggaatatcttgggtagtgtgaccagtgtggaatgtaacacaattggagccgggtatatacagcgtcgtaacgtatatttggatcagctgtgc' );
+insert into tile_seq values ( '248.00.0001.0000', '60677ce76eded2536db0c12bd7a0d78f', 'tgaccagtgtggaatgtaacacaattggagccgggtatatacagcgtcgtaacgtatatttggatcagctgtgc' );
+insert into tile_seq values ( '248.00.0001.0001', '98b805a835b26737774ad86dfc2b8a4f', 'tgaccagtgtggaatgtaacacaattggagccgggtaaatacagcgtcgtaacgtatatttggatcagctgtgc' );
+insert into tile_seq values ( '248.00.0001.0002', '179231151caa301e864821040654b39a', 'tgaccagtgtggaatgtaacacaattggagccgggtacatacagcgtcgtaacgtatatttggatcagctgtgc' );
+insert into tile_seq values ( '248.00.0001.0003', '66b4dc61047b2782c2db17c8d11a51b3', 'tgaccagtgtggaatgtaacacaattggagccgggtaaatacagcgtcgtaacgtatatttggatcagctgtgcctataacatgctcccccaaggccagtgtcccttcgccttggcccaggtgc' );
+insert into tile_seq values ( '248.00.0002.0000', '1ba7ff2bf7aa994505881139209a8187', 'aacgtatatttggatcagctgtgcctataacatgctcccccaaggccagtgtcccttcgccttggcccaggtgc' );
+insert into tile_seq values ( '248.00.0002.0001', '7d9686a6bac685a37f06ea535bbc05d6', 'aacgtatatttggatcagctgtgcctataacatgctcgcccaaggccagtgtcccttcgccttggcccaggtgc' );
+insert into tile_seq values ( '248.00.0002.0002', '5e9ec862b79c4000fc201fa7fa43ee82', 'aacgtatatttggatcagctgtgcctataacatgctctcccaaggccagtgtcccttcgccttggcccaggtgc' );
+insert into tile_seq values ( '248.00.0002.0003', 'bca3cc7fa646b94b000ec2bd0dadfb0b', 'aacgtatatttggatcagctgtgcctataacatgctcgcccaaggccagtgtcccttcgccttggcccaggtgctagtttccggccctgtgtcttaaggaaaaatgcgcaaagaggcttattat' );
+insert into tile_seq values ( '248.00.0003.0000', 'ad6fe56245ae9a6b695739c5a12c400f', 'gtcccttcgccttggcccaggtgctagtttccggccctgtgtcttaaggaaaaatgcgcaaagaggcttattat' );
+insert into tile_seq values ( '248.00.0003.0001', '0f363571d29e1b9480846c86b1783442', 'gtcccttcgccttggcccaggtgctagtttccggcccagtgtcttaaggaaaaatgcgcaaagaggcttattat' );
+insert into tile_seq values ( '248.00.0003.0002', 'e8a853f076df089620f90d4a5e082a5d', 'gtcccttcgccttggcccaggtgctagtttccggccccgtgtcttaaggaaaaatgcgcaaagaggcttattat' );
+insert into tile_seq values ( '248.00.0003.0003', 'f853c7c64e2922cce55a5b81191e8308', 'gtcccttcgccttggcccaggtgctagtttccggcccagtgtcttaaggaaaaatgcgcaaagaggcttattatctcgaccacggcacaccaggtgagggtcaaccacattggtatggcagtag' );
+insert into tile_seq values ( '248.00.0004.0000', '3a91ad6949ec2e1d886c3367aae2c288', 'aaaatgcgcaaagaggcttattatctcgaccacggcacaccaggtgagggtcaaccacattggtatggcagtag' );
+insert into tile_seq values ( '248.00.0004.0001', '9772b922a913028d1d808c40943aa938', 'aaaatgcgcaaagaggcttattatctcgaccacggcagaccaggtgagggtcaaccacattggtatggcagtag' );
+insert into tile_seq values ( '248.00.0004.0002', '115ef0780b5eff561f09891aeab4c9d8', 'aaaatgcgcaaagaggcttattatctcgaccacggcataccaggtgagggtcaaccacattggtatggcagtag' );
+insert into tile_seq values ( '248.00.0004.0003', 'c30dd8c68f0f5843c147ac45af37a2f3', 'aaaatgcgcaaagaggcttattatctcgaccacggcagaccaggtgagggtcaaccacattggtatggcagtagttcacttggagtgagtggataggtccatggaggtctaatagacggtcagg' );
+insert into tile_seq values ( '248.00.0005.0000', 'fd50d3c75ebbfb8ff96b4db0df7b130f', 'tcaaccacattggtatggcagtagttcacttggagtgagtggataggtccatggaggtctaatagacggtcagg' );
+insert into tile_seq values ( '248.00.0005.0001', 'f9a6b245817f598c053ad449aed52b86', 'tcaaccacattggtatggcagtagttcacttggagtgcgtggataggtccatggaggtctaatagacggtcagg' );
+insert into tile_seq values ( '248.00.0005.0002', 'db3ada8e3996f7d5c06e8da38bec1e6a', 'tcaaccacattggtatggcagtagttcacttggagtgggtggataggtccatggaggtctaatagacggtcagg' );
+insert into tile_seq values ( '248.00.0005.0003', '68340a4919452bd1b850167c8cba2aa9', 'tcaaccacattggtatggcagtagttcacttggagtgcgtggataggtccatggaggtctaatagacggtcagggttgcctagtgggggtcgcacaaggaagtgttcactttggatcagtatag' );
+insert into tile_seq values ( '248.00.0006.0000', '8aef52a81ff8b4682cf818f9f27dd5b4', 'atggaggtctaatagacggtcagggttgcctagtgggggtcgcacaaggaagtgttcactttggatcagtatag' );
+insert into tile_seq values ( '248.00.0006.0001', '300770c2b6b61edc9800657c8496eee1', 'atggaggtctaatagacggtcagggttgcctagtgggtgtcgcacaaggaagtgttcactttggatcagtatag' );
+insert into tile_seq values ( '248.00.0006.0002', 'fad81827a5476c8879766bbf9df1c5c0', 'atggaggtctaatagacggtcagggttgcctagtgggagtcgcacaaggaagtgttcactttggatcagtatag' );
+insert into tile_seq values ( '248.00.0006.0003', 'a8432ddd03d95441fa6eccd7f35cc861', 'atggaggtctaatagacggtcagggttgcctagtgggtgtcgcacaaggaagtgttcactttggatcagtatagctgcattaaggaggcatctcttgattcatatcagctctgacccggggccg' );
+insert into tile_seq values ( '248.00.0007.0000', 'cd5bbea44b7b3b74b1f00839a6aa1af8', 'agtgttcactttggatcagtatagctgcattaaggaggcatctcttgattcatatcagctctgacccggggccg' );
+insert into tile_seq values ( '248.00.0007.0001', '92960f85820e8bfe0ae7dca4e779e332', 'agtgttcactttggatcagtatagctgcattaaggagtcatctcttgattcatatcagctctgacccggggccg' );
+insert into tile_seq values ( '248.00.0007.0002', '62ef7530ee97fe59ea9ed9b8bf882937', 'agtgttcactttggatcagtatagctgcattaaggagacatctcttgattcatatcagctctgacccggggccg' );
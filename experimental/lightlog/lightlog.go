@@ -0,0 +1,163 @@
+package lightlog
+
+/*
+
+Leveled, structured logging shared by lantern and the fjtools.
+
+Messages below the current level are dropped.  The default
+level is WARN so the tools stay quiet unless something goes
+wrong.  Each message can carry a set of fields (for example
+the request id lantern hands out from its counter).
+
+Text output (the default) is one line per message:
+
+  2015-10-20T10:11:12Z [ WARN] could not load sample fn=x.cgf req=12
+
+JSON output is one object per line:
+
+  {"fn":"x.cgf","level":"warn","msg":"could not load sample","req":12,"time":"2015-10-20T10:11:12Z"}
+
+*/
+
+import "fmt"
+import "os"
+import "io"
+import "sort"
+import "sync"
+import "time"
+import "strings"
+import "encoding/json"
+
+const (
+  DEBUG = iota
+  INFO
+  WARN
+  ERROR
+  FATAL
+)
+
+var LevelName = [...]string{ "debug", "info", "warn", "error", "fatal" }
+
+const TIME_FORMAT = time.RFC3339
+
+type Fields map[string]interface{}
+
+type Logger struct {
+  fields Fields
+}
+
+var gLevel int = WARN
+var gJSON bool = false
+var gOut io.Writer = os.Stderr
+var gMutex sync.Mutex
+
+var gStd *Logger = &Logger{}
+
+func SetLevel( level int ) {
+  gMutex.Lock() ; defer gMutex.Unlock()
+  if level < DEBUG { level = DEBUG }
+  if level > FATAL { level = FATAL }
+  gLevel = level
+}
+
+func Level() int {
+  gMutex.Lock() ; defer gMutex.Unlock()
+  return gLevel
+}
+
+// Accepts the names in LevelName (case insensitive) as well as "warning".
+//
+func ParseLevel( s string ) (int, error) {
+  t := strings.ToLower( strings.TrimSpace(s) )
+  if t == "warning" { return WARN, nil }
+  for i:=0; i<len(LevelName); i++ {
+    if LevelName[i] == t { return i, nil }
+  }
+  return -1, fmt.Errorf("invalid log level '%s'", s)
+}
+
+func SetLevelString( s string ) error {
+  level,e := ParseLevel( s )
+  if e!=nil { return e }
+  SetLevel( level )
+  return nil
+}
+
+func SetJSON( flag bool ) {
+  gMutex.Lock() ; defer gMutex.Unlock()
+  gJSON = flag
+}
+
+func SetOutput( w io.Writer ) {
+  gMutex.Lock() ; defer gMutex.Unlock()
+  gOut = w
+}
+
+func Enabled( level int ) bool {
+  return level >= Level()
+}
+
+// Return a new Logger that adds the fields in f to every message.
+// Fields already on the logger are overridden by those in f.
+// A nil Logger behaves like the package level logger.
+//
+func (l *Logger) With( f Fields ) *Logger {
+  if l == nil { l = gStd }
+  nf := make( Fields )
+  for k,v := range l.fields { nf[k] = v }
+  for k,v := range f { nf[k] = v }
+  return &Logger{ fields: nf }
+}
+
+func (l *Logger) Print( level int, format string, args ...interface{} ) {
+  if !Enabled( level ) { return }
+  if l == nil { l = gStd }
+
+  msg := fmt.Sprintf( format, args...)
+  now := time.Now().UTC().Format( TIME_FORMAT )
+
+  gMutex.Lock()
+
+  if gJSON {
+    m := make( map[string]interface{} )
+    for k,v := range l.fields {
+      if e,ok := v.(error) ; ok { v = e.Error() }
+      m[k] = v
+    }
+    m["time"] = now
+    m["level"] = LevelName[level]
+    m["msg"] = msg
+
+    b,e := json.Marshal( m )
+    if e!=nil { b = []byte( fmt.Sprintf(`{"level":"error","msg":"lightlog: %v"}`, e) ) }
+    gOut.Write( append( b, '\n' ) )
+  } else {
+    keys := make( []string, 0, len(l.fields) )
+    for k := range l.fields { keys = append( keys, k ) }
+    sort.Strings( keys )
+
+    line := fmt.Sprintf( "%s [%5s] %s", now, strings.ToUpper( LevelName[level] ), msg )
+    for i:=0; i<len(keys); i++ {
+      line += fmt.Sprintf( " %s=%v", keys[i], l.fields[keys[i]] )
+    }
+    io.WriteString( gOut, line + "\n" )
+  }
+
+  gMutex.Unlock()
+
+  if level == FATAL { os.Exit(1) }
+}
+
+func (l *Logger) Debug( format string, args ...interface{} ) { l.Print( DEBUG, format, args... ) }
+func (l *Logger) Info( format string, args ...interface{} )  { l.Print( INFO, format, args... ) }
+func (l *Logger) Warn( format string, args ...interface{} )  { l.Print( WARN, format, args... ) }
+func (l *Logger) Error( format string, args ...interface{} ) { l.Print( ERROR, format, args... ) }
+func (l *Logger) Fatal( format string, args ...interface{} ) { l.Print( FATAL, format, args... ) }
+
+func With( f Fields ) *Logger { return gStd.With( f ) }
+
+func Debug( format string, args ...interface{} ) { gStd.Print( DEBUG, format, args... ) }
+func Info( format string, args ...interface{} )  { gStd.Print( INFO, format, args... ) }
+func Warn( format string, args ...interface{} )  { gStd.Print( WARN, format, args... ) }
+func Error( format string, args ...interface{} ) { gStd.Print( ERROR, format, args... ) }
+func Fatal( format string, args ...interface{} ) { gStd.Print( FATAL, format, args... ) }
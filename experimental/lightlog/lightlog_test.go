@@ -0,0 +1,48 @@
+package lightlog
+
+import "bytes"
+import "strings"
+import "testing"
+import "encoding/json"
+
+func TestLevel( t *testing.T ) {
+  var buf bytes.Buffer
+  SetOutput( &buf )
+  SetJSON( false )
+
+  e := SetLevelString( "warning" )
+  if e!=nil { t.Errorf("%v", e) }
+
+  Debug("debug %d", 1)
+  Info("info %d", 2)
+  if buf.Len() != 0 { t.Errorf("expected no output below WARN, got '%s'", buf.String()) }
+
+  With( Fields{ "req":7 } ).Warn("warn %d", 3)
+  if !strings.Contains( buf.String(), "[ WARN] warn 3 req=7" ) {
+    t.Errorf("unexpected text output '%s'", buf.String())
+  }
+
+  if _,e := ParseLevel("loud") ; e==nil { t.Errorf("expected error for invalid level") }
+}
+
+func TestJSON( t *testing.T ) {
+  var buf bytes.Buffer
+  SetOutput( &buf )
+  SetJSON( true )
+  SetLevel( DEBUG )
+
+  lg := With( Fields{ "req":3 } ).With( Fields{ "type":"system-info" } )
+  lg.Debug("request")
+
+  m := make( map[string]interface{} )
+  e := json.Unmarshal( buf.Bytes(), &m )
+  if e!=nil { t.Fatalf("%v (%s)", e, buf.String()) }
+
+  if m["level"] != "debug" { t.Errorf("level %v != debug", m["level"]) }
+  if m["msg"] != "request" { t.Errorf("msg %v != request", m["msg"]) }
+  if m["req"] != float64(3) { t.Errorf("req %v != 3", m["req"]) }
+  if m["type"] != "system-info" { t.Errorf("type %v != system-info", m["type"]) }
+
+  SetJSON( false )
+  SetLevel( WARN )
+}
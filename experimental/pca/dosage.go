@@ -0,0 +1,153 @@
+package pca
+
+import "fmt"
+import "math"
+import "sort"
+import "strconv"
+
+import "../cgf"
+
+/*
+
+Tile variant dosage features from CGF samples.
+
+At every tile position, each tile variant seen is a feature holding
+the number of alleles (0, 1 or 2) each sample has it on.  Samples
+not called at the position are missing.  Spanning tiles count at
+the step they start at.
+
+Tile variants with a minor frequency below MinFrequency are dropped
+and the rest are scaled by 1/sqrt(p(1-p)), p being the tile variant
+frequency, so rare tile variants aren't swamped by common ones.
+
+*/
+
+var DefaultMinFrequency float64 = 0.01
+
+type DosageOption struct {
+
+  // Path ranges, as returned by parseIntOption (end exclusive, -1
+  // for open ended).  Empty for all paths.
+  //
+  PathRange [][2]int64
+
+  // If not nil, only the tile positions Keep returns true for are
+  // used.
+  //
+  Keep func( path, step int ) bool
+
+  MinFrequency float64
+}
+
+func in_path_range( path int64, r [][2]int64 ) bool {
+  if len(r)==0 { return true }
+  for i:=0; i<len(r); i++ {
+    if (path>=r[i][0]) && ((r[i][1]<0) || (path<r[i][1])) { return true }
+  }
+  return false
+}
+
+// Tile variants starting at path, step, one per allele.
+//
+func start_variant( cg *cgf.CGF, tile_map []cgf.TileMapEntry, path, step int, abv string ) ( []int, bool ) {
+  code := cg.CharMap[ abv[step:step+1] ]
+  if code == -2 {
+    var e error
+    code,e = cg.LookupABVTileMapVariant( path, step )
+    if e!=nil { return nil, false }
+  }
+  if (code<0) || (code>=len(tile_map)) { return nil, false }
+
+  tme := tile_map[code]
+  v := make( []int, len(tme.Variant) )
+  for a:=0; a<len(tme.Variant); a++ {
+    if (len(tme.Variant[a])==0) || (tme.Variant[a][0]<0) { return nil, false }
+    v[a] = tme.Variant[a][0]
+  }
+  return v, len(v)>0
+}
+
+// Build the Gram matrix of the tile variant dosages of the samples
+// in cgs, using tile_map to decode their ABVs.  progress and
+// cancelled are optional.
+//
+func DosageGram( cgs []*cgf.CGF, tile_map []cgf.TileMapEntry, opt DosageOption, progress func(done, total int), cancelled func() bool ) ( *Gram, error ) {
+  n := len(cgs)
+  if opt.MinFrequency<0 { opt.MinFrequency = 0 }
+
+  path_len := make( map[string]int )
+  for s:=0; s<n; s++ {
+    for path_str,abv := range cgs[s].ABV {
+      if len(abv) > path_len[path_str] { path_len[path_str] = len(abv) }
+    }
+  }
+
+  paths := []int{}
+  for path_str := range path_len {
+    p,e := strconv.ParseInt( path_str, 16, 64 )
+    if e!=nil { continue }
+    if in_path_range( p, opt.PathRange ) { paths = append( paths, int(p) ) }
+  }
+  sort.Ints( paths )
+
+  g := NewGram( n )
+  gt := make( [][]int, n )
+  abv := make( []string, n )
+
+  for pi:=0; pi<len(paths); pi++ {
+    if (cancelled!=nil) && cancelled() { return nil, fmt.Errorf("cancelled") }
+    if progress!=nil { progress( pi, len(paths) ) }
+
+    path := paths[pi]
+    path_str := fmt.Sprintf("%x", path)
+    for s:=0; s<n; s++ { abv[s] = cgs[s].ABV[path_str] }
+
+    for step:=0; step<path_len[path_str]; step++ {
+      if (opt.Keep!=nil) && !opt.Keep( path, step ) { continue }
+
+      variant := []int{}
+      seen := make( map[int]bool )
+      n_called,ploidy := 0,0
+
+      for s:=0; s<n; s++ {
+        gt[s] = nil
+        if step>=len(abv[s]) { continue }
+
+        v,ok := start_variant( cgs[s], tile_map, path, step, abv[s] )
+        if !ok { continue }
+        gt[s] = v
+        n_called++
+        if len(v)>ploidy { ploidy = len(v) }
+
+        for a:=0; a<len(v); a++ {
+          if !seen[v[a]] { seen[v[a]] = true ; variant = append( variant, v[a] ) }
+        }
+      }
+      if (n_called<2) || (len(variant)<2) { continue }
+      sort.Ints( variant )
+
+      for vi:=0; vi<len(variant); vi++ {
+        col := make( []float64, n )
+        sum := 0.0
+        for s:=0; s<n; s++ {
+          if gt[s]==nil { col[s] = math.NaN() ; continue }
+          for a:=0; a<len(gt[s]); a++ {
+            if gt[s][a]==variant[vi] { col[s]++ }
+          }
+          sum += col[s]
+        }
+
+        p := sum / float64( n_called*ploidy )
+        maf := p
+        if maf>0.5 { maf = 1-maf }
+        if (maf<=0) || (maf<opt.MinFrequency) { continue }
+
+        scale := 1/math.Sqrt( p*(1-p) )
+        for s:=0; s<n; s++ { col[s] *= scale }
+        g.Add( col )
+      }
+    }
+  }
+
+  return g, nil
+}
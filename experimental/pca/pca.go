@@ -0,0 +1,281 @@
+package pca
+
+import "fmt"
+import "math"
+import "sort"
+import "math/rand"
+
+/*
+
+Principal component analysis of samples.
+
+Features (e.g. tile variant dosages) are added one at a time, as a
+column holding the value for every sample, and accumulated into the
+sample by sample Gram matrix of the centred data.  Only the Gram
+matrix is kept, so memory is quadratic in the number of samples
+and independent of the number of features.
+
+The top k eigenvectors of the Gram matrix are found with subspace
+(block power) iteration followed by a Rayleigh-Ritz step on the
+resulting k by k matrix, solved with Jacobi rotations.  Sample
+coordinates are the projections of the samples onto the principal
+axes, that is the eigenvectors scaled by the square root of their
+eigenvalues.
+
+*/
+
+var DefaultMaxIter int = 500
+var DefaultTol float64 = 1e-9
+
+type Gram struct {
+  N int
+  Feature int
+
+  // Upper triangle, row major, N by N.
+  //
+  M []float64
+}
+
+type Result struct {
+  Feature int
+  Iteration int
+
+  // Coordinate[sample][component]
+  //
+  Coordinate [][]float64
+
+  // Eigenvalues of the sample covariance matrix.
+  //
+  Eigenvalue []float64
+  VarianceExplained []float64
+}
+
+func NewGram( n int ) *Gram {
+  return &Gram{ N:n, M:make( []float64, n*n ) }
+}
+
+// Add a feature column.  NaN values are missing and take the column
+// mean.  Columns that are constant, or missing everywhere, add
+// nothing and are skipped.
+//
+func (g *Gram) Add( col []float64 ) {
+  n := g.N
+  if len(col)!=n { return }
+
+  sum,cnt := 0.0,0
+  for i:=0; i<n; i++ {
+    if math.IsNaN( col[i] ) { continue }
+    sum += col[i]
+    cnt++
+  }
+  if cnt==0 { return }
+  mean := sum/float64(cnt)
+
+  c := make( []float64, n )
+  nonzero := false
+  for i:=0; i<n; i++ {
+    if !math.IsNaN( col[i] ) { c[i] = col[i]-mean }
+    if c[i]!=0 { nonzero = true }
+  }
+  if !nonzero { return }
+
+  for i:=0; i<n; i++ {
+    ci := c[i]
+    if ci==0 { continue }
+    row := g.M[i*n:(i+1)*n]
+    for j:=i; j<n; j++ { row[j] += ci*c[j] }
+  }
+  g.Feature++
+}
+
+func dot( a, b []float64 ) float64 {
+  s := 0.0
+  for i:=0; i<len(a); i++ { s += a[i]*b[i] }
+  return s
+}
+
+// Modified Gram-Schmidt on the columns of q (stored as rows).
+//
+func orthonormalize( q [][]float64 ) {
+  for j:=0; j<len(q); j++ {
+    n := len(q[j])
+
+    // A degenerate column is replaced with unit vectors until one
+    // is independent of the columns before it.
+    //
+    for u:=0; u<=n; u++ {
+      for i:=0; i<j; i++ {
+        d := dot( q[i], q[j] )
+        for x:=0; x<n; x++ { q[j][x] -= d*q[i][x] }
+      }
+      nrm := math.Sqrt( dot( q[j], q[j] ) )
+      if (nrm>1e-12) || (u==n) {
+        if nrm>0 {
+          for x:=0; x<n; x++ { q[j][x] /= nrm }
+        }
+        break
+      }
+      for x:=0; x<n; x++ { q[j][x] = 0 }
+      q[j][(j+u)%n] = 1
+    }
+  }
+}
+
+func mul( g [][]float64, v []float64, r []float64 ) {
+  for i:=0; i<len(g); i++ { r[i] = dot( g[i], v ) }
+}
+
+// Eigen-decomposition of the symmetric matrix a with cyclic Jacobi
+// rotations.  Returns the eigenvalues and the eigenvectors as the
+// columns of v.  a is overwritten.
+//
+func jacobi( a [][]float64 ) ( []float64, [][]float64 ) {
+  k := len(a)
+  v := make( [][]float64, k )
+  for i:=0; i<k; i++ { v[i] = make( []float64, k ) ; v[i][i] = 1 }
+
+  for sweep:=0; sweep<100; sweep++ {
+    off := 0.0
+    for p:=0; p<k; p++ {
+      for q:=p+1; q<k; q++ { off += a[p][q]*a[p][q] }
+    }
+    if off < 1e-30 { break }
+
+    for p:=0; p<k; p++ {
+      for q:=p+1; q<k; q++ {
+        if a[p][q]==0 { continue }
+
+        theta := (a[q][q]-a[p][p]) / (2*a[p][q])
+        t := 1/(math.Abs(theta) + math.Sqrt(theta*theta+1))
+        if theta<0 { t = -t }
+        c := 1/math.Sqrt(t*t+1)
+        s := t*c
+
+        for r:=0; r<k; r++ {
+          arp,arq := a[r][p],a[r][q]
+          a[r][p] = c*arp - s*arq
+          a[r][q] = s*arp + c*arq
+        }
+        for r:=0; r<k; r++ {
+          apr,aqr := a[p][r],a[q][r]
+          a[p][r] = c*apr - s*aqr
+          a[q][r] = s*apr + c*aqr
+        }
+        for r:=0; r<k; r++ {
+          vrp,vrq := v[r][p],v[r][q]
+          v[r][p] = c*vrp - s*vrq
+          v[r][q] = s*vrp + c*vrq
+        }
+      }
+    }
+  }
+
+  w := make( []float64, k )
+  for i:=0; i<k; i++ { w[i] = a[i][i] }
+  return w, v
+}
+
+// Top k principal components.  max_iter and tol of 0 take the
+// defaults.  cancelled, if not nil, is checked every iteration.
+//
+func (g *Gram) PCA( k, max_iter int, tol float64, cancelled func() bool ) ( *Result, error ) {
+  n := g.N
+  if n<2 { return nil, fmt.Errorf("need at least 2 samples (have %d)", n) }
+  if g.Feature==0 { return nil, fmt.Errorf("no informative features") }
+  if k<1 { return nil, fmt.Errorf("invalid number of components %d", k) }
+  if k>n-1 { k = n-1 }
+  if max_iter<=0 { max_iter = DefaultMaxIter }
+  if tol<=0 { tol = DefaultTol }
+
+  // Full symmetric copy, one row per sample.
+  //
+  m := make( [][]float64, n )
+  trace := 0.0
+  for i:=0; i<n; i++ {
+    m[i] = make( []float64, n )
+    for j:=0; j<n; j++ {
+      if j>=i { m[i][j] = g.M[i*n+j] } else { m[i][j] = g.M[j*n+i] }
+    }
+    trace += m[i][i]
+  }
+  if trace<=0 { return nil, fmt.Errorf("no variance") }
+
+  // Fixed seed so results are reproducible.
+  //
+  rnd := rand.New( rand.NewSource( 1 ) )
+  q := make( [][]float64, k )
+  for j:=0; j<k; j++ {
+    q[j] = make( []float64, n )
+    for i:=0; i<n; i++ { q[j][i] = rnd.Float64()-0.5 }
+  }
+  orthonormalize( q )
+
+  z := make( [][]float64, k )
+  for j:=0; j<k; j++ { z[j] = make( []float64, n ) }
+
+  iter := 0
+  for iter=1; iter<=max_iter; iter++ {
+    if (cancelled!=nil) && cancelled() { return nil, fmt.Errorf("cancelled") }
+
+    for j:=0; j<k; j++ { mul( m, q[j], z[j] ) }
+    orthonormalize( z )
+
+    delta := 0.0
+    for j:=0; j<k; j++ {
+      d := 1-math.Abs( dot( q[j], z[j] ) )
+      if d>delta { delta = d }
+    }
+    q,z = z,q
+
+    if delta<tol { break }
+  }
+  if iter>max_iter { iter = max_iter }
+
+  // Rayleigh-Ritz on the converged subspace.
+  //
+  h := make( [][]float64, k )
+  for a:=0; a<k; a++ {
+    mul( m, q[a], z[a] )
+    h[a] = make( []float64, k )
+  }
+  for a:=0; a<k; a++ {
+    for b:=0; b<k; b++ { h[a][b] = dot( q[a], z[b] ) }
+  }
+  w,v := jacobi( h )
+
+  order := make( []int, k )
+  for i:=0; i<k; i++ { order[i] = i }
+  sort.Slice( order, func(a,b int) bool { return w[order[a]] > w[order[b]] } )
+
+  res := &Result{ Feature:g.Feature, Iteration:iter }
+  res.Coordinate = make( [][]float64, n )
+  for i:=0; i<n; i++ { res.Coordinate[i] = make( []float64, k ) }
+
+  for c:=0; c<k; c++ {
+    o := order[c]
+    lambda := w[o]
+    if lambda<0 { lambda = 0 }
+
+    u := make( []float64, n )
+    for a:=0; a<k; a++ {
+      for i:=0; i<n; i++ { u[i] += v[a][o]*q[a][i] }
+    }
+
+    // Sign is arbitrary, make the largest entry positive.
+    //
+    big := 0
+    for i:=1; i<n; i++ {
+      if math.Abs(u[i]) > math.Abs(u[big]) { big = i }
+    }
+    sgn := 1.0
+    if u[big]<0 { sgn = -1 }
+
+    s := math.Sqrt( lambda )
+    for i:=0; i<n; i++ { res.Coordinate[i][c] = sgn*s*u[i] }
+
+    res.Eigenvalue = append( res.Eigenvalue, lambda/float64(n-1) )
+    res.VarianceExplained = append( res.VarianceExplained, lambda/trace )
+  }
+
+  return res, nil
+}
@@ -0,0 +1,73 @@
+package pca
+
+import "testing"
+import "math"
+
+func TestPCAClusters( t *testing.T ) {
+
+  // Two groups of samples differing in the first five features,
+  // the rest is small noise.
+  //
+  n,m := 20,40
+  g := NewGram( n )
+  for f:=0; f<m; f++ {
+    col := make( []float64, n )
+    for i:=0; i<n; i++ {
+      if f<5 {
+        if i<n/2 { col[i] = 2 }
+      } else {
+        col[i] = float64( (i*7+f*13)%5 ) * 0.01
+      }
+    }
+    g.Add( col )
+  }
+
+  res,e := g.PCA( 2, 0, 0, nil )
+  if e!=nil { t.Fatal(e) }
+
+  if len(res.Coordinate)!=n || len(res.Coordinate[0])!=2 { t.Fatalf("bad shape") }
+  if res.VarianceExplained[0] < 0.9 { t.Errorf("first component explains %f", res.VarianceExplained[0]) }
+  if res.Eigenvalue[0] < res.Eigenvalue[1] { t.Errorf("eigenvalues out of order %v", res.Eigenvalue) }
+
+  for i:=0; i<n; i++ {
+    a := res.Coordinate[i][0]
+    b := res.Coordinate[0][0]
+    if (i<n/2) != (a*b>0) { t.Errorf("sample %d on wrong side (%f)", i, a) }
+  }
+}
+
+func TestPCAKnown( t *testing.T ) {
+
+  // Three samples on a line: coordinates -1, 0, 1 along one axis.
+  //
+  g := NewGram( 3 )
+  g.Add( []float64{ 0, 1, 2 } )
+  g.Add( []float64{ 0, 1, 2 } )
+  g.Add( []float64{ 5, 5, 5 } )
+
+  if g.Feature!=2 { t.Errorf("constant feature not skipped (%d)", g.Feature) }
+
+  res,e := g.PCA( 1, 0, 0, nil )
+  if e!=nil { t.Fatal(e) }
+
+  // Distance from the centre is sqrt(2) along the axis.
+  //
+  want := []float64{ math.Sqrt2, 0, -math.Sqrt2 }
+  sgn := 1.0
+  if res.Coordinate[0][0]<0 { sgn = -1 }
+  for i:=0; i<3; i++ {
+    if math.Abs( sgn*res.Coordinate[i][0]-want[i] ) > 1e-6 { t.Errorf("coordinate %d: %f != %f", i, res.Coordinate[i][0], want[i]) }
+  }
+  if math.Abs( res.VarianceExplained[0]-1 ) > 1e-9 { t.Errorf("variance explained %f", res.VarianceExplained[0]) }
+  if math.Abs( res.Eigenvalue[0]-2 ) > 1e-9 { t.Errorf("eigenvalue %f", res.Eigenvalue[0]) }
+}
+
+func TestPCAMissing( t *testing.T ) {
+  g := NewGram( 4 )
+  g.Add( []float64{ 0, math.NaN(), 2, 2 } )
+  g.Add( []float64{ math.NaN(), math.NaN(), math.NaN(), math.NaN() } )
+  if g.Feature!=1 { t.Errorf("feature count %d", g.Feature) }
+
+  if _,e := NewGram( 1 ).PCA( 1, 0, 0, nil ) ; e==nil { t.Errorf("expected error for one sample") }
+  if _,e := NewGram( 3 ).PCA( 1, 0, 0, nil ) ; e==nil { t.Errorf("expected error for no features") }
+}